@@ -3,8 +3,12 @@ package apptest
 import (
 	"bufio"
 	"context"
+	"encoding/base32"
+	"encoding/json"
 	"flag"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
@@ -12,6 +16,8 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/AltSoyuz/adequate/lib/totp"
 )
 
 type App struct {
@@ -126,12 +132,28 @@ func (a *App) waitForReady(path string) {
 	}
 }
 
+// FreeAddr returns a local address free for -http.listenAddr, for tests which must know the app URL before starting it.
+func FreeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot find a free port: %v", err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// KeyRing is the keyring of the apps started by StartApp, unless they set -keys.ring.
+const KeyRing = `{"active":"test","keys":[{"id":"test","secret":"dGVzdCBrZXkgZm9yIHRvdHAgc2VjcmV0cywgMzIgYiE=","created_at":"2026-01-01T00:00:00Z"}]}`
+
 func setDefaultFlags(flags []string) []string {
 	defaults := []struct {
 		key   string
 		value string
 	}{
 		{"-http.listenAddr=", "127.0.0.1:0"},
+		// A fixed keyring, so tests can enable two-factor authentication. Tests loading -keys.file set an empty -keys.ring.
+		{"-keys.ring=", KeyRing},
 	}
 
 	for _, def := range defaults {
@@ -149,3 +171,99 @@ func setDefaultFlags(flags []string) []string {
 
 	return flags
 }
+
+// RunCommand runs the app subcommand name against the database of a and returns its stdout.
+func (a *App) RunCommand(name string, args ...string) string {
+	a.tc.T().Helper()
+	return a.RunCommandInput("", name, args...)
+}
+
+// RunCommandInput is like RunCommand, but writes stdin to the standard input of the command.
+func (a *App) RunCommandInput(stdin, name string, args ...string) string {
+	t := a.tc.T()
+	t.Helper()
+
+	// The store flag goes last, after the action of commands such as token create.
+	args = append(append([]string{name}, args...), "-store.sqlitePath="+a.dbPath)
+	var stdout, stderr strings.Builder
+	cmd := exec.Command(*binPath, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("app %s: %v; stderr: %s", strings.Join(args, " "), err, stderr.String())
+	}
+	return stdout.String()
+}
+
+// DBPath returns the path of the database of a, for commands run with RunBinary.
+func (a *App) DBPath() string {
+	return a.dbPath
+}
+
+// RunBinary runs the app binary with args, for commands which do not use the database of an app, e.g. keys generate.
+// It returns the stdout of the command, and an error with its stderr if it failed.
+func RunBinary(stdin string, args ...string) (string, error) {
+	var stdout, stderr strings.Builder
+	cmd := exec.Command(*binPath, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return stdout.String(), fmt.Errorf("app %s: %w; stderr: %s", strings.Join(args, " "), err, stderr.String())
+	}
+	return stdout.String(), nil
+}
+
+// Login creates a user with the given roles and logs the client of a in as this user.
+func (a *App) Login(username string, roles ...string) {
+	t := a.tc.T()
+	t.Helper()
+
+	const password = "correct horse"
+	a.RunCommandInput(password+"\n", "user-add", "-username="+username, "-roles="+strings.Join(roles, ","))
+	body := `{"username":"` + username + `","password":"` + password + `"}`
+	res, statusCode, _ := a.Cli.Do(t, http.MethodPost, a.BaseURL+"/api/auth/login", []byte(body), http.Header{"Content-Type": {"application/json"}})
+	if statusCode != http.StatusOK {
+		t.Fatalf("cannot log in as %s: status code %d, resp body: %s", username, statusCode, res)
+	}
+	// Safe requests get the CSRF cookie, which the client echoes in the mutations of the session.
+	if res, statusCode := a.Cli.Get(t, a.BaseURL+"/api/me"); statusCode != http.StatusOK {
+		t.Fatalf("cannot load the user of the session: status code %d, resp body: %s", statusCode, res)
+	}
+}
+
+// EnableTOTP enables two-factor authentication for the user logged in with a.Cli by Login, which also verifies the session
+// for sensitive endpoints. It returns the TOTP secret and the recovery codes.
+func (a *App) EnableTOTP() ([]byte, []string) {
+	t := a.tc.T()
+	t.Helper()
+
+	res, statusCode := a.Cli.Post(t, a.BaseURL+"/api/auth/totp/enroll", []byte(`{"password":"correct horse"}`))
+	if statusCode != http.StatusOK {
+		t.Fatalf("cannot enroll TOTP: status code %d, resp body: %s", statusCode, res)
+	}
+	var enrollment struct {
+		Secret string `json:"secret"`
+	}
+	if err := json.Unmarshal([]byte(res), &enrollment); err != nil {
+		t.Fatalf("cannot parse TOTP enrollment %q: %v", res, err)
+	}
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enrollment.Secret)
+	if err != nil {
+		t.Fatalf("cannot decode TOTP secret %q: %v", enrollment.Secret, err)
+	}
+
+	body := `{"password":"correct horse","code":"` + totp.Code(secret, time.Now()) + `"}`
+	res, statusCode = a.Cli.Post(t, a.BaseURL+"/api/auth/totp/enable", []byte(body))
+	if statusCode != http.StatusOK {
+		t.Fatalf("cannot enable TOTP: status code %d, resp body: %s", statusCode, res)
+	}
+	var recovery struct {
+		Codes []string `json:"codes"`
+	}
+	if err := json.Unmarshal([]byte(res), &recovery); err != nil {
+		t.Fatalf("cannot parse recovery codes %q: %v", res, err)
+	}
+	return secret, recovery.Codes
+}
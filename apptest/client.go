@@ -25,13 +25,34 @@ func NewClient() *Client {
 func (c *Client) do(t *testing.T, method, url, contentType string, data []byte) (string, int) {
 	t.Helper()
 
+	h := http.Header{}
+	if contentType != "" {
+		h.Set("Content-Type", contentType)
+	}
+	body, statusCode, _ := c.Do(t, method, url, data, h)
+	return body, statusCode
+}
+
+// Do sends a request with the given headers and returns the response body, status code and headers.
+func (c *Client) Do(t *testing.T, method, url string, data []byte, headers http.Header) (string, int, http.Header) {
+	t.Helper()
+
 	req, err := http.NewRequest(method, url, bytes.NewReader(data))
 	if err != nil {
 		t.Fatalf("could not create a HTTP request: %v", err)
 	}
-
-	if contentType != "" {
-		req.Header.Set("Content-Type", contentType)
+	for k, vs := range headers {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	// Echo the CSRF token like the UI does, unless the test sets the header itself.
+	if method != http.MethodGet && method != http.MethodHead && req.Header.Get("X-CSRF-Token") == "" {
+		for _, c := range c.httpCli.Jar.Cookies(req.URL) {
+			if c.Name == "adequate_csrf" {
+				req.Header.Set("X-CSRF-Token", c.Value)
+			}
+		}
 	}
 
 	res, err := c.httpCli.Do(req)
@@ -41,7 +62,7 @@ func (c *Client) do(t *testing.T, method, url, contentType string, data []byte)
 	}
 	body := readAllAndClose(t, res.Body)
 
-	return body, res.StatusCode
+	return body, res.StatusCode, res.Header
 }
 
 func (c *Client) CloseConnections() {
@@ -0,0 +1,102 @@
+package apptest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+)
+
+// CollectedSpan is a span received by a Collector.
+type CollectedSpan struct {
+	TraceID      string `json:"traceId"`
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Name         string `json:"name"`
+	Kind         int    `json:"kind"`
+	Attributes   []struct {
+		Key   string `json:"key"`
+		Value struct {
+			StringValue string `json:"stringValue"`
+			IntValue    string `json:"intValue"`
+		} `json:"value"`
+	} `json:"attributes"`
+}
+
+// Attr returns the string or integer value of the attribute key of s.
+func (s CollectedSpan) Attr(key string) string {
+	for _, a := range s.Attributes {
+		if a.Key == key {
+			return a.Value.StringValue + a.Value.IntValue
+		}
+	}
+	return ""
+}
+
+// Collector is an in-process stand-in for an OTLP/HTTP collector receiving JSON encoded spans.
+type Collector struct {
+	srv *httptest.Server
+	// URL is the traces endpoint to pass to -tracing.otlpEndpoint.
+	URL string
+
+	mu    sync.Mutex
+	spans []CollectedSpan
+}
+
+// NewCollector starts a collector stopped with tc.
+func NewCollector(tc *TestCase) *Collector {
+	c := &Collector{}
+	c.srv = httptest.NewServer(http.HandlerFunc(c.serveTraces))
+	c.URL = c.srv.URL + "/v1/traces"
+	tc.RegisterCleanup(c.srv.Close)
+	return c
+}
+
+func (c *Collector) serveTraces(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != "/v1/traces" {
+		http.NotFound(w, r)
+		return
+	}
+	var req struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []CollectedSpan `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			c.spans = append(c.spans, ss.Spans...)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte("{}"))
+}
+
+// WaitSpans waits until at least n spans of the trace traceID are received and returns them.
+func (c *Collector) WaitSpans(traceID string, n int) []CollectedSpan {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for {
+		var spans []CollectedSpan
+		c.mu.Lock()
+		for _, s := range c.spans {
+			if s.TraceID == traceID {
+				spans = append(spans, s)
+			}
+		}
+		c.mu.Unlock()
+		if len(spans) >= n || ctx.Err() != nil {
+			return spans
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,237 @@
+package apptest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// OIDCProvider is an in-process OpenID Connect provider for testing single sign-on offline.
+//
+// It approves every authorization request with the identity set by SetIdentity,
+// and signs ID tokens with RS256 or ES256, as set by SetAlg.
+type OIDCProvider struct {
+	srv *httptest.Server
+	// URL is the issuer URL to pass to -auth.oidc.issuer.
+	URL string
+
+	ClientID     string
+	ClientSecret string
+
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+
+	mu      sync.Mutex
+	alg     string
+	subject string
+	claims  map[string]any
+	codes   map[string]oidcCode
+}
+
+type oidcCode struct {
+	clientID    string
+	redirectURI string
+	nonce       string
+	challenge   string
+	subject     string
+	claims      map[string]any
+}
+
+// NewOIDCProvider starts a provider stopped with tc.
+func NewOIDCProvider(tc *TestCase) *OIDCProvider {
+	t := tc.T()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("cannot generate RSA key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("cannot generate EC key: %v", err)
+	}
+	p := &OIDCProvider{
+		ClientID:     "adequate",
+		ClientSecret: "test-secret",
+		rsaKey:       rsaKey,
+		ecKey:        ecKey,
+		alg:          "RS256",
+		codes:        map[string]oidcCode{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.serveDiscovery)
+	mux.HandleFunc("GET /authorize", p.serveAuthorize)
+	mux.HandleFunc("POST /token", p.serveToken)
+	mux.HandleFunc("GET /jwks", p.serveJWKS)
+	p.srv = httptest.NewServer(mux)
+	p.URL = p.srv.URL
+	tc.RegisterCleanup(p.srv.Close)
+	return p
+}
+
+// Flags returns the flags of an app served at appURL for logging in with p.
+func (p *OIDCProvider) Flags(appURL string) []string {
+	return []string{
+		"-auth.oidc.issuer=" + p.URL,
+		"-auth.oidc.clientID=" + p.ClientID,
+		"-auth.oidc.clientSecret=" + p.ClientSecret,
+		"-auth.oidc.redirectURL=" + appURL + "/api/auth/oidc/callback",
+	}
+}
+
+// SetIdentity sets the subject and the extra ID token claims of the next logins, e.g. preferred_username.
+func (p *OIDCProvider) SetIdentity(subject string, claims map[string]any) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.subject = subject
+	p.claims = claims
+}
+
+// SetAlg sets the algorithm signing the next ID tokens, RS256 or ES256.
+func (p *OIDCProvider) SetAlg(alg string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.alg = alg
+}
+
+func (p *OIDCProvider) serveDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                p.URL,
+		"authorization_endpoint":                p.URL + "/authorize",
+		"token_endpoint":                        p.URL + "/token",
+		"jwks_uri":                              p.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"id_token_signing_alg_values_supported": []string{"RS256", "ES256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *OIDCProvider) serveAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("response_type") != "code" || q.Get("client_id") != p.ClientID || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	p.mu.Lock()
+	code := rand.Text()
+	p.codes[code] = oidcCode{
+		clientID:    q.Get("client_id"),
+		redirectURI: q.Get("redirect_uri"),
+		nonce:       q.Get("nonce"),
+		challenge:   q.Get("code_challenge"),
+		subject:     p.subject,
+		claims:      p.claims,
+	}
+	p.mu.Unlock()
+
+	rq := redirect.Query()
+	rq.Set("code", code)
+	rq.Set("state", q.Get("state"))
+	redirect.RawQuery = rq.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (p *OIDCProvider) serveToken(w http.ResponseWriter, r *http.Request) {
+	id, secret, ok := r.BasicAuth()
+	if !ok || id != p.ClientID || secret != p.ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	p.mu.Lock()
+	c, ok := p.codes[r.PostFormValue("code")]
+	// Codes are single use.
+	delete(p.codes, r.PostFormValue("code"))
+	alg := p.alg
+	p.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("redirect_uri") != c.redirectURI ||
+		base64.RawURLEncoding.EncodeToString(sum[:]) != c.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now().Unix()
+	claims := map[string]any{
+		"iss":   p.URL,
+		"sub":   c.subject,
+		"aud":   c.clientID,
+		"iat":   now,
+		"exp":   now + 300,
+		"nonce": c.nonce,
+	}
+	for k, v := range c.claims {
+		claims[k] = v
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": rand.Text(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     p.sign(alg, claims),
+	})
+}
+
+func (p *OIDCProvider) serveJWKS(w http.ResponseWriter, r *http.Request) {
+	ecPub, err := p.ecKey.PublicKey.Bytes()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa-1", "use": "sig", "alg": "RS256", "n": b64(p.rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(p.rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec-1", "use": "sig", "alg": "ES256", "crv": "P-256", "x": b64(ecPub[1:33]), "y": b64(ecPub[33:])},
+	}})
+}
+
+func (p *OIDCProvider) sign(alg string, claims map[string]any) string {
+	kid := "rsa-1"
+	if alg == "ES256" {
+		kid = "ec-1"
+	}
+	hb, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	cb, _ := json.Marshal(claims)
+	signed := b64(hb) + "." + b64(cb)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	if alg == "ES256" {
+		r, s, err := ecdsa.Sign(rand.Reader, p.ecKey, digest[:])
+		if err != nil {
+			panic(err)
+		}
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	} else {
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, p.rsaKey, crypto.SHA256, digest[:]); err != nil {
+			panic(err)
+		}
+	}
+	return signed + "." + b64(sig)
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,127 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/AltSoyuz/adequate/apptest"
+)
+
+type auditEntry struct {
+	ID           int64  `json:"id"`
+	Actor        string `json:"actor"`
+	ActorTokenID int64  `json:"actor_token_id"`
+	Action       string `json:"action"`
+	Target       string `json:"target"`
+	Diff         map[string]struct {
+		From any `json:"from"`
+		To   any `json:"to"`
+	} `json:"diff"`
+	RequestID string `json:"request_id"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent"`
+}
+
+func TestAuditLog(t *testing.T) {
+	tc := apptest.NewTestCase(t)
+	defer tc.Stop()
+
+	app := apptest.StartApp(tc)
+	app.Login("admin", "admin")
+	app.EnableTOTP()
+
+	f := func(method, path, body string, wantStatus int) string {
+		t.Helper()
+		h := http.Header{"X-Request-Id": {"rid-" + method}}
+		var data []byte
+		if body != "" {
+			data = []byte(body)
+			h.Set("Content-Type", "application/json")
+		}
+		res, statusCode, _ := app.Cli.Do(t, method, app.BaseURL+path, data, h)
+		if statusCode != wantStatus {
+			t.Fatalf("%s %s: unexpected status code: got %d, want %d, resp body: %s", method, path, statusCode, wantStatus, res)
+		}
+		return res
+	}
+	list := func(q url.Values) ([]auditEntry, string) {
+		t.Helper()
+		res := f(http.MethodGet, "/api/admin/audit-log?"+q.Encode(), "", http.StatusOK)
+		var page struct {
+			Items      []auditEntry `json:"items"`
+			NextCursor string       `json:"next_cursor"`
+		}
+		if err := json.Unmarshal([]byte(res), &page); err != nil {
+			t.Fatalf("cannot parse audit log %q: %v", res, err)
+		}
+		return page.Items, page.NextCursor
+	}
+
+	f(http.MethodPut, "/api/admin/maintenance", `{"mode":"read_only","reason":"backup"}`, http.StatusOK)
+	f(http.MethodPut, "/api/admin/maintenance", `{"mode":"off","reason":""}`, http.StatusOK)
+	res := f(http.MethodPost, "/api/admin/tokens", `{"name":"deploy","scopes":["read"]}`, http.StatusCreated)
+	var token struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.Unmarshal([]byte(res), &token); err != nil {
+		t.Fatalf("cannot parse created token %q: %v", res, err)
+	}
+	f(http.MethodDelete, "/api/admin/tokens/"+strconv.FormatInt(token.ID, 10), "", http.StatusNoContent)
+	// Failed changes roll back their entry.
+	f(http.MethodDelete, "/api/admin/tokens/"+strconv.FormatInt(token.ID, 10), "", http.StatusNotFound)
+	app.RunCommand("user-roles", "-username=admin", "-roles=admin,viewer")
+
+	// Entries are listed newest first, with the actor and the request of the change.
+	entries, _ := list(nil)
+	var actions []string
+	for _, e := range entries {
+		actions = append(actions, e.Action)
+	}
+	want := []string{"user.roles", "token.revoke", "token.create", "maintenance.set", "maintenance.set", "totp.enable", "user.roles", "user.create"}
+	if len(actions) != len(want) {
+		t.Fatalf("unexpected actions %q; want %q", actions, want)
+	}
+	for i := range want {
+		if actions[i] != want[i] {
+			t.Fatalf("unexpected actions %q; want %q", actions, want)
+		}
+	}
+
+	create := entries[2]
+	if create.Actor != "admin" || create.Target != "token:"+strconv.FormatInt(token.ID, 10) || create.RequestID != "rid-POST" ||
+		create.IP != "127.0.0.1" || create.UserAgent == "" || create.Diff["name"].To != "deploy" || create.Diff["name"].From != nil {
+		t.Fatalf("unexpected token.create entry %+v", create)
+	}
+	// Changes made with the app commands have no actor nor request.
+	roles := entries[0]
+	if roles.Actor != "" || roles.IP != "" || roles.Target != "user:admin" {
+		t.Fatalf("unexpected user.roles entry %+v", roles)
+	}
+	if from, to := roles.Diff["roles"].From.([]any), roles.Diff["roles"].To.([]any); len(from) != 1 || len(to) != 2 {
+		t.Fatalf("unexpected roles diff %+v", roles.Diff)
+	}
+
+	// Entries are filtered and paginated.
+	entries, cursor := list(url.Values{"action": {"maintenance.set"}, "limit": {"1"}})
+	if len(entries) != 1 || entries[0].Diff["mode"].From != "read_only" || entries[0].Diff["mode"].To != "off" || cursor == "" {
+		t.Fatalf("unexpected first page %+v, cursor %q", entries, cursor)
+	}
+	entries, cursor = list(url.Values{"action": {"maintenance.set"}, "limit": {"1"}, "cursor": {cursor}})
+	if len(entries) != 1 || entries[0].Diff["mode"].From != "off" || entries[0].Diff["reason"].To != "backup" || cursor != "" {
+		t.Fatalf("unexpected last page %+v, cursor %q", entries, cursor)
+	}
+	if entries, _ := list(url.Values{"actor": {"admin"}, "target": {"maintenance"}}); len(entries) != 2 {
+		t.Fatalf("got %d entries of admin on maintenance; want 2", len(entries))
+	}
+	if entries, _ := list(url.Values{"until": {"2000-01-01T00:00:00Z"}}); len(entries) != 0 {
+		t.Fatalf("got %d entries before 2000; want 0", len(entries))
+	}
+	f(http.MethodGet, "/api/admin/audit-log?since=yesterday", "", http.StatusBadRequest)
+
+	// Reading the log needs the audit:read permission.
+	app.Login("bob", "operator")
+	f(http.MethodGet, "/api/admin/audit-log", "", http.StatusForbidden)
+}
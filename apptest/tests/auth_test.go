@@ -0,0 +1,163 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AltSoyuz/adequate/apptest"
+)
+
+func TestAuth(t *testing.T) {
+	tc := apptest.NewTestCase(t)
+	defer tc.Stop()
+
+	staticDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(staticDir, "200.html"), []byte("<html>app</html>"), 0o644); err != nil {
+		t.Fatalf("cannot write 200.html: %v", err)
+	}
+	app := apptest.StartApp(tc, "-http.staticDir="+staticDir)
+
+	out := app.RunCommandInput("correct horse\n", "user-add", "-username=alice")
+	var created struct {
+		ID       int64  `json:"id"`
+		Username string `json:"username"`
+	}
+	if err := json.Unmarshal([]byte(out), &created); err != nil || created.ID == 0 || created.Username != "alice" {
+		t.Fatalf("unexpected user-add output %q: %v", out, err)
+	}
+
+	// noRedirect sends requests with the given session cookie and does not follow redirects.
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	page := func(path, session string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, app.BaseURL+path, nil)
+		if err != nil {
+			t.Fatalf("cannot create request: %v", err)
+		}
+		if session != "" {
+			req.AddCookie(&http.Cookie{Name: "adequate_session", Value: session})
+		}
+		res, err := noRedirect.Do(req)
+		if err != nil {
+			t.Fatalf("cannot send request: %v", err)
+		}
+		_ = res.Body.Close()
+		return res
+	}
+	login := func(username, password string, wantStatus int) *http.Cookie {
+		t.Helper()
+		body, _ := json.Marshal(map[string]string{"username": username, "password": password})
+		res, statusCode, h := app.Cli.Do(t, http.MethodPost, app.BaseURL+"/api/auth/login", body, http.Header{"Content-Type": {"application/json"}})
+		if statusCode != wantStatus {
+			t.Fatalf("unexpected login status code: got %d, want %d, resp body: %s", statusCode, wantStatus, res)
+		}
+		for _, c := range (&http.Response{Header: h}).Cookies() {
+			if c.Name == "adequate_session" {
+				return c
+			}
+		}
+		return nil
+	}
+	me := func(wantStatus int) string {
+		t.Helper()
+		res, statusCode := app.Cli.Get(t, app.BaseURL+"/api/me")
+		if statusCode != wantStatus {
+			t.Fatalf("unexpected /api/me status code: got %d, want %d, resp body: %s", statusCode, wantStatus, res)
+		}
+		return res
+	}
+
+	me(http.StatusUnauthorized)
+	res := page("/app/migration?x=1", "")
+	if res.StatusCode != http.StatusFound || res.Header.Get("Location") != "/app/login?next=%2Fapp%2Fmigration%3Fx%3D1" {
+		t.Fatalf("anonymous /app requests must be redirected to the login page: got %d to %q", res.StatusCode, res.Header.Get("Location"))
+	}
+	if res := page("/app/login", ""); res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected login page status code: got %d, want %d", res.StatusCode, http.StatusOK)
+	}
+
+	if c := login("alice", "wrong horse", http.StatusUnauthorized); c != nil {
+		t.Fatalf("failed logins must not set a session cookie")
+	}
+	login("bob", "correct horse", http.StatusUnauthorized)
+
+	c := login("Alice", "correct horse", http.StatusOK)
+	if c == nil || !c.HttpOnly || c.SameSite != http.SameSiteLaxMode || c.Path != "/" || c.Expires.IsZero() {
+		t.Fatalf("unexpected session cookie %+v", c)
+	}
+	if got := me(http.StatusOK); got != `{"id":`+strconv.FormatInt(created.ID, 10)+`,"username":"alice"}`+"\n" {
+		t.Fatalf("unexpected /api/me response %q", got)
+	}
+	if res := page("/app/migration", c.Value); res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected /app status code with a session: got %d, want %d", res.StatusCode, http.StatusOK)
+	}
+
+	t.Run("sessions are revoked", func(t *testing.T) {
+		out := app.RunCommand("user-revoke-sessions", "-username=alice")
+		if !strings.Contains(out, `"revoked": 1`) {
+			t.Fatalf("unexpected user-revoke-sessions output %q", out)
+		}
+		me(http.StatusUnauthorized)
+	})
+
+	t.Run("logout ends the session", func(t *testing.T) {
+		c := login("alice", "correct horse", http.StatusOK)
+		me(http.StatusOK)
+
+		res, statusCode := app.Cli.Post(t, app.BaseURL+"/api/auth/logout", nil)
+		if statusCode != http.StatusNoContent {
+			t.Fatalf("unexpected logout status code: got %d, want %d, resp body: %s", statusCode, http.StatusNoContent, res)
+		}
+		me(http.StatusUnauthorized)
+		if res := page("/app/migration", c.Value); res.StatusCode != http.StatusFound {
+			t.Fatalf("the session must not be usable after logout: got status code %d", res.StatusCode)
+		}
+	})
+
+	t.Run("duplicate users are rejected", func(t *testing.T) {
+		if out := app.RunCommandInput("correct horse\n", "user-add", "-username=bob"); !strings.Contains(out, `"username": "bob"`) {
+			t.Fatalf("unexpected user-add output %q", out)
+		}
+		// Usernames are case-insensitive.
+		body := []byte(`{"username":"BOB","password":"correct horse"}`)
+		if res, statusCode := app.Cli.Post(t, app.BaseURL+"/api/auth/login", body); statusCode != http.StatusOK {
+			t.Fatalf("unexpected login status code: got %d, want %d, resp body: %s", statusCode, http.StatusOK, res)
+		}
+	})
+}
+
+func TestSessionIdleExpiry(t *testing.T) {
+	testSessionExpiry(t, "-auth.sessionIdleTimeout=1s")
+}
+
+func TestSessionAbsoluteExpiry(t *testing.T) {
+	testSessionExpiry(t, "-auth.sessionMaxLifetime=1s")
+}
+
+func testSessionExpiry(t *testing.T, flag string) {
+	tc := apptest.NewTestCase(t)
+	defer tc.Stop()
+
+	app := apptest.StartApp(tc, flag)
+	app.RunCommandInput("correct horse\n", "user-add", "-username=alice")
+
+	res, statusCode := app.Cli.Post(t, app.BaseURL+"/api/auth/login", []byte(`{"username":"alice","password":"correct horse"}`))
+	if statusCode != http.StatusOK {
+		t.Fatalf("unexpected login status code: got %d, want %d, resp body: %s", statusCode, http.StatusOK, res)
+	}
+	if res, statusCode := app.Cli.Get(t, app.BaseURL+"/api/me"); statusCode != http.StatusOK {
+		t.Fatalf("unexpected /api/me status code: got %d, want %d, resp body: %s", statusCode, http.StatusOK, res)
+	}
+
+	// Session times are stored with a second precision.
+	time.Sleep(2 * time.Second)
+	if res, statusCode := app.Cli.Get(t, app.BaseURL+"/api/me"); statusCode != http.StatusUnauthorized {
+		t.Fatalf("unexpected /api/me status code with %s: got %d, want %d, resp body: %s", flag, statusCode, http.StatusUnauthorized, res)
+	}
+}
@@ -0,0 +1,66 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/AltSoyuz/adequate/apptest"
+)
+
+func TestCSRF(t *testing.T) {
+	tc := apptest.NewTestCase(t)
+	defer tc.Stop()
+
+	app := apptest.StartApp(tc)
+	app.RunCommandInput("correct horse\n", "user-add", "-username=alice")
+
+	// Safe requests hand out the token cookie.
+	_, _, h := app.Cli.Do(t, http.MethodGet, app.BaseURL+"/api/me", nil, nil)
+	var token string
+	for _, c := range (&http.Response{Header: h}).Cookies() {
+		if c.Name == "adequate_csrf" {
+			token = c.Value
+			if c.HttpOnly || c.SameSite != http.SameSiteStrictMode {
+				t.Fatalf("unexpected CSRF cookie %+v", c)
+			}
+		}
+	}
+	if token == "" {
+		t.Fatalf("missing CSRF cookie in %v", h)
+	}
+
+	f := func(path string, headers http.Header, wantStatus int) {
+		t.Helper()
+		res, statusCode, h := app.Cli.Do(t, http.MethodPost, app.BaseURL+path, []byte(`{"username":"alice","password":"correct horse"}`), headers)
+		if statusCode != wantStatus {
+			t.Fatalf("POST %s with %v: unexpected status code: got %d, want %d, resp body: %s", path, headers, statusCode, wantStatus, res)
+		}
+		if wantStatus == http.StatusForbidden && h.Get("Content-Type") != "application/problem+json" {
+			t.Fatalf("unexpected content type: got %q, want %q", h.Get("Content-Type"), "application/problem+json")
+		}
+	}
+
+	// Login needs no token, but must be same-origin.
+	f("/api/auth/login", http.Header{"Sec-Fetch-Site": {"cross-site"}}, http.StatusForbidden)
+	f("/api/auth/login", http.Header{"Origin": {"https://evil.example"}}, http.StatusForbidden)
+	f("/api/auth/login", http.Header{"Sec-Fetch-Site": {"same-origin"}}, http.StatusOK)
+
+	// Session-authenticated mutations must echo the token.
+	f("/api/auth/logout", http.Header{"X-CSRF-Token": {"forged"}}, http.StatusForbidden)
+	f("/api/auth/logout", http.Header{"X-CSRF-Token": {token}, "Sec-Fetch-Site": {"cross-site"}}, http.StatusForbidden)
+	if res, statusCode := app.Cli.Get(t, app.BaseURL+"/api/me"); statusCode != http.StatusOK {
+		t.Fatalf("rejected requests must not end the session: got status code %d, resp body: %s", statusCode, res)
+	}
+	f("/api/auth/logout", http.Header{"X-CSRF-Token": {token}}, http.StatusNoContent)
+
+	// Bearer-token requests carry no ambient credentials.
+	var created struct {
+		Token string `json:"token"`
+	}
+	out := app.RunCommand("token", "create", "-username=alice", "-name=script", "-scopes=read,write")
+	if err := json.Unmarshal([]byte(out), &created); err != nil {
+		t.Fatalf("cannot parse token create output %q: %v", out, err)
+	}
+	f("/api/auth/logout", http.Header{"Authorization": {"Bearer " + created.Token}, "Sec-Fetch-Site": {"cross-site"}}, http.StatusNoContent)
+}
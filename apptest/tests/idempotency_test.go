@@ -0,0 +1,140 @@
+package tests
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/AltSoyuz/adequate/apptest"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	tc := apptest.NewTestCase(t)
+	defer tc.Stop()
+
+	app := apptest.StartApp(tc)
+	app.Login("alice", "admin")
+	app.EnableTOTP()
+
+	// bob is another admin, logged in with the client of a second app sharing the database.
+	app2 := apptest.StartApp(tc)
+	app2.Login("bob", "admin")
+	app2.EnableTOTP()
+
+	const body = `{"name":"deploy","scopes":["read"]}`
+	post := func(cli *apptest.Client, key, body string, wantStatus int) (string, http.Header) {
+		t.Helper()
+		h := http.Header{}
+		h.Set("Content-Type", "application/json")
+		h.Set("Idempotency-Key", key)
+		res, statusCode, h := cli.Do(t, http.MethodPost, app.BaseURL+"/api/admin/tokens", []byte(body), h)
+		if statusCode != wantStatus {
+			t.Fatalf("unexpected status code: got %d, want %d, resp body: %s", statusCode, wantStatus, res)
+		}
+		return res, h
+	}
+	tokens := func() int {
+		t.Helper()
+		res, statusCode := app.Cli.Get(t, app.BaseURL+"/api/admin/tokens")
+		if statusCode != http.StatusOK {
+			t.Fatalf("unexpected status code: got %d, want %d, resp body: %s", statusCode, http.StatusOK, res)
+		}
+		var list []json.RawMessage
+		if err := json.Unmarshal([]byte(res), &list); err != nil {
+			t.Fatalf("cannot parse tokens %q: %v", res, err)
+		}
+		return len(list)
+	}
+
+	first, h := post(app.Cli, "key-1", body, http.StatusCreated)
+	if h.Get("Idempotent-Replayed") != "" {
+		t.Fatalf("first response must not be marked as replayed")
+	}
+
+	// The retry of the same principal gets the stored response, and does not create a second token.
+	replayed, h := post(app.Cli, "key-1", body, http.StatusCreated)
+	if h.Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("replayed response must be marked with Idempotent-Replayed header")
+	}
+	if replayed != first {
+		t.Fatalf("unexpected replayed body: got %q, want %q", replayed, first)
+	}
+	if n := tokens(); n != 1 {
+		t.Fatalf("unexpected number of tokens after a replay: got %d, want 1", n)
+	}
+
+	post(app.Cli, "key-1", `{"name":"other","scopes":["read"]}`, http.StatusUnprocessableEntity)
+
+	// Keys are scoped to their principal, so another admin with the same key and payload gets a token of their own.
+	res, h := post(app2.Cli, "key-1", body, http.StatusCreated)
+	if h.Get("Idempotent-Replayed") != "" || res == first {
+		t.Fatalf("the stored response of alice must not be replayed to bob: %s", res)
+	}
+	if n := tokens(); n != 2 {
+		t.Fatalf("unexpected number of tokens: got %d, want 2", n)
+	}
+
+	// Anonymous clients are not served the stored response either.
+	res, h = post(apptest.NewClient(), "key-1", body, http.StatusUnauthorized)
+	if h.Get("Idempotent-Replayed") != "" || res == first {
+		t.Fatalf("the stored response of alice must not be replayed to anonymous clients: %s", res)
+	}
+
+	// Responses setting cookies may carry session tokens, so they are not stored, and their retries log in again.
+	// carol has no second factor, so she logs in again with her password only.
+	app3 := apptest.StartApp(tc)
+	app3.Login("carol")
+	login := func() string {
+		t.Helper()
+		h := http.Header{}
+		h.Set("Content-Type", "application/json")
+		h.Set("Idempotency-Key", "login-1")
+		body := `{"username":"carol","password":"correct horse"}`
+		res, statusCode, h := app3.Cli.Do(t, http.MethodPost, app3.BaseURL+"/api/auth/login", []byte(body), h)
+		if statusCode != http.StatusOK {
+			t.Fatalf("unexpected status code: got %d, want %d, resp body: %s", statusCode, http.StatusOK, res)
+		}
+		if h.Get("Idempotent-Replayed") != "" {
+			t.Fatalf("responses setting cookies must not be replayed")
+		}
+		for _, c := range (&http.Response{Header: h}).Cookies() {
+			if c.Name == "adequate_session" && c.Value != "" {
+				return c.Value
+			}
+		}
+		t.Fatalf("the login did not set the session cookie")
+		return ""
+	}
+	sessions := []string{login(), login()}
+
+	db, err := sql.Open("sqlite3", app.DBPath())
+	if err != nil {
+		t.Fatalf("cannot open database: %v", err)
+	}
+	defer db.Close()
+	rows, err := db.Query(`SELECT idempotency_key, headers, COALESCE(body, '') FROM idempotency_keys`)
+	if err != nil {
+		t.Fatalf("cannot read idempotency keys: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key, headers, body string
+		if err := rows.Scan(&key, &headers, &body); err != nil {
+			t.Fatalf("cannot read idempotency key: %v", err)
+		}
+		if strings.HasSuffix(key, ":login-1") {
+			t.Fatalf("the response of the login must not be stored under %q", key)
+		}
+		for _, token := range sessions {
+			if strings.Contains(headers, token) || strings.Contains(body, token) {
+				t.Fatalf("idempotency key %q stores a session token: headers %q, body %q", key, headers, body)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("cannot read idempotency keys: %v", err)
+	}
+}
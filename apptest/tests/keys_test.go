@@ -0,0 +1,93 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AltSoyuz/adequate/apptest"
+	"github.com/AltSoyuz/adequate/lib/totp"
+)
+
+func TestKeyRotation(t *testing.T) {
+	tc := apptest.NewTestCase(t)
+	defer tc.Stop()
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	type keyInfo struct {
+		ID     string `json:"id"`
+		Active bool   `json:"active"`
+	}
+	keysCmd := func(args ...string) []keyInfo {
+		t.Helper()
+		res, err := apptest.RunBinary("", append([]string{"keys"}, args...)...)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		var infos []keyInfo
+		if err := json.Unmarshal([]byte(res), &infos); err != nil {
+			t.Fatalf("cannot parse keys %q: %v", res, err)
+		}
+		return infos
+	}
+	// cursor returns the cursor to the second page of migrations of app.
+	cursor := func(app *apptest.App) string {
+		t.Helper()
+		res, statusCode := app.Cli.Get(t, app.BaseURL+"/api/migrations?limit=1")
+		if statusCode != http.StatusOK {
+			t.Fatalf("unexpected status code: got %d, want %d, resp body: %s", statusCode, http.StatusOK, res)
+		}
+		var p struct {
+			NextCursor string `json:"next_cursor"`
+		}
+		if err := json.Unmarshal([]byte(res), &p); err != nil || p.NextCursor == "" {
+			t.Fatalf("cannot parse page %q: %v", res, err)
+		}
+		return p.NextCursor
+	}
+	f := func(app *apptest.App, cursor string, wantStatus int) {
+		t.Helper()
+		res, statusCode := app.Cli.Get(t, app.BaseURL+"/api/migrations?limit=1&cursor="+url.QueryEscape(cursor))
+		if statusCode != wantStatus {
+			t.Fatalf("unexpected status code: got %d, want %d, resp body: %s", statusCode, wantStatus, res)
+		}
+	}
+
+	generated := keysCmd("generate", "-file="+path)
+	if len(generated) != 1 || !generated[0].Active {
+		t.Fatalf("unexpected generated keys %+v", generated)
+	}
+	if _, err := apptest.RunBinary("", "keys", "generate", "-file="+path); err == nil {
+		t.Fatalf("keys generate must not overwrite an existing keyring")
+	}
+
+	// The empty -keys.ring overrides the default keyring of tests, so the app loads the file.
+	app1 := apptest.StartApp(tc, "-keys.ring=", "-keys.file="+path)
+	app1.Login("alice", "admin")
+	secret, _ := app1.EnableTOTP()
+	oldCursor := cursor(app1)
+
+	rotated := keysCmd("rotate", "-file="+path)
+	if len(rotated) != 2 || !rotated[0].Active || rotated[0].ID == generated[0].ID || rotated[1].ID != generated[0].ID {
+		t.Fatalf("unexpected rotated keys %+v; want a new active key followed by %q", rotated, generated[0].ID)
+	}
+	if listed := keysCmd("list", "-file="+path); len(listed) != 2 || listed[0] != rotated[0] || listed[1] != rotated[1] {
+		t.Fatalf("unexpected listed keys %+v; want %+v", listed, rotated)
+	}
+
+	// The app started with the rotated keyring shares the database of app1, and still opens what the retired key
+	// sealed or signed.
+	app2 := apptest.StartApp(tc, "-keys.ring=", "-keys.file="+path)
+	body := `{"username":"alice","password":"correct horse","code":"` + totp.Code(secret, time.Now().Add(totp.Period)) + `"}`
+	res, statusCode, _ := app2.Cli.Do(t, http.MethodPost, app2.BaseURL+"/api/auth/login", []byte(body), http.Header{"Content-Type": {"application/json"}})
+	if statusCode != http.StatusOK {
+		t.Fatalf("cannot log in with a TOTP secret sealed with the retired key: status code %d, resp body: %s", statusCode, res)
+	}
+	f(app2, oldCursor, http.StatusOK)
+
+	// New cursors are signed with the new active key, which app1 does not know.
+	f(app1, cursor(app2), http.StatusBadRequest)
+}
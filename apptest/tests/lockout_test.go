@@ -0,0 +1,210 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AltSoyuz/adequate/apptest"
+)
+
+func TestLoginLockout(t *testing.T) {
+	tc := apptest.NewTestCase(t)
+	defer tc.Stop()
+
+	app := apptest.StartApp(tc, "-auth.loginBackoff=2s", "-auth.lockoutThreshold=5", "-auth.lockoutDuration=1h")
+	app.Login("admin", "admin")
+	app.RunCommandInput("correct horse\n", "user-add", "-username=alice")
+
+	// login logs in from a client without session, and returns the response body and Retry-After header.
+	login := func(username, password string, wantStatus int) (string, string) {
+		t.Helper()
+		body := []byte(`{"username":"` + username + `","password":"` + password + `"}`)
+		res, statusCode, h := apptest.NewClient().Do(t, http.MethodPost, app.BaseURL+"/api/auth/login", body, http.Header{"Content-Type": {"application/json"}})
+		if statusCode != wantStatus {
+			t.Fatalf("login of %s: unexpected status code: got %d, want %d, resp body: %s", username, statusCode, wantStatus, res)
+		}
+		return res, h.Get("Retry-After")
+	}
+	f := func(method, path string, wantStatus int) string {
+		t.Helper()
+		res, statusCode, _ := app.Cli.Do(t, method, app.BaseURL+path, nil, nil)
+		if statusCode != wantStatus {
+			t.Fatalf("%s %s: unexpected status code: got %d, want %d, resp body: %s", method, path, statusCode, wantStatus, res)
+		}
+		return res
+	}
+
+	// The first failures are not delayed, and the next ones are delayed exponentially,
+	// with the same responses for existing and unknown users.
+	for _, username := range []string{"alice", "ghost"} {
+		for range 3 {
+			login(username, "wrong password", http.StatusUnauthorized)
+		}
+		login(username, "wrong password", http.StatusUnauthorized)
+		res, retryAfter := login(username, "correct horse", http.StatusTooManyRequests)
+		if n, _ := strconv.Atoi(retryAfter); !strings.Contains(res, "urn:adequate:problem:login-throttled") || n < 1 || n > 2 {
+			t.Fatalf("unexpected throttled response %s with Retry-After %q", res, retryAfter)
+		}
+	}
+	time.Sleep(2 * time.Second)
+
+	// Accounts are locked at the lockout threshold, even for the right password.
+	login("alice", "wrong password", http.StatusUnauthorized)
+	if _, retryAfter := login("alice", "correct horse", http.StatusTooManyRequests); retryAfter != "3600" && retryAfter != "3599" {
+		t.Fatalf("unexpected Retry-After %q; want an hour", retryAfter)
+	}
+	var lockouts []struct {
+		Kind     string `json:"kind"`
+		Name     string `json:"name"`
+		Failures int64  `json:"failures"`
+		Locked   bool   `json:"locked"`
+	}
+	res := f(http.MethodGet, "/api/admin/lockouts", http.StatusOK)
+	if err := json.Unmarshal([]byte(res), &lockouts); err != nil {
+		t.Fatalf("cannot parse lockouts %q: %v", res, err)
+	}
+	// The delay of ghost is over, so only alice is listed.
+	if len(lockouts) != 1 || lockouts[0].Kind != "user" || lockouts[0].Name != "alice" || !lockouts[0].Locked || lockouts[0].Failures != 5 {
+		t.Fatalf("unexpected lockouts %s", res)
+	}
+	if res := f(http.MethodGet, "/api/admin/audit-log?action=login.lock", http.StatusOK); !strings.Contains(res, `"target":"user:alice"`) {
+		t.Fatalf("unexpected audit log %s; want the lockout of alice", res)
+	}
+
+	// Admins unlock accounts after verifying their second factor.
+	f(http.MethodDelete, "/api/admin/lockouts/user/alice", http.StatusForbidden)
+	app.EnableTOTP()
+	f(http.MethodDelete, "/api/admin/lockouts/user/Alice", http.StatusNoContent)
+	f(http.MethodDelete, "/api/admin/lockouts/user/alice", http.StatusNotFound)
+	f(http.MethodDelete, "/api/admin/lockouts/host/alice", http.StatusBadRequest)
+	login("alice", "correct horse", http.StatusOK)
+
+	// Successful logins forget the failures of the account.
+	for range 3 {
+		login("alice", "wrong password", http.StatusUnauthorized)
+	}
+	login("alice", "correct horse", http.StatusOK)
+	login("alice", "wrong password", http.StatusUnauthorized)
+	login("alice", "correct horse", http.StatusOK)
+
+	// Usernames are case-insensitive, so their case variants share the failures of the account.
+	for _, username := range []string{"Alice", "ALICE", "aLiCe", "alice"} {
+		login(username, "wrong password", http.StatusUnauthorized)
+	}
+	login("ALICE", "correct horse", http.StatusTooManyRequests)
+	time.Sleep(2 * time.Second)
+	login("Alice", "correct horse", http.StatusOK)
+	for range 4 {
+		login("ALICE", "wrong password", http.StatusUnauthorized)
+	}
+
+	// Reading and unlocking lockouts needs the lockouts:manage permission.
+	app.Login("bob", "operator")
+	f(http.MethodGet, "/api/admin/lockouts", http.StatusForbidden)
+}
+
+func TestLoginLockoutConcurrent(t *testing.T) {
+	tc := apptest.NewTestCase(t)
+	defer tc.Stop()
+
+	app := apptest.StartApp(tc, "-auth.loginBackoff=1h", "-auth.lockoutThreshold=100")
+	app.Login("admin", "admin")
+	app.RunCommandInput("correct horse\n", "user-add", "-username=alice")
+
+	// Concurrent guesses are counted before the password is checked,
+	// so no more guesses are checked than sequential ones.
+	const n = 20
+	var wg sync.WaitGroup
+	statusCodes := make(chan int, n)
+	for range n {
+		wg.Go(func() {
+			body := strings.NewReader(`{"username":"alice","password":"wrong password"}`)
+			resp, err := http.Post(app.BaseURL+"/api/auth/login", "application/json", body)
+			if err != nil {
+				statusCodes <- 0
+				return
+			}
+			resp.Body.Close()
+			statusCodes <- resp.StatusCode
+		})
+	}
+	wg.Wait()
+	close(statusCodes)
+	counts := map[int]int{}
+	for statusCode := range statusCodes {
+		counts[statusCode]++
+	}
+	// The first 3 failures are free, and the 4th one delays the next guesses.
+	if counts[http.StatusUnauthorized] != 4 || counts[http.StatusTooManyRequests] != n-4 {
+		t.Fatalf("unexpected status codes %v; want 4 checked guesses and %d throttled ones", counts, n-4)
+	}
+}
+
+func TestLoginLockoutIP(t *testing.T) {
+	tc := apptest.NewTestCase(t)
+	defer tc.Stop()
+
+	app := apptest.StartApp(tc, "-auth.loginBackoff=0", "-auth.lockoutThreshold=1")
+	app.Login("admin", "admin")
+	app.EnableTOTP()
+
+	login := func(username string, wantStatus int) {
+		t.Helper()
+		body := []byte(`{"username":"` + username + `","password":"wrong password"}`)
+		res, statusCode, _ := apptest.NewClient().Do(t, http.MethodPost, app.BaseURL+"/api/auth/login", body, http.Header{"Content-Type": {"application/json"}})
+		if statusCode != wantStatus {
+			t.Fatalf("login of %s: unexpected status code: got %d, want %d, resp body: %s", username, statusCode, wantStatus, res)
+		}
+	}
+
+	// Client IPs trying many accounts are locked after 10 times the failures of an account.
+	for i := range 10 {
+		login(fmt.Sprintf("user%d", i), http.StatusUnauthorized)
+	}
+	login("user10", http.StatusTooManyRequests)
+
+	res, statusCode, _ := app.Cli.Do(t, http.MethodDelete, app.BaseURL+"/api/admin/lockouts/ip/127.0.0.1", nil, nil)
+	if statusCode != http.StatusNoContent {
+		t.Fatalf("cannot unlock the client IP: status code %d, resp body: %s", statusCode, res)
+	}
+	login("user10", http.StatusUnauthorized)
+	login("user0", http.StatusTooManyRequests)
+}
+
+func TestLoginLockoutTrustedProxy(t *testing.T) {
+	tc := apptest.NewTestCase(t)
+	defer tc.Stop()
+
+	app := apptest.StartApp(tc, "-auth.loginBackoff=0", "-auth.lockoutThreshold=1", "-http.trustedProxies=127.0.0.1")
+	app.Login("admin", "admin")
+	app.EnableTOTP()
+
+	// login logs in through the proxy for the client with the given IP.
+	login := func(clientIP, username string, wantStatus int) {
+		t.Helper()
+		body := []byte(`{"username":"` + username + `","password":"wrong password"}`)
+		h := http.Header{"Content-Type": {"application/json"}, "X-Forwarded-For": {clientIP}}
+		res, statusCode, _ := apptest.NewClient().Do(t, http.MethodPost, app.BaseURL+"/api/auth/login", body, h)
+		if statusCode != wantStatus {
+			t.Fatalf("login of %s from %s: unexpected status code: got %d, want %d, resp body: %s", username, clientIP, statusCode, wantStatus, res)
+		}
+	}
+
+	// The clients behind the proxy are throttled separately.
+	for i := range 10 {
+		login("192.0.2.1", fmt.Sprintf("user%d", i), http.StatusUnauthorized)
+	}
+	login("192.0.2.1", "user10", http.StatusTooManyRequests)
+	login("192.0.2.2", "user11", http.StatusUnauthorized)
+
+	res, statusCode, _ := app.Cli.Do(t, http.MethodDelete, app.BaseURL+"/api/admin/lockouts/ip/192.0.2.1", nil, nil)
+	if statusCode != http.StatusNoContent {
+		t.Fatalf("cannot unlock the client IP: status code %d, resp body: %s", statusCode, res)
+	}
+}
@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AltSoyuz/adequate/apptest"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	tc := apptest.NewTestCase(t)
+	defer tc.Stop()
+
+	app := apptest.StartApp(tc, "-maintenance.pollInterval=50ms")
+	app.Login("admin", "admin")
+
+	setMode := func(mode string) {
+		t.Helper()
+		h := http.Header{}
+		h.Set("Content-Type", "application/json")
+		res, statusCode, _ := app.Cli.Do(t, http.MethodPut, app.BaseURL+"/api/admin/maintenance", []byte(`{"mode":"`+mode+`","reason":"data repair"}`), h)
+		if statusCode != http.StatusOK {
+			t.Fatalf("unexpected status code switching to %s: got %d, want %d, resp body: %s", mode, statusCode, http.StatusOK, res)
+		}
+	}
+	f := func(method, path string, headers http.Header, wantStatus int) (string, http.Header) {
+		t.Helper()
+		res, statusCode, h := app.Cli.Do(t, method, app.BaseURL+path, nil, headers)
+		if statusCode != wantStatus {
+			t.Fatalf("%s %s: unexpected status code: got %d, want %d, resp body: %s", method, path, statusCode, wantStatus, res)
+		}
+		return res, h
+	}
+	// Bypass tokens are derived from the keyring of the app.
+	out, err := apptest.RunBinary(apptest.KeyRing, "keys", "bypass-token")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var token struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal([]byte(out), &token); err != nil || !strings.HasPrefix(token.Token, "test.") {
+		t.Fatalf("unexpected keys bypass-token output %q: %v", out, err)
+	}
+	bypass := http.Header{}
+	bypass.Set("X-Maintenance-Bypass", token.Token)
+	forged := http.Header{}
+	forged.Set("X-Maintenance-Bypass", "test.let-me-in")
+
+	_, h := f(http.MethodGet, "/api/version", nil, http.StatusOK)
+	if got := h.Get("X-Maintenance-Mode"); got != "off" {
+		t.Fatalf("unexpected X-Maintenance-Mode: got %q, want %q", got, "off")
+	}
+
+	setMode("read_only")
+
+	// reads are served, writes get a problem+json response
+	f(http.MethodGet, "/api/migrations/version", nil, http.StatusOK)
+	res, h := f(http.MethodPost, "/api/migrations/version", nil, http.StatusServiceUnavailable)
+	if ct := h.Get("Content-Type"); ct != "application/problem+json" {
+		t.Fatalf("unexpected content type: got %q, want %q", ct, "application/problem+json")
+	}
+	var problem struct {
+		Status int    `json:"status"`
+		Detail string `json:"detail"`
+	}
+	if err := json.Unmarshal([]byte(res), &problem); err != nil {
+		t.Fatalf("could not unmarshal response: %v", err)
+	}
+	if problem.Status != http.StatusServiceUnavailable || !strings.Contains(problem.Detail, "data repair") {
+		t.Fatalf("unexpected problem: %+v", problem)
+	}
+
+	// bypass tokens let writes through
+	f(http.MethodPost, "/api/migrations/version", bypass, http.StatusMethodNotAllowed)
+	f(http.MethodPost, "/api/migrations/version", forged, http.StatusServiceUnavailable)
+
+	res, h = f(http.MethodGet, "/api/readyz", nil, http.StatusOK)
+	if got := h.Get("X-Maintenance-Mode"); got != "read_only" {
+		t.Fatalf("unexpected X-Maintenance-Mode: got %q, want %q", got, "read_only")
+	}
+	if !strings.Contains(res, `"maintenance":"read_only"`) {
+		t.Fatalf("readiness must report the maintenance mode: %s", res)
+	}
+
+	setMode("full")
+
+	f(http.MethodGet, "/api/migrations/version", nil, http.StatusServiceUnavailable)
+	f(http.MethodGet, "/api/migrations/version", bypass, http.StatusOK)
+	res, h = f(http.MethodGet, "/app/migration", nil, http.StatusServiceUnavailable)
+	if ct := h.Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Fatalf("UI routes must get the maintenance page; got content type %q, body: %s", ct, res)
+	}
+
+	// the CLI switches the running app through the database
+	app.RunCommand("maintenance", "-mode=off")
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		_, statusCode, _ := app.Cli.Do(t, http.MethodGet, app.BaseURL+"/api/migrations/version", nil, nil)
+		if statusCode == http.StatusOK {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("maintenance mode switched with the CLI was not picked up: status code %d", statusCode)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
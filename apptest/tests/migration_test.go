@@ -1,11 +1,14 @@
 package tests
 
 import (
+	"database/sql"
 	"encoding/json"
 	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/AltSoyuz/adequate/apptest"
+	_ "github.com/mattn/go-sqlite3"
 )
 
 func TestMigrationVersion(t *testing.T) {
@@ -13,6 +16,7 @@ func TestMigrationVersion(t *testing.T) {
 	defer tc.Stop()
 
 	app := apptest.StartApp(tc)
+	app.Login("admin", "admin")
 
 	res, statusCode := app.Cli.Get(t, app.BaseURL+"/api/migrations/version")
 	var resp struct {
@@ -27,7 +31,99 @@ func TestMigrationVersion(t *testing.T) {
 		t.Fatalf("could not unmarshal response: %v", err)
 	}
 
-	if resp.Version != 1 {
-		t.Fatalf("unexpected migration version: got %d, want %d", resp.Version, 1)
+	if resp.Version != 12 {
+		t.Fatalf("unexpected migration version: got %d, want %d", resp.Version, 12)
 	}
 }
+
+func TestMigrateDown(t *testing.T) {
+	tc := apptest.NewTestCase(t)
+	defer tc.Stop()
+
+	app := apptest.StartApp(tc)
+	app.Login("admin", "admin")
+
+	f := func(app *apptest.App, path string, wantStatus int) string {
+		t.Helper()
+		res, statusCode := app.Cli.Get(t, app.BaseURL+path)
+		if statusCode != wantStatus {
+			t.Fatalf("GET %s: unexpected status code: got %d, want %d, resp body: %s", path, statusCode, wantStatus, res)
+		}
+		return res
+	}
+	f(app, "/api/admin/lockouts", http.StatusOK)
+
+	// 009 has no down script, so nothing is rolled back.
+	_, err := apptest.RunBinary("", "migrate", "down", "--to=8", "-store.sqlitePath="+app.DBPath())
+	if err == nil || !strings.Contains(err.Error(), "version 9 has no down script") {
+		t.Fatalf("unexpected error rolling back to 8: %v", err)
+	}
+	if res := f(app, "/api/migrations/version", http.StatusOK); !strings.Contains(res, `"version":12`) {
+		t.Fatalf("unexpected version after a refused rollback: %s", res)
+	}
+
+	if res := app.RunCommand("migrate", "down", "--to=9"); !strings.Contains(res, `"version": 9`) {
+		t.Fatalf("unexpected migrate down output %q", res)
+	}
+	if res := f(app, "/api/migrations/version", http.StatusOK); !strings.Contains(res, `"version":9`) {
+		t.Fatalf("unexpected version after the rollback: %s", res)
+	}
+	// The down scripts of 012, 011 and 010 ran, and 010 removed the lockouts:manage permission of admins.
+	f(app, "/api/admin/lockouts", http.StatusForbidden)
+
+	// Starting the app on the rolled back database applies 010 to 012 again.
+	app2 := apptest.StartApp(tc)
+	body := `{"username":"admin","password":"correct horse"}`
+	res, statusCode, _ := app2.Cli.Do(t, http.MethodPost, app2.BaseURL+"/api/auth/login", []byte(body), http.Header{"Content-Type": {"application/json"}})
+	if statusCode != http.StatusOK {
+		t.Fatalf("cannot log in: status code %d, resp body: %s", statusCode, res)
+	}
+	if res := f(app2, "/api/migrations/version", http.StatusOK); !strings.Contains(res, `"version":12`) {
+		t.Fatalf("unexpected version after migrating up again: %s", res)
+	}
+	f(app2, "/api/admin/lockouts", http.StatusOK)
+}
+
+func TestMigrationChecksums(t *testing.T) {
+	tc := apptest.NewTestCase(t)
+	defer tc.Stop()
+
+	app := apptest.StartApp(tc)
+
+	// Simulate an edit of 001_init.sql after it was applied.
+	db, err := sql.Open("sqlite3", app.DBPath())
+	if err != nil {
+		t.Fatalf("cannot open database: %v", err)
+	}
+	var name, checksum string
+	if err := db.QueryRow(`SELECT name, checksum FROM schema_migrations WHERE version = 1`).Scan(&name, &checksum); err != nil {
+		t.Fatalf("cannot read the recorded migration: %v", err)
+	}
+	if name != "001_init.sql" || len(checksum) != 64 {
+		t.Fatalf("unexpected recorded migration %q with checksum %q", name, checksum)
+	}
+	if _, err := db.Exec(`UPDATE schema_migrations SET checksum = 'edited' WHERE version = 1`); err != nil {
+		t.Fatalf("cannot update checksum: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("cannot close database: %v", err)
+	}
+
+	res, err := apptest.RunBinary("", "maintenance", "-store.sqlitePath="+app.DBPath())
+	if err == nil || !strings.Contains(res, "(001_init.sql) was changed after it was applied") {
+		t.Fatalf("unexpected result of opening a drifted database: %v, stdout: %s", err, res)
+	}
+
+	// Commands accept the flags of the app, so migrations are rolled back anyway with -db.allowMigrationDrift.
+	_, err = apptest.RunBinary("", "migrate", "down", "-to=11", "-store.sqlitePath="+app.DBPath())
+	if err == nil || !strings.Contains(err.Error(), "(001_init.sql) was changed after it was applied") {
+		t.Fatalf("unexpected error rolling back a drifted database: %v", err)
+	}
+	res, err = apptest.RunBinary("", "migrate", "down", "-to=11", "-db.allowMigrationDrift", "-store.sqlitePath="+app.DBPath())
+	if err != nil || !strings.Contains(res, `"version": 11`) {
+		t.Fatalf("unexpected result of rolling back with -db.allowMigrationDrift: %v, stdout: %s", err, res)
+	}
+
+	// The app starts anyway with -db.allowMigrationDrift.
+	apptest.StartApp(tc, "-db.allowMigrationDrift")
+}
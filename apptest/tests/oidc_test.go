@@ -0,0 +1,270 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AltSoyuz/adequate/apptest"
+	"github.com/AltSoyuz/adequate/lib/totp"
+)
+
+func TestOIDCLogin(t *testing.T) {
+	tc := apptest.NewTestCase(t)
+	defer tc.Stop()
+
+	idp := apptest.NewOIDCProvider(tc)
+	staticDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(staticDir, "200.html"), []byte("<html>app</html>"), 0o644); err != nil {
+		t.Fatalf("cannot write 200.html: %v", err)
+	}
+	addr := apptest.FreeAddr(t)
+	flags := append(idp.Flags("http://"+addr), "-http.listenAddr="+addr, "-http.staticDir="+staticDir)
+	app := apptest.StartApp(tc, flags...)
+
+	res, statusCode := app.Cli.Get(t, app.BaseURL+"/api/auth/config")
+	if statusCode != http.StatusOK || !strings.Contains(res, `"oidc":true`) {
+		t.Fatalf("unexpected auth config: status code %d, resp body: %s", statusCode, res)
+	}
+
+	// browser follows redirects between the app and the provider, and keeps the cookies of both.
+	browser := func() *http.Client {
+		jar, _ := cookiejar.New(nil)
+		return &http.Client{Jar: jar}
+	}
+	get := func(cli *http.Client, path string) *url.URL {
+		t.Helper()
+		res, err := cli.Get(app.BaseURL + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		_, _ = io.Copy(io.Discard, res.Body)
+		_ = res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s: unexpected status code %d at %s", path, res.StatusCode, res.Request.URL)
+		}
+		return res.Request.URL
+	}
+	// login logs in with single sign-on and returns the URL the browser lands on.
+	login := func(cli *http.Client, subject, username string) *url.URL {
+		t.Helper()
+		idp.SetIdentity(subject, map[string]any{"preferred_username": username})
+		return get(cli, "/api/auth/oidc/login?next=/app/migration")
+	}
+	me := func(cli *http.Client) string {
+		t.Helper()
+		res, err := cli.Get(app.BaseURL + "/api/me")
+		if err != nil {
+			t.Fatalf("GET /api/me: %v", err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode == http.StatusUnauthorized {
+			return ""
+		}
+		var u struct {
+			Username string `json:"username"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&u); err != nil {
+			t.Fatalf("cannot parse /api/me response: %v", err)
+		}
+		return u.Username
+	}
+	wantLoginError := func(u *url.URL, msg string) {
+		t.Helper()
+		if u.Path != "/app/login" || !strings.Contains(u.Query().Get("error"), msg) {
+			t.Fatalf("unexpected landing URL %s; want the login page with error %q", u, msg)
+		}
+	}
+
+	// passwordLogin logs cli in with the password of username.
+	passwordLogin := func(cli *http.Client, username string) {
+		t.Helper()
+		body := []byte(`{"username":"` + username + `","password":"correct horse"}`)
+		res, err := cli.Post(app.BaseURL+"/api/auth/login", "application/json", bytes.NewReader(body))
+		if err != nil || res.StatusCode != http.StatusOK {
+			t.Fatalf("cannot log in as %s: %v %v", username, err, res)
+		}
+		_ = res.Body.Close()
+	}
+
+	// Identities linked to no local user are rejected.
+	cli := browser()
+	wantLoginError(login(cli, "sub-alice", "alice"), "not linked")
+	if u := me(cli); u != "" {
+		t.Fatalf("unexpected session of %q after a rejected login", u)
+	}
+
+	// Identities are not linked to the local user with the same username, which anybody may claim at the provider.
+	app.RunCommandInput("correct horse\n", "user-add", "-username=alice", "-roles=admin")
+	wantLoginError(login(cli, "sub-alice", "alice"), "not linked")
+	if u := me(cli); u != "" {
+		t.Fatalf("unexpected session of %q after a rejected login", u)
+	}
+
+	// Users logging in with single sign-on from a session link the identity to their account.
+	passwordLogin(cli, "alice")
+	if u := login(cli, "sub-alice", "alice"); u.Path != "/app/migration" {
+		t.Fatalf("unexpected landing URL %s", u)
+	}
+	cli = browser()
+	login(cli, "sub-alice", "alice")
+	if u := me(cli); u != "alice" {
+		t.Fatalf("unexpected user %q; want alice", u)
+	}
+
+	// Linked identities are found by subject, whatever their username, and ES256 tokens are accepted.
+	idp.SetAlg("ES256")
+	cli = browser()
+	login(cli, "sub-alice", "alice.smith")
+	if u := me(cli); u != "alice" {
+		t.Fatalf("unexpected user %q; want alice", u)
+	}
+
+	// Linked identities may have any username at the provider.
+	app.RunCommandInput("correct horse\n", "user-add", "-username=bob")
+	cli = browser()
+	passwordLogin(cli, "bob")
+	login(cli, "sub-robert", "robert")
+	cli = browser()
+	login(cli, "sub-robert", "robert")
+	if u := me(cli); u != "bob" {
+		t.Fatalf("unexpected user %q; want bob", u)
+	}
+
+	// Users with two-factor authentication enter their code after single sign-on before getting a session.
+	app.Login("carol")
+	cli = browser()
+	passwordLogin(cli, "carol")
+	login(cli, "sub-carol", "carol")
+	secret, _ := app.EnableTOTP()
+	completeMFA := func(cli *http.Client, code string, wantStatus int) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, app.BaseURL+"/api/auth/mfa", strings.NewReader(`{"code":"`+code+`"}`))
+		if err != nil {
+			t.Fatalf("cannot create request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		// Echo the CSRF token like the UI does, which browsers with a session must send.
+		for _, c := range cli.Jar.Cookies(req.URL) {
+			if c.Name == "adequate_csrf" {
+				req.Header.Set("X-CSRF-Token", c.Value)
+			}
+		}
+		res, err := cli.Do(req)
+		if err != nil {
+			t.Fatalf("POST /api/auth/mfa: %v", err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		if res.StatusCode != wantStatus {
+			t.Fatalf("POST /api/auth/mfa: unexpected status code: got %d, want %d, resp body: %s", res.StatusCode, wantStatus, body)
+		}
+	}
+	cli = browser()
+	if u := login(cli, "sub-carol", "carol"); u.Path != "/app/login" || u.Query().Get("mfa") != "1" || u.Query().Get("next") != "/app/migration" {
+		t.Fatalf("unexpected landing URL %s; want the login page asking for the code", u)
+	}
+	if u := me(cli); u != "" {
+		t.Fatalf("unexpected session of %q before the second factor", u)
+	}
+	completeMFA(cli, "000000", http.StatusUnauthorized)
+	completeMFA(browser(), totp.Code(secret, time.Now().Add(totp.Period)), http.StatusUnauthorized)
+	completeMFA(cli, totp.Code(secret, time.Now().Add(totp.Period)), http.StatusOK)
+	if u := me(cli); u != "carol" {
+		t.Fatalf("unexpected user %q; want carol", u)
+	}
+	// Challenges are single use.
+	completeMFA(cli, totp.Code(secret, time.Now().Add(totp.Period)), http.StatusUnauthorized)
+
+	// Callbacks without the state of the browser are rejected.
+	cli = browser()
+	wantLoginError(get(cli, "/api/auth/oidc/callback?code=x&state=y"), "failed")
+	if u := me(cli); u != "" {
+		t.Fatalf("unexpected session of %q after a forged callback", u)
+	}
+
+	// The next URL cannot redirect out of the app.
+	cli = browser()
+	idp.SetIdentity("sub-alice", nil)
+	if u := get(cli, "/api/auth/oidc/login?next=//evil.example.com/"); u.Host != addr || u.Path != "/app/" {
+		t.Fatalf("unexpected landing URL %s", u)
+	}
+}
+
+func TestOIDCCreateUsers(t *testing.T) {
+	tc := apptest.NewTestCase(t)
+	defer tc.Stop()
+
+	idp := apptest.NewOIDCProvider(tc)
+	addr := apptest.FreeAddr(t)
+	flags := append(idp.Flags("http://"+addr), "-http.listenAddr="+addr, "-auth.oidc.createUsers", "-auth.stepUpMaxAge=2s")
+	app := apptest.StartApp(tc, flags...)
+
+	jar, _ := cookiejar.New(nil)
+	cli := &http.Client{Jar: jar}
+	// do sends a request with cli, echoing the CSRF token like the UI does, and returns the status code.
+	do := func(method, path, body string) int {
+		t.Helper()
+		req, err := http.NewRequest(method, app.BaseURL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("cannot create request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for _, c := range cli.Jar.Cookies(req.URL) {
+			if c.Name == "adequate_csrf" {
+				req.Header.Set("X-CSRF-Token", c.Value)
+			}
+		}
+		res, err := cli.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		_, _ = io.Copy(io.Discard, res.Body)
+		_ = res.Body.Close()
+		return res.StatusCode
+	}
+	login := func(subject, username string) *url.URL {
+		t.Helper()
+		idp.SetIdentity(subject, map[string]any{"preferred_username": username})
+		res, err := cli.Get(app.BaseURL + "/api/auth/oidc/login?next=/app/migration")
+		if err != nil {
+			t.Fatalf("cannot log in: %v", err)
+		}
+		_ = res.Body.Close()
+		return res.Request.URL
+	}
+
+	// Identities claiming the name of an existing user do not get their account.
+	app.RunCommandInput("correct horse\n", "user-add", "-username=alice", "-roles=admin")
+	if u := login("sub-mallory", "alice"); u.Path != "/app/login" || !strings.Contains(u.Query().Get("error"), "not linked") {
+		t.Fatalf("unexpected landing URL %s; want the login page with an error", u)
+	}
+	if statusCode := do(http.MethodGet, "/api/me", ""); statusCode != http.StatusUnauthorized {
+		t.Fatalf("unexpected status code of /api/me after a rejected login: %d", statusCode)
+	}
+
+	// Other identities get a new user, without password.
+	if u := login("sub-dave", "dave"); u.Path != "/app/migration" {
+		t.Fatalf("unexpected landing URL %s", u)
+	}
+	if statusCode := do(http.MethodGet, "/api/me", ""); statusCode != http.StatusOK {
+		t.Fatalf("unexpected status code of /api/me: %d", statusCode)
+	}
+
+	// Users without password enroll a second factor from fresh logins only.
+	if statusCode := do(http.MethodPost, "/api/auth/totp/enroll", `{}`); statusCode != http.StatusOK {
+		t.Fatalf("unexpected status code of the enrollment of a fresh login: %d", statusCode)
+	}
+	time.Sleep(2 * time.Second)
+	if statusCode := do(http.MethodPost, "/api/auth/totp/enroll", `{}`); statusCode != http.StatusForbidden {
+		t.Fatalf("unexpected status code of the enrollment of a stale login: %d", statusCode)
+	}
+}
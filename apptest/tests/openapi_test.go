@@ -0,0 +1,41 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/AltSoyuz/adequate/apptest"
+)
+
+func TestOpenAPIDocument(t *testing.T) {
+	tc := apptest.NewTestCase(t)
+	defer tc.Stop()
+
+	app := apptest.StartApp(tc)
+
+	res, statusCode := app.Cli.Get(t, app.BaseURL+"/api/openapi.json")
+	if statusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: got %d, want %d, resp body: %s", statusCode, http.StatusOK, res)
+	}
+
+	var doc struct {
+		OpenAPI string `json:"openapi"`
+		Paths   map[string]map[string]struct {
+			OperationID string `json:"operationId"`
+		} `json:"paths"`
+	}
+	if err := json.Unmarshal([]byte(res), &doc); err != nil {
+		t.Fatalf("could not unmarshal response: %v", err)
+	}
+	if doc.OpenAPI != "3.1.0" {
+		t.Fatalf("unexpected openapi version: got %q, want %q", doc.OpenAPI, "3.1.0")
+	}
+	op, ok := doc.Paths["/api/v1/migrations/version"]["get"]
+	if !ok {
+		t.Fatalf("missing GET /api/v1/migrations/version in %v", doc.Paths)
+	}
+	if op.OperationID != "getMigrationVersion" {
+		t.Fatalf("unexpected operationId: got %q, want %q", op.OperationID, "getMigrationVersion")
+	}
+}
@@ -0,0 +1,71 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/AltSoyuz/adequate/apptest"
+)
+
+func TestMigrationListPagination(t *testing.T) {
+	tc := apptest.NewTestCase(t)
+	defer tc.Stop()
+
+	app := apptest.StartApp(tc)
+	app.Login("admin", "admin")
+
+	type page struct {
+		Items []struct {
+			Version int64 `json:"version"`
+		} `json:"items"`
+		NextCursor string `json:"next_cursor"`
+	}
+	get := func(query string) (page, int) {
+		t.Helper()
+		res, statusCode := app.Cli.Get(t, app.BaseURL+"/api/migrations?"+query)
+		var p page
+		if statusCode == http.StatusOK {
+			if err := json.Unmarshal([]byte(res), &p); err != nil {
+				t.Fatalf("could not unmarshal response: %v", err)
+			}
+		}
+		return p, statusCode
+	}
+
+	var versions []int64
+	query := "limit=1"
+	for i := 0; ; i++ {
+		if i > 100 {
+			t.Fatalf("pagination did not terminate")
+		}
+		p, statusCode := get(query)
+		if statusCode != http.StatusOK {
+			t.Fatalf("unexpected status code: got %d, want %d", statusCode, http.StatusOK)
+		}
+		if len(p.Items) > 1 {
+			t.Fatalf("got %d items; want at most 1", len(p.Items))
+		}
+		for _, it := range p.Items {
+			versions = append(versions, it.Version)
+		}
+		if p.NextCursor == "" {
+			break
+		}
+		query = "limit=1&cursor=" + url.QueryEscape(p.NextCursor)
+	}
+
+	for i, v := range versions {
+		if v != int64(i+1) {
+			t.Fatalf("unexpected versions: %v", versions)
+		}
+	}
+	if len(versions) < 2 {
+		t.Fatalf("expected at least 2 migrations; got %v", versions)
+	}
+
+	if _, statusCode := get("cursor=forged.cursor"); statusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected status code for forged cursor: got %d, want %d", statusCode, http.StatusBadRequest)
+	}
+}
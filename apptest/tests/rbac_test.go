@@ -0,0 +1,92 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/AltSoyuz/adequate/apptest"
+)
+
+func TestRBAC(t *testing.T) {
+	tc := apptest.NewTestCase(t)
+	defer tc.Stop()
+
+	app := apptest.StartApp(tc)
+
+	f := func(method, path string, body []byte, wantStatus int) (string, http.Header) {
+		t.Helper()
+		h := http.Header{}
+		if body != nil {
+			h.Set("Content-Type", "application/json")
+		}
+		res, statusCode, rh := app.Cli.Do(t, method, app.BaseURL+path, body, h)
+		if statusCode != wantStatus {
+			t.Fatalf("%s %s: unexpected status code: got %d, want %d, resp body: %s", method, path, statusCode, wantStatus, res)
+		}
+		return res, rh
+	}
+
+	// Anonymous requests are not authorized.
+	f(http.MethodGet, "/api/migrations/version", nil, http.StatusUnauthorized)
+	f(http.MethodGet, "/api/admin/maintenance", nil, http.StatusUnauthorized)
+	f(http.MethodGet, "/api/me/permissions", nil, http.StatusUnauthorized)
+
+	// Viewers read, but cannot switch the maintenance mode.
+	app.Login("vera", "viewer")
+	f(http.MethodGet, "/api/migrations/version", nil, http.StatusOK)
+	f(http.MethodGet, "/api/admin/maintenance", nil, http.StatusOK)
+	res, h := f(http.MethodPut, "/api/admin/maintenance", []byte(`{"mode":"full"}`), http.StatusForbidden)
+	if ct := h.Get("Content-Type"); ct != "application/problem+json" {
+		t.Fatalf("unexpected content type: got %q, want %q", ct, "application/problem+json")
+	}
+	if !strings.Contains(res, "maintenance:write") {
+		t.Fatalf("the problem must name the missing permission: %s", res)
+	}
+	f(http.MethodGet, "/api/admin/tokens", nil, http.StatusForbidden)
+
+	var perms struct {
+		Roles       []string `json:"roles"`
+		Permissions []string `json:"permissions"`
+	}
+	res, _ = f(http.MethodGet, "/api/me/permissions", nil, http.StatusOK)
+	if err := json.Unmarshal([]byte(res), &perms); err != nil {
+		t.Fatalf("cannot parse permissions %q: %v", res, err)
+	}
+	if strings.Join(perms.Roles, ",") != "viewer" || strings.Join(perms.Permissions, ",") != "maintenance:read,migrations:read" {
+		t.Fatalf("unexpected permissions %s", res)
+	}
+
+	// Role changes apply to existing sessions.
+	out := app.RunCommand("user-roles", "-username=vera", "-roles=operator")
+	if !strings.Contains(out, `"operator"`) {
+		t.Fatalf("unexpected user-roles output %q", out)
+	}
+	f(http.MethodPut, "/api/admin/maintenance", []byte(`{"mode":"off"}`), http.StatusOK)
+	f(http.MethodGet, "/api/admin/tokens", nil, http.StatusForbidden)
+
+	app.RunCommand("user-roles", "-username=vera", "-roles=")
+	f(http.MethodGet, "/api/migrations/version", nil, http.StatusForbidden)
+	res, _ = f(http.MethodGet, "/api/me/permissions", nil, http.StatusOK)
+	if res != "{\"roles\":[],\"permissions\":[]}\n" {
+		t.Fatalf("unexpected permissions of a user without roles %q", res)
+	}
+
+	// API tokens are limited by both their scopes and the roles of their user.
+	var token struct {
+		Token string `json:"token"`
+	}
+	out = app.RunCommand("token", "create", "-username=vera", "-name=ci", "-scopes=read,admin")
+	if err := json.Unmarshal([]byte(out), &token); err != nil {
+		t.Fatalf("cannot parse token create output %q: %v", out, err)
+	}
+	bearer := http.Header{"Authorization": {"Bearer " + token.Token}}
+	if _, statusCode, _ := app.Cli.Do(t, http.MethodGet, app.BaseURL+"/api/admin/slow-requests", nil, bearer); statusCode != http.StatusForbidden {
+		t.Fatalf("unexpected status code for a token of a user without roles: got %d, want %d", statusCode, http.StatusForbidden)
+	}
+	app.RunCommand("user-roles", "-username=vera", "-roles=admin")
+	if _, statusCode, _ := app.Cli.Do(t, http.MethodGet, app.BaseURL+"/api/admin/slow-requests", nil, bearer); statusCode != http.StatusOK {
+		t.Fatalf("unexpected status code for a token of an admin: got %d, want %d", statusCode, http.StatusOK)
+	}
+}
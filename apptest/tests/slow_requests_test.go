@@ -0,0 +1,57 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/AltSoyuz/adequate/apptest"
+)
+
+func TestSlowRequests(t *testing.T) {
+	tc := apptest.NewTestCase(t)
+	defer tc.Stop()
+
+	app := apptest.StartApp(tc, "-http.slowRequestThreshold=1ns")
+	app.Login("admin", "admin")
+
+	res, statusCode := app.Cli.Get(t, app.BaseURL+"/api/migrations/version")
+	if statusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: got %d, want %d, resp body: %s", statusCode, http.StatusOK, res)
+	}
+
+	res, statusCode = app.Cli.Get(t, app.BaseURL+"/api/admin/slow-requests")
+	if statusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: got %d, want %d, resp body: %s", statusCode, http.StatusOK, res)
+	}
+	var slow []struct {
+		Path  string `json:"path"`
+		Spans []struct {
+			Phase  string `json:"phase"`
+			Detail string `json:"detail"`
+		} `json:"spans"`
+	}
+	if err := json.Unmarshal([]byte(res), &slow); err != nil {
+		t.Fatalf("could not unmarshal response: %v", err)
+	}
+
+	for _, req := range slow {
+		if req.Path != "/api/migrations/version" {
+			continue
+		}
+		phases := map[string]string{}
+		for _, s := range req.Spans {
+			phases[s.Phase] = s.Detail
+		}
+		if phases["db.query"] != "GetLastMigrationVersion" {
+			t.Fatalf("missing db.query span of GetLastMigrationVersion in %+v", req.Spans)
+		}
+		for _, p := range []string{"db.wait", "json.encode", "write"} {
+			if _, ok := phases[p]; !ok {
+				t.Fatalf("missing %s span in %+v", p, req.Spans)
+			}
+		}
+		return
+	}
+	t.Fatalf("GET /api/migrations/version is missing in the slow requests: %s", res)
+}
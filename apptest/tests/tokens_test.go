@@ -0,0 +1,134 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/AltSoyuz/adequate/apptest"
+)
+
+type apiToken struct {
+	ID         int64    `json:"id"`
+	Username   string   `json:"username"`
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"`
+	Scopes     []string `json:"scopes"`
+	ExpiresAt  string   `json:"expires_at"`
+	LastUsedAt string   `json:"last_used_at"`
+	RevokedAt  string   `json:"revoked_at"`
+	Token      string   `json:"token"`
+}
+
+func TestAPITokens(t *testing.T) {
+	tc := apptest.NewTestCase(t)
+	defer tc.Stop()
+
+	app := apptest.StartApp(tc)
+	app.Login("ci", "admin")
+
+	var reader apiToken
+	out := app.RunCommand("token", "create", "-username=ci", "-name=nightly", "-scopes=read")
+	if err := json.Unmarshal([]byte(out), &reader); err != nil {
+		t.Fatalf("cannot parse token create output %q: %v", out, err)
+	}
+	if !strings.HasPrefix(reader.Token, "adq_") || !strings.HasPrefix(reader.Token, reader.Prefix) || reader.ExpiresAt == "" {
+		t.Fatalf("unexpected token %+v", reader)
+	}
+
+	f := func(method, path, token string, body []byte, wantStatus int) string {
+		t.Helper()
+		h := http.Header{}
+		if token != "" {
+			h.Set("Authorization", "Bearer "+token)
+		}
+		if body != nil {
+			h.Set("Content-Type", "application/json")
+		}
+		res, statusCode, _ := app.Cli.Do(t, method, app.BaseURL+path, body, h)
+		if statusCode != wantStatus {
+			t.Fatalf("%s %s: unexpected status code: got %d, want %d, resp body: %s", method, path, statusCode, wantStatus, res)
+		}
+		return res
+	}
+
+	// Tokens authenticate requests and are scoped.
+	if res := f(http.MethodGet, "/api/me", reader.Token, nil, http.StatusOK); !strings.Contains(res, `"username":"ci"`) {
+		t.Fatalf("unexpected /api/me response %q", res)
+	}
+	f(http.MethodGet, "/api/me", "adq_unknown", nil, http.StatusUnauthorized)
+	f(http.MethodGet, "/api/me", reader.Token+"x", nil, http.StatusUnauthorized)
+	f(http.MethodPost, "/api/auth/logout", reader.Token, nil, http.StatusForbidden)
+	f(http.MethodGet, "/api/admin/tokens", reader.Token, nil, http.StatusForbidden)
+
+	// Sessions manage tokens after verifying their second factor.
+	res := f(http.MethodPost, "/api/admin/tokens", "", []byte(`{"name":"deploy","scopes":["read"]}`), http.StatusForbidden)
+	if !strings.Contains(res, "urn:adequate:problem:step-up-required") {
+		t.Fatalf("unexpected response %q; want a step-up problem", res)
+	}
+	app.EnableTOTP()
+
+	// Admin endpoints manage tokens.
+	res = f(http.MethodPost, "/api/admin/tokens", "", []byte(`{"name":"deploy","scopes":["write","read","admin"],"expires_in_days":0}`), http.StatusCreated)
+	var admin apiToken
+	if err := json.Unmarshal([]byte(res), &admin); err != nil {
+		t.Fatalf("cannot parse created token %q: %v", res, err)
+	}
+	if strings.Join(admin.Scopes, ",") != "admin,read,write" || admin.ExpiresAt != "" {
+		t.Fatalf("unexpected token %+v", admin)
+	}
+	f(http.MethodPost, "/api/admin/tokens", "", []byte(`{"name":"x","scopes":["root"]}`), http.StatusBadRequest)
+
+	res = f(http.MethodGet, "/api/admin/tokens", admin.Token, nil, http.StatusOK)
+	var tokens []apiToken
+	if err := json.Unmarshal([]byte(res), &tokens); err != nil {
+		t.Fatalf("cannot parse token list %q: %v", res, err)
+	}
+	if len(tokens) != 2 || tokens[0].Name != "nightly" || tokens[0].LastUsedAt == "" || tokens[1].Name != "deploy" {
+		t.Fatalf("unexpected token list %s", res)
+	}
+	if strings.Contains(res, reader.Token) || strings.Contains(res, `"token"`) {
+		t.Fatalf("token secrets must not be listed: %s", res)
+	}
+
+	t.Run("tokens of other users are issued by sessions", func(t *testing.T) {
+		app.RunCommandInput("correct horse\n", "user-add", "-username=alice")
+
+		// alice has no permissions, so her token does not get the admin scope.
+		res := f(http.MethodPost, "/api/admin/users/alice/tokens", "", []byte(`{"name":"alice-ci","scopes":["read","admin"]}`), http.StatusCreated)
+		var issued apiToken
+		if err := json.Unmarshal([]byte(res), &issued); err != nil {
+			t.Fatalf("cannot parse issued token %q: %v", res, err)
+		}
+		if issued.Username != "alice" || strings.Join(issued.Scopes, ",") != "read" {
+			t.Fatalf("unexpected issued token %+v", issued)
+		}
+		if res := f(http.MethodGet, "/api/me", issued.Token, nil, http.StatusOK); !strings.Contains(res, `"username":"alice"`) {
+			t.Fatalf("unexpected /api/me response %q", res)
+		}
+		f(http.MethodPost, "/api/admin/users/alice/tokens", "", []byte(`{"name":"x","scopes":["admin"]}`), http.StatusBadRequest)
+		f(http.MethodPost, "/api/admin/users/nobody/tokens", "", []byte(`{"name":"x","scopes":["read"]}`), http.StatusNotFound)
+		f(http.MethodPost, "/api/admin/users/alice/tokens", admin.Token, []byte(`{"name":"x","scopes":["read"]}`), http.StatusForbidden)
+
+		res = f(http.MethodGet, "/api/admin/audit-log?action=token.issue", "", nil, http.StatusOK)
+		if !strings.Contains(res, `"actor":"ci"`) || !strings.Contains(res, `"target":"token:`+strconv.FormatInt(issued.ID, 10)+`"`) {
+			t.Fatalf("unexpected audit log %s; want the token issued to alice", res)
+		}
+	})
+
+	t.Run("revoked tokens are rejected", func(t *testing.T) {
+		f(http.MethodDelete, "/api/admin/tokens/"+strconv.FormatInt(reader.ID, 10), admin.Token, nil, http.StatusNoContent)
+		f(http.MethodDelete, "/api/admin/tokens/"+strconv.FormatInt(reader.ID, 10), admin.Token, nil, http.StatusNotFound)
+		f(http.MethodGet, "/api/me", reader.Token, nil, http.StatusUnauthorized)
+
+		app.RunCommand("token", "revoke", "-id="+strconv.FormatInt(admin.ID, 10))
+		f(http.MethodGet, "/api/me", admin.Token, nil, http.StatusUnauthorized)
+
+		out := app.RunCommand("token", "list")
+		if strings.Count(out, `"revoked_at"`) != 2 {
+			t.Fatalf("unexpected token list output %q", out)
+		}
+	})
+}
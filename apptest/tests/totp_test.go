@@ -0,0 +1,165 @@
+package tests
+
+import (
+	"encoding/base32"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AltSoyuz/adequate/apptest"
+	"github.com/AltSoyuz/adequate/lib/totp"
+)
+
+func TestTOTP(t *testing.T) {
+	tc := apptest.NewTestCase(t)
+	defer tc.Stop()
+
+	// Wrong passwords and codes count as failed logins, which would delay the next logins of the test.
+	app := apptest.StartApp(tc, "-auth.stepUpMaxAge=3s", "-auth.loginBackoff=0")
+	app.Login("alice", "admin")
+
+	f := func(method, path, body string, wantStatus int) string {
+		t.Helper()
+		var data []byte
+		h := http.Header{}
+		if body != "" {
+			data = []byte(body)
+			h.Set("Content-Type", "application/json")
+		}
+		res, statusCode, _ := app.Cli.Do(t, method, app.BaseURL+path, data, h)
+		if statusCode != wantStatus {
+			t.Fatalf("%s %s: unexpected status code: got %d, want %d, resp body: %s", method, path, statusCode, wantStatus, res)
+		}
+		return res
+	}
+	login := func(code string, wantStatus int) string {
+		t.Helper()
+		f(http.MethodPost, "/api/auth/logout", "", http.StatusNoContent)
+		return f(http.MethodPost, "/api/auth/login", `{"username":"alice","password":"correct horse","code":"`+code+`"}`, wantStatus)
+	}
+
+	// Users without two-factor authentication cannot pass step-up checks.
+	if res := f(http.MethodGet, "/api/auth/totp", "", http.StatusOK); !strings.Contains(res, `"enabled":false`) {
+		t.Fatalf("unexpected status %s", res)
+	}
+	if res := f(http.MethodDelete, "/api/auth/totp", "", http.StatusForbidden); !strings.Contains(res, "Enable two-factor authentication") {
+		t.Fatalf("unexpected response %s", res)
+	}
+	f(http.MethodPost, "/api/auth/totp/enable", `{"password":"correct horse","code":"123456"}`, http.StatusConflict)
+
+	// Enrolling needs the password, so a stolen session cookie cannot enroll another authenticator.
+	if res := f(http.MethodPost, "/api/auth/totp/enroll", `{}`, http.StatusForbidden); !strings.Contains(res, "invalid password") {
+		t.Fatalf("unexpected response %s", res)
+	}
+	f(http.MethodPost, "/api/auth/totp/enroll", `{"password":"wrong"}`, http.StatusForbidden)
+
+	// Enrollments are confirmed with a code.
+	res := f(http.MethodPost, "/api/auth/totp/enroll", `{"password":"correct horse"}`, http.StatusOK)
+	var enrollment struct {
+		Secret string `json:"secret"`
+		URI    string `json:"uri"`
+	}
+	if err := json.Unmarshal([]byte(res), &enrollment); err != nil {
+		t.Fatalf("cannot parse enrollment %q: %v", res, err)
+	}
+	if !strings.HasPrefix(enrollment.URI, "otpauth://totp/Adequate:alice?") || !strings.Contains(enrollment.URI, "secret="+enrollment.Secret) {
+		t.Fatalf("unexpected provisioning URI %q", enrollment.URI)
+	}
+	f(http.MethodPost, "/api/auth/totp/enable", `{"password":"correct horse","code":"000000"}`, http.StatusBadRequest)
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enrollment.Secret)
+	if err != nil {
+		t.Fatalf("cannot decode TOTP secret %q: %v", enrollment.Secret, err)
+	}
+	f(http.MethodPost, "/api/auth/totp/enable", `{"password":"wrong","code":"`+totp.Code(secret, time.Now())+`"}`, http.StatusForbidden)
+
+	secret, codes := app.EnableTOTP()
+	if len(codes) != 10 {
+		t.Fatalf("unexpected recovery codes %q", codes)
+	}
+	if res := f(http.MethodGet, "/api/auth/totp", "", http.StatusOK); !strings.Contains(res, `"enabled":true`) || !strings.Contains(res, `"recovery_codes_left":10`) {
+		t.Fatalf("unexpected status %s", res)
+	}
+	f(http.MethodPost, "/api/auth/totp/enroll", `{"password":"correct horse"}`, http.StatusConflict)
+
+	// Logins need a second factor.
+	if res := login("", http.StatusUnauthorized); !strings.Contains(res, "urn:adequate:problem:mfa-required") {
+		t.Fatalf("unexpected response %s; want a mfa-required problem", res)
+	}
+	f(http.MethodGet, "/api/me", "", http.StatusUnauthorized)
+	login("000000", http.StatusUnauthorized)
+
+	// Recovery codes are single use.
+	login(strings.ToUpper(codes[0]), http.StatusOK)
+	login(codes[0], http.StatusUnauthorized)
+
+	// TOTP codes are accepted within the drift window, and cannot be replayed.
+	// The current code was used by the enrollment, so the next one is used.
+	next := totp.Code(secret, time.Now().Add(totp.Period))
+	login(next, http.StatusOK)
+	if res := f(http.MethodGet, "/api/auth/totp", "", http.StatusOK); !strings.Contains(res, `"recovery_codes_left":9`) || !strings.Contains(res, `"verified_at"`) {
+		t.Fatalf("unexpected status %s", res)
+	}
+	login(next, http.StatusUnauthorized)
+	login(codes[1], http.StatusOK)
+
+	// Sessions logged in with a second factor pass step-up checks until -auth.stepUpMaxAge.
+	res = f(http.MethodPost, "/api/auth/totp/recovery-codes", "", http.StatusOK)
+	var recovery struct {
+		Codes []string `json:"codes"`
+	}
+	if err := json.Unmarshal([]byte(res), &recovery); err != nil || len(recovery.Codes) != 10 {
+		t.Fatalf("unexpected recovery codes %q: %v", res, err)
+	}
+	time.Sleep(3 * time.Second)
+	if res := f(http.MethodDelete, "/api/auth/totp", "", http.StatusForbidden); !strings.Contains(res, "urn:adequate:problem:step-up-required") {
+		t.Fatalf("unexpected response %s; want a step-up problem", res)
+	}
+
+	// Replaced recovery codes are rejected.
+	f(http.MethodPost, "/api/auth/totp/verify", `{"code":"`+codes[2]+`"}`, http.StatusBadRequest)
+	f(http.MethodPost, "/api/auth/totp/verify", `{"code":"`+recovery.Codes[0]+`"}`, http.StatusNoContent)
+	f(http.MethodDelete, "/api/auth/totp", "", http.StatusNoContent)
+	login("", http.StatusOK)
+
+	// Admins reset the two-factor authentication of users who lost their device.
+	app.EnableTOTP()
+	app.RunCommand("user-reset-totp", "-username=alice")
+	login("", http.StatusOK)
+}
+
+func TestTOTPVerifyThrottle(t *testing.T) {
+	tc := apptest.NewTestCase(t)
+	defer tc.Stop()
+
+	app := apptest.StartApp(tc, "-auth.lockoutThreshold=3", "-auth.lockoutDuration=1h")
+	app.Login("alice", "admin")
+	secret, _ := app.EnableTOTP()
+
+	verify := func(code string, wantStatus int) (string, string) {
+		t.Helper()
+		res, statusCode, h := app.Cli.Do(t, http.MethodPost, app.BaseURL+"/api/auth/totp/verify", []byte(`{"code":"`+code+`"}`),
+			http.Header{"Content-Type": {"application/json"}})
+		if statusCode != wantStatus {
+			t.Fatalf("verify %q: unexpected status code: got %d, want %d, resp body: %s", code, statusCode, wantStatus, res)
+		}
+		return res, h.Get("Retry-After")
+	}
+
+	// Sessions cannot guess codes past the lockout threshold, even with the right code.
+	for range 3 {
+		verify("000000", http.StatusBadRequest)
+	}
+	res, retryAfter := verify(totp.Code(secret, time.Now().Add(totp.Period)), http.StatusTooManyRequests)
+	if !strings.Contains(res, "urn:adequate:problem:login-throttled") || (retryAfter != "3600" && retryAfter != "3599") {
+		t.Fatalf("unexpected throttled response %s with Retry-After %q", res, retryAfter)
+	}
+
+	// The session verified its second factor when enabling it, so it can unlock the verifications of its user.
+	res, statusCode, _ := app.Cli.Do(t, http.MethodDelete, app.BaseURL+"/api/admin/lockouts/mfa/Alice", nil, nil)
+	if statusCode != http.StatusNoContent {
+		t.Fatalf("cannot unlock the verifications of alice: status code %d, resp body: %s", statusCode, res)
+	}
+	verify(totp.Code(secret, time.Now().Add(totp.Period)), http.StatusNoContent)
+}
@@ -0,0 +1,55 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/AltSoyuz/adequate/apptest"
+)
+
+func TestTracing(t *testing.T) {
+	tc := apptest.NewTestCase(t)
+	defer tc.Stop()
+
+	collector := apptest.NewCollector(tc)
+	app := apptest.StartApp(tc, "-tracing.otlpEndpoint="+collector.URL, "-tracing.batchInterval=50ms")
+	app.Login("admin", "admin")
+
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+	h := http.Header{}
+	h.Set("traceparent", "00-"+traceID+"-"+spanID+"-01")
+	res, statusCode, _ := app.Cli.Do(t, http.MethodGet, app.BaseURL+"/api/migrations/version", nil, h)
+	if statusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: got %d, want %d, resp body: %s", statusCode, http.StatusOK, res)
+	}
+
+	spans := collector.WaitSpans(traceID, 2)
+	var server, query *apptest.CollectedSpan
+	for i := range spans {
+		switch spans[i].Name {
+		case "GET /api/v1/migrations/version":
+			server = &spans[i]
+		case "GetLastMigrationVersion":
+			query = &spans[i]
+		}
+	}
+	if server == nil || query == nil {
+		t.Fatalf("missing server or query span in %+v", spans)
+	}
+	if server.ParentSpanID != spanID {
+		t.Fatalf("server span parent: got %q, want %q", server.ParentSpanID, spanID)
+	}
+	if got := server.Attr("http.response.status_code"); got != "200" {
+		t.Fatalf("server span status code: got %q, want %q", got, "200")
+	}
+	if query.ParentSpanID != server.SpanID {
+		t.Fatalf("query span parent: got %q, want the server span %q", query.ParentSpanID, server.SpanID)
+	}
+	if got := query.Attr("db.system.name"); got != "sqlite" {
+		t.Fatalf("query span db.system.name: got %q, want %q", got, "sqlite")
+	}
+
+}
@@ -0,0 +1,38 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/AltSoyuz/adequate/apptest"
+)
+
+func TestAPIVersioning(t *testing.T) {
+	tc := apptest.NewTestCase(t)
+	defer tc.Stop()
+
+	app := apptest.StartApp(tc)
+	app.Login("admin", "admin")
+
+	f := func(path, version string, wantStatus int, wantVersion string) {
+		t.Helper()
+		headers := http.Header{}
+		if version != "" {
+			headers.Set("API-Version", version)
+		}
+		res, statusCode, h := app.Cli.Do(t, http.MethodGet, app.BaseURL+path, nil, headers)
+		if statusCode != wantStatus {
+			t.Fatalf("GET %s (API-Version=%q): unexpected status code: got %d, want %d, resp body: %s", path, version, statusCode, wantStatus, res)
+		}
+		if got := h.Get("API-Version"); got != wantVersion {
+			t.Fatalf("GET %s (API-Version=%q): unexpected API-Version response header: got %q, want %q", path, version, got, wantVersion)
+		}
+	}
+
+	f("/api/v1/migrations/version", "", http.StatusOK, "v1")
+
+	// unversioned paths used by the deployed UI are served by the default version
+	f("/api/migrations/version", "", http.StatusOK, "v1")
+	f("/api/migrations/version", "v1", http.StatusOK, "v1")
+	f("/api/migrations/version", "v0", http.StatusBadRequest, "")
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands = []command{
+	{"openapi", "Write the OpenAPI document of the API", runOpenAPI},
+	{"tsgen", "Write the TypeScript API client of the UI", runTSGen},
+	{"maintenance", "Show or switch the maintenance mode", runMaintenance},
+	{"user-add", "Create a user, reading the password from stdin", runUserAdd},
+	{"user-roles", "Set the roles of a user", runUserRoles},
+	{"user-revoke-sessions", "Revoke all the sessions of a user", runUserRevokeSessions},
+	{"user-reset-totp", "Disable the two-factor authentication of a user who lost their device", runUserResetTOTP},
+	{"token", "Create, list or revoke API tokens", runToken},
+	{"keys", "Generate, rotate or list the keys of a keyring, or print its maintenance bypass token", runKeys},
+	{"migrate", "Roll the database back to an older migration version", runMigrate},
+}
+
+// runCommand runs the subcommand with the given name and returns the process exit code.
+func runCommand(name string, args []string) int {
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+		if err := c.run(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return 0
+			}
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			return 1
+		}
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command %q; available commands:\n", name)
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-22s %s\n", c.name, c.summary)
+	}
+	return 2
+}
+
+// appFlags registers the flags of the app on fs for commands working on the database, so they open it as the app does,
+// e.g. with -store.sqlitePath and -db.allowMigrationDrift. Such commands parse fs with envflag.ParseFlagSetErr,
+// so the flags are also read from environment vars.
+func appFlags(fs *flag.FlagSet) {
+	flag.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/AltSoyuz/adequate/internal/maintenance"
+	"github.com/AltSoyuz/adequate/lib/keys"
+)
+
+// keyInfo describes a key of a keyring, without its secret.
+type keyInfo struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Active    bool      `json:"active"`
+}
+
+// runKeys runs the keys generate, rotate, list and bypass-token actions.
+//
+// Keyrings are read from and written to -file, or to stdin and stdout if it is empty, e.g. for -keys.ring.
+func runKeys(args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing action; usage: keys generate|rotate|list|bypass-token [flags]")
+	}
+	action, args := args[0], args[1:]
+
+	flags := flag.NewFlagSet("keys "+action, flag.ContinueOnError)
+	path := flags.String("file", "", "Path to the keyring file, as set with -keys.file. The keyring is read from stdin "+
+		"and written to stdout if empty")
+	var run func() error
+	switch action {
+	case "generate":
+		run = func() error {
+			if *path != "" {
+				if _, err := os.Stat(*path); !errors.Is(err, fs.ErrNotExist) {
+					return fmt.Errorf("keyring %s already exists; rotate it with keys rotate", *path)
+				}
+			}
+			r, err := keys.Generate()
+			if err != nil {
+				return err
+			}
+			return writeKeyring(r, *path)
+		}
+	case "rotate":
+		keep := flags.Int("keep", 0, "Number of retired keys to keep, the newest first. All are kept if zero. "+
+			"Data signed or encrypted with the dropped keys, e.g. TOTP secrets, becomes invalid")
+		run = func() error {
+			r, err := readKeyring(*path)
+			if err != nil {
+				return err
+			}
+			if r, err = r.Rotate(*keep); err != nil {
+				return err
+			}
+			return writeKeyring(r, *path)
+		}
+	case "list":
+		run = func() error {
+			r, err := readKeyring(*path)
+			if err != nil {
+				return err
+			}
+			return printJSON(keyInfos(r))
+		}
+	case "bypass-token":
+		run = func() error {
+			r, err := readKeyring(*path)
+			if err != nil {
+				return err
+			}
+			k := r.Active()
+			return printJSON(map[string]string{"key_id": k.ID, "token": maintenance.BypassToken(k)})
+		}
+	default:
+		return fmt.Errorf("unknown action %q; usage: keys generate|rotate|list|bypass-token [flags]", action)
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	return run()
+}
+
+func readKeyring(path string) (*keys.Ring, error) {
+	if path != "" {
+		return keys.Load(path)
+	}
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read keyring from stdin: %w", err)
+	}
+	return keys.Parse(data)
+}
+
+// writeKeyring saves r to path and prints its keys, or prints r with the secrets if path is empty.
+func writeKeyring(r *keys.Ring, path string) error {
+	if path == "" {
+		data, err := r.Marshal()
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Printf("%s\n", data)
+		return err
+	}
+	if err := r.Save(path); err != nil {
+		return err
+	}
+	return printJSON(keyInfos(r))
+}
+
+func keyInfos(r *keys.Ring) []keyInfo {
+	var infos []keyInfo
+	for i, k := range r.Keys() {
+		infos = append(infos, keyInfo{ID: k.ID, CreatedAt: k.CreatedAt, Active: i == 0})
+	}
+	return infos
+}
@@ -6,27 +6,48 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/AltSoyuz/adequate/internal/audit"
+	"github.com/AltSoyuz/adequate/internal/auth"
+	"github.com/AltSoyuz/adequate/internal/idempotency"
+	"github.com/AltSoyuz/adequate/internal/maintenance"
 	"github.com/AltSoyuz/adequate/internal/migration"
 	"github.com/AltSoyuz/adequate/internal/store"
 	"github.com/AltSoyuz/adequate/lib/buildinfo"
 	"github.com/AltSoyuz/adequate/lib/envflag"
 	"github.com/AltSoyuz/adequate/lib/httpserver"
+	"github.com/AltSoyuz/adequate/lib/keys"
 	"github.com/AltSoyuz/adequate/lib/logger"
+	"github.com/AltSoyuz/adequate/lib/oidc"
+	"github.com/AltSoyuz/adequate/lib/openapi"
+	"github.com/AltSoyuz/adequate/lib/secretbox"
+	"github.com/AltSoyuz/adequate/lib/tracing"
 )
 
 var (
-	httpAddr      = flag.String("http.listenAddr", ":8080", "HTTP listen address")
-	sqlitePath    = flag.String("store.sqlitePath", "data/db", "SQLite database file path")
-	staticDirPath = flag.String("http.staticDir", "", "Static files directory (for serving UI assets)")
+	httpAddr       = flag.String("http.listenAddr", ":8080", "HTTP listen address")
+	sqlitePath     = flag.String("store.sqlitePath", "data/db", "SQLite database file path")
+	staticDirPath  = flag.String("http.staticDir", "", "Static files directory (for serving UI assets)")
+	idempotencyTTL = flag.Duration("http.idempotencyKeyTTL", 24*time.Hour, "How long responses to requests with an Idempotency-Key header are kept for replay")
 )
 
+var apiInfo = openapi.Info{
+	Title:   "Adequate API",
+	Version: "1.0.0",
+}
+
 func main() {
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		os.Exit(runCommand(os.Args[1], os.Args[2:]))
+	}
+
 	envflag.Parse()
 	logger.Init()
 	buildinfo.Init()
+	tracing.Init()
 	startime := time.Now()
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
@@ -35,24 +56,249 @@ func main() {
 	store := store.Init(ctx, *sqlitePath)
 	defer store.Close()
 
-	mux := http.NewServeMux()
+	ring, err := keys.Init()
+	if err != nil {
+		logger.Fatal("keys.init", "err", err)
+	}
+	if ring.Ephemeral() {
+		logger.Warn("no keyring set with -keys.file or -keys.ring; using a random key, " +
+			"so cursors do not survive restarts and two-factor authentication and maintenance bypass tokens are disabled")
+	}
+
+	sw, err := maintenance.NewSwitch(ctx, store)
+	if err != nil {
+		logger.Fatal("maintenance.init", "err", err)
+	}
+	go sw.Run(ctx)
+	maintenanceMiddleware, err := maintenance.Middleware(sw, ring)
+	if err != nil {
+		logger.Fatal("maintenance.init", "err", err)
+	}
+	httpserver.AddStatusHeader(maintenance.StatusHeader, func() string { return string(sw.Mode()) })
+	httpserver.AddReadinessCheck("maintenance", func(ctx context.Context) (string, error) {
+		return string(sw.Mode()), nil
+	})
+	httpserver.AddReadinessCheck("store", func(ctx context.Context) (string, error) {
+		return "ok", store.DB.PingContext(ctx)
+	})
+
+	sso, err := auth.NewOIDCProvider()
+	if err != nil {
+		logger.Fatal("oidc.init", "err", err)
+	}
+	box := auth.NewSecretBox(ring)
+
+	rt := httpserver.NewRouter()
 
-	addRoutes(mux, store)
+	addRoutes(rt, store, sw, sso, box, ring)
 
 	if *staticDirPath != "" {
 		logger.Info("ui app", "prefix", "/", "staticDir", *staticDirPath)
-		mux.HandleFunc("/", httpserver.SPAFileServer(*staticDirPath))
+		rt.Mount("/", auth.RequireUI(httpserver.SPAFileServer(*staticDirPath)))
 	}
 
+	go idempotency.RunCleanup(ctx, store, time.Hour)
+	go auth.RunCleanup(ctx, store, time.Hour)
+	csrf, err := auth.CSRF()
+	if err != nil {
+		logger.Fatal("csrf.init", "err", err)
+	}
+	handler := maintenanceMiddleware(csrf(audit.Middleware(auth.Middleware(store)(idempotency.Middleware(store, *idempotencyTTL)(rt)))))
+	// Unversioned API paths are rewritten first, so the limiter and all the middlewares see the path the router serves.
+	handler = rt.NegotiateVersion(httpserver.LimitConcurrency(handler))
+
 	logger.Info("started app", "duration", time.Since(startime).String())
 
-	if err := httpserver.Serve(ctx, *httpAddr, mux); err != nil {
+	if err := httpserver.Serve(ctx, *httpAddr, handler); err != nil {
 		logger.Fatal("http serve", "err", err)
 	}
+	tracing.Stop()
 
 	logger.Info("graceful shutdown completed")
 }
 
-func addRoutes(mux *http.ServeMux, store *store.Store) {
-	mux.HandleFunc("/api/migrations/version", migration.MigrationHandler(store))
+// addRoutes registers the API routes on rt.
+//
+// It is also called with a nil store by commands which only need the route descriptions,
+// so it must not use its dependencies outside the handlers.
+//
+// Unversioned /api/ paths are served by the version from the API-Version request header, or by v1.
+//
+// Routes with a httpserver.Permission option are only served to users whose roles grant the permission.
+// Routes wrapped with auth.RequireStepUp also need a recent second factor verification in the session.
+func addRoutes(rt *httpserver.Router, store *store.Store, sw *maintenance.Switch, sso *oidc.Provider, box *secretbox.Box, ring *keys.Ring) {
+	stepUp := auth.RequireStepUp(store)
+
+	rt.SetAuthorizer(auth.Authorize(store))
+	v1 := rt.Version(httpserver.APIVersion{Name: "v1"})
+	rt.SetDefaultVersion("v1")
+
+	v1.HandleFunc(http.MethodGet, "/migrations/version", migration.MigrationHandler(store),
+		httpserver.Name("getMigrationVersion"),
+		httpserver.Summary("Returns the version of the last applied migration"),
+		httpserver.Permission(auth.PermMigrationsRead),
+		httpserver.Response[migration.MigrationHandlerResp](http.StatusOK),
+	)
+	v1.HandleFunc(http.MethodGet, "/migrations", migration.MigrationListHandler(store, httpserver.NewCursors(ring, "migrations")),
+		httpserver.Name("listMigrations"),
+		httpserver.Summary("Lists the applied migrations"),
+		httpserver.Query("limit", "Maximum number of items to return"),
+		httpserver.Query("cursor", "Cursor from the next_cursor field of the previous page"),
+		httpserver.Permission(auth.PermMigrationsRead),
+		httpserver.Response[httpserver.Page[migration.MigrationListItem]](http.StatusOK),
+	)
+
+	v1.HandleFunc(http.MethodPost, "/auth/login", auth.LoginHandler(store, box),
+		httpserver.Name("login"),
+		httpserver.Summary("Checks the credentials and starts a session in an HttpOnly cookie"),
+		httpserver.Request[auth.LoginRequest](),
+		httpserver.Response[auth.User](http.StatusOK),
+	)
+	v1.HandleFunc(http.MethodPost, "/auth/logout", auth.LogoutHandler(store),
+		httpserver.Name("logout"),
+		httpserver.Summary("Ends the current session"),
+		httpserver.Status(http.StatusNoContent),
+	)
+	v1.HandleFunc(http.MethodGet, "/auth/config", auth.AuthConfigHandler(sso),
+		httpserver.Name("getAuthConfig"),
+		httpserver.Summary("Returns the login methods of the app"),
+		httpserver.Response[auth.AuthConfig](http.StatusOK),
+	)
+	if sso != nil {
+		// Single sign-on redirects the browser rather than returning JSON, so it is not part of the API contract.
+		rt.Mount("GET "+auth.OIDCLoginPath, auth.OIDCLoginHandler(store, sso))
+		rt.Mount("GET "+auth.OIDCCallbackPath, auth.OIDCCallbackHandler(store, sso))
+	}
+	v1.HandleFunc(http.MethodPost, "/auth/mfa", auth.MFAChallengeHandler(store, box),
+		httpserver.Name("completeMFALogin"),
+		httpserver.Summary("Completes a login waiting for a two-factor code, such as a single sign-on, and starts a session"),
+		httpserver.Request[auth.TOTPCodeRequest](),
+		httpserver.Response[auth.User](http.StatusOK),
+	)
+	v1.HandleFunc(http.MethodGet, "/auth/totp", auth.TOTPStatusHandler(store),
+		httpserver.Name("getTOTPStatus"),
+		httpserver.Summary("Returns the two-factor authentication status of the current user"),
+		httpserver.Response[auth.TOTPStatus](http.StatusOK),
+	)
+	v1.HandleFunc(http.MethodPost, "/auth/totp/enroll", auth.TOTPEnrollHandler(store, box),
+		httpserver.Name("enrollTOTP"),
+		httpserver.Summary("Generates a TOTP secret to add to an authenticator app, replacing any unconfirmed one. Needs the current password"),
+		httpserver.Request[auth.TOTPEnrollRequest](),
+		httpserver.Response[auth.TOTPEnrollment](http.StatusOK),
+	)
+	v1.HandleFunc(http.MethodPost, "/auth/totp/enable", auth.TOTPEnableHandler(store, box),
+		httpserver.Name("enableTOTP"),
+		httpserver.Summary("Enables two-factor authentication with a first code of the enrolled secret and the current password, and returns recovery codes"),
+		httpserver.Request[auth.TOTPEnableRequest](),
+		httpserver.Response[auth.RecoveryCodes](http.StatusOK),
+	)
+	v1.HandleFunc(http.MethodPost, "/auth/totp/verify", auth.TOTPVerifyHandler(store, box),
+		httpserver.Name("verifyTOTP"),
+		httpserver.Summary("Checks a TOTP or recovery code, so the session can call sensitive endpoints. Failed codes are throttled"),
+		httpserver.Request[auth.TOTPCodeRequest](),
+		httpserver.Status(http.StatusNoContent),
+	)
+	v1.Handle(http.MethodPost, "/auth/totp/recovery-codes", stepUp(auth.RecoveryCodesHandler(store)),
+		httpserver.Name("regenerateRecoveryCodes"),
+		httpserver.Summary("Replaces the recovery codes. Requires a recent second factor verification"),
+		httpserver.Response[auth.RecoveryCodes](http.StatusOK),
+	)
+	v1.Handle(http.MethodDelete, "/auth/totp", stepUp(auth.TOTPDisableHandler(store)),
+		httpserver.Name("disableTOTP"),
+		httpserver.Summary("Disables two-factor authentication. Requires a recent second factor verification"),
+		httpserver.Status(http.StatusNoContent),
+	)
+	v1.Handle(http.MethodGet, "/me", auth.Require(auth.MeHandler()),
+		httpserver.Name("getMe"),
+		httpserver.Summary("Returns the user of the current session"),
+		httpserver.Response[auth.User](http.StatusOK),
+	)
+	v1.HandleFunc(http.MethodGet, "/me/permissions", auth.MyPermissionsHandler(store),
+		httpserver.Name("getMyPermissions"),
+		httpserver.Summary("Returns the roles of the current user and the permissions they grant"),
+		httpserver.Response[auth.MyPermissions](http.StatusOK),
+	)
+
+	// Admin routes are not versioned.
+	admin := rt.Group("/api/admin")
+	admin.HandleFunc(http.MethodGet, "/maintenance", maintenance.GetHandler(sw),
+		httpserver.Name("getMaintenance"),
+		httpserver.Summary("Returns the maintenance state"),
+		httpserver.Permission(auth.PermMaintenanceRead),
+		httpserver.Response[maintenance.MaintenanceState](http.StatusOK),
+	)
+	admin.HandleFunc(http.MethodPut, "/maintenance", maintenance.SetHandler(sw),
+		httpserver.Name("setMaintenance"),
+		httpserver.Summary("Switches the maintenance mode"),
+		httpserver.Permission(auth.PermMaintenanceWrite),
+		httpserver.Request[maintenance.SetMaintenanceRequest](),
+		httpserver.Response[maintenance.MaintenanceState](http.StatusOK),
+	)
+
+	admin.HandleFunc(http.MethodGet, "/tokens", auth.ListAPITokensHandler(store),
+		httpserver.Name("listAPITokens"),
+		httpserver.Summary("Lists the API tokens, including expired and revoked ones"),
+		httpserver.Permission(auth.PermTokensManage),
+		httpserver.Response[[]auth.APIToken](http.StatusOK),
+	)
+	admin.Handle(http.MethodPost, "/tokens", stepUp(auth.CreateAPITokenHandler(store)),
+		httpserver.Name("createAPIToken"),
+		httpserver.Summary("Creates an API token of the current user and returns its secret, which cannot be retrieved later. "+
+			"Sessions require a recent second factor verification"),
+		httpserver.Permission(auth.PermTokensManage),
+		httpserver.Request[auth.CreateAPITokenRequest](),
+		httpserver.Response[auth.CreatedAPIToken](http.StatusCreated),
+	)
+	admin.Handle(http.MethodPost, "/users/{username}/tokens", stepUp(auth.IssueAPITokenHandler(store)),
+		httpserver.Name("issueAPIToken"),
+		httpserver.Summary("Creates an API token of another user and returns its secret, which cannot be retrieved later. "+
+			"Only sessions with a recent second factor verification issue tokens, and the admin scope needs a user with permissions"),
+		httpserver.Permission(auth.PermTokensManage),
+		httpserver.Request[auth.CreateAPITokenRequest](),
+		httpserver.Response[auth.CreatedAPIToken](http.StatusCreated),
+	)
+	admin.Handle(http.MethodDelete, "/tokens/{id}", stepUp(auth.RevokeAPITokenHandler(store)),
+		httpserver.Name("revokeAPIToken"),
+		httpserver.Summary("Revokes an API token. Sessions require a recent second factor verification"),
+		httpserver.Permission(auth.PermTokensManage),
+		httpserver.Status(http.StatusNoContent),
+	)
+
+	admin.HandleFunc(http.MethodGet, "/lockouts", auth.ListLockoutsHandler(store),
+		httpserver.Name("listLockouts"),
+		httpserver.Summary("Lists the accounts, client IPs and users whose logins or codes are rejected after failures"),
+		httpserver.Permission(auth.PermLockoutsManage),
+		httpserver.Response[[]auth.Lockout](http.StatusOK),
+	)
+	admin.Handle(http.MethodDelete, "/lockouts/{kind}/{name}", stepUp(auth.UnlockHandler(store)),
+		httpserver.Name("unlock"),
+		httpserver.Summary("Forgets the failed logins of an account (kind user), a client IP (kind ip) or the failed codes of a user (kind mfa), "+
+			"so it can log in again. "+
+			"Requires a recent second factor verification"),
+		httpserver.Permission(auth.PermLockoutsManage),
+		httpserver.Status(http.StatusNoContent),
+	)
+
+	admin.HandleFunc(http.MethodGet, "/audit-log", audit.ListHandler(store, httpserver.NewCursors(ring, "audit-log")),
+		httpserver.Name("listAuditLog"),
+		httpserver.Summary("Lists the audit log of changes, newest first"),
+		httpserver.Query("action", "Only return the entries of this action, e.g. token.create"),
+		httpserver.Query("actor", "Only return the entries of changes made by the user with this username"),
+		httpserver.Query("target", "Only return the entries of this target, e.g. user:alice"),
+		httpserver.Query("since", "Only return the entries from this RFC 3339 time"),
+		httpserver.Query("until", "Only return the entries before this RFC 3339 time"),
+		httpserver.Query("limit", "Maximum number of items to return"),
+		httpserver.Query("cursor", "Cursor from the next_cursor field of the previous page"),
+		httpserver.Permission(auth.PermAuditRead),
+		httpserver.Response[httpserver.Page[audit.AuditEntry]](http.StatusOK),
+	)
+
+	admin.HandleFunc(http.MethodGet, "/slow-requests", httpserver.SlowRequestsHandler(),
+		httpserver.Name("listSlowRequests"),
+		httpserver.Summary("Lists the slowest requests since the start, with their per-phase breakdown"),
+		httpserver.Permission(auth.PermDiagnosticsRead),
+		httpserver.Response[[]httpserver.SlowRequest](http.StatusOK),
+	)
+
+	rt.Mount("GET /api/openapi.json", openapi.Handler(apiInfo, rt.Routes))
 }
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"github.com/AltSoyuz/adequate/internal/maintenance"
+	"github.com/AltSoyuz/adequate/internal/store"
+	"github.com/AltSoyuz/adequate/lib/envflag"
+)
+
+func runMaintenance(args []string) error {
+	fs := flag.NewFlagSet("maintenance", flag.ContinueOnError)
+	appFlags(fs)
+	mode := fs.String("mode", "", "Maintenance mode to switch to: off, read_only or full. The current state is printed if empty")
+	reason := fs.String("reason", "", "Reason of the maintenance, reported to clients")
+	if err := envflag.ParseFlagSetErr(fs, args); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	s := store.Init(ctx, *sqlitePath)
+	defer s.Close()
+
+	var (
+		st  maintenance.MaintenanceState
+		err error
+	)
+	if *mode == "" {
+		st, err = maintenance.Get(ctx, s)
+	} else {
+		var m maintenance.Mode
+		m, err = maintenance.ParseMode(*mode)
+		if err != nil {
+			return err
+		}
+		st, err = maintenance.Set(ctx, s, m, *reason)
+	}
+	if err != nil {
+		return err
+	}
+
+	return printJSON(st)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/AltSoyuz/adequate/internal/store"
+	"github.com/AltSoyuz/adequate/lib/envflag"
+)
+
+// runMigrate runs the migrate down action, which rolls the database back before a release is rolled back.
+func runMigrate(args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing action; usage: migrate down -to=N [flags]")
+	}
+	action, args := args[0], args[1:]
+	if action != "down" {
+		return fmt.Errorf("unknown action %q; usage: migrate down -to=N [flags]", action)
+	}
+
+	fs := flag.NewFlagSet("migrate down", flag.ContinueOnError)
+	appFlags(fs)
+	to := fs.Int("to", -1, "Version to roll the database back to. The migrations applied after it are reverted "+
+		"with their down scripts, newest first; nothing is reverted if one of them has no down script")
+	if err := envflag.ParseFlagSetErr(fs, args); err != nil {
+		return err
+	}
+	if *to < 0 {
+		return errors.New("missing -to")
+	}
+
+	// The store is not migrated up first, so the migrations of a newer binary are not applied only to be reverted.
+	ctx := context.Background()
+	s := store.Open(ctx, *sqlitePath)
+	defer s.Close()
+
+	if err := s.MigrateDown(ctx, *to); err != nil {
+		return err
+	}
+	version, err := s.Queries.GetLastMigrationVersion(ctx)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	return printJSON(map[string]any{"version": version})
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/AltSoyuz/adequate/lib/httpserver"
+	"github.com/AltSoyuz/adequate/lib/openapi"
+)
+
+func runOpenAPI(args []string) error {
+	fs := flag.NewFlagSet("openapi", flag.ContinueOnError)
+	out := fs.String("out", "", "Path to write the OpenAPI document to. The document is written to stdout if empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	rt := httpserver.NewRouter()
+	addRoutes(rt, nil, nil, nil, nil, nil)
+
+	b, err := openapi.Marshal(openapi.Build(apiInfo, rt.Routes()))
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		_, err := os.Stdout.Write(b)
+		return err
+	}
+	return os.WriteFile(*out, b, 0644)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/AltSoyuz/adequate/internal/auth"
+	"github.com/AltSoyuz/adequate/internal/store"
+	"github.com/AltSoyuz/adequate/lib/envflag"
+)
+
+// runToken runs the token create, list and revoke actions.
+func runToken(args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing action; usage: token create|list|revoke [flags]")
+	}
+	action, args := args[0], args[1:]
+
+	fs := flag.NewFlagSet("token "+action, flag.ContinueOnError)
+	appFlags(fs)
+	var run func(ctx context.Context, s *store.Store) error
+	switch action {
+	case "create":
+		username := fs.String("username", "", "Name of the user owning the token")
+		name := fs.String("name", "", "Name describing the token usage, such as ci")
+		scopes := fs.String("scopes", auth.ScopeRead, "Comma-separated scopes of the token: read, write, admin")
+		expiresInDays := fs.Int("expiresInDays", 90, "Lifetime of the token in days. The token never expires if zero")
+		run = func(ctx context.Context, s *store.Store) error {
+			sc, err := auth.ParseScopes(*scopes)
+			if err != nil {
+				return err
+			}
+			t, err := auth.CreateAPIToken(ctx, s, *username, auth.CreateAPITokenRequest{
+				Name:          *name,
+				Scopes:        sc,
+				ExpiresInDays: *expiresInDays,
+			})
+			if err != nil {
+				return err
+			}
+			return printJSON(t)
+		}
+	case "list":
+		run = func(ctx context.Context, s *store.Store) error {
+			tokens, err := auth.ListAPITokens(ctx, s)
+			if err != nil {
+				return err
+			}
+			return printJSON(tokens)
+		}
+	case "revoke":
+		id := fs.String("id", "", "ID of the token to revoke")
+		run = func(ctx context.Context, s *store.Store) error {
+			n, err := strconv.ParseInt(*id, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid -id %q", *id)
+			}
+			if err := auth.RevokeAPIToken(ctx, s, n); err != nil {
+				return err
+			}
+			return printJSON(map[string]any{"id": n, "revoked": true})
+		}
+	default:
+		return fmt.Errorf("unknown action %q; usage: token create|list|revoke [flags]", action)
+	}
+	if err := envflag.ParseFlagSetErr(fs, args); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	s := store.Init(ctx, *sqlitePath)
+	defer s.Close()
+	return run(ctx, s)
+}
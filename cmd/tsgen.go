@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/AltSoyuz/adequate/lib/httpserver"
+	"github.com/AltSoyuz/adequate/lib/tsgen"
+)
+
+func runTSGen(args []string) error {
+	fs := flag.NewFlagSet("tsgen", flag.ContinueOnError)
+	out := fs.String("out", "ui/src/lib/api.ts", "Path to write the TypeScript API client to")
+	check := fs.Bool("check", false, "Whether to fail if the file at -out differs from the generated client instead of writing it")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	rt := httpserver.NewRouter()
+	addRoutes(rt, nil, nil, nil, nil, nil)
+
+	b, err := tsgen.Generate(rt.Routes(), "./api-client")
+	if err != nil {
+		return err
+	}
+
+	if *check {
+		cur, err := os.ReadFile(*out)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(cur, b) {
+			return fmt.Errorf("%s is stale; run `make tsgen` and commit the result", *out)
+		}
+		return nil
+	}
+	return os.WriteFile(*out, b, 0644)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/AltSoyuz/adequate/internal/auth"
+	"github.com/AltSoyuz/adequate/internal/store"
+	"github.com/AltSoyuz/adequate/lib/envflag"
+)
+
+func runUserAdd(args []string) error {
+	fs := flag.NewFlagSet("user-add", flag.ContinueOnError)
+	appFlags(fs)
+	username := fs.String("username", "", "Name of the user to create")
+	roles := fs.String("roles", "", "Comma-separated roles of the user, e.g. admin")
+	if err := envflag.ParseFlagSetErr(fs, args); err != nil {
+		return err
+	}
+	if *username == "" {
+		return errors.New("missing -username")
+	}
+
+	// The password is read from stdin rather than a flag, so it does not leak into the process list or the shell history.
+	fmt.Fprintf(os.Stderr, "Password for %s: ", *username)
+	pass, err := readLine(os.Stdin)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return fmt.Errorf("cannot read password: %w", err)
+	}
+
+	ctx := context.Background()
+	s := store.Init(ctx, *sqlitePath)
+	defer s.Close()
+
+	// Roles are checked first, so a typo does not leave a user without roles behind.
+	rs := auth.ParseRoles(*roles)
+	if err := auth.CheckRoles(ctx, s, rs); err != nil {
+		return err
+	}
+	u, err := auth.CreateUser(ctx, s, *username, pass)
+	if err != nil {
+		return err
+	}
+	if err := auth.SetUserRoles(ctx, s, u.Username, rs); err != nil {
+		return err
+	}
+	return printJSON(u)
+}
+
+func runUserRoles(args []string) error {
+	fs := flag.NewFlagSet("user-roles", flag.ContinueOnError)
+	appFlags(fs)
+	username := fs.String("username", "", "Name of the user whose roles are set")
+	roles := fs.String("roles", "", "Comma-separated roles replacing the roles of the user. Empty removes all the roles")
+	if err := envflag.ParseFlagSetErr(fs, args); err != nil {
+		return err
+	}
+	if *username == "" {
+		return errors.New("missing -username")
+	}
+
+	ctx := context.Background()
+	s := store.Init(ctx, *sqlitePath)
+	defer s.Close()
+
+	rs := auth.ParseRoles(*roles)
+	if err := auth.SetUserRoles(ctx, s, *username, rs); err != nil {
+		return err
+	}
+	return printJSON(map[string]any{"username": *username, "roles": append([]string{}, rs...)})
+}
+
+func runUserRevokeSessions(args []string) error {
+	fs := flag.NewFlagSet("user-revoke-sessions", flag.ContinueOnError)
+	appFlags(fs)
+	username := fs.String("username", "", "Name of the user whose sessions are revoked")
+	if err := envflag.ParseFlagSetErr(fs, args); err != nil {
+		return err
+	}
+	if *username == "" {
+		return errors.New("missing -username")
+	}
+
+	ctx := context.Background()
+	s := store.Init(ctx, *sqlitePath)
+	defer s.Close()
+
+	n, err := auth.RevokeUserSessions(ctx, s, *username)
+	if err != nil {
+		return err
+	}
+	return printJSON(map[string]any{"username": *username, "revoked": n})
+}
+
+func runUserResetTOTP(args []string) error {
+	fs := flag.NewFlagSet("user-reset-totp", flag.ContinueOnError)
+	appFlags(fs)
+	username := fs.String("username", "", "Name of the user whose two-factor authentication is disabled")
+	if err := envflag.ParseFlagSetErr(fs, args); err != nil {
+		return err
+	}
+	if *username == "" {
+		return errors.New("missing -username")
+	}
+
+	ctx := context.Background()
+	s := store.Init(ctx, *sqlitePath)
+	defer s.Close()
+
+	if err := auth.ResetUserTOTP(ctx, s, *username); err != nil {
+		return err
+	}
+	return printJSON(map[string]any{"username": *username, "totp": "disabled"})
+}
+
+func readLine(r io.Reader) (string, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
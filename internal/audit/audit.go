@@ -0,0 +1,231 @@
+// Package audit records who changed what, for compliance.
+//
+// Entries are written with Record in the transaction of the change they describe, so they commit or roll back with it.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/AltSoyuz/adequate/internal/store"
+	"github.com/AltSoyuz/adequate/internal/store/dal"
+	"github.com/AltSoyuz/adequate/lib/httpserver"
+	"github.com/AltSoyuz/adequate/lib/metrics"
+)
+
+// Actions of the audit entries.
+const (
+	ActionUserCreate         = "user.create"
+	ActionUserRoles          = "user.roles"
+	ActionUserSessionsRevoke = "user.sessions.revoke"
+	ActionUserIdentityLink   = "user.identity.link"
+	ActionTokenCreate        = "token.create"
+	ActionTokenIssue         = "token.issue"
+	ActionTokenRevoke        = "token.revoke"
+	ActionTOTPEnable         = "totp.enable"
+	ActionTOTPDisable        = "totp.disable"
+	ActionRecoveryCodesRenew = "totp.recovery_codes.renew"
+	ActionMaintenanceSet     = "maintenance.set"
+	ActionLoginLock          = "login.lock"
+	ActionLoginUnlock        = "login.unlock"
+)
+
+var entriesTotal = metrics.NewCounter("audit_entries_total")
+
+// AuditChange is the old and new value of a changed field. From is nil for created fields, and To for deleted ones.
+type AuditChange struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+// Diff maps the changed fields of a target to their change.
+//
+// It must not hold secrets, e.g. tokens or TOTP secrets, since the audit log is readable by admins.
+type Diff map[string]AuditChange
+
+// AuditEntry is an audit log entry, as returned by the admin API.
+type AuditEntry struct {
+	ID   int64     `json:"id"`
+	Time time.Time `json:"time"`
+	// Actor is the username of the user who made the change. It is empty for changes made with the app commands.
+	Actor        string `json:"actor,omitempty"`
+	ActorUserID  int64  `json:"actor_user_id,omitempty"`
+	ActorTokenID int64  `json:"actor_token_id,omitempty"`
+	Action       string `json:"action"`
+	// Target identifies the changed object, e.g. user:alice or token:42.
+	Target    string `json:"target"`
+	Diff      Diff   `json:"diff,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+	IP        string `json:"ip,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+}
+
+// Actor is the authenticated client making changes.
+type Actor struct {
+	UserID   int64
+	Username string
+	// TokenID is the ID of the API token of the request, or zero for sessions.
+	TokenID int64
+}
+
+type actorKey struct{}
+
+// WithActor attaches the actor of the request to ctx, for Record.
+func WithActor(ctx context.Context, a Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, a)
+}
+
+type request struct {
+	id        string
+	ip        string
+	userAgent string
+}
+
+type requestKey struct{}
+
+// Middleware attaches the request ID, client IP and user agent of requests to their context, for Record.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := request{id: r.Header.Get("X-Request-Id"), ip: httpserver.ClientIP(r), userAgent: r.UserAgent()}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestKey{}, req)))
+	})
+}
+
+// Record adds an entry for the change of target by action, with the actor and the request of ctx.
+//
+// It must be called in store.WithTx with the context of the transaction, so the entry commits atomically with the change.
+func Record(ctx context.Context, action, target string, diff Diff) error {
+	q := store.TxQueries(ctx)
+	if q == nil {
+		return fmt.Errorf("BUG: audit.Record(%q) must be called in store.WithTx", action)
+	}
+	var b []byte
+	if len(diff) > 0 {
+		var err error
+		if b, err = json.Marshal(diff); err != nil {
+			return fmt.Errorf("cannot encode audit diff: %w", err)
+		}
+	}
+	actor, _ := ctx.Value(actorKey{}).(Actor)
+	req, _ := ctx.Value(requestKey{}).(request)
+	err := q.CreateAuditEntry(ctx, dal.CreateAuditEntryParams{
+		CreatedAt:     time.Now().Unix(),
+		ActorUserID:   actor.UserID,
+		ActorUsername: actor.Username,
+		ActorTokenID:  actor.TokenID,
+		Action:        action,
+		Target:        target,
+		Diff:          string(b),
+		RequestID:     req.id,
+		Ip:            req.ip,
+		UserAgent:     req.userAgent,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot record audit entry: %w", err)
+	}
+	entriesTotal.Inc()
+	return nil
+}
+
+// ListHandler returns the audit log, newest first.
+//
+// The action, actor and target query params filter entries by exact match,
+// and since and until by time, as RFC 3339 timestamps.
+func ListHandler(s *store.Store, cursors *httpserver.Cursors) http.HandlerFunc {
+	type auditCursor struct {
+		ID int64 `json:"id"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		after := auditCursor{ID: math.MaxInt64}
+		limit, _, err := cursors.ParsePage(r, 50, 500, &after)
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		q := r.URL.Query()
+		since, err := parseTime(q.Get("since"), 0)
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusBadRequest, fmt.Errorf("invalid since: %w", err))
+			return
+		}
+		until, err := parseTime(q.Get("until"), math.MaxInt64)
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusBadRequest, fmt.Errorf("invalid until: %w", err))
+			return
+		}
+
+		rows, err := s.Queries.ListAuditEntries(ctx, dal.ListAuditEntriesParams{
+			BeforeID: after.ID,
+			Action:   strings.TrimSpace(q.Get("action")),
+			Actor:    strings.TrimSpace(q.Get("actor")),
+			Target:   strings.TrimSpace(q.Get("target")),
+			Since:    since,
+			Until:    until,
+			Limit:    int64(limit + 1),
+		})
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		items := make([]AuditEntry, 0, len(rows))
+		for _, row := range rows {
+			e, err := entryFromRow(row)
+			if err != nil {
+				httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			items = append(items, e)
+		}
+		page, err := httpserver.NewPage(cursors, items, limit, func(e AuditEntry) any {
+			return auditCursor{ID: e.ID}
+		})
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		httpserver.WriteJSON(w, r, http.StatusOK, page)
+	}
+}
+
+func entryFromRow(row dal.AuditLog) (AuditEntry, error) {
+	e := AuditEntry{
+		ID:           row.ID,
+		Time:         time.Unix(row.CreatedAt, 0).UTC(),
+		Actor:        row.ActorUsername,
+		ActorUserID:  row.ActorUserID,
+		ActorTokenID: row.ActorTokenID,
+		Action:       row.Action,
+		Target:       row.Target,
+		RequestID:    row.RequestID,
+		IP:           row.Ip,
+		UserAgent:    row.UserAgent,
+	}
+	if row.Diff != "" {
+		if err := json.Unmarshal([]byte(row.Diff), &e.Diff); err != nil {
+			return AuditEntry{}, fmt.Errorf("cannot decode diff of audit entry %d: %w", row.ID, err)
+		}
+	}
+	return e, nil
+}
+
+// parseTime parses the RFC 3339 timestamp s into Unix seconds, or returns def if s is empty.
+func parseTime(s string, def int64) (int64, error) {
+	if s == "" {
+		return def, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, errors.New("must be an RFC 3339 timestamp, e.g. 2006-01-02T15:04:05Z")
+	}
+	return t.Unix(), nil
+}
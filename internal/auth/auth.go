@@ -0,0 +1,290 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/AltSoyuz/adequate/internal/audit"
+	"github.com/AltSoyuz/adequate/internal/store"
+	"github.com/AltSoyuz/adequate/internal/store/dal"
+	"github.com/AltSoyuz/adequate/lib/httpserver"
+	"github.com/AltSoyuz/adequate/lib/logger"
+	"github.com/AltSoyuz/adequate/lib/metrics"
+	"github.com/AltSoyuz/adequate/lib/password"
+	"github.com/AltSoyuz/adequate/lib/secretbox"
+)
+
+// User is an authenticated user, as returned by the API.
+type User struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+}
+
+// LoginRequest is the body of the login request.
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// Code is the TOTP or recovery code of users with two-factor authentication.
+	Code string `json:"code,omitempty"`
+}
+
+// ErrInvalidCredentials is returned by Authenticate for unknown users and wrong passwords alike,
+// so clients cannot tell which usernames exist.
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+const (
+	maxUsernameLen    = 64
+	minPasswordLen    = 8
+	maxPasswordLength = 1024
+)
+
+var (
+	loginsTotal       = metrics.NewCounter(`auth_logins_total{result="success"}`)
+	failedLoginsTotal = metrics.NewCounter(`auth_logins_total{result="failure"}`)
+)
+
+// CreateUser creates a user with the given username and password.
+func CreateUser(ctx context.Context, s *store.Store, username, pass string) (User, error) {
+	username = strings.TrimSpace(username)
+	if err := validateUsername(username); err != nil {
+		return User{}, err
+	}
+	if len(pass) < minPasswordLen || len(pass) > maxPasswordLength {
+		return User{}, fmt.Errorf("password must have between %d and %d characters", minPasswordLen, maxPasswordLength)
+	}
+	hash, err := password.Hash(pass)
+	if err != nil {
+		return User{}, fmt.Errorf("cannot hash password: %w", err)
+	}
+	var u User
+	err = store.WithTx(ctx, s.DB, func(ctx context.Context, q *dal.Queries) error {
+		u, err = insertUser(ctx, q, username, hash)
+		return err
+	})
+	return u, err
+}
+
+// insertUser creates a user in the transaction of ctx. Users without password hash can only log in with single sign-on.
+func insertUser(ctx context.Context, q *dal.Queries, username, passwordHash string) (User, error) {
+	now := time.Now().Unix()
+	id, err := q.CreateUser(ctx, dal.CreateUserParams{
+		Username:     username,
+		PasswordHash: passwordHash,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return User{}, fmt.Errorf("user %q already exists", username)
+		}
+		return User{}, fmt.Errorf("cannot create user: %w", err)
+	}
+	err = audit.Record(ctx, audit.ActionUserCreate, userTarget(username), audit.Diff{
+		"username": {To: username},
+		"password": {To: passwordHash != ""},
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return User{ID: id, Username: username}, nil
+}
+
+// userTarget returns the audit target of the user with the given username.
+func userTarget(username string) string {
+	return "user:" + username
+}
+
+func validateUsername(username string) error {
+	if username == "" || len(username) > maxUsernameLen {
+		return fmt.Errorf("username must have between 1 and %d characters", maxUsernameLen)
+	}
+	for _, r := range username {
+		if unicode.IsSpace(r) || !unicode.IsPrint(r) {
+			return fmt.Errorf("username %q must not contain spaces or control characters", username)
+		}
+	}
+	return nil
+}
+
+// dummyHash is verified for unknown users, so they take as long to reject as wrong passwords.
+var dummyHash = sync.OnceValue(func() string {
+	h, err := password.Hash("dummy password")
+	if err != nil {
+		panic(fmt.Errorf("cannot hash dummy password: %w", err))
+	}
+	return h
+})
+
+// Authenticate returns the user with the given username if pass is its password.
+//
+// Password hashes made with weaker settings are upgraded on success.
+func Authenticate(ctx context.Context, s *store.Store, username, pass string) (User, error) {
+	u, err := s.Queries.GetUserByUsername(ctx, strings.TrimSpace(username))
+	// Users created by single sign-on have no password.
+	if errors.Is(err, sql.ErrNoRows) || err == nil && u.PasswordHash == "" || len(pass) > maxPasswordLength {
+		_ = password.Verify(pass, dummyHash())
+		return User{}, ErrInvalidCredentials
+	}
+	if err != nil {
+		return User{}, fmt.Errorf("cannot load user: %w", err)
+	}
+	if err := password.Verify(pass, u.PasswordHash); err != nil {
+		if errors.Is(err, password.ErrMismatch) {
+			return User{}, ErrInvalidCredentials
+		}
+		return User{}, fmt.Errorf("cannot verify password of user %d: %w", u.ID, err)
+	}
+
+	if password.NeedsRehash(u.PasswordHash) {
+		if hash, err := password.Hash(pass); err == nil {
+			_, err = s.Queries.UpdateUserPassword(ctx, dal.UpdateUserPasswordParams{
+				PasswordHash: hash,
+				UpdatedAt:    time.Now().Unix(),
+				ID:           u.ID,
+			})
+			if err != nil {
+				logger.ErrorCtx(ctx, "auth.rehash", "user_id", u.ID, "err", err)
+			}
+		}
+	}
+	return User{ID: u.ID, Username: u.Username}, nil
+}
+
+// LoginHandler checks the credentials of the request and starts a session.
+//
+// Users with two-factor authentication also need a code. Requests without one get 401 Unauthorized
+// with the ProblemMFARequired problem type, so the client can ask for it.
+//
+// Failed logins delay and then lock the next logins of the account and of the client IP; throttled logins get
+// 429 Too Many Requests with the ProblemLoginThrottled problem type, whether or not the user exists.
+func LoginHandler(s *store.Store, box *secretbox.Box) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		req, err := httpserver.DecodeJSON[LoginRequest](r)
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		// Throttled logins are rejected before checking the password, so guesses cannot go on during lockouts.
+		// Others are counted as failed until the password is checked, so concurrent guesses are throttled too.
+		keys := loginKeys(r, req.Username)
+		attempt, retryAfter, err := reserveLoginAttempt(ctx, s, keys)
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		if retryAfter > 0 {
+			throttledLoginsTotal.Inc()
+			logger.InfoCtx(ctx, "auth.login.throttled", "username", req.Username)
+			writeLoginThrottled(w, r, retryAfter, "logins")
+			return
+		}
+
+		u, err := Authenticate(ctx, s, req.Username, req.Password)
+		if errors.Is(err, ErrInvalidCredentials) {
+			failedLoginsTotal.Inc()
+			logger.InfoCtx(ctx, "auth.login.failed", "username", req.Username)
+			if err := attempt.fail(ctx, s); err != nil {
+				httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			httpserver.WriteJSON(w, r, http.StatusUnauthorized, httpserver.ErrResponse{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		mfa, err := totpEnabled(ctx, s, u.ID)
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		if mfa && req.Code == "" {
+			// The password was right, so the attempt is not a failure.
+			if err := attempt.release(ctx, s); err != nil {
+				httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			httpserver.WriteProblem(w, r, httpserver.Problem{
+				Type:   ProblemMFARequired,
+				Title:  "Two-factor code required",
+				Status: http.StatusUnauthorized,
+				Detail: "Enter the code of your authenticator app or a recovery code",
+			})
+			return
+		}
+		if mfa {
+			ok, err := verifySecondFactor(ctx, s, box, u.ID, req.Code)
+			if err != nil {
+				httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			if !ok {
+				failedLoginsTotal.Inc()
+				failedMFAVerificationsTotal.Inc()
+				logger.InfoCtx(ctx, "auth.login.failed", "username", req.Username, "reason", "mfa")
+				if err := attempt.fail(ctx, s); err != nil {
+					httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+					return
+				}
+				httpserver.WriteJSON(w, r, http.StatusUnauthorized, httpserver.ErrResponse{Error: errInvalidCode.Error()})
+				return
+			}
+			mfaVerificationsTotal.Inc()
+		}
+		if err := attempt.release(ctx, s, userLoginKey(u.Username)); err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		// Drop the session the client logged in from, if any.
+		if c, err := r.Cookie(SessionCookie); err == nil {
+			if err := RevokeSession(ctx, s, c.Value); err != nil {
+				logger.ErrorCtx(ctx, "auth.session.revoke", "err", err)
+			}
+		}
+		token, expires, err := CreateSession(ctx, s, u.ID, mfa)
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		setSessionCookie(w, token, expires)
+
+		loginsTotal.Inc()
+		logger.InfoCtx(ctx, "auth.login", "user_id", u.ID, "mfa", mfa)
+		w.Header().Set("Cache-Control", "no-store")
+		httpserver.WriteJSON(w, r, http.StatusOK, u)
+	}
+}
+
+// LogoutHandler revokes the session of the request.
+func LogoutHandler(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie(SessionCookie); err == nil {
+			if err := RevokeSession(r.Context(), s, c.Value); err != nil {
+				httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+				return
+			}
+		}
+		clearSessionCookie(w)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// MeHandler returns the user of the request, authenticated by a session or an API token. It must be wrapped with Require.
+func MeHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		httpserver.WriteJSON(w, r, http.StatusOK, UserFromContext(r.Context()))
+	}
+}
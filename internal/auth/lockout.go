@@ -0,0 +1,341 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AltSoyuz/adequate/internal/audit"
+	"github.com/AltSoyuz/adequate/internal/store"
+	"github.com/AltSoyuz/adequate/internal/store/dal"
+	"github.com/AltSoyuz/adequate/lib/httpserver"
+	"github.com/AltSoyuz/adequate/lib/logger"
+	"github.com/AltSoyuz/adequate/lib/metrics"
+)
+
+var (
+	loginBackoff = flag.Duration("auth.loginBackoff", time.Second, "Delay before the next login of an account after a few failed logins, "+
+		"doubled at each further failure up to -auth.lockoutDuration. Zero disables the delays")
+	lockoutThreshold = flag.Int("auth.lockoutThreshold", 10, "Number of failed logins after which an account is locked for -auth.lockoutDuration "+
+		"or until an admin unlocks it. Client IPs get 10 times as many failures and delays start after 10 times as many, "+
+		"so clients sharing an IP behind a proxy are not locked by a single user. Zero disables lockouts")
+	lockoutDuration = flag.Duration("auth.lockoutDuration", 15*time.Minute, "How long accounts and client IPs stay locked. "+
+		"Failed logins are forgotten after as long without failures nor locks")
+)
+
+// ProblemLoginThrottled is returned by logins of accounts or client IPs with too many recent failed logins,
+// and by second factor verifications of users with too many recent failed codes.
+const ProblemLoginThrottled = "urn:adequate:problem:login-throttled"
+
+// Kinds of the throttled logins.
+const (
+	LockoutKindUser = "user"
+	LockoutKindIP   = "ip"
+	// LockoutKindMFA throttles the second factor verifications of the sessions of a user.
+	LockoutKindMFA = "mfa"
+)
+
+const (
+	// freeLoginFailures is the number of failed logins of an account before the next logins are delayed.
+	freeLoginFailures = 3
+	// ipLoginFailuresFactor multiplies the limits of accounts for client IPs, which may be shared by many users.
+	ipLoginFailuresFactor = 10
+)
+
+var (
+	throttledLoginsTotal = metrics.NewCounter(`auth_logins_total{result="throttled"}`)
+	userLockoutsTotal    = metrics.NewCounter(`auth_lockouts_total{kind="user"}`)
+	ipLockoutsTotal      = metrics.NewCounter(`auth_lockouts_total{kind="ip"}`)
+	mfaLockoutsTotal     = metrics.NewCounter(`auth_lockouts_total{kind="mfa"}`)
+)
+
+// ErrLockoutNotFound is returned by Unlock for accounts and client IPs without failed logins.
+var ErrLockoutNotFound = errors.New("no failed logins for this account or client IP")
+
+// Lockout is an account or client IP whose logins are rejected after failed logins, as returned by the admin API.
+type Lockout struct {
+	// Kind is user for accounts, ip for client IPs and mfa for the second factor verifications of users.
+	Kind string `json:"kind"`
+	// Name is the username of accounts, which may not exist, or of users, or the client IP.
+	Name string `json:"name"`
+	// Failures is the number of recent failed logins.
+	Failures      int64     `json:"failures"`
+	LastFailureAt time.Time `json:"last_failure_at"`
+	// BlockedUntil is when logins are accepted again.
+	BlockedUntil time.Time `json:"blocked_until"`
+	// Locked is true when the failures reached the lockout threshold, rather than only delaying the next login.
+	Locked bool `json:"locked"`
+}
+
+// loginKey identifies the failed logins of an account or a client IP.
+type loginKey struct {
+	kind string
+	name string
+}
+
+// loginKeys returns the keys of a login of username from the client of r.
+func loginKeys(r *http.Request, username string) []loginKey {
+	return []loginKey{userLoginKey(username), {kind: LockoutKindIP, name: httpserver.ClientIP(r)}}
+}
+
+// userLoginKey returns the key of the account of username.
+//
+// Usernames are case-insensitive, so their case variants share the failures of the account.
+func userLoginKey(username string) loginKey {
+	return loginKey{kind: LockoutKindUser, name: strings.ToLower(strings.TrimSpace(username))}
+}
+
+// mfaLoginKey returns the key of the second factor verifications of the user with username.
+//
+// Sessions verifying their second factor passed the password check, so their failures are counted apart from the
+// logins of the account, and a stolen session cannot guess the codes faster than logins would.
+func mfaLoginKey(username string) loginKey {
+	return loginKey{kind: LockoutKindMFA, name: strings.ToLower(username)}
+}
+
+// limits returns the number of failures of k before its logins are delayed, and before they are locked.
+func (k loginKey) limits() (free, threshold int64) {
+	free, threshold = freeLoginFailures, int64(*lockoutThreshold)
+	if k.kind == LockoutKindIP {
+		free, threshold = free*ipLoginFailuresFactor, threshold*ipLoginFailuresFactor
+	}
+	return free, threshold
+}
+
+// loginBlock returns how long logins of k are rejected after the given number of recent failures,
+// and whether the failures lock them.
+func (k loginKey) loginBlock(failures int64) (time.Duration, bool) {
+	free, threshold := k.limits()
+	if threshold > 0 && failures >= threshold {
+		return *lockoutDuration, true
+	}
+	n := failures - free
+	if n <= 0 || *loginBackoff <= 0 {
+		return 0, false
+	}
+	if n > 30 {
+		return *lockoutDuration, false
+	}
+	return min(*loginBackoff<<(n-1), *lockoutDuration), false
+}
+
+// errLoginsBlocked rolls back the reservations of reserveLoginAttempt when one of its keys is blocked.
+var errLoginsBlocked = errors.New("logins are blocked")
+
+// loginAttempt is a login or a verification reserved by reserveLoginAttempt, which counts as failed until released.
+type loginAttempt struct {
+	keys []reservedLoginKey
+}
+
+type reservedLoginKey struct {
+	loginKey
+	failures int64
+	// blockedUntil is the block set by the reservation, or zero.
+	blockedUntil int64
+	locked       bool
+}
+
+// reserveLoginAttempt counts an attempt of keys as failed before it is checked, and delays or locks their next
+// attempts accordingly; see fail and release. It returns in how many seconds attempts are accepted again instead,
+// without counting the attempt, if any of keys is blocked.
+//
+// Reserving attempts up front lets concurrent guesses see each other, so they cannot all pass the check before
+// any failure is recorded.
+func reserveLoginAttempt(ctx context.Context, s *store.Store, keys []loginKey) (*loginAttempt, int64, error) {
+	var a loginAttempt
+	var retryAfter int64
+	err := store.WithTx(ctx, s.DB, func(ctx context.Context, q *dal.Queries) error {
+		now := time.Now()
+		for _, k := range keys {
+			f, err := q.ReserveLoginAttempt(ctx, dal.ReserveLoginAttemptParams{
+				Kind:         k.kind,
+				Name:         k.name,
+				Now:          now.Unix(),
+				ForgetBefore: now.Add(-*lockoutDuration).Unix(),
+			})
+			if errors.Is(err, sql.ErrNoRows) {
+				f, err = q.GetLoginFailures(ctx, dal.GetLoginFailuresParams{Kind: k.kind, Name: k.name})
+				if err != nil {
+					return fmt.Errorf("cannot load failed logins: %w", err)
+				}
+				retryAfter = max(retryAfter, f.BlockedUntil-now.Unix())
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("cannot reserve login attempt: %w", err)
+			}
+			rk := reservedLoginKey{loginKey: k, failures: f.Failures}
+			d, locked := k.loginBlock(f.Failures)
+			if d > 0 {
+				rk.blockedUntil = now.Unix() + int64(math.Ceil(d.Seconds()))
+				rk.locked = locked
+				if err := q.BlockLogins(ctx, dal.BlockLoginsParams{BlockedUntil: rk.blockedUntil, Kind: k.kind, Name: k.name}); err != nil {
+					return fmt.Errorf("cannot block logins: %w", err)
+				}
+			}
+			a.keys = append(a.keys, rk)
+		}
+		if retryAfter > 0 {
+			// Roll back the reservations of the other keys.
+			return errLoginsBlocked
+		}
+		return nil
+	})
+	if errors.Is(err, errLoginsBlocked) {
+		return nil, retryAfter, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return &a, 0, nil
+}
+
+// fail confirms that the attempt failed, and records the lockouts it caused.
+func (a *loginAttempt) fail(ctx context.Context, s *store.Store) error {
+	return store.WithTx(ctx, s.DB, func(ctx context.Context, q *dal.Queries) error {
+		for _, k := range a.keys {
+			if !k.locked {
+				continue
+			}
+			switch k.kind {
+			case LockoutKindIP:
+				ipLockoutsTotal.Inc()
+			case LockoutKindMFA:
+				mfaLockoutsTotal.Inc()
+			default:
+				userLockoutsTotal.Inc()
+			}
+			logger.WarnCtx(ctx, "auth.lockout", "kind", k.kind, "name", k.name, "failures", k.failures)
+			err := audit.Record(ctx, audit.ActionLoginLock, k.kind+":"+k.name, audit.Diff{
+				"blocked_until": {To: time.Unix(k.blockedUntil, 0).UTC()},
+				"failures":      {To: k.failures},
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// release uncounts the attempt after it succeeded, and forgets all the failures of the keys in clear,
+// such as the account of a successful login.
+//
+// Client IPs are never cleared, so attackers cannot reset their failures with an account of their own.
+func (a *loginAttempt) release(ctx context.Context, s *store.Store, clear ...loginKey) error {
+	return store.WithTx(ctx, s.DB, func(ctx context.Context, q *dal.Queries) error {
+		for _, k := range a.keys {
+			if slices.Contains(clear, k.loginKey) {
+				if _, err := q.DeleteLoginFailures(ctx, dal.DeleteLoginFailuresParams{Kind: k.kind, Name: k.name}); err != nil {
+					return fmt.Errorf("cannot clear failed logins: %w", err)
+				}
+				continue
+			}
+			err := q.ReleaseLoginAttempt(ctx, dal.ReleaseLoginAttemptParams{ReservedUntil: k.blockedUntil, Kind: k.kind, Name: k.name})
+			if err != nil {
+				return fmt.Errorf("cannot release login attempt: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// writeLoginThrottled rejects a login or a verification with 429 Too Many Requests and the ProblemLoginThrottled
+// problem type. what names the failures, such as logins.
+//
+// The response of logins is the same for existing and unknown users, so it does not tell which users exist.
+func writeLoginThrottled(w http.ResponseWriter, r *http.Request, secs int64, what string) {
+	w.Header().Set("Retry-After", strconv.FormatInt(secs, 10))
+	httpserver.WriteProblem(w, r, httpserver.Problem{
+		Type:   ProblemLoginThrottled,
+		Title:  "Too many failed " + what,
+		Status: http.StatusTooManyRequests,
+		Detail: fmt.Sprintf("Too many failed %s, retry in %d seconds", what, secs),
+	})
+}
+
+// ListLockouts returns the accounts, client IPs and second factors whose logins are currently rejected, latest block first.
+func ListLockouts(ctx context.Context, s *store.Store) ([]Lockout, error) {
+	rows, err := s.Queries.ListBlockedLogins(ctx, time.Now().Unix())
+	if err != nil {
+		return nil, fmt.Errorf("cannot list lockouts: %w", err)
+	}
+	lockouts := make([]Lockout, 0, len(rows))
+	for _, row := range rows {
+		k := loginKey{kind: row.Kind, name: row.Name}
+		_, locked := k.loginBlock(row.Failures)
+		lockouts = append(lockouts, Lockout{
+			Kind:          row.Kind,
+			Name:          row.Name,
+			Failures:      row.Failures,
+			LastFailureAt: time.Unix(row.LastFailureAt, 0).UTC(),
+			BlockedUntil:  time.Unix(row.BlockedUntil, 0).UTC(),
+			Locked:        locked,
+		})
+	}
+	return lockouts, nil
+}
+
+// Unlock forgets the failed logins of the account or client IP, so it can log in again at once.
+func Unlock(ctx context.Context, s *store.Store, kind, name string) error {
+	switch kind {
+	case LockoutKindUser:
+		name = userLoginKey(name).name
+	case LockoutKindMFA:
+		name = mfaLoginKey(name).name
+	}
+	return store.WithTx(ctx, s.DB, func(ctx context.Context, q *dal.Queries) error {
+		n, err := q.DeleteLoginFailures(ctx, dal.DeleteLoginFailuresParams{Kind: kind, Name: name})
+		if err != nil {
+			return fmt.Errorf("cannot unlock: %w", err)
+		}
+		if n == 0 {
+			return ErrLockoutNotFound
+		}
+		return audit.Record(ctx, audit.ActionLoginUnlock, kind+":"+name, nil)
+	})
+}
+
+// ListLockoutsHandler returns the accounts and client IPs whose logins are currently rejected.
+func ListLockoutsHandler(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		lockouts, err := ListLockouts(r.Context(), s)
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		httpserver.WriteJSON(w, r, http.StatusOK, lockouts)
+	}
+}
+
+// UnlockHandler forgets the failed logins of the account or client IP of the path.
+func UnlockHandler(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		kind, name := r.PathValue("kind"), r.PathValue("name")
+		if kind != LockoutKindUser && kind != LockoutKindIP && kind != LockoutKindMFA {
+			httpserver.WriteError(w, r, http.StatusBadRequest, fmt.Errorf("invalid lockout kind %q; want %s, %s or %s",
+				kind, LockoutKindUser, LockoutKindIP, LockoutKindMFA))
+			return
+		}
+		err := Unlock(r.Context(), s, kind, name)
+		if errors.Is(err, ErrLockoutNotFound) {
+			httpserver.WriteError(w, r, http.StatusNotFound, nil)
+			return
+		}
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		logger.InfoCtx(r.Context(), "auth.unlock", "kind", kind, "name", name)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/AltSoyuz/adequate/internal/store"
+	"github.com/AltSoyuz/adequate/internal/store/dal"
+	"github.com/AltSoyuz/adequate/lib/httpserver"
+	"github.com/AltSoyuz/adequate/lib/logger"
+	"github.com/AltSoyuz/adequate/lib/secretbox"
+)
+
+// mfaChallengeCookie holds the token of a login waiting for the second factor of its user.
+const mfaChallengeCookie = "adequate_mfa"
+
+// mfaChallengeTimeout is how long users have for entering their code after single sign-on.
+const mfaChallengeTimeout = 5 * time.Minute
+
+var errNoMFAChallenge = errors.New("no login is waiting for a two-factor code; log in again")
+
+// startMFAChallenge stores a login of the user userID waiting for their second factor, and sets its cookie.
+//
+// The session is only created by MFAChallengeHandler, so single sign-on cannot bypass two-factor authentication.
+func startMFAChallenge(ctx context.Context, w http.ResponseWriter, s *store.Store, userID int64) error {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Errorf("cannot generate challenge token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	expires := time.Now().Add(mfaChallengeTimeout)
+	err := s.Queries.CreateMFAChallenge(ctx, dal.CreateMFAChallengeParams{
+		TokenHash: hashToken(token),
+		UserID:    userID,
+		ExpiresAt: expires.Unix(),
+	})
+	if err != nil {
+		return fmt.Errorf("cannot store challenge: %w", err)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     mfaChallengeCookie,
+		Value:    token,
+		Path:     "/api",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   *secureCookie,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// MFAChallengeHandler completes a login waiting for the second factor of its user with a TOTP or recovery code,
+// and starts a session.
+//
+// Failed codes are throttled like the codes of TOTPVerifyHandler.
+func MFAChallengeHandler(s *store.Store, box *secretbox.Box) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		req, err := httpserver.DecodeJSON[TOTPCodeRequest](r)
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		c, err := r.Cookie(mfaChallengeCookie)
+		if err != nil {
+			httpserver.WriteJSON(w, r, http.StatusUnauthorized, httpserver.ErrResponse{Error: errNoMFAChallenge.Error()})
+			return
+		}
+		tokenHash := hashToken(c.Value)
+		row, err := s.Queries.GetMFAChallengeUser(ctx, dal.GetMFAChallengeUserParams{TokenHash: tokenHash, ExpiresAt: time.Now().Unix()})
+		if errors.Is(err, sql.ErrNoRows) {
+			httpserver.WriteJSON(w, r, http.StatusUnauthorized, httpserver.ErrResponse{Error: errNoMFAChallenge.Error()})
+			return
+		}
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, fmt.Errorf("cannot load challenge: %w", err))
+			return
+		}
+		u := User{ID: row.ID, Username: row.Username}
+
+		keys := []loginKey{mfaLoginKey(u.Username)}
+		attempt, retryAfter, err := reserveLoginAttempt(ctx, s, keys)
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		if retryAfter > 0 {
+			throttledMFAVerificationsTotal.Inc()
+			logger.InfoCtx(ctx, "auth.mfa.throttled", "user_id", u.ID)
+			writeLoginThrottled(w, r, retryAfter, "codes")
+			return
+		}
+		ok, err := verifySecondFactor(ctx, s, box, u.ID, req.Code)
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		if !ok {
+			failedLoginsTotal.Inc()
+			failedMFAVerificationsTotal.Inc()
+			logger.InfoCtx(ctx, "auth.login.failed", "username", u.Username, "reason", "mfa")
+			if err := attempt.fail(ctx, s); err != nil {
+				httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			httpserver.WriteJSON(w, r, http.StatusUnauthorized, httpserver.ErrResponse{Error: errInvalidCode.Error()})
+			return
+		}
+		mfaVerificationsTotal.Inc()
+
+		// Challenges are single use, so concurrent requests with the same code cannot start two sessions.
+		n, err := s.Queries.DeleteMFAChallenge(ctx, tokenHash)
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, fmt.Errorf("cannot delete challenge: %w", err))
+			return
+		}
+		if n == 0 {
+			httpserver.WriteJSON(w, r, http.StatusUnauthorized, httpserver.ErrResponse{Error: errNoMFAChallenge.Error()})
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: mfaChallengeCookie, Path: "/api", MaxAge: -1, HttpOnly: true, Secure: *secureCookie})
+		if err := attempt.release(ctx, s, keys[0]); err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		if c, err := r.Cookie(SessionCookie); err == nil {
+			if err := RevokeSession(ctx, s, c.Value); err != nil {
+				logger.ErrorCtx(ctx, "auth.session.revoke", "err", err)
+			}
+		}
+		token, expires, err := CreateSession(ctx, s, u.ID, true)
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		setSessionCookie(w, token, expires)
+
+		loginsTotal.Inc()
+		logger.InfoCtx(ctx, "auth.login", "user_id", u.ID, "method", "oidc", "mfa", true)
+		w.Header().Set("Cache-Control", "no-store")
+		httpserver.WriteJSON(w, r, http.StatusOK, u)
+	}
+}
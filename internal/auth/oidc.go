@@ -0,0 +1,322 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/AltSoyuz/adequate/internal/audit"
+	"github.com/AltSoyuz/adequate/internal/store"
+	"github.com/AltSoyuz/adequate/internal/store/dal"
+	"github.com/AltSoyuz/adequate/lib/httpserver"
+	"github.com/AltSoyuz/adequate/lib/logger"
+	"github.com/AltSoyuz/adequate/lib/oidc"
+)
+
+var (
+	oidcIssuer = flag.String("auth.oidc.issuer", "", "Issuer URL of the OpenID Connect provider used for single sign-on, e.g. https://accounts.example.com. "+
+		"It must be the issuer of the provider verbatim, including any trailing slash. Single sign-on is disabled if empty")
+	oidcClientID      = flag.String("auth.oidc.clientID", "", "Client ID of the app at the OpenID Connect provider")
+	oidcClientSecret  = flag.String("auth.oidc.clientSecret", "", "Client secret of the app at the OpenID Connect provider")
+	oidcRedirectURL   = flag.String("auth.oidc.redirectURL", "", "Public URL of the "+OIDCCallbackPath+" endpoint, as registered at the OpenID Connect provider")
+	oidcScopes        = flag.String("auth.oidc.scopes", "profile,email", "Comma-separated scopes requested in addition to openid")
+	oidcUsernameClaim = flag.String("auth.oidc.usernameClaim", "preferred_username", "ID token claim naming the users created by "+
+		"-auth.oidc.createUsers. Identities are never linked to existing users by this claim: users link them by logging in "+
+		"with single sign-on from a session")
+	oidcCreateUsers = flag.Bool("auth.oidc.createUsers", false, "Whether identities linked to no user get a new user, without roles nor password, "+
+		"unless a user already has the name of -auth.oidc.usernameClaim. Otherwise their login is rejected")
+)
+
+// Paths of the single sign-on endpoints.
+const (
+	OIDCLoginPath    = "/api/auth/oidc/login"
+	OIDCCallbackPath = "/api/auth/oidc/callback"
+)
+
+// oidcStateCookie binds the login to the browser which started it, so a callback cannot be replayed in another browser.
+const oidcStateCookie = "adequate_oidc_state"
+
+// oidcLoginTimeout is how long users have for logging in at the provider.
+const oidcLoginTimeout = 10 * time.Minute
+
+var errNoLocalUser = errors.New("the identity is not linked to a local user")
+
+// AuthConfig describes the login methods of the app.
+type AuthConfig struct {
+	// OIDC is set if users can log in with single sign-on, by navigating to OIDCLoginURL.
+	OIDC         bool   `json:"oidc"`
+	OIDCLoginURL string `json:"oidc_login_url,omitempty"`
+}
+
+// NewOIDCProvider returns the OpenID Connect provider configured by the -auth.oidc.* flags,
+// or nil if single sign-on is disabled.
+func NewOIDCProvider() (*oidc.Provider, error) {
+	if *oidcIssuer == "" {
+		return nil, nil
+	}
+	if *oidcClientID == "" || *oidcRedirectURL == "" {
+		return nil, errors.New("-auth.oidc.clientID and -auth.oidc.redirectURL must be set with -auth.oidc.issuer")
+	}
+	u, err := url.Parse(*oidcRedirectURL)
+	if err != nil || !u.IsAbs() || u.Path != OIDCCallbackPath {
+		return nil, fmt.Errorf("-auth.oidc.redirectURL must be an absolute URL with the %s path; got %q", OIDCCallbackPath, *oidcRedirectURL)
+	}
+	return oidc.NewProvider(oidc.Config{
+		Issuer:       *oidcIssuer,
+		ClientID:     *oidcClientID,
+		ClientSecret: *oidcClientSecret,
+		RedirectURL:  *oidcRedirectURL,
+		Scopes:       splitList(*oidcScopes),
+	}), nil
+}
+
+// AuthConfigHandler returns the login methods of the app, so the login page can offer single sign-on.
+func AuthConfigHandler(p *oidc.Provider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := AuthConfig{OIDC: p != nil}
+		if cfg.OIDC {
+			cfg.OIDCLoginURL = OIDCLoginPath
+		}
+		httpserver.WriteJSON(w, r, http.StatusOK, cfg)
+	}
+}
+
+// OIDCLoginHandler redirects the browser to the provider for logging in, and back to the next query param afterwards.
+//
+// Users starting the login from a session link the identity to their account.
+func OIDCLoginHandler(s *store.Store, p *oidc.Provider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		var state, nonce, verifier string
+		for _, v := range []*string{&state, &nonce, &verifier} {
+			var err error
+			if *v, err = oidc.NewRandom(); err != nil {
+				httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+				return
+			}
+		}
+		var linkUserID int64
+		if u := UserFromContext(ctx); u != nil {
+			linkUserID = u.ID
+		}
+		err := s.Queries.CreateOIDCLogin(ctx, dal.CreateOIDCLoginParams{
+			StateHash:    hashToken(state),
+			Nonce:        nonce,
+			CodeVerifier: verifier,
+			Next:         safeNext(r.URL.Query().Get("next")),
+			LinkUserID:   linkUserID,
+			ExpiresAt:    time.Now().Add(oidcLoginTimeout).Unix(),
+		})
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, fmt.Errorf("cannot store login: %w", err))
+			return
+		}
+		authURL, err := p.AuthCodeURL(ctx, state, nonce, verifier)
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusBadGateway, err)
+			return
+		}
+
+		// The cookie must be sent with the top-level navigation back from the provider, so it cannot be SameSite=Strict.
+		http.SetCookie(w, &http.Cookie{
+			Name:     oidcStateCookie,
+			Value:    state,
+			Path:     OIDCCallbackPath,
+			MaxAge:   int(oidcLoginTimeout.Seconds()),
+			HttpOnly: true,
+			Secure:   *secureCookie,
+			SameSite: http.SameSiteLaxMode,
+		})
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, authURL, http.StatusFound)
+	}
+}
+
+// OIDCCallbackHandler completes the login redirected back by the provider, and starts a session.
+// Users with two-factor authentication are redirected to the login page for their code instead.
+//
+// Failed logins are redirected to the login page with an error message.
+func OIDCCallbackHandler(s *store.Store, p *oidc.Provider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: OIDCCallbackPath, MaxAge: -1, HttpOnly: true, Secure: *secureCookie})
+		w.Header().Set("Cache-Control", "no-store")
+
+		u, next, err := completeOIDCLogin(ctx, s, p, r)
+		if err != nil {
+			failedLoginsTotal.Inc()
+			logger.WarnCtx(ctx, "auth.oidc.failed", "err", err)
+			msg := "Single sign-on failed, please try again"
+			if errors.Is(err, errNoLocalUser) {
+				msg = "Your identity is not linked to any user of this app. Log in with your password, then with single sign-on to link it"
+			}
+			http.Redirect(w, r, LoginPath+"?error="+url.QueryEscape(msg), http.StatusFound)
+			return
+		}
+
+		// The provider may have checked a second factor, but the app cannot tell: users with two-factor authentication
+		// enter their code on the login page, which completes the login with MFAChallengeHandler.
+		mfa, err := totpEnabled(ctx, s, u.ID)
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		if mfa {
+			if err := startMFAChallenge(ctx, w, s, u.ID); err != nil {
+				httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			logger.InfoCtx(ctx, "auth.oidc.mfa_required", "user_id", u.ID)
+			http.Redirect(w, r, LoginPath+"?mfa=1&next="+url.QueryEscape(next), http.StatusFound)
+			return
+		}
+
+		if c, err := r.Cookie(SessionCookie); err == nil {
+			if err := RevokeSession(ctx, s, c.Value); err != nil {
+				logger.ErrorCtx(ctx, "auth.session.revoke", "err", err)
+			}
+		}
+		token, expires, err := CreateSession(ctx, s, u.ID, false)
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		setSessionCookie(w, token, expires)
+
+		loginsTotal.Inc()
+		logger.InfoCtx(ctx, "auth.login", "user_id", u.ID, "method", "oidc")
+		http.Redirect(w, r, next, http.StatusFound)
+	}
+}
+
+func completeOIDCLogin(ctx context.Context, s *store.Store, p *oidc.Provider, r *http.Request) (User, string, error) {
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		return User{}, "", fmt.Errorf("provider returned %s: %s", e, q.Get("error_description"))
+	}
+	state := q.Get("state")
+	c, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(c.Value), []byte(state)) != 1 {
+		return User{}, "", errors.New("state does not match the state cookie")
+	}
+	login, err := s.Queries.TakeOIDCLogin(ctx, hashToken(state))
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, "", errors.New("unknown login state")
+	}
+	if err != nil {
+		return User{}, "", fmt.Errorf("cannot load login: %w", err)
+	}
+	if time.Now().Unix() >= login.ExpiresAt {
+		return User{}, "", errors.New("login expired")
+	}
+
+	tokens, err := p.Exchange(ctx, q.Get("code"), login.CodeVerifier)
+	if err != nil {
+		return User{}, "", err
+	}
+	claims, err := p.VerifyIDToken(ctx, tokens.IDToken, login.Nonce)
+	if err != nil {
+		return User{}, "", err
+	}
+	u, err := identityUser(ctx, s, claims, login.LinkUserID)
+	if err != nil {
+		return User{}, "", err
+	}
+	return u, login.Next, nil
+}
+
+// identityUser returns the local user of the identity in claims.
+//
+// Identities seen for the first time are linked to linkUserID if set, which is the user of the session which
+// started the login, or else to a new user if -auth.oidc.createUsers is set; see localUserID.
+func identityUser(ctx context.Context, s *store.Store, claims *oidc.Claims, linkUserID int64) (User, error) {
+	key := dal.GetIdentityUserParams{Issuer: claims.Issuer, Subject: claims.Subject}
+	row, err := s.Queries.GetIdentityUser(ctx, key)
+	if err == nil {
+		if linkUserID != 0 && linkUserID != row.ID {
+			return User{}, fmt.Errorf("identity %s is already linked to user %d", claims.Subject, row.ID)
+		}
+		return User{ID: row.ID, Username: row.Username}, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return User{}, fmt.Errorf("cannot load identity: %w", err)
+	}
+
+	var u User
+	err = store.WithTx(ctx, s.DB, func(ctx context.Context, q *dal.Queries) error {
+		userID := linkUserID
+		if userID == 0 {
+			var err error
+			if userID, err = localUserID(ctx, q, claims); err != nil {
+				return err
+			}
+		}
+		err := q.CreateUserIdentity(ctx, dal.CreateUserIdentityParams{
+			Issuer:    claims.Issuer,
+			Subject:   claims.Subject,
+			UserID:    userID,
+			CreatedAt: time.Now().Unix(),
+		})
+		if err != nil {
+			return fmt.Errorf("cannot link identity: %w", err)
+		}
+		row, err := q.GetIdentityUser(ctx, key)
+		if err != nil {
+			return fmt.Errorf("cannot load linked user: %w", err)
+		}
+		u = User{ID: row.ID, Username: row.Username}
+		return audit.Record(ctx, audit.ActionUserIdentityLink, userTarget(u.Username), audit.Diff{
+			"identity": {To: claims.Issuer + " " + claims.Subject},
+		})
+	})
+	if err != nil {
+		return User{}, err
+	}
+	logger.InfoCtx(ctx, "auth.oidc.linked", "user_id", u.ID, "issuer", claims.Issuer, "subject", claims.Subject)
+	return u, nil
+}
+
+// localUserID creates a user named after the -auth.oidc.usernameClaim claim if -auth.oidc.createUsers is set.
+//
+// Identities are never linked to existing users by their claims, since providers may let users pick them:
+// logging in as an admin would only take a matching username.
+func localUserID(ctx context.Context, q *dal.Queries, claims *oidc.Claims) (int64, error) {
+	if !*oidcCreateUsers {
+		return 0, errNoLocalUser
+	}
+	username := strings.TrimSpace(claims.String(*oidcUsernameClaim))
+	if username == "" {
+		return 0, fmt.Errorf("ID token lacks the %s claim", *oidcUsernameClaim)
+	}
+	_, err := q.GetUserByUsername(ctx, username)
+	if err == nil {
+		return 0, fmt.Errorf("%w: user %q already exists", errNoLocalUser, username)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("cannot load user: %w", err)
+	}
+	if err := validateUsername(username); err != nil {
+		return 0, err
+	}
+	// Users created by single sign-on have no password.
+	created, err := insertUser(ctx, q, username, "")
+	if err != nil {
+		return 0, err
+	}
+	return created.ID, nil
+}
+
+// safeNext returns next if it is a path of the app area, so the login cannot be used as an open redirect.
+func safeNext(next string) string {
+	if strings.HasPrefix(next, "/app/") && !strings.HasPrefix(next, "//") && !strings.ContainsAny(next, "\\\r\n") && next != LoginPath {
+		return next
+	}
+	return "/app/"
+}
@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/AltSoyuz/adequate/internal/audit"
+	"github.com/AltSoyuz/adequate/internal/store"
+	"github.com/AltSoyuz/adequate/internal/store/dal"
+	"github.com/AltSoyuz/adequate/lib/httpserver"
+	"github.com/AltSoyuz/adequate/lib/logger"
+	"github.com/AltSoyuz/adequate/lib/metrics"
+)
+
+// Permissions required by the API routes.
+//
+// They are stored with the roles granting them in the database and seeded by the migrations,
+// so a migration must add any new permission.
+const (
+	PermMigrationsRead   = "migrations:read"
+	PermMaintenanceRead  = "maintenance:read"
+	PermMaintenanceWrite = "maintenance:write"
+	PermTokensManage     = "tokens:manage"
+	PermDiagnosticsRead  = "diagnostics:read"
+	PermAuditRead        = "audit:read"
+	PermLockoutsManage   = "lockouts:manage"
+)
+
+var forbiddenRequestsTotal = metrics.NewCounter("auth_forbidden_requests_total")
+
+// MyPermissions lists the roles of the current user and the permissions they grant.
+type MyPermissions struct {
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+}
+
+// permissions returns the permissions granted to p by the roles of its user.
+//
+// They are loaded once per request, on first use.
+func (p *Principal) permissions(ctx context.Context, s *store.Store) ([]string, error) {
+	if p.perms != nil {
+		return p.perms, nil
+	}
+	perms, err := s.Queries.ListUserPermissions(ctx, p.ID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load permissions of user %d: %w", p.ID, err)
+	}
+	p.perms = append([]string{}, perms...)
+	return p.perms, nil
+}
+
+// Authorize returns the authorizer checking the permissions of routes against the roles of the request principal.
+//
+// Anonymous requests get 401 Unauthorized, and principals lacking the permission get 403 Forbidden.
+// It relies on Middleware for authenticating requests.
+func Authorize(s *store.Store) httpserver.Authorizer {
+	return func(permission string) httpserver.Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctx := r.Context()
+				p := PrincipalFromContext(ctx)
+				if p == nil {
+					httpserver.WriteError(w, r, http.StatusUnauthorized, nil)
+					return
+				}
+				perms, err := p.permissions(ctx, s)
+				if err != nil {
+					httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+					return
+				}
+				if !slices.Contains(perms, permission) {
+					forbiddenRequestsTotal.Inc()
+					logger.WarnCtx(ctx, "auth.forbidden", "permission", permission)
+					httpserver.WriteProblem(w, r, httpserver.Problem{
+						Status: http.StatusForbidden,
+						Detail: "Missing the " + permission + " permission",
+					})
+					return
+				}
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+}
+
+// MyPermissionsHandler returns the roles and permissions of the current user, so the UI can hide what they cannot do.
+func MyPermissionsHandler(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		p := PrincipalFromContext(ctx)
+		if p == nil {
+			httpserver.WriteError(w, r, http.StatusUnauthorized, nil)
+			return
+		}
+		roles, err := s.Queries.ListUserRoles(ctx, p.ID)
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		perms, err := p.permissions(ctx, s)
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		httpserver.WriteJSON(w, r, http.StatusOK, MyPermissions{
+			Roles:       append([]string{}, roles...),
+			Permissions: perms,
+		})
+	}
+}
+
+// ParseRoles parses comma-separated roles.
+func ParseRoles(s string) []string {
+	return splitList(s)
+}
+
+// CheckRoles returns an error if any of roles does not exist.
+func CheckRoles(ctx context.Context, s *store.Store, roles []string) error {
+	all, err := s.Queries.ListRoles(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot list roles: %w", err)
+	}
+	for _, role := range roles {
+		if !slices.Contains(all, role) {
+			return fmt.Errorf("unknown role %q; supported roles: %s", role, strings.Join(all, ", "))
+		}
+	}
+	return nil
+}
+
+// SetUserRoles replaces the roles of the user with the given username.
+//
+// The new roles apply to the next requests of the existing sessions and API tokens of the user.
+func SetUserRoles(ctx context.Context, s *store.Store, username string, roles []string) error {
+	if err := CheckRoles(ctx, s, roles); err != nil {
+		return err
+	}
+	u, err := s.Queries.GetUserByUsername(ctx, username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("user %q does not exist", username)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot load user: %w", err)
+	}
+	return store.WithTx(ctx, s.DB, func(ctx context.Context, q *dal.Queries) error {
+		old, err := q.ListUserRoles(ctx, u.ID)
+		if err != nil {
+			return fmt.Errorf("cannot list roles: %w", err)
+		}
+		if err := q.DeleteUserRoles(ctx, u.ID); err != nil {
+			return fmt.Errorf("cannot delete roles: %w", err)
+		}
+		for _, role := range roles {
+			if err := q.AddUserRole(ctx, dal.AddUserRoleParams{UserID: u.ID, Role: role}); err != nil {
+				return fmt.Errorf("cannot add role %q: %w", role, err)
+			}
+		}
+		return audit.Record(ctx, audit.ActionUserRoles, userTarget(u.Username), audit.Diff{
+			"roles": {From: append([]string{}, old...), To: append([]string{}, roles...)},
+		})
+	})
+}
@@ -0,0 +1,337 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/AltSoyuz/adequate/internal/audit"
+	"github.com/AltSoyuz/adequate/internal/store"
+	"github.com/AltSoyuz/adequate/internal/store/dal"
+	"github.com/AltSoyuz/adequate/lib/httpserver"
+	"github.com/AltSoyuz/adequate/lib/logger"
+)
+
+var (
+	sessionIdleTimeout = flag.Duration("auth.sessionIdleTimeout", 24*time.Hour, "Sessions which are not used for this long expire")
+	sessionMaxLifetime = flag.Duration("auth.sessionMaxLifetime", 30*24*time.Hour, "Sessions expire this long after login, even if they are in use")
+	secureCookie       = flag.Bool("auth.secureCookie", false, "Whether the session cookie is only sent over HTTPS. "+
+		"Set it when the app is served over HTTPS")
+)
+
+// SessionCookie is the name of the cookie carrying the session token.
+const SessionCookie = "adequate_session"
+
+// touchInterval limits how often the last use of a session is stored, so requests do not all write to the database.
+const touchInterval = time.Minute
+
+// CreateSession starts a session of the user userID and returns its token and absolute expiry.
+// mfa tells whether the user verified their second factor when logging in.
+//
+// Only the SHA-256 hash of the token is stored, so a leaked database does not leak usable sessions.
+func CreateSession(ctx context.Context, s *store.Store, userID int64, mfa bool) (string, time.Time, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", time.Time{}, fmt.Errorf("cannot generate session token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	now := time.Now()
+	expires := now.Add(*sessionMaxLifetime)
+	var mfaAt int64
+	if mfa {
+		mfaAt = now.Unix()
+	}
+	err := s.Queries.CreateSession(ctx, dal.CreateSessionParams{
+		ID:         hashToken(token),
+		UserID:     userID,
+		CreatedAt:  now.Unix(),
+		LastSeenAt: now.Unix(),
+		ExpiresAt:  expires.Unix(),
+		MfaAt:      mfaAt,
+	})
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("cannot create session: %w", err)
+	}
+	return token, expires, nil
+}
+
+// RevokeSession ends the session with the given token.
+func RevokeSession(ctx context.Context, s *store.Store, token string) error {
+	if err := s.Queries.DeleteSession(ctx, hashToken(token)); err != nil {
+		return fmt.Errorf("cannot revoke session: %w", err)
+	}
+	return nil
+}
+
+// RevokeUserSessions ends all the sessions of the user with the given username and returns their number.
+func RevokeUserSessions(ctx context.Context, s *store.Store, username string) (int64, error) {
+	u, err := s.Queries.GetUserByUsername(ctx, username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("user %q does not exist", username)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("cannot load user: %w", err)
+	}
+	var n int64
+	err = store.WithTx(ctx, s.DB, func(ctx context.Context, q *dal.Queries) error {
+		if n, err = q.DeleteUserSessions(ctx, u.ID); err != nil {
+			return fmt.Errorf("cannot revoke sessions: %w", err)
+		}
+		return audit.Record(ctx, audit.ActionUserSessionsRevoke, userTarget(u.Username), audit.Diff{"sessions": {From: n, To: 0}})
+	})
+	return n, err
+}
+
+// lookupSession returns the principal of the session with the given token, or nil if the session does not exist or expired.
+func lookupSession(ctx context.Context, s *store.Store, token string, now time.Time) (*Principal, error) {
+	id := hashToken(token)
+	sess, err := s.Queries.GetSession(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot load session: %w", err)
+	}
+
+	lastSeen := time.Unix(sess.LastSeenAt, 0)
+	if !now.Before(time.Unix(sess.ExpiresAt, 0)) || now.Sub(lastSeen) >= *sessionIdleTimeout {
+		if err := s.Queries.DeleteSession(ctx, id); err != nil {
+			return nil, fmt.Errorf("cannot delete expired session: %w", err)
+		}
+		return nil, nil
+	}
+	if now.Sub(lastSeen) >= touchInterval {
+		err := s.Queries.TouchSession(ctx, dal.TouchSessionParams{LastSeenAt: now.Unix(), ID: id})
+		if err != nil {
+			return nil, fmt.Errorf("cannot update session: %w", err)
+		}
+	}
+	p := &Principal{User: User{ID: sess.UserID, Username: sess.Username}, sessionID: id, sessionCreatedAt: time.Unix(sess.CreatedAt, 0)}
+	if sess.MfaAt != 0 {
+		p.mfaAt = time.Unix(sess.MfaAt, 0)
+	}
+	return p, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func setSessionCookie(w http.ResponseWriter, token string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   *secureCookie,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   *secureCookie,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Principal is the authenticated client of a request.
+type Principal struct {
+	User
+	// TokenID is the ID of the API token of the request, or zero for session requests.
+	TokenID int64
+	// Scopes are the scopes of the API token of the request. Sessions are not limited by scopes.
+	Scopes []string
+
+	// sessionID is the ID of the session of the request, or empty for API token requests.
+	sessionID string
+	// sessionCreatedAt is when the session of the request was created, or zero for API token requests.
+	sessionCreatedAt time.Time
+	// mfaAt is when the second factor was last verified in the session, or zero if never.
+	mfaAt time.Time
+	// perms caches the permissions of the user; see permissions.
+	perms []string
+}
+
+type principalKey struct{}
+
+// PrincipalFromContext returns the principal of the request, or nil for anonymous requests.
+func PrincipalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// UserFromContext returns the user of the request, or nil for anonymous requests.
+func UserFromContext(ctx context.Context) *User {
+	if p := PrincipalFromContext(ctx); p != nil {
+		return &p.User
+	}
+	return nil
+}
+
+// Middleware attaches the principal of requests to their context, for PrincipalFromContext.
+//
+// Requests with an Authorization: Bearer header are authenticated by the API token only, and get
+// 401 Unauthorized if it is invalid, or 403 Forbidden if it lacks the scope of the request.
+// Other requests are authenticated by their session cookie, if any.
+// Anonymous requests are not rejected; see Require and RequireUI.
+func Middleware(s *store.Store) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			if token, ok := bearerToken(r); ok {
+				p, err := lookupAPIToken(ctx, s, token, time.Now())
+				if err != nil {
+					httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+					return
+				}
+				if p == nil {
+					w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+					httpserver.WriteError(w, r, http.StatusUnauthorized, nil)
+					return
+				}
+				if scope := requiredScope(r); !slices.Contains(p.Scopes, scope) {
+					w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+					httpserver.WriteProblem(w, r, httpserver.Problem{
+						Status: http.StatusForbidden,
+						Detail: "The API token lacks the " + scope + " scope",
+					})
+					return
+				}
+				ctx = logger.With(ctx, "user_id", p.ID, "token_id", p.TokenID)
+				ctx = audit.WithActor(ctx, audit.Actor{UserID: p.ID, Username: p.Username, TokenID: p.TokenID})
+				next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, principalKey{}, p)))
+				return
+			}
+
+			c, err := r.Cookie(SessionCookie)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			p, err := lookupSession(ctx, s, c.Value, time.Now())
+			if err != nil {
+				logger.ErrorCtx(ctx, "auth.session", "err", err)
+			}
+			if p == nil {
+				if err == nil {
+					clearSessionCookie(w)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx = logger.With(ctx, "user_id", p.ID)
+			ctx = audit.WithActor(ctx, audit.Actor{UserID: p.ID, Username: p.Username})
+			next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, principalKey{}, p)))
+		})
+	}
+}
+
+// Require rejects requests without a session with 401 Unauthorized.
+func Require(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if UserFromContext(r.Context()) == nil {
+			httpserver.WriteError(w, r, http.StatusUnauthorized, nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// LoginPath is the UI route of the login page.
+const LoginPath = "/app/login"
+
+// RequireUI redirects page loads of the /app area without a session to the login page,
+// which redirects back once logged in.
+func RequireUI(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := r.URL.Path
+		isApp := p == "/app" || strings.HasPrefix(p, "/app/")
+		if !isApp || p == LoginPath || UserFromContext(r.Context()) != nil ||
+			r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		http.Redirect(w, r, LoginPath+"?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+	})
+}
+
+// RunCleanup deletes expired sessions, single sign-on logins and failed logins every interval until ctx is done.
+func RunCleanup(ctx context.Context, s *store.Store, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			n, err := s.Queries.DeleteExpiredSessions(ctx, dal.DeleteExpiredSessionsParams{
+				ExpiresAt:  now.Unix(),
+				LastSeenAt: now.Add(-*sessionIdleTimeout).Unix(),
+			})
+			if err != nil {
+				if ctx.Err() == nil {
+					logger.Error("auth.session.cleanup", "err", err)
+				}
+				continue
+			}
+			if n > 0 {
+				logger.Info("auth.session.cleanup", "deleted", n)
+			}
+			n, err = s.Queries.DeleteExpiredOIDCLogins(ctx, now.Unix())
+			if err != nil {
+				if ctx.Err() == nil {
+					logger.Error("auth.oidc.cleanup", "err", err)
+				}
+				continue
+			}
+			if n > 0 {
+				logger.Info("auth.oidc.cleanup", "deleted", n)
+			}
+			n, err = s.Queries.DeleteExpiredMFAChallenges(ctx, now.Unix())
+			if err != nil {
+				if ctx.Err() == nil {
+					logger.Error("auth.mfa.cleanup", "err", err)
+				}
+				continue
+			}
+			if n > 0 {
+				logger.Info("auth.mfa.cleanup", "deleted", n)
+			}
+			n, err = s.Queries.DeleteExpiredLoginFailures(ctx, now.Add(-*lockoutDuration).Unix())
+			if err != nil {
+				if ctx.Err() == nil {
+					logger.Error("auth.lockout.cleanup", "err", err)
+				}
+				continue
+			}
+			if n > 0 {
+				logger.Info("auth.lockout.cleanup", "deleted", n)
+			}
+		}
+	}
+}
+
+// CSRF returns the middleware protecting session-authenticated mutations from cross-site request forgery.
+func CSRF() (httpserver.Middleware, error) {
+	return httpserver.CSRF(httpserver.CSRFOptions{AuthCookie: SessionCookie, Secure: *secureCookie})
+}
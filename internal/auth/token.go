@@ -0,0 +1,428 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AltSoyuz/adequate/internal/audit"
+	"github.com/AltSoyuz/adequate/internal/store"
+	"github.com/AltSoyuz/adequate/internal/store/dal"
+	"github.com/AltSoyuz/adequate/lib/httpserver"
+	"github.com/AltSoyuz/adequate/lib/logger"
+)
+
+// TokenPrefix starts every API token, so leaked tokens are easy to recognize, e.g. by secret scanners.
+const TokenPrefix = "adq_"
+
+// Scopes of API tokens.
+const (
+	// ScopeRead allows safe requests.
+	ScopeRead = "read"
+	// ScopeWrite allows mutating requests.
+	ScopeWrite = "write"
+	// ScopeAdmin allows requests to /api/admin/.
+	ScopeAdmin = "admin"
+)
+
+var allScopes = []string{ScopeRead, ScopeWrite, ScopeAdmin}
+
+// displayPrefixLen is the length of the token start kept in clear, so users can tell their tokens apart.
+const displayPrefixLen = len(TokenPrefix) + 6
+
+// APIToken describes an API token, without its secret.
+type APIToken struct {
+	ID       int64    `json:"id"`
+	Username string   `json:"username"`
+	Name     string   `json:"name"`
+	Prefix   string   `json:"prefix"`
+	Scopes   []string `json:"scopes"`
+
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at,omitzero"`
+	LastUsedAt time.Time `json:"last_used_at,omitzero"`
+	RevokedAt  time.Time `json:"revoked_at,omitzero"`
+}
+
+// CreateAPITokenRequest is the body of the admin requests creating an API token.
+type CreateAPITokenRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// ExpiresInDays is the lifetime of the token. The token never expires if zero.
+	ExpiresInDays int `json:"expires_in_days"`
+}
+
+// CreatedAPIToken is a newly created API token.
+type CreatedAPIToken struct {
+	APIToken
+	// Token is the secret to send in the Authorization: Bearer header. It cannot be retrieved later.
+	Token string `json:"token"`
+}
+
+// ParseScopes parses comma-separated scopes.
+func ParseScopes(s string) ([]string, error) {
+	return normalizeScopes(splitList(s))
+}
+
+// splitList splits a comma-separated list, skipping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			items = append(items, v)
+		}
+	}
+	return items
+}
+
+func normalizeScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("missing scopes; supported scopes: %s", strings.Join(allScopes, ", "))
+	}
+	var out []string
+	for _, s := range scopes {
+		if !slices.Contains(allScopes, s) {
+			return nil, fmt.Errorf("unsupported scope %q; supported scopes: %s", s, strings.Join(allScopes, ", "))
+		}
+		if !slices.Contains(out, s) {
+			out = append(out, s)
+		}
+	}
+	slices.Sort(out)
+	return out, nil
+}
+
+// ErrUserNotFound is returned by IssueAPIToken for unknown users.
+var ErrUserNotFound = errors.New("user not found")
+
+// CreateAPIToken creates an API token of the user with the given username.
+//
+// Only the SHA-256 hash of the token is stored; the returned secret cannot be retrieved later.
+func CreateAPIToken(ctx context.Context, s *store.Store, username string, req CreateAPITokenRequest) (CreatedAPIToken, error) {
+	u, err := s.Queries.GetUserByUsername(ctx, username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return CreatedAPIToken{}, fmt.Errorf("user %q does not exist", username)
+	}
+	if err != nil {
+		return CreatedAPIToken{}, fmt.Errorf("cannot load user: %w", err)
+	}
+	scopes, err := normalizeScopes(req.Scopes)
+	if err != nil {
+		return CreatedAPIToken{}, err
+	}
+	return createAPIToken(ctx, s, u, scopes, req, audit.ActionTokenCreate)
+}
+
+// IssueAPIToken creates an API token of the user with the given username on behalf of an admin.
+//
+// The scopes of the token are limited to the ones the user could use: only users with permissions get the admin scope.
+func IssueAPIToken(ctx context.Context, s *store.Store, username string, req CreateAPITokenRequest) (CreatedAPIToken, error) {
+	u, err := s.Queries.GetUserByUsername(ctx, username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return CreatedAPIToken{}, ErrUserNotFound
+	}
+	if err != nil {
+		return CreatedAPIToken{}, fmt.Errorf("cannot load user: %w", err)
+	}
+	scopes, err := normalizeScopes(req.Scopes)
+	if err != nil {
+		return CreatedAPIToken{}, err
+	}
+	perms, err := s.Queries.ListUserPermissions(ctx, u.ID)
+	if err != nil {
+		return CreatedAPIToken{}, fmt.Errorf("cannot load permissions of user %q: %w", u.Username, err)
+	}
+	if len(perms) == 0 {
+		scopes = slices.DeleteFunc(scopes, func(s string) bool { return s == ScopeAdmin })
+	}
+	if len(scopes) == 0 {
+		return CreatedAPIToken{}, fmt.Errorf("user %q has no permissions for the admin scope", u.Username)
+	}
+	return createAPIToken(ctx, s, u, scopes, req, audit.ActionTokenIssue)
+}
+
+// createAPIToken creates an API token of u with the given scopes, and records it with the given audit action.
+func createAPIToken(ctx context.Context, s *store.Store, u dal.User, scopes []string, req CreateAPITokenRequest, action string) (CreatedAPIToken, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > 100 {
+		return CreatedAPIToken{}, errors.New("token name must have between 1 and 100 characters")
+	}
+	if req.ExpiresInDays < 0 {
+		return CreatedAPIToken{}, errors.New("expires_in_days must not be negative")
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return CreatedAPIToken{}, fmt.Errorf("cannot generate token: %w", err)
+	}
+	token := TokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	var expires time.Time
+	if req.ExpiresInDays > 0 {
+		expires = now.AddDate(0, 0, req.ExpiresInDays)
+	}
+	var id int64
+	err := store.WithTx(ctx, s.DB, func(ctx context.Context, q *dal.Queries) error {
+		var err error
+		id, err = q.CreateAPIToken(ctx, dal.CreateAPITokenParams{
+			UserID:    u.ID,
+			Name:      name,
+			Prefix:    token[:displayPrefixLen],
+			TokenHash: hashToken(token),
+			Scopes:    strings.Join(scopes, " "),
+			CreatedAt: now.Unix(),
+			ExpiresAt: unixOrZero(expires),
+		})
+		if err != nil {
+			return fmt.Errorf("cannot create token: %w", err)
+		}
+		diff := audit.Diff{
+			"username": {To: u.Username},
+			"name":     {To: name},
+			"scopes":   {To: scopes},
+		}
+		if !expires.IsZero() {
+			diff["expires_at"] = audit.AuditChange{To: expires}
+		}
+		return audit.Record(ctx, action, tokenTarget(id), diff)
+	})
+	if err != nil {
+		return CreatedAPIToken{}, err
+	}
+	return CreatedAPIToken{
+		APIToken: APIToken{
+			ID:        id,
+			Username:  u.Username,
+			Name:      name,
+			Prefix:    token[:displayPrefixLen],
+			Scopes:    scopes,
+			CreatedAt: now,
+			ExpiresAt: expires,
+		},
+		Token: token,
+	}, nil
+}
+
+// ListAPITokens returns all the API tokens, including expired and revoked ones.
+func ListAPITokens(ctx context.Context, s *store.Store) ([]APIToken, error) {
+	rows, err := s.Queries.ListAPITokens(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list tokens: %w", err)
+	}
+	tokens := make([]APIToken, 0, len(rows))
+	for _, r := range rows {
+		tokens = append(tokens, APIToken{
+			ID:         r.ID,
+			Username:   r.Username,
+			Name:       r.Name,
+			Prefix:     r.Prefix,
+			Scopes:     strings.Fields(r.Scopes),
+			CreatedAt:  timeOrZero(r.CreatedAt),
+			ExpiresAt:  timeOrZero(r.ExpiresAt),
+			LastUsedAt: timeOrZero(r.LastUsedAt),
+			RevokedAt:  timeOrZero(r.RevokedAt),
+		})
+	}
+	return tokens, nil
+}
+
+// ErrTokenNotFound is returned by RevokeAPIToken for unknown or already revoked tokens.
+var ErrTokenNotFound = errors.New("token not found or already revoked")
+
+// RevokeAPIToken revokes the API token with the given ID.
+func RevokeAPIToken(ctx context.Context, s *store.Store, id int64) error {
+	return store.WithTx(ctx, s.DB, func(ctx context.Context, q *dal.Queries) error {
+		now := time.Now().UTC().Truncate(time.Second)
+		n, err := q.RevokeAPIToken(ctx, dal.RevokeAPITokenParams{RevokedAt: now.Unix(), ID: id})
+		if err != nil {
+			return fmt.Errorf("cannot revoke token: %w", err)
+		}
+		if n == 0 {
+			return ErrTokenNotFound
+		}
+		return audit.Record(ctx, audit.ActionTokenRevoke, tokenTarget(id), audit.Diff{"revoked_at": {To: now}})
+	})
+}
+
+// tokenTarget returns the audit target of the API token with the given ID.
+func tokenTarget(id int64) string {
+	return "token:" + strconv.FormatInt(id, 10)
+}
+
+// lookupAPIToken returns the principal of the given token, or nil if the token is unknown, expired or revoked.
+func lookupAPIToken(ctx context.Context, s *store.Store, token string, now time.Time) (*Principal, error) {
+	if !strings.HasPrefix(token, TokenPrefix) {
+		return nil, nil
+	}
+	t, err := s.Queries.GetAPITokenByHash(ctx, hashToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot load token: %w", err)
+	}
+	if t.RevokedAt != 0 || t.ExpiresAt != 0 && now.Unix() >= t.ExpiresAt {
+		return nil, nil
+	}
+	if now.Sub(time.Unix(t.LastUsedAt, 0)) >= touchInterval {
+		if err := s.Queries.TouchAPIToken(ctx, dal.TouchAPITokenParams{LastUsedAt: now.Unix(), ID: t.ID}); err != nil {
+			return nil, fmt.Errorf("cannot update token: %w", err)
+		}
+	}
+	return &Principal{
+		User:    User{ID: t.UserID, Username: t.Username},
+		TokenID: t.ID,
+		Scopes:  strings.Fields(t.Scopes),
+	}, nil
+}
+
+// requiredScope returns the scope API tokens need for r.
+func requiredScope(r *http.Request) string {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/api/admin/"):
+		return ScopeAdmin
+	case r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions:
+		return ScopeRead
+	default:
+		return ScopeWrite
+	}
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// ListAPITokensHandler returns all the API tokens.
+func ListAPITokensHandler(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tokens, err := ListAPITokens(r.Context(), s)
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		httpserver.WriteJSON(w, r, http.StatusOK, tokens)
+	}
+}
+
+// CreateAPITokenHandler creates an API token of the request principal and returns its secret.
+//
+// API tokens cannot create tokens with scopes they do not have.
+func CreateAPITokenHandler(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := PrincipalFromContext(r.Context())
+		if p == nil {
+			httpserver.WriteError(w, r, http.StatusUnauthorized, nil)
+			return
+		}
+		req, err := httpserver.DecodeJSON[CreateAPITokenRequest](r)
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if p.TokenID != 0 {
+			for _, sc := range req.Scopes {
+				if slices.Contains(allScopes, sc) && !slices.Contains(p.Scopes, sc) {
+					httpserver.WriteProblem(w, r, httpserver.Problem{
+						Status: http.StatusForbidden,
+						Detail: "The API token of the request lacks the " + sc + " scope",
+					})
+					return
+				}
+			}
+		}
+		t, err := CreateAPIToken(r.Context(), s, p.Username, req)
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		logger.InfoCtx(r.Context(), "auth.token.created", "token_id", t.ID, "owner", t.Username, "scopes", strings.Join(t.Scopes, ","))
+		w.Header().Set("Cache-Control", "no-store")
+		httpserver.WriteJSON(w, r, http.StatusCreated, t)
+	}
+}
+
+// IssueAPITokenHandler creates an API token of the user of the path and returns its secret.
+//
+// Tokens are only issued from sessions, so RequireStepUp makes the admin verify their second factor first.
+func IssueAPITokenHandler(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := PrincipalFromContext(r.Context())
+		if p == nil {
+			httpserver.WriteError(w, r, http.StatusUnauthorized, nil)
+			return
+		}
+		if p.sessionID == "" {
+			httpserver.WriteProblem(w, r, httpserver.Problem{
+				Status: http.StatusForbidden,
+				Detail: "Tokens of other users are only issued from sessions",
+			})
+			return
+		}
+		req, err := httpserver.DecodeJSON[CreateAPITokenRequest](r)
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		t, err := IssueAPIToken(r.Context(), s, r.PathValue("username"), req)
+		if errors.Is(err, ErrUserNotFound) {
+			httpserver.WriteError(w, r, http.StatusNotFound, nil)
+			return
+		}
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		logger.InfoCtx(r.Context(), "auth.token.issued", "token_id", t.ID, "owner", t.Username, "scopes", strings.Join(t.Scopes, ","))
+		w.Header().Set("Cache-Control", "no-store")
+		httpserver.WriteJSON(w, r, http.StatusCreated, t)
+	}
+}
+
+// RevokeAPITokenHandler revokes the API token with the ID of the path.
+func RevokeAPITokenHandler(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusBadRequest, fmt.Errorf("invalid token id %q", r.PathValue("id")))
+			return
+		}
+		err = RevokeAPIToken(r.Context(), s, id)
+		if errors.Is(err, ErrTokenNotFound) {
+			httpserver.WriteError(w, r, http.StatusNotFound, nil)
+			return
+		}
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		logger.InfoCtx(r.Context(), "auth.token.revoked", "token_id", id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func timeOrZero(unix int64) time.Time {
+	if unix == 0 {
+		return time.Time{}
+	}
+	return time.Unix(unix, 0).UTC()
+}
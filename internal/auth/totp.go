@@ -0,0 +1,637 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/AltSoyuz/adequate/internal/audit"
+	"github.com/AltSoyuz/adequate/internal/store"
+	"github.com/AltSoyuz/adequate/internal/store/dal"
+	"github.com/AltSoyuz/adequate/lib/httpserver"
+	"github.com/AltSoyuz/adequate/lib/keys"
+	"github.com/AltSoyuz/adequate/lib/logger"
+	"github.com/AltSoyuz/adequate/lib/metrics"
+	"github.com/AltSoyuz/adequate/lib/secretbox"
+	"github.com/AltSoyuz/adequate/lib/totp"
+)
+
+var (
+	totpIssuer   = flag.String("auth.totpIssuer", "Adequate", "Name of the app shown by authenticator apps")
+	stepUpMaxAge = flag.Duration("auth.stepUpMaxAge", 10*time.Minute, "How long after verifying their second factor "+
+		"users can call sensitive endpoints, e.g. creating API tokens, without verifying it again")
+)
+
+// Problem types of the responses asking for a second factor.
+const (
+	// ProblemMFARequired is returned by logins of users with two-factor authentication which lack a code.
+	ProblemMFARequired = "urn:adequate:problem:mfa-required"
+	// ProblemStepUpRequired is returned by sensitive endpoints if the second factor was not verified recently in the session.
+	ProblemStepUpRequired = "urn:adequate:problem:step-up-required"
+)
+
+// totpSkew is the number of time steps accepted before and after the current one, for clock drift and typing delays.
+const totpSkew = 1
+
+// recoveryCodesCount is the number of recovery codes generated at once.
+const recoveryCodesCount = 10
+
+var (
+	mfaVerificationsTotal          = metrics.NewCounter(`auth_mfa_verifications_total{result="success"}`)
+	failedMFAVerificationsTotal    = metrics.NewCounter(`auth_mfa_verifications_total{result="failure"}`)
+	throttledMFAVerificationsTotal = metrics.NewCounter(`auth_mfa_verifications_total{result="throttled"}`)
+	stepUpRequiredTotal            = metrics.NewCounter("auth_step_up_required_total")
+)
+
+var (
+	errInvalidCode   = errors.New("invalid two-factor code")
+	errNoEncryption  = errors.New("two-factor authentication requires a keyring set with -keys.file or -keys.ring")
+	errSessionNeeded = errors.New("two-factor authentication is managed from a session, not with API tokens")
+	errTOTPDisabled  = errors.New("two-factor authentication is not enabled")
+	errWrongPassword = errors.New("invalid password")
+	errStaleSession  = errors.New("log in again with single sign-on to set up two-factor authentication")
+)
+
+// TOTPStatus describes the two-factor authentication of the current user.
+type TOTPStatus struct {
+	Enabled bool `json:"enabled"`
+	// RecoveryCodesLeft is the number of unused recovery codes.
+	RecoveryCodesLeft int64 `json:"recovery_codes_left"`
+	// VerifiedAt is when the second factor was last verified in the current session.
+	VerifiedAt time.Time `json:"verified_at,omitzero"`
+}
+
+// TOTPEnrollment is the secret of a TOTP enrollment, to add to an authenticator app.
+type TOTPEnrollment struct {
+	// Secret is the base32-encoded secret, for typing in apps which cannot scan QR codes.
+	Secret string `json:"secret"`
+	// URI is the otpauth:// provisioning URI to show as a QR code.
+	URI string `json:"uri"`
+}
+
+// TOTPEnrollRequest is the body of the requests enrolling a TOTP secret.
+type TOTPEnrollRequest struct {
+	// Password is the current password of the user. Users without a password need a fresh single sign-on login instead.
+	Password string `json:"password"`
+}
+
+// TOTPEnableRequest is the body of the requests enabling two-factor authentication.
+type TOTPEnableRequest struct {
+	// Password is the current password of the user. Users without a password need a fresh single sign-on login instead.
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+// TOTPCodeRequest is the body of the requests checking a TOTP or recovery code.
+type TOTPCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// RecoveryCodes are newly generated recovery codes. They cannot be retrieved later.
+type RecoveryCodes struct {
+	Codes []string `json:"codes"`
+}
+
+// NewSecretBox returns the box encrypting TOTP secrets with keys of ring,
+// or nil if ring is ephemeral, since secrets encrypted with it could not be decrypted after a restart.
+func NewSecretBox(ring *keys.Ring) *secretbox.Box {
+	if ring.Ephemeral() {
+		return nil
+	}
+	return secretbox.New(ring, "totp-secrets")
+}
+
+// totpAD binds encrypted TOTP secrets to their user, so a secret copied to another user cannot be opened.
+func totpAD(userID int64) []byte {
+	return fmt.Appendf(nil, "totp:%d", userID)
+}
+
+// totpEnabled reports whether the user userID has two-factor authentication enabled.
+func totpEnabled(ctx context.Context, s *store.Store, userID int64) (bool, error) {
+	cred, err := s.Queries.GetTOTPCredential(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("cannot load TOTP credential: %w", err)
+	}
+	return cred.EnabledAt != 0, nil
+}
+
+// verifySecondFactor reports whether code is a valid TOTP or unused recovery code of the user userID,
+// which has two-factor authentication enabled.
+//
+// Accepted codes are consumed: TOTP codes cannot be replayed and recovery codes are single use.
+func verifySecondFactor(ctx context.Context, s *store.Store, box *secretbox.Box, userID int64, code string) (bool, error) {
+	cred, err := s.Queries.GetTOTPCredential(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("cannot load TOTP credential: %w", err)
+	}
+	if cred.EnabledAt == 0 {
+		return false, nil
+	}
+
+	now := time.Now()
+	code = strings.TrimSpace(code)
+	if len(strings.ReplaceAll(code, " ", "")) == totp.Digits {
+		if box == nil {
+			return false, errNoEncryption
+		}
+		secret, err := box.Open(cred.Secret, totpAD(userID))
+		if err != nil {
+			return false, fmt.Errorf("cannot decrypt TOTP secret of user %d: %w", userID, err)
+		}
+		step, ok := totp.Validate(secret, code, now, totpSkew)
+		if !ok {
+			return false, nil
+		}
+		n, err := s.Queries.UseTOTPStep(ctx, dal.UseTOTPStepParams{LastUsedStep: step, UserID: userID, LastUsedStep_2: step})
+		if err != nil {
+			return false, fmt.Errorf("cannot store TOTP use: %w", err)
+		}
+		return n == 1, nil
+	}
+
+	n, err := s.Queries.UseRecoveryCode(ctx, dal.UseRecoveryCodeParams{
+		UsedAt:   now.Unix(),
+		UserID:   userID,
+		CodeHash: hashRecoveryCode(code),
+	})
+	if err != nil {
+		return false, fmt.Errorf("cannot store recovery code use: %w", err)
+	}
+	if n == 1 {
+		logger.InfoCtx(ctx, "auth.mfa.recovery_code_used")
+	}
+	return n == 1, nil
+}
+
+// recoveryEncoding encodes recovery codes in lowercase base32, which is unambiguous to read and type.
+var recoveryEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// newRecoveryCode returns a random code of 80 bits, in groups of 4 characters, e.g. abcd-efgh-ijkl-mnop.
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("cannot generate recovery code: %w", err)
+	}
+	s := recoveryEncoding.EncodeToString(b)
+	return s[0:4] + "-" + s[4:8] + "-" + s[8:12] + "-" + s[12:16], nil
+}
+
+// hashRecoveryCode returns the SHA-256 hash of code, ignoring its case, spaces and dashes.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// replaceRecoveryCodes replaces the recovery codes of the user userID with new ones, and returns them.
+func replaceRecoveryCodes(ctx context.Context, q *dal.Queries, userID int64) ([]string, error) {
+	if err := q.DeleteRecoveryCodes(ctx, userID); err != nil {
+		return nil, fmt.Errorf("cannot delete recovery codes: %w", err)
+	}
+	now := time.Now().Unix()
+	codes := make([]string, 0, recoveryCodesCount)
+	for range recoveryCodesCount {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		err = q.CreateRecoveryCode(ctx, dal.CreateRecoveryCodeParams{UserID: userID, CodeHash: hashRecoveryCode(code), CreatedAt: now})
+		if err != nil {
+			return nil, fmt.Errorf("cannot create recovery code: %w", err)
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// markSessionMFA records that the second factor was just verified in the session of p.
+func markSessionMFA(ctx context.Context, s *store.Store, p *Principal) error {
+	now := time.Now()
+	if err := s.Queries.SetSessionMFA(ctx, dal.SetSessionMFAParams{MfaAt: now.Unix(), ID: p.sessionID}); err != nil {
+		return fmt.Errorf("cannot update session: %w", err)
+	}
+	p.mfaAt = now
+	return nil
+}
+
+// sessionPrincipal returns the principal of session requests. It writes an error response and returns nil for other requests.
+func sessionPrincipal(w http.ResponseWriter, r *http.Request) *Principal {
+	p := PrincipalFromContext(r.Context())
+	if p == nil {
+		httpserver.WriteError(w, r, http.StatusUnauthorized, nil)
+		return nil
+	}
+	if p.sessionID == "" {
+		httpserver.WriteJSON(w, r, http.StatusForbidden, httpserver.ErrResponse{Error: errSessionNeeded.Error()})
+		return nil
+	}
+	return p
+}
+
+// RequireStepUp rejects session requests with 403 Forbidden and the ProblemStepUpRequired problem type
+// unless the second factor was verified in the session for less than -auth.stepUpMaxAge.
+// Enrolling a second factor also needs the password of the user, so a stolen session cookie is not enough for
+// sensitive operations; see reauthenticate.
+//
+// Users without two-factor authentication must enable it first. API tokens are not interactive and are not affected.
+func RequireStepUp(s *store.Store) httpserver.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			p := PrincipalFromContext(ctx)
+			if p == nil {
+				httpserver.WriteError(w, r, http.StatusUnauthorized, nil)
+				return
+			}
+			if p.sessionID == "" || !p.mfaAt.IsZero() && time.Since(p.mfaAt) < *stepUpMaxAge {
+				next.ServeHTTP(w, r)
+				return
+			}
+			enabled, err := totpEnabled(ctx, s, p.ID)
+			if err != nil {
+				httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			detail := "Verify a two-factor code to continue"
+			if !enabled {
+				detail = "Enable two-factor authentication to continue"
+			}
+			stepUpRequiredTotal.Inc()
+			httpserver.WriteProblem(w, r, httpserver.Problem{
+				Type:   ProblemStepUpRequired,
+				Title:  "Step-up authentication required",
+				Status: http.StatusForbidden,
+				Detail: detail,
+			})
+		})
+	}
+}
+
+// reauthenticate checks that the user of the session p is at the keyboard, with their current password.
+// Users without a password, created by single sign-on, must have logged in for less than -auth.stepUpMaxAge instead.
+// It writes an error response and returns false if the check fails.
+//
+// Passwords are checked like logins of the account, so sessions cannot guess passwords past lockouts; see reserveLoginAttempt.
+func reauthenticate(w http.ResponseWriter, r *http.Request, s *store.Store, p *Principal, pass string) bool {
+	ctx := r.Context()
+	u, err := s.Queries.GetUserByUsername(ctx, p.Username)
+	if err != nil {
+		httpserver.WriteError(w, r, http.StatusInternalServerError, fmt.Errorf("cannot load user: %w", err))
+		return false
+	}
+	if u.PasswordHash == "" {
+		if time.Since(p.sessionCreatedAt) >= *stepUpMaxAge {
+			httpserver.WriteJSON(w, r, http.StatusForbidden, httpserver.ErrResponse{Error: errStaleSession.Error()})
+			return false
+		}
+		return true
+	}
+
+	keys := []loginKey{userLoginKey(p.Username)}
+	attempt, retryAfter, err := reserveLoginAttempt(ctx, s, keys)
+	if err != nil {
+		httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+		return false
+	}
+	if retryAfter > 0 {
+		throttledLoginsTotal.Inc()
+		writeLoginThrottled(w, r, retryAfter, "logins")
+		return false
+	}
+	_, err = Authenticate(ctx, s, p.Username, pass)
+	if errors.Is(err, ErrInvalidCredentials) {
+		failedLoginsTotal.Inc()
+		logger.InfoCtx(ctx, "auth.reauthenticate.failed", "user_id", p.ID)
+		if err := attempt.fail(ctx, s); err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+			return false
+		}
+		httpserver.WriteJSON(w, r, http.StatusForbidden, httpserver.ErrResponse{Error: errWrongPassword.Error()})
+		return false
+	}
+	if err != nil {
+		httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+		return false
+	}
+	if err := attempt.release(ctx, s, keys[0]); err != nil {
+		httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+		return false
+	}
+	return true
+}
+
+// TOTPStatusHandler returns the two-factor authentication status of the current user.
+func TOTPStatusHandler(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		p := sessionPrincipal(w, r)
+		if p == nil {
+			return
+		}
+		enabled, err := totpEnabled(ctx, s, p.ID)
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		status := TOTPStatus{Enabled: enabled, VerifiedAt: p.mfaAt}
+		if enabled {
+			if status.RecoveryCodesLeft, err = s.Queries.CountRecoveryCodes(ctx, p.ID); err != nil {
+				httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+				return
+			}
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		httpserver.WriteJSON(w, r, http.StatusOK, status)
+	}
+}
+
+// TOTPEnrollHandler generates a TOTP secret for the current user, replacing any unconfirmed one.
+//
+// The request needs the current password of the user; see reauthenticate. Users with two-factor authentication
+// must disable it first, which needs a code.
+// Two-factor authentication is enabled once the user confirms the secret with a code; see TOTPEnableHandler.
+func TOTPEnrollHandler(s *store.Store, box *secretbox.Box) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		p := sessionPrincipal(w, r)
+		if p == nil {
+			return
+		}
+		req, err := httpserver.DecodeJSON[TOTPEnrollRequest](r)
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if box == nil {
+			httpserver.WriteJSON(w, r, http.StatusNotImplemented, httpserver.ErrResponse{Error: errNoEncryption.Error()})
+			return
+		}
+		enabled, err := totpEnabled(ctx, s, p.ID)
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		if enabled {
+			httpserver.WriteJSON(w, r, http.StatusConflict, httpserver.ErrResponse{Error: "two-factor authentication is already enabled"})
+			return
+		}
+		if !reauthenticate(w, r, s, p, req.Password) {
+			return
+		}
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		sealed, err := box.Seal(secret, totpAD(p.ID))
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		n, err := s.Queries.CreateTOTPCredential(ctx, dal.CreateTOTPCredentialParams{UserID: p.ID, Secret: sealed, CreatedAt: time.Now().Unix()})
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		if n == 0 {
+			httpserver.WriteJSON(w, r, http.StatusConflict, httpserver.ErrResponse{Error: "two-factor authentication is already enabled"})
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		httpserver.WriteJSON(w, r, http.StatusOK, TOTPEnrollment{
+			Secret: totp.EncodeSecret(secret),
+			URI:    totp.URI(*totpIssuer, p.Username, secret),
+		})
+	}
+}
+
+// TOTPEnableHandler enables two-factor authentication once the user proves their app has the enrolled secret,
+// and returns their recovery codes.
+//
+// The request needs the current password of the user too; see reauthenticate.
+func TOTPEnableHandler(s *store.Store, box *secretbox.Box) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		p := sessionPrincipal(w, r)
+		if p == nil {
+			return
+		}
+		req, err := httpserver.DecodeJSON[TOTPEnableRequest](r)
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if box == nil {
+			httpserver.WriteJSON(w, r, http.StatusNotImplemented, httpserver.ErrResponse{Error: errNoEncryption.Error()})
+			return
+		}
+		cred, err := s.Queries.GetTOTPCredential(ctx, p.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			httpserver.WriteJSON(w, r, http.StatusConflict, httpserver.ErrResponse{Error: "no pending enrollment; enroll first"})
+			return
+		}
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		if cred.EnabledAt != 0 {
+			httpserver.WriteJSON(w, r, http.StatusConflict, httpserver.ErrResponse{Error: "two-factor authentication is already enabled"})
+			return
+		}
+		if !reauthenticate(w, r, s, p, req.Password) {
+			return
+		}
+		secret, err := box.Open(cred.Secret, totpAD(p.ID))
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, fmt.Errorf("cannot decrypt TOTP secret of user %d: %w", p.ID, err))
+			return
+		}
+		step, ok := totp.Validate(secret, req.Code, time.Now(), totpSkew)
+		if !ok {
+			failedMFAVerificationsTotal.Inc()
+			httpserver.WriteJSON(w, r, http.StatusBadRequest, httpserver.ErrResponse{Error: errInvalidCode.Error()})
+			return
+		}
+
+		var codes []string
+		err = store.WithTx(ctx, s.DB, func(ctx context.Context, q *dal.Queries) error {
+			n, err := q.EnableTOTPCredential(ctx, dal.EnableTOTPCredentialParams{EnabledAt: time.Now().Unix(), LastUsedStep: step, UserID: p.ID})
+			if err != nil {
+				return fmt.Errorf("cannot enable TOTP credential: %w", err)
+			}
+			if n == 0 {
+				return errors.New("two-factor authentication was enabled concurrently")
+			}
+			if codes, err = replaceRecoveryCodes(ctx, q, p.ID); err != nil {
+				return err
+			}
+			return audit.Record(ctx, audit.ActionTOTPEnable, userTarget(p.Username), audit.Diff{"totp": {From: false, To: true}})
+		})
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		if err := markSessionMFA(ctx, s, p); err != nil {
+			logger.ErrorCtx(ctx, "auth.mfa.session", "err", err)
+		}
+
+		mfaVerificationsTotal.Inc()
+		logger.InfoCtx(ctx, "auth.mfa.enabled")
+		w.Header().Set("Cache-Control", "no-store")
+		httpserver.WriteJSON(w, r, http.StatusOK, RecoveryCodes{Codes: codes})
+	}
+}
+
+// TOTPVerifyHandler checks a TOTP or recovery code of the current user, so the session can call sensitive endpoints;
+// see RequireStepUp.
+//
+// Failed codes delay and then lock the next verifications of the user like failed logins, with 429 Too Many Requests
+// and the ProblemLoginThrottled problem type.
+func TOTPVerifyHandler(s *store.Store, box *secretbox.Box) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		p := sessionPrincipal(w, r)
+		if p == nil {
+			return
+		}
+		req, err := httpserver.DecodeJSON[TOTPCodeRequest](r)
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		keys := []loginKey{mfaLoginKey(p.Username)}
+		attempt, retryAfter, err := reserveLoginAttempt(ctx, s, keys)
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		if retryAfter > 0 {
+			throttledMFAVerificationsTotal.Inc()
+			logger.InfoCtx(ctx, "auth.mfa.throttled")
+			writeLoginThrottled(w, r, retryAfter, "codes")
+			return
+		}
+		ok, err := verifySecondFactor(ctx, s, box, p.ID, req.Code)
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		if !ok {
+			failedMFAVerificationsTotal.Inc()
+			logger.InfoCtx(ctx, "auth.mfa.failed")
+			if err := attempt.fail(ctx, s); err != nil {
+				httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			httpserver.WriteJSON(w, r, http.StatusBadRequest, httpserver.ErrResponse{Error: errInvalidCode.Error()})
+			return
+		}
+		if err := attempt.release(ctx, s, keys[0]); err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		if err := markSessionMFA(ctx, s, p); err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		mfaVerificationsTotal.Inc()
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// RecoveryCodesHandler replaces the recovery codes of the current user. It must be wrapped with RequireStepUp.
+func RecoveryCodesHandler(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		p := sessionPrincipal(w, r)
+		if p == nil {
+			return
+		}
+		var codes []string
+		err := store.WithTx(ctx, s.DB, func(ctx context.Context, q *dal.Queries) error {
+			cred, err := q.GetTOTPCredential(ctx, p.ID)
+			if errors.Is(err, sql.ErrNoRows) || err == nil && cred.EnabledAt == 0 {
+				return errTOTPDisabled
+			}
+			if err != nil {
+				return fmt.Errorf("cannot load TOTP credential: %w", err)
+			}
+			if codes, err = replaceRecoveryCodes(ctx, q, p.ID); err != nil {
+				return err
+			}
+			return audit.Record(ctx, audit.ActionRecoveryCodesRenew, userTarget(p.Username), nil)
+		})
+		if errors.Is(err, errTOTPDisabled) {
+			httpserver.WriteJSON(w, r, http.StatusConflict, httpserver.ErrResponse{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		logger.InfoCtx(ctx, "auth.mfa.recovery_codes_replaced")
+		w.Header().Set("Cache-Control", "no-store")
+		httpserver.WriteJSON(w, r, http.StatusOK, RecoveryCodes{Codes: codes})
+	}
+}
+
+// TOTPDisableHandler disables two-factor authentication of the current user. It must be wrapped with RequireStepUp.
+func TOTPDisableHandler(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		p := sessionPrincipal(w, r)
+		if p == nil {
+			return
+		}
+		if err := DisableTOTP(ctx, s, p.User); err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		logger.InfoCtx(ctx, "auth.mfa.disabled")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ResetUserTOTP disables two-factor authentication of the user with the given username, e.g. after they lost their device.
+func ResetUserTOTP(ctx context.Context, s *store.Store, username string) error {
+	u, err := s.Queries.GetUserByUsername(ctx, username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("user %q does not exist", username)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot load user: %w", err)
+	}
+	return DisableTOTP(ctx, s, User{ID: u.ID, Username: u.Username})
+}
+
+// DisableTOTP removes the TOTP secret and the recovery codes of u.
+func DisableTOTP(ctx context.Context, s *store.Store, u User) error {
+	return store.WithTx(ctx, s.DB, func(ctx context.Context, q *dal.Queries) error {
+		n, err := q.DeleteTOTPCredential(ctx, u.ID)
+		if err != nil {
+			return fmt.Errorf("cannot delete TOTP credential: %w", err)
+		}
+		if err := q.DeleteRecoveryCodes(ctx, u.ID); err != nil {
+			return fmt.Errorf("cannot delete recovery codes: %w", err)
+		}
+		if n == 0 {
+			return nil
+		}
+		return audit.Record(ctx, audit.ActionTOTPDisable, userTarget(u.Username), audit.Diff{"totp": {From: true, To: false}})
+	})
+}
@@ -0,0 +1,273 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AltSoyuz/adequate/internal/auth"
+	"github.com/AltSoyuz/adequate/internal/store"
+	"github.com/AltSoyuz/adequate/internal/store/dal"
+	"github.com/AltSoyuz/adequate/lib/httpserver"
+	"github.com/AltSoyuz/adequate/lib/logger"
+)
+
+// HeaderName is the request header carrying the idempotency key.
+const HeaderName = "Idempotency-Key"
+
+const (
+	maxKeyLen  = 255
+	maxBodyLen = 1 << 20
+)
+
+// replayedHeaders are the response headers stored and replayed besides Content-Type.
+//
+// Set-Cookie is not one of them: responses setting cookies may carry session tokens, which must not be stored
+// in plaintext, so they are not stored at all; see Middleware.
+var replayedHeaders = []string{"Location", "ETag"}
+
+// Middleware makes mutating requests carrying an Idempotency-Key header safe to retry.
+//
+// The first request with a given key is processed and its response is stored for ttl.
+// Retries with the same key and payload get the stored response replayed.
+// Retries with the same key and a different payload are rejected with 422,
+// and retries arriving while the first request is still processed are rejected with 409.
+// Server errors, panics and responses setting cookies are not stored, so their retries are processed again.
+//
+// Keys are scoped to the principal of the request, so the middleware must run inside auth.Middleware.
+// Responses are never replayed to other principals, and requests of anonymous clients are not deduplicated.
+func Middleware(s *store.Store, ttl time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			clientKey := r.Header.Get(HeaderName)
+			if clientKey == "" || httpserver.IsSafeMethod(r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(clientKey) > maxKeyLen {
+				httpserver.WriteError(w, r, http.StatusBadRequest, fmt.Errorf("%s header must not exceed %d bytes", HeaderName, maxKeyLen))
+				return
+			}
+			scope := principalScope(auth.PrincipalFromContext(r.Context()))
+			if scope == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			key := scope + ":" + clientKey
+
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxBodyLen+1))
+			_ = r.Body.Close()
+			if err != nil {
+				httpserver.WriteError(w, r, http.StatusBadRequest, fmt.Errorf("cannot read request body: %w", err))
+				return
+			}
+			if len(body) > maxBodyLen {
+				httpserver.WriteError(w, r, http.StatusRequestEntityTooLarge, fmt.Errorf("request body must not exceed %d bytes", maxBodyLen))
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			ctx := r.Context()
+			fp := fingerprint(r, body)
+			now := time.Now()
+
+			created, err := s.Queries.CreateIdempotencyKey(ctx, dal.CreateIdempotencyKeyParams{
+				IdempotencyKey: key,
+				Fingerprint:    fp,
+				CreatedAt:      now.Unix(),
+				ExpiresAt:      now.Add(ttl).Unix(),
+			})
+			if err != nil {
+				httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			if created == 0 {
+				replay(w, r, s, key, clientKey, fp, now)
+				return
+			}
+
+			// Use a fresh context, since the request context may be canceled by now,
+			// which would leave the key pending until it expires.
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+			defer cancel()
+			defer func() {
+				if p := recover(); p != nil {
+					// Release the key, so retries are not rejected with 409 until it expires.
+					release(ctx, s, key)
+					panic(p)
+				}
+			}()
+
+			rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+
+			if rec.status >= http.StatusInternalServerError || len(rec.Header().Values("Set-Cookie")) > 0 {
+				// Server errors are worth retrying, and cookies may carry session tokens,
+				// so do not pin them to the key.
+				release(ctx, s, key)
+				return
+			}
+			headers, err := encodeHeaders(rec.Header())
+			if err != nil {
+				logger.ErrorCtx(ctx, "idempotency.complete", "key", key, "err", err)
+			}
+			if err := s.Queries.CompleteIdempotencyKey(ctx, dal.CompleteIdempotencyKeyParams{
+				Status:         int64(rec.status),
+				ContentType:    rec.Header().Get("Content-Type"),
+				Headers:        headers,
+				Body:           rec.body.Bytes(),
+				IdempotencyKey: key,
+			}); err != nil {
+				logger.ErrorCtx(ctx, "idempotency.complete", "key", key, "err", err)
+			}
+		})
+	}
+}
+
+func replay(w http.ResponseWriter, r *http.Request, s *store.Store, key, clientKey, fp string, now time.Time) {
+	k, err := s.Queries.GetIdempotencyKey(r.Context(), dal.GetIdempotencyKeyParams{
+		IdempotencyKey: key,
+		ExpiresAt:      now.Unix(),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		// The key expired between the insert attempt and this lookup.
+		httpserver.WriteError(w, r, http.StatusConflict, fmt.Errorf("%s %q is being recycled, retry the request", HeaderName, clientKey))
+		return
+	}
+	if err != nil {
+		httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if k.Fingerprint != fp {
+		httpserver.WriteError(w, r, http.StatusUnprocessableEntity, fmt.Errorf("%s %q was already used with a different request", HeaderName, clientKey))
+		return
+	}
+	if k.Status == 0 {
+		httpserver.WriteError(w, r, http.StatusConflict, fmt.Errorf("a request with %s %q is still being processed", HeaderName, clientKey))
+		return
+	}
+
+	h := w.Header()
+	if k.ContentType != "" {
+		h.Set("Content-Type", k.ContentType)
+	}
+	if k.Headers != "" {
+		var stored http.Header
+		if err := json.Unmarshal([]byte(k.Headers), &stored); err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, fmt.Errorf("cannot decode stored headers: %w", err))
+			return
+		}
+		for name, values := range stored {
+			h[name] = values
+		}
+	}
+	h.Set("Idempotent-Replayed", "true")
+	w.WriteHeader(int(k.Status))
+	_, _ = w.Write(k.Body)
+}
+
+// release deletes the pending key, so its retries are processed again.
+func release(ctx context.Context, s *store.Store, key string) {
+	if err := s.Queries.DeleteIdempotencyKey(ctx, key); err != nil {
+		logger.ErrorCtx(ctx, "idempotency.delete", "key", key, "err", err)
+	}
+}
+
+// RunCleanup deletes expired keys every interval until ctx is done.
+func RunCleanup(ctx context.Context, s *store.Store, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			n, err := s.Queries.DeleteExpiredIdempotencyKeys(ctx, now.Unix())
+			if err != nil {
+				if ctx.Err() == nil {
+					logger.Error("idempotency.cleanup", "err", err)
+				}
+				continue
+			}
+			if n > 0 {
+				logger.Info("idempotency.cleanup", "deleted", n)
+			}
+		}
+	}
+}
+
+// principalScope returns the scope of the keys of p, or an empty string for anonymous requests.
+func principalScope(p *auth.Principal) string {
+	switch {
+	case p == nil:
+		return ""
+	case p.TokenID != 0:
+		return "token:" + strconv.FormatInt(p.TokenID, 10)
+	default:
+		return "user:" + strconv.FormatInt(p.ID, 10)
+	}
+}
+
+// encodeHeaders encodes the replayedHeaders of h for storage.
+func encodeHeaders(h http.Header) (string, error) {
+	stored := http.Header{}
+	for _, name := range replayedHeaders {
+		if values := h.Values(name); len(values) > 0 {
+			stored[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+	if len(stored) == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal(stored)
+	if err != nil {
+		return "", fmt.Errorf("cannot encode headers: %w", err)
+	}
+	return string(b), nil
+}
+
+// fingerprint identifies the request payload a key was first used with.
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	_, _ = io.WriteString(h, r.Method+"\n"+r.URL.RequestURI()+"\n")
+	_, _ = h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// recorder passes the response through while keeping a copy for later replays.
+type recorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	if rec.wroteHeader {
+		return
+	}
+	rec.wroteHeader = true
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *recorder) Write(p []byte) (int, error) {
+	if !rec.wroteHeader {
+		rec.WriteHeader(http.StatusOK)
+	}
+	rec.body.Write(p)
+	return rec.ResponseWriter.Write(p)
+}
+
+func (rec *recorder) Unwrap() http.ResponseWriter { return rec.ResponseWriter }
@@ -0,0 +1,362 @@
+package maintenance
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	_ "embed"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/AltSoyuz/adequate/internal/audit"
+	"github.com/AltSoyuz/adequate/internal/store"
+	"github.com/AltSoyuz/adequate/internal/store/dal"
+	"github.com/AltSoyuz/adequate/lib/httpserver"
+	"github.com/AltSoyuz/adequate/lib/keys"
+	"github.com/AltSoyuz/adequate/lib/logger"
+	"github.com/AltSoyuz/adequate/lib/metrics"
+)
+
+var (
+	initialMode = flag.String("maintenance.mode", "", "Maintenance mode to switch to at startup: off, read_only or full. "+
+		"The mode stored in the database is kept if empty")
+	allowedIPs   = flag.String("maintenance.allowedIPs", "", "Comma-separated IPs or CIDRs of clients bypassing maintenance mode")
+	pagePath     = flag.String("maintenance.page", "", "Path to the HTML page served for UI routes in full maintenance mode. A built-in page is served if empty")
+	pollInterval = flag.Duration("maintenance.pollInterval", 5*time.Second, "How often the maintenance mode is reloaded from the database, "+
+		"so changes made with the maintenance command are picked up")
+)
+
+// BypassHeader is the request header carrying a bypass token; see BypassToken.
+const BypassHeader = "X-Maintenance-Bypass"
+
+// bypassPurpose is the purpose of the keys deriving bypass tokens.
+const bypassPurpose = "maintenance-bypass"
+
+// BypassToken returns the token bypassing maintenance mode derived from k, e.g. the active key of the keyring.
+//
+// Tokens are derived rather than configured, so no secret sits in the flags of the app. They stay valid while k is
+// in the keyring, and are revoked by rotating the keyring and dropping k.
+func BypassToken(k keys.Key) string {
+	return k.ID + "." + base64.RawURLEncoding.EncodeToString(k.Derive(bypassPurpose))
+}
+
+// validBypassToken reports whether tok was derived from a key of ring with BypassToken.
+func validBypassToken(ring *keys.Ring, tok string) bool {
+	id, _, ok := strings.Cut(tok, ".")
+	if !ok {
+		return false
+	}
+	k, ok := ring.Lookup(id)
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(tok), []byte(BypassToken(k))) == 1
+}
+
+// StatusHeader is the response header of /api/version and /api/readyz reporting the current mode.
+const StatusHeader = "X-Maintenance-Mode"
+
+// Mode is the maintenance mode of the app.
+type Mode string
+
+const (
+	// Off serves all requests.
+	Off Mode = "off"
+	// ReadOnly rejects mutating API requests.
+	ReadOnly Mode = "read_only"
+	// Full rejects all API requests and serves the maintenance page for UI routes.
+	Full Mode = "full"
+)
+
+// ParseMode parses s into a Mode.
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(s); m {
+	case Off, ReadOnly, Full:
+		return m, nil
+	}
+	return "", fmt.Errorf("invalid maintenance mode %q; supported modes: %s, %s, %s", s, Off, ReadOnly, Full)
+}
+
+// MaintenanceState is the maintenance state, as returned by the admin API.
+type MaintenanceState struct {
+	Mode      Mode      `json:"mode"`
+	Reason    string    `json:"reason"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SetMaintenanceRequest is the body of the admin request switching the maintenance mode.
+type SetMaintenanceRequest struct {
+	Mode   Mode   `json:"mode"`
+	Reason string `json:"reason"`
+}
+
+var modeGauges = map[Mode]*metrics.Gauge{
+	Off:      metrics.NewGauge(`maintenance_mode{mode="off"}`, nil),
+	ReadOnly: metrics.NewGauge(`maintenance_mode{mode="read_only"}`, nil),
+	Full:     metrics.NewGauge(`maintenance_mode{mode="full"}`, nil),
+}
+
+// Switch holds the maintenance state persisted in the store.
+//
+// The state is cached in memory, so checking it on every request is cheap.
+type Switch struct {
+	s     *store.Store
+	state atomic.Pointer[MaintenanceState]
+}
+
+// NewSwitch loads the maintenance state from s and applies -maintenance.mode, if set.
+func NewSwitch(ctx context.Context, s *store.Store) (*Switch, error) {
+	sw := &Switch{s: s}
+	if err := sw.Reload(ctx); err != nil {
+		return nil, err
+	}
+	if *initialMode != "" {
+		mode, err := ParseMode(*initialMode)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse -maintenance.mode: %w", err)
+		}
+		if mode != sw.State().Mode {
+			if _, err := sw.Set(ctx, mode, "set with -maintenance.mode"); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return sw, nil
+}
+
+// State returns the current maintenance state.
+func (sw *Switch) State() MaintenanceState {
+	return *sw.state.Load()
+}
+
+// Mode returns the current maintenance mode.
+func (sw *Switch) Mode() Mode {
+	return sw.State().Mode
+}
+
+// Set persists the maintenance mode and applies it immediately.
+func (sw *Switch) Set(ctx context.Context, mode Mode, reason string) (MaintenanceState, error) {
+	st, err := Set(ctx, sw.s, mode, reason)
+	if err != nil {
+		return MaintenanceState{}, err
+	}
+	sw.store(st)
+	return st, nil
+}
+
+// Reload loads the maintenance state from the store.
+func (sw *Switch) Reload(ctx context.Context) error {
+	st, err := Get(ctx, sw.s)
+	if err != nil {
+		return err
+	}
+	sw.store(st)
+	return nil
+}
+
+// Run reloads the maintenance state every -maintenance.pollInterval until ctx is done,
+// so changes made by other processes are picked up.
+func (sw *Switch) Run(ctx context.Context) {
+	t := time.NewTicker(*pollInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := sw.Reload(ctx); err != nil && ctx.Err() == nil {
+				logger.Error("maintenance.reload", "err", err)
+			}
+		}
+	}
+}
+
+func (sw *Switch) store(st MaintenanceState) {
+	prev := sw.state.Swap(&st)
+	if prev == nil || prev.Mode != st.Mode {
+		logger.Info("maintenance mode", "mode", st.Mode, "reason", st.Reason)
+	}
+	for mode, g := range modeGauges {
+		if mode == st.Mode {
+			g.Set(1)
+		} else {
+			g.Set(0)
+		}
+	}
+}
+
+// Get returns the maintenance state stored in s.
+func Get(ctx context.Context, s *store.Store) (MaintenanceState, error) {
+	m, err := s.Queries.GetMaintenance(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return MaintenanceState{Mode: Off}, nil
+	}
+	if err != nil {
+		return MaintenanceState{}, fmt.Errorf("cannot load maintenance state: %w", err)
+	}
+	return MaintenanceState{
+		Mode:      Mode(m.Mode),
+		Reason:    m.Reason,
+		UpdatedAt: time.Unix(m.UpdatedAt, 0).UTC(),
+	}, nil
+}
+
+// Set stores the maintenance state in s.
+//
+// Running apps pick the change up within -maintenance.pollInterval.
+func Set(ctx context.Context, s *store.Store, mode Mode, reason string) (MaintenanceState, error) {
+	if _, err := ParseMode(string(mode)); err != nil {
+		return MaintenanceState{}, err
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	err := store.WithTx(ctx, s.DB, func(ctx context.Context, q *dal.Queries) error {
+		old := dal.Maintenance{Mode: string(Off)}
+		if m, err := q.GetMaintenance(ctx); err == nil {
+			old = m
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("cannot load maintenance state: %w", err)
+		}
+		err := q.SetMaintenance(ctx, dal.SetMaintenanceParams{
+			Mode:      string(mode),
+			Reason:    reason,
+			UpdatedAt: now.Unix(),
+		})
+		if err != nil {
+			return fmt.Errorf("cannot store maintenance state: %w", err)
+		}
+		return audit.Record(ctx, audit.ActionMaintenanceSet, "maintenance", audit.Diff{
+			"mode":   {From: old.Mode, To: string(mode)},
+			"reason": {From: old.Reason, To: reason},
+		})
+	})
+	if err != nil {
+		return MaintenanceState{}, err
+	}
+	return MaintenanceState{Mode: mode, Reason: reason, UpdatedAt: now}, nil
+}
+
+// GetHandler returns the current maintenance state.
+func GetHandler(sw *Switch) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpserver.WriteJSON(w, r, http.StatusOK, sw.State())
+	}
+}
+
+// SetHandler switches the maintenance mode.
+func SetHandler(sw *Switch) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, err := httpserver.DecodeJSON[SetMaintenanceRequest](r)
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if _, err := ParseMode(string(req.Mode)); err != nil {
+			httpserver.WriteError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		st, err := sw.Set(r.Context(), req.Mode, req.Reason)
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		httpserver.WriteJSON(w, r, http.StatusOK, st)
+	}
+}
+
+//go:embed maintenance.html
+var defaultPage []byte
+
+// adminPath is the path of the admin endpoint switching the mode, which is never blocked,
+// so maintenance can be turned off over the API.
+const adminPath = "/api/admin/maintenance"
+
+var rejectedRequests = metrics.NewCounter("maintenance_rejected_requests_total")
+
+// Middleware rejects requests according to the maintenance mode of sw.
+//
+// In ReadOnly mode, mutating API requests get a 503 problem+json response.
+// In Full mode, all API requests get it, and other requests get the maintenance page.
+// Clients from -maintenance.allowedIPs or sending a bypass token derived from ring are let through.
+// Ephemeral keyrings derive no bypass tokens, since their tokens could not be printed with the keys command.
+func Middleware(sw *Switch, ring *keys.Ring) (func(http.Handler) http.Handler, error) {
+	allowed, err := httpserver.ParseIPPrefixes(*allowedIPs)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse -maintenance.allowedIPs: %w", err)
+	}
+	page := defaultPage
+	if *pagePath != "" {
+		page, err = os.ReadFile(*pagePath)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read -maintenance.page: %w", err)
+		}
+	}
+
+	bypass := func(r *http.Request) bool {
+		if tok := r.Header.Get(BypassHeader); tok != "" && !ring.Ephemeral() && validBypassToken(ring, tok) {
+			return true
+		}
+		if len(allowed) == 0 {
+			return false
+		}
+		addr, err := netip.ParseAddr(httpserver.ClientIP(r))
+		if err != nil {
+			return false
+		}
+		addr = addr.Unmap()
+		for _, p := range allowed {
+			if p.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			st := sw.State()
+			isAPI := strings.HasPrefix(r.URL.Path, "/api/")
+			blocked := false
+			switch st.Mode {
+			case ReadOnly:
+				blocked = isAPI && !httpserver.IsSafeMethod(r.Method)
+			case Full:
+				blocked = true
+			}
+			if !blocked || r.URL.Path == adminPath || bypass(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			rejectedRequests.Inc()
+			w.Header().Set(StatusHeader, string(st.Mode))
+			if !isAPI {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				w.Header().Set("Cache-Control", "no-store")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				_, _ = w.Write(page)
+				return
+			}
+
+			detail := "The service is in full maintenance"
+			if st.Mode == ReadOnly {
+				detail = "The service is in read-only maintenance"
+			}
+			if st.Reason != "" {
+				detail += ": " + st.Reason
+			}
+			httpserver.WriteProblem(w, r, httpserver.Problem{
+				Status: http.StatusServiceUnavailable,
+				Detail: detail,
+			})
+		})
+	}, nil
+}
@@ -4,14 +4,16 @@ import (
 	"net/http"
 
 	"github.com/AltSoyuz/adequate/internal/store"
+	"github.com/AltSoyuz/adequate/internal/store/dal"
 	"github.com/AltSoyuz/adequate/lib/httpserver"
 	"github.com/AltSoyuz/adequate/lib/logger"
 )
 
+type MigrationHandlerResp struct {
+	Version int64 `json:"version"`
+}
+
 func MigrationHandler(s *store.Store) http.HandlerFunc {
-	type MigrationHandlerResp struct {
-		Version int64 `json:"version"`
-	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		version, err := s.Queries.GetLastMigrationVersion(ctx)
@@ -20,10 +22,53 @@ func MigrationHandler(s *store.Store) http.HandlerFunc {
 			return
 		}
 
-		logger.Info("migration version fetched", "version", version)
+		logger.InfoCtx(ctx, "migration version fetched", "version", version)
 
-		httpserver.WriteJSON(w, r, http.StatusOK, MigrationHandlerResp{
+		httpserver.WriteJSONETag(w, r, http.StatusOK, MigrationHandlerResp{
 			Version: version,
 		})
 	}
 }
+
+type MigrationListItem struct {
+	Version int64 `json:"version"`
+}
+
+func MigrationListHandler(s *store.Store, cursors *httpserver.Cursors) http.HandlerFunc {
+	type migrationCursor struct {
+		Version int64 `json:"v"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		var after migrationCursor
+		limit, _, err := cursors.ParsePage(r, 50, 500, &after)
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		versions, err := s.Queries.ListMigrations(ctx, dal.ListMigrationsParams{
+			Version: after.Version,
+			Limit:   int64(limit + 1),
+		})
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		items := make([]MigrationListItem, 0, len(versions))
+		for _, v := range versions {
+			items = append(items, MigrationListItem{Version: v})
+		}
+		page, err := httpserver.NewPage(cursors, items, limit, func(it MigrationListItem) any {
+			return migrationCursor{Version: it.Version}
+		})
+		if err != nil {
+			httpserver.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		httpserver.WriteJSON(w, r, http.StatusOK, page)
+	}
+}
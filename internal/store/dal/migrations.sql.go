@@ -22,3 +22,43 @@ func (q *Queries) GetLastMigrationVersion(ctx context.Context) (int64, error) {
 	err := row.Scan(&version)
 	return version, err
 }
+
+const listMigrations = `-- name: ListMigrations :many
+SELECT version
+FROM schema_migrations
+WHERE version > ?
+ORDER BY version
+LIMIT ?
+`
+
+type ListMigrationsParams struct {
+	Version int64
+	Limit   int64
+}
+
+// Keyset pagination: the cursor carries the sort key of the last row of the
+// previous page, and the query fetches limit+1 rows to detect a next page.
+// With a composite sort key, compare row values instead, for example
+// WHERE (created_at, id) > (?, ?) ORDER BY created_at, id.
+func (q *Queries) ListMigrations(ctx context.Context, arg ListMigrationsParams) ([]int64, error) {
+	rows, err := q.db.QueryContext(ctx, listMigrations, arg.Version, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []int64
+	for rows.Next() {
+		var version int64
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		items = append(items, version)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
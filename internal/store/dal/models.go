@@ -4,6 +4,134 @@
 
 package dal
 
+type ApiToken struct {
+	ID         int64
+	UserID     int64
+	Name       string
+	Prefix     string
+	TokenHash  string
+	Scopes     string
+	CreatedAt  int64
+	ExpiresAt  int64
+	LastUsedAt int64
+	RevokedAt  int64
+}
+
+type AuditLog struct {
+	ID            int64
+	CreatedAt     int64
+	ActorUserID   int64
+	ActorUsername string
+	ActorTokenID  int64
+	Action        string
+	Target        string
+	Diff          string
+	RequestID     string
+	Ip            string
+	UserAgent     string
+}
+
+type IdempotencyKey struct {
+	IdempotencyKey string
+	Fingerprint    string
+	Status         int64
+	ContentType    string
+	Body           []byte
+	CreatedAt      int64
+	ExpiresAt      int64
+	Headers        string
+}
+
+type LoginFailure struct {
+	Kind          string
+	Name          string
+	Failures      int64
+	LastFailureAt int64
+	BlockedUntil  int64
+}
+
+type Maintenance struct {
+	ID        int64
+	Mode      string
+	Reason    string
+	UpdatedAt int64
+}
+
+type MfaChallenge struct {
+	TokenHash string
+	UserID    int64
+	ExpiresAt int64
+}
+
+type OidcLogin struct {
+	StateHash    string
+	Nonce        string
+	CodeVerifier string
+	Next         string
+	LinkUserID   int64
+	ExpiresAt    int64
+}
+
+type Permission struct {
+	Name        string
+	Description string
+}
+
+type RecoveryCode struct {
+	ID        int64
+	UserID    int64
+	CodeHash  string
+	CreatedAt int64
+	UsedAt    int64
+}
+
+type Role struct {
+	Name        string
+	Description string
+}
+
+type RolePermission struct {
+	Role       string
+	Permission string
+}
+
 type SchemaMigration struct {
 	Version int64
 }
+
+type Session struct {
+	ID         string
+	UserID     int64
+	CreatedAt  int64
+	LastSeenAt int64
+	ExpiresAt  int64
+	MfaAt      int64
+}
+
+type TotpCredential struct {
+	UserID       int64
+	Secret       []byte
+	CreatedAt    int64
+	EnabledAt    int64
+	LastUsedStep int64
+}
+
+type User struct {
+	ID           int64
+	Username     string
+	PasswordHash string
+	CreatedAt    int64
+	UpdatedAt    int64
+}
+
+type UserIdentity struct {
+	Issuer    string
+	Subject   string
+	UserID    int64
+	CreatedAt int64
+}
+
+type UserRole struct {
+	UserID int64
+	Role   string
+}
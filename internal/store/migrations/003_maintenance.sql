@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS maintenance (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    mode TEXT NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    updated_at INTEGER NOT NULL
+);
@@ -0,0 +1,45 @@
+CREATE TABLE IF NOT EXISTS permissions (
+    name TEXT PRIMARY KEY,
+    description TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS roles (
+    name TEXT PRIMARY KEY,
+    description TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role TEXT NOT NULL REFERENCES roles (name) ON DELETE CASCADE,
+    permission TEXT NOT NULL REFERENCES permissions (name) ON DELETE CASCADE,
+    PRIMARY KEY (role, permission)
+);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    role TEXT NOT NULL REFERENCES roles (name) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, role)
+);
+
+-- Permissions are checked by the routes declaring them; new permissions are added by later migrations.
+INSERT INTO permissions (name, description) VALUES
+    ('migrations:read', 'View the applied migrations'),
+    ('maintenance:read', 'View the maintenance state'),
+    ('maintenance:write', 'Switch the maintenance mode'),
+    ('tokens:manage', 'Create, list and revoke API tokens'),
+    ('diagnostics:read', 'View the slow requests');
+
+INSERT INTO roles (name, description) VALUES
+    ('admin', 'Full access'),
+    ('operator', 'Runs the app, e.g. switches the maintenance mode'),
+    ('viewer', 'Read-only access to the app state');
+
+INSERT INTO role_permissions (role, permission)
+SELECT 'admin', name FROM permissions;
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('operator', 'migrations:read'),
+    ('operator', 'maintenance:read'),
+    ('operator', 'maintenance:write'),
+    ('operator', 'diagnostics:read'),
+    ('viewer', 'migrations:read'),
+    ('viewer', 'maintenance:read');
@@ -0,0 +1,21 @@
+-- Identities of users at OpenID Connect providers.
+CREATE TABLE IF NOT EXISTS user_identities (
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at INTEGER NOT NULL,
+    PRIMARY KEY (issuer, subject)
+);
+
+CREATE INDEX IF NOT EXISTS user_identities_user_id ON user_identities (user_id);
+
+-- Logins redirected to an OpenID Connect provider and waiting for its callback.
+CREATE TABLE IF NOT EXISTS oidc_logins (
+    state_hash TEXT PRIMARY KEY,
+    nonce TEXT NOT NULL,
+    code_verifier TEXT NOT NULL,
+    next TEXT NOT NULL,
+    -- The user the identity is linked to, if the login was started from a session; zero otherwise.
+    link_user_id INTEGER NOT NULL DEFAULT 0,
+    expires_at INTEGER NOT NULL
+);
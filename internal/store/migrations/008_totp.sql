@@ -0,0 +1,25 @@
+-- TOTP second factors of users.
+CREATE TABLE IF NOT EXISTS totp_credentials (
+    user_id INTEGER PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    -- The secret is encrypted with the -auth.encryptionKey key.
+    secret BLOB NOT NULL,
+    created_at INTEGER NOT NULL,
+    -- Zero until the user confirms the enrollment with a first code.
+    enabled_at INTEGER NOT NULL DEFAULT 0,
+    -- The time step of the last accepted code, so codes cannot be replayed.
+    last_used_step INTEGER NOT NULL DEFAULT 0
+);
+
+-- One-time codes for logging in without the TOTP app. Only their SHA-256 hashes are stored.
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    used_at INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS recovery_codes_user_id ON recovery_codes (user_id);
+
+-- When the second factor was last verified in the session, for endpoints requiring a recent verification; zero if never.
+ALTER TABLE sessions ADD COLUMN mfa_at INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,27 @@
+-- Who changed what. Entries are written in the transaction of the change they describe.
+-- The actor columns are not foreign keys, so entries outlive the users and tokens they name.
+CREATE TABLE IF NOT EXISTS audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at INTEGER NOT NULL,
+    -- Zero and empty for changes made with the app commands rather than the API.
+    actor_user_id INTEGER NOT NULL DEFAULT 0,
+    actor_username TEXT NOT NULL DEFAULT '',
+    actor_token_id INTEGER NOT NULL DEFAULT 0,
+    action TEXT NOT NULL,
+    target TEXT NOT NULL,
+    -- JSON object of the changed fields with their old and new values.
+    diff TEXT NOT NULL DEFAULT '',
+    request_id TEXT NOT NULL DEFAULT '',
+    ip TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_action ON audit_log (action);
+CREATE INDEX IF NOT EXISTS audit_log_target ON audit_log (target);
+CREATE INDEX IF NOT EXISTS audit_log_actor_username ON audit_log (actor_username);
+
+INSERT INTO permissions (name, description) VALUES
+    ('audit:read', 'View the audit log');
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('admin', 'audit:read');
@@ -0,0 +1,5 @@
+DELETE FROM role_permissions WHERE permission = 'lockouts:manage';
+
+DELETE FROM permissions WHERE name = 'lockouts:manage';
+
+DROP TABLE IF EXISTS login_failures;
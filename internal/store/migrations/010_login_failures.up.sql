@@ -0,0 +1,21 @@
+-- Recent failed logins per account and per client IP, throttling password guessing.
+-- Accounts are tracked by username whether or not the user exists, so throttled responses do not tell which users exist.
+CREATE TABLE IF NOT EXISTS login_failures (
+    -- user for accounts and ip for client IPs.
+    kind TEXT NOT NULL,
+    -- The username or the IP address.
+    name TEXT NOT NULL,
+    failures INTEGER NOT NULL,
+    last_failure_at INTEGER NOT NULL,
+    -- Logins are rejected until this time.
+    blocked_until INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (kind, name)
+);
+
+CREATE INDEX IF NOT EXISTS login_failures_blocked_until ON login_failures (blocked_until);
+
+INSERT INTO permissions (name, description) VALUES
+    ('lockouts:manage', 'View and unlock the accounts and client IPs locked after failed logins');
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('admin', 'lockouts:manage');
@@ -0,0 +1,7 @@
+-- Logins waiting for the second factor of their user, such as single sign-on logins of users with two-factor
+-- authentication. The session is only created once the code is checked.
+CREATE TABLE IF NOT EXISTS mfa_challenges (
+    token_hash TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    expires_at INTEGER NOT NULL
+);
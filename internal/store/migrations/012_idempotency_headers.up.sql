@@ -0,0 +1,5 @@
+-- Response headers replayed with the stored response, such as Location and Set-Cookie, as a JSON object of lists.
+ALTER TABLE idempotency_keys ADD COLUMN headers TEXT NOT NULL DEFAULT '';
+
+-- Keys are now scoped to the principal of the request, so keys stored before cannot be matched anymore.
+DELETE FROM idempotency_keys;
@@ -0,0 +1,26 @@
+-- name: CreateAPIToken :one
+INSERT INTO api_tokens (user_id, name, prefix, token_hash, scopes, created_at, expires_at)
+VALUES (?, ?, ?, ?, ?, ?, ?)
+RETURNING id;
+
+-- name: GetAPITokenByHash :one
+SELECT api_tokens.id, api_tokens.user_id, api_tokens.scopes, api_tokens.expires_at, api_tokens.last_used_at, api_tokens.revoked_at, users.username
+FROM api_tokens
+JOIN users ON users.id = api_tokens.user_id
+WHERE api_tokens.token_hash = ?;
+
+-- name: ListAPITokens :many
+SELECT api_tokens.id, api_tokens.name, api_tokens.prefix, api_tokens.scopes, api_tokens.created_at, api_tokens.expires_at, api_tokens.last_used_at, api_tokens.revoked_at, users.username
+FROM api_tokens
+JOIN users ON users.id = api_tokens.user_id
+ORDER BY api_tokens.id;
+
+-- name: TouchAPIToken :exec
+UPDATE api_tokens
+SET last_used_at = ?
+WHERE id = ?;
+
+-- name: RevokeAPIToken :execrows
+UPDATE api_tokens
+SET revoked_at = ?
+WHERE id = ? AND revoked_at = 0;
@@ -0,0 +1,16 @@
+-- name: CreateAuditEntry :exec
+INSERT INTO audit_log (created_at, actor_user_id, actor_username, actor_token_id, action, target, diff, request_id, ip, user_agent)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+
+-- name: ListAuditEntries :many
+-- Newest first. Empty filters match all the entries.
+SELECT *
+FROM audit_log
+WHERE id < sqlc.arg(before_id)
+    AND (action = sqlc.arg(action) OR sqlc.arg(action) = '')
+    AND (actor_username = sqlc.arg(actor) OR sqlc.arg(actor) = '')
+    AND (target = sqlc.arg(target) OR sqlc.arg(target) = '')
+    AND created_at >= sqlc.arg(since)
+    AND created_at < sqlc.arg(until)
+ORDER BY id DESC
+LIMIT sqlc.arg(limit);
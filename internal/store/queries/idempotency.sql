@@ -0,0 +1,30 @@
+-- name: GetIdempotencyKey :one
+SELECT *
+FROM idempotency_keys
+WHERE idempotency_key = ? AND expires_at > ?;
+
+-- name: CreateIdempotencyKey :execrows
+INSERT INTO idempotency_keys (idempotency_key, fingerprint, created_at, expires_at)
+VALUES (?, ?, ?, ?)
+ON CONFLICT (idempotency_key) DO UPDATE SET
+    fingerprint = excluded.fingerprint,
+    status = 0,
+    content_type = '',
+    headers = '',
+    body = NULL,
+    created_at = excluded.created_at,
+    expires_at = excluded.expires_at
+WHERE idempotency_keys.expires_at <= excluded.created_at;
+
+-- name: CompleteIdempotencyKey :exec
+UPDATE idempotency_keys
+SET status = ?, content_type = ?, headers = ?, body = ?
+WHERE idempotency_key = ?;
+
+-- name: DeleteIdempotencyKey :exec
+DELETE FROM idempotency_keys
+WHERE idempotency_key = ?;
+
+-- name: DeleteExpiredIdempotencyKeys :execrows
+DELETE FROM idempotency_keys
+WHERE expires_at <= ?;
@@ -0,0 +1,42 @@
+-- name: ReserveLoginAttempt :one
+-- Counts an attempt as failed before it is checked, unless logins are blocked, in which case no row is returned.
+INSERT INTO login_failures (kind, name, failures, last_failure_at)
+VALUES (sqlc.arg(kind), sqlc.arg(name), 1, sqlc.arg(now))
+ON CONFLICT (kind, name) DO UPDATE SET
+    failures = CASE
+        WHEN login_failures.last_failure_at <= sqlc.arg(forget_before) AND login_failures.blocked_until <= sqlc.arg(forget_before) THEN 1
+        ELSE login_failures.failures + 1
+    END,
+    last_failure_at = excluded.last_failure_at
+WHERE login_failures.blocked_until <= sqlc.arg(now)
+RETURNING *;
+
+-- name: ReleaseLoginAttempt :exec
+-- Uncounts a reserved attempt which succeeded, and lifts the block it set unless a later failure replaced it.
+UPDATE login_failures
+SET failures = MAX(failures - 1, 0),
+    blocked_until = CASE WHEN blocked_until = sqlc.arg(reserved_until) THEN 0 ELSE blocked_until END
+WHERE kind = sqlc.arg(kind) AND name = sqlc.arg(name);
+
+-- name: BlockLogins :exec
+UPDATE login_failures
+SET blocked_until = ?
+WHERE kind = ? AND name = ?;
+
+-- name: GetLoginFailures :one
+SELECT * FROM login_failures
+WHERE kind = ? AND name = ?;
+
+-- name: ListBlockedLogins :many
+SELECT * FROM login_failures
+WHERE blocked_until > ?
+ORDER BY blocked_until DESC, kind, name;
+
+-- name: DeleteLoginFailures :execrows
+DELETE FROM login_failures
+WHERE kind = ? AND name = ?;
+
+-- name: DeleteExpiredLoginFailures :execrows
+-- Failures are forgotten once both the last failure and the block are older than forget_before.
+DELETE FROM login_failures
+WHERE last_failure_at <= sqlc.arg(forget_before) AND blocked_until <= sqlc.arg(forget_before);
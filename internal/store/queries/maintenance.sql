@@ -0,0 +1,12 @@
+-- name: GetMaintenance :one
+SELECT *
+FROM maintenance
+WHERE id = 1;
+
+-- name: SetMaintenance :exec
+INSERT INTO maintenance (id, mode, reason, updated_at)
+VALUES (1, ?, ?, ?)
+ON CONFLICT (id) DO UPDATE SET
+    mode = excluded.mode,
+    reason = excluded.reason,
+    updated_at = excluded.updated_at;
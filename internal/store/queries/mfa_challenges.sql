@@ -0,0 +1,17 @@
+-- name: CreateMFAChallenge :exec
+INSERT INTO mfa_challenges (token_hash, user_id, expires_at)
+VALUES (?, ?, ?);
+
+-- name: GetMFAChallengeUser :one
+SELECT u.id, u.username
+FROM mfa_challenges c
+JOIN users u ON u.id = c.user_id
+WHERE c.token_hash = ? AND c.expires_at > ?;
+
+-- name: DeleteMFAChallenge :execrows
+DELETE FROM mfa_challenges
+WHERE token_hash = ?;
+
+-- name: DeleteExpiredMFAChallenges :execrows
+DELETE FROM mfa_challenges
+WHERE expires_at <= ?;
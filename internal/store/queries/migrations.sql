@@ -2,4 +2,15 @@
 SELECT version
 FROM schema_migrations
 ORDER BY version DESC
-LIMIT 1;
\ No newline at end of file
+LIMIT 1;
+
+-- name: ListMigrations :many
+-- Keyset pagination: the cursor carries the sort key of the last row of the
+-- previous page, and the query fetches limit+1 rows to detect a next page.
+-- With a composite sort key, compare row values instead, for example
+-- WHERE (created_at, id) > (?, ?) ORDER BY created_at, id.
+SELECT version
+FROM schema_migrations
+WHERE version > ?
+ORDER BY version
+LIMIT ?;
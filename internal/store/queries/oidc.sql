@@ -0,0 +1,22 @@
+-- name: CreateOIDCLogin :exec
+INSERT INTO oidc_logins (state_hash, nonce, code_verifier, next, link_user_id, expires_at)
+VALUES (?, ?, ?, ?, ?, ?);
+
+-- name: TakeOIDCLogin :one
+DELETE FROM oidc_logins
+WHERE state_hash = ?
+RETURNING *;
+
+-- name: DeleteExpiredOIDCLogins :execrows
+DELETE FROM oidc_logins
+WHERE expires_at <= ?;
+
+-- name: GetIdentityUser :one
+SELECT u.id, u.username
+FROM user_identities i
+JOIN users u ON u.id = i.user_id
+WHERE i.issuer = ? AND i.subject = ?;
+
+-- name: CreateUserIdentity :exec
+INSERT INTO user_identities (issuer, subject, user_id, created_at)
+VALUES (?, ?, ?, ?);
@@ -0,0 +1,25 @@
+-- name: ListRoles :many
+SELECT name
+FROM roles
+ORDER BY name;
+
+-- name: ListUserRoles :many
+SELECT role
+FROM user_roles
+WHERE user_id = ?
+ORDER BY role;
+
+-- name: ListUserPermissions :many
+SELECT DISTINCT rp.permission
+FROM user_roles ur
+JOIN role_permissions rp ON rp.role = ur.role
+WHERE ur.user_id = ?
+ORDER BY rp.permission;
+
+-- name: AddUserRole :exec
+INSERT OR IGNORE INTO user_roles (user_id, role)
+VALUES (?, ?);
+
+-- name: DeleteUserRoles :exec
+DELETE FROM user_roles
+WHERE user_id = ?;
@@ -0,0 +1,31 @@
+-- name: CreateSession :exec
+INSERT INTO sessions (id, user_id, created_at, last_seen_at, expires_at, mfa_at)
+VALUES (?, ?, ?, ?, ?, ?);
+
+-- name: GetSession :one
+SELECT sessions.id, sessions.user_id, sessions.created_at, sessions.last_seen_at, sessions.expires_at, sessions.mfa_at, users.username
+FROM sessions
+JOIN users ON users.id = sessions.user_id
+WHERE sessions.id = ?;
+
+-- name: TouchSession :exec
+UPDATE sessions
+SET last_seen_at = ?
+WHERE id = ?;
+
+-- name: SetSessionMFA :exec
+UPDATE sessions
+SET mfa_at = ?
+WHERE id = ?;
+
+-- name: DeleteSession :exec
+DELETE FROM sessions
+WHERE id = ?;
+
+-- name: DeleteUserSessions :execrows
+DELETE FROM sessions
+WHERE user_id = ?;
+
+-- name: DeleteExpiredSessions :execrows
+DELETE FROM sessions
+WHERE expires_at <= ? OR last_seen_at <= ?;
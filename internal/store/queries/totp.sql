@@ -0,0 +1,40 @@
+-- name: CreateTOTPCredential :execrows
+INSERT INTO totp_credentials (user_id, secret, created_at)
+VALUES (?, ?, ?)
+ON CONFLICT (user_id) DO UPDATE SET secret = excluded.secret, created_at = excluded.created_at
+WHERE totp_credentials.enabled_at = 0;
+
+-- name: GetTOTPCredential :one
+SELECT * FROM totp_credentials
+WHERE user_id = ?;
+
+-- name: EnableTOTPCredential :execrows
+UPDATE totp_credentials
+SET enabled_at = ?, last_used_step = ?
+WHERE user_id = ? AND enabled_at = 0;
+
+-- name: UseTOTPStep :execrows
+UPDATE totp_credentials
+SET last_used_step = ?
+WHERE user_id = ? AND last_used_step < ?;
+
+-- name: DeleteTOTPCredential :execrows
+DELETE FROM totp_credentials
+WHERE user_id = ?;
+
+-- name: CreateRecoveryCode :exec
+INSERT INTO recovery_codes (user_id, code_hash, created_at)
+VALUES (?, ?, ?);
+
+-- name: UseRecoveryCode :execrows
+UPDATE recovery_codes
+SET used_at = ?
+WHERE user_id = ? AND code_hash = ? AND used_at = 0;
+
+-- name: CountRecoveryCodes :one
+SELECT COUNT(*) FROM recovery_codes
+WHERE user_id = ? AND used_at = 0;
+
+-- name: DeleteRecoveryCodes :exec
+DELETE FROM recovery_codes
+WHERE user_id = ?;
@@ -0,0 +1,14 @@
+-- name: CreateUser :one
+INSERT INTO users (username, password_hash, created_at, updated_at)
+VALUES (?, ?, ?, ?)
+RETURNING id;
+
+-- name: GetUserByUsername :one
+SELECT *
+FROM users
+WHERE username = ?;
+
+-- name: UpdateUserPassword :execrows
+UPDATE users
+SET password_hash = ?, updated_at = ?
+WHERE id = ?;
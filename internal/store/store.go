@@ -12,6 +12,7 @@ import (
 	"github.com/AltSoyuz/adequate/internal/store/dal"
 	"github.com/AltSoyuz/adequate/lib/db"
 	"github.com/AltSoyuz/adequate/lib/logger"
+	"github.com/AltSoyuz/adequate/lib/reqtrace"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -23,7 +24,18 @@ type Store struct {
 	Queries *dal.Queries
 }
 
+// Init opens the database at path and applies its pending migrations.
 func Init(ctx context.Context, path string) *Store {
+	s := Open(ctx, path)
+	if err := db.Migrate(ctx, s.DB, migFS); err != nil {
+		s.Close()
+		logger.Fatal("store.migrate", "err", err)
+	}
+	return s
+}
+
+// Open opens the database at path without migrating it, e.g. to roll back its migrations.
+func Open(ctx context.Context, path string) *Store {
 	// Ensure the directory exists
 	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0755); err != nil {
@@ -51,36 +63,46 @@ func Init(ctx context.Context, path string) *Store {
 		logger.Fatal("store.init.ping", "err", err)
 	}
 
-	err = db.Migrate(ctx, sqlDb, migFS)
-	if err != nil {
-		_ = sqlDb.Close()
-		logger.Fatal("store.migrate", "err", err)
-	}
-
-	q := dal.New(sqlDb)
+	q := dal.New(db.TracedDB{DB: sqlDb})
 	return &Store{DB: sqlDb, Queries: q}
 }
 
+// MigrateDown rolls the database back to version to with the down scripts of the migrations applied after it.
+func (s *Store) MigrateDown(ctx context.Context, to int) error {
+	return db.MigrateDown(ctx, s.DB, migFS, to)
+}
+
 func (s *Store) Close() {
 	if err := s.DB.Close(); err != nil {
 		logger.Error("store.close", "err", err)
 	}
 }
 
+type txKey struct{}
+
+// WithTx runs fn in a transaction, which is committed if fn returns nil and rolled back otherwise.
+//
+// The queries of the transaction are also attached to the context of fn, for TxQueries.
 func WithTx(ctx context.Context, db *sql.DB, fn func(ctx context.Context, q *dal.Queries) error) error {
-	tx, err := db.BeginTx(ctx, nil)
+	tx, err := db.BeginTx(reqtrace.WithIssued(ctx), nil)
 	if err != nil {
 		return err
 	}
 	defer func() {
 		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
-			logger.Error("store.tx.rollback", "error", err)
+			logger.ErrorCtx(ctx, "store.tx.rollback", "error", err)
 		}
 	}()
 
 	q := dal.New(tx)
-	if err := fn(ctx, q); err != nil {
+	if err := fn(context.WithValue(ctx, txKey{}, q), q); err != nil {
 		return err
 	}
 	return tx.Commit()
 }
+
+// TxQueries returns the queries of the transaction run by WithTx with ctx, or nil outside of WithTx.
+func TxQueries(ctx context.Context) *dal.Queries {
+	q, _ := ctx.Value(txKey{}).(*dal.Queries)
+	return q
+}
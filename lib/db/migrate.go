@@ -2,7 +2,10 @@ package db
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"flag"
 	"fmt"
 	"io"
 	"io/fs"
@@ -14,60 +17,106 @@ import (
 	"github.com/AltSoyuz/adequate/lib/logger"
 )
 
+var allowMigrationDrift = flag.Bool("db.allowMigrationDrift", false, "Whether to start when migrations were changed after they were applied, "+
+	"logging a warning instead of failing. Changed migrations are not applied again")
+
+// migration is a schema change, read from NNN_name.sql or from the paired NNN_name.up.sql and NNN_name.down.sql files.
 type migration struct {
 	v    int
 	name string
 	sql  string
+	// down reverts sql. It is empty for single-file migrations, which cannot be rolled back.
+	down     string
+	downName string
 }
 
-func Migrate(ctx context.Context, db *sql.DB, dir fs.FS) error {
-	start := time.Now()
+// checksum returns the SHA-256 of the up script of m, recorded when m is applied.
+func (m migration) checksum() string {
+	sum := sha256.Sum256([]byte(m.sql))
+	return hex.EncodeToString(sum[:])
+}
 
+// readMigrations returns the migrations of the migrations dir of dir, sorted by version.
+func readMigrations(dir fs.FS) ([]migration, error) {
 	entries, err := fs.ReadDir(dir, "migrations")
 	if err != nil {
-		return fmt.Errorf("migrate: readdir: %w", err)
+		return nil, fmt.Errorf("migrate: readdir: %w", err)
 	}
 
-	var ms []migration
-	seen := map[int]string{}
+	byVersion := map[int]*migration{}
+	var downs []migration
 
 	for _, e := range entries {
 		if e.IsDir() {
 			continue
 		}
 
-		name := e.Name() // ex: 001_init.sql
+		name := e.Name() // ex: 001_init.sql, 002_posts.up.sql or 002_posts.down.sql
 		if strings.HasPrefix(name, ".") {
 			continue
 		}
 		parts := strings.SplitN(name, "_", 2)
 		v, err := strconv.Atoi(parts[0])
 		if err != nil {
-			return fmt.Errorf("migrate: bad name %q: %w", name, err)
+			return nil, fmt.Errorf("migrate: bad name %q: %w", name, err)
 		}
-		if prev, ok := seen[v]; ok {
-			return fmt.Errorf("migrate: duplicate version %d: %q and %q", v, prev, name)
-		}
-		seen[v] = name
 
 		f, err := dir.Open("migrations/" + name)
 		if err != nil {
-			return fmt.Errorf("migrate: read %q: %w", name, err)
+			return nil, fmt.Errorf("migrate: read %q: %w", name, err)
 		}
 
 		b, err := io.ReadAll(f)
+		_ = f.Close()
 		if err != nil {
-			return fmt.Errorf("migrate: read %q: %w", name, err)
+			return nil, fmt.Errorf("migrate: read %q: %w", name, err)
 		}
 
-		ms = append(ms, migration{
+		if strings.HasSuffix(name, ".down.sql") {
+			downs = append(downs, migration{v: v, down: string(b), downName: name})
+			continue
+		}
+		if prev, ok := byVersion[v]; ok {
+			return nil, fmt.Errorf("migrate: duplicate version %d: %q and %q", v, prev.name, name)
+		}
+		byVersion[v] = &migration{
 			v:    v,
 			name: name,
 			sql:  string(b),
-		})
+		}
+	}
+
+	for _, d := range downs {
+		m, ok := byVersion[d.v]
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("migrate: down script %q has no up script", d.downName)
+		case !strings.HasSuffix(m.name, ".up.sql"):
+			return nil, fmt.Errorf("migrate: down script %q pairs with %q; name it %s.up.sql",
+				d.downName, m.name, strings.TrimSuffix(m.name, ".sql"))
+		case m.downName != "":
+			return nil, fmt.Errorf("migrate: duplicate down scripts for version %d: %q and %q", d.v, m.downName, d.downName)
+		}
+		m.down, m.downName = d.down, d.downName
 	}
 
+	ms := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		ms = append(ms, *m)
+	}
 	sort.Slice(ms, func(i, j int) bool { return ms[i].v < ms[j].v })
+	return ms, nil
+}
+
+// Migrate applies the migrations of the migrations dir of dir which are newer than the version of db,
+// in a single transaction.
+func Migrate(ctx context.Context, db *sql.DB, dir fs.FS) error {
+	start := time.Now()
+
+	ms, err := readMigrations(dir)
+	if err != nil {
+		return err
+	}
 
 	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
@@ -79,10 +128,12 @@ func Migrate(ctx context.Context, db *sql.DB, dir fs.FS) error {
 		}
 	}()
 
-	if _, err := tx.ExecContext(ctx,
-		`CREATE TABLE IF NOT EXISTS schema_migrations(version INTEGER PRIMARY KEY);`,
-	); err != nil {
-		return fmt.Errorf("migrate: create table: %w", err)
+	if err := bootstrap(ctx, tx); err != nil {
+		return err
+	}
+
+	if err := verifyChecksums(ctx, tx, ms); err != nil {
+		return err
 	}
 
 	var cur int
@@ -98,11 +149,13 @@ func Migrate(ctx context.Context, db *sql.DB, dir fs.FS) error {
 			continue
 		}
 
+		mStart := time.Now()
 		if _, err := tx.ExecContext(ctx, m.sql); err != nil {
 			return fmt.Errorf("migrate: exec %d (%s): %w", m.v, m.name, err)
 		}
 		if _, err := tx.ExecContext(ctx,
-			`INSERT INTO schema_migrations(version) VALUES (?)`, m.v,
+			`INSERT INTO schema_migrations(version, name, checksum, applied_at, duration_ms) VALUES (?, ?, ?, ?, ?)`,
+			m.v, m.name, m.checksum(), mStart.Unix(), time.Since(mStart).Milliseconds(),
 		); err != nil {
 			return fmt.Errorf("migrate: record %d: %w", m.v, err)
 		}
@@ -124,3 +177,203 @@ func Migrate(ctx context.Context, db *sql.DB, dir fs.FS) error {
 
 	return nil
 }
+
+// MigrateDown rolls db back to version to, running the down scripts of the migrations applied after it
+// in reverse order, in a single transaction.
+//
+// Nothing is rolled back if one of these migrations has no down script, or if applied migrations were changed
+// since, unless -db.allowMigrationDrift is set; see verifyChecksums.
+func MigrateDown(ctx context.Context, db *sql.DB, dir fs.FS, to int) error {
+	start := time.Now()
+
+	if to < 0 {
+		return fmt.Errorf("migrate: invalid target version %d", to)
+	}
+	ms, err := readMigrations(dir)
+	if err != nil {
+		return err
+	}
+	byVersion := make(map[int]migration, len(ms))
+	for _, m := range ms {
+		byVersion[m.v] = m
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("migrate: begin: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			logger.Error("db.migrate.rollback", "error", err)
+		}
+	}()
+
+	if err := bootstrap(ctx, tx); err != nil {
+		return err
+	}
+
+	if err := verifyChecksums(ctx, tx, ms); err != nil {
+		return err
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT version FROM schema_migrations WHERE version > ? ORDER BY version DESC`, to,
+	)
+	if err != nil {
+		return fmt.Errorf("migrate: applied versions: %w", err)
+	}
+	var revert []migration
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			_ = rows.Close()
+			return fmt.Errorf("migrate: applied versions: %w", err)
+		}
+		m, ok := byVersion[v]
+		if !ok || m.downName == "" {
+			_ = rows.Close()
+			return fmt.Errorf("migrate: cannot roll back to %d: version %d has no down script", to, v)
+		}
+		revert = append(revert, m)
+	}
+	if err := rows.Close(); err != nil {
+		return fmt.Errorf("migrate: applied versions: %w", err)
+	}
+
+	for _, m := range revert {
+		if _, err := tx.ExecContext(ctx, m.down); err != nil {
+			return fmt.Errorf("migrate: exec down %d (%s): %w", m.v, m.downName, err)
+		}
+		if _, err := tx.ExecContext(ctx,
+			`DELETE FROM schema_migrations WHERE version = ?`, m.v,
+		); err != nil {
+			return fmt.Errorf("migrate: unrecord %d: %w", m.v, err)
+		}
+
+		logger.Info("db.migrate.reverted", "version", m.v, "name", m.downName)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("migrate: commit: %w", err)
+	}
+
+	if len(revert) > 0 {
+		logger.Info("db.migrate.down.done",
+			"reverted", len(revert),
+			"to", to,
+			"dur", time.Since(start),
+		)
+	}
+
+	return nil
+}
+
+// schemaMigrationsColumns are the columns added to schema_migrations after its version column, in the order
+// they were introduced.
+var schemaMigrationsColumns = []struct {
+	name string
+	def  string
+}{
+	{"name", "TEXT NOT NULL DEFAULT ''"},
+	{"checksum", "TEXT NOT NULL DEFAULT ''"},
+	{"applied_at", "INTEGER NOT NULL DEFAULT 0"},
+	{"duration_ms", "INTEGER NOT NULL DEFAULT 0"},
+}
+
+// bootstrap creates the schema_migrations table, or adds the columns missing from the tables created by
+// older releases, which only recorded versions.
+func bootstrap(ctx context.Context, tx *sql.Tx) error {
+	if _, err := tx.ExecContext(ctx,
+		`CREATE TABLE IF NOT EXISTS schema_migrations(version INTEGER PRIMARY KEY);`,
+	); err != nil {
+		return fmt.Errorf("migrate: create table: %w", err)
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT name FROM pragma_table_info('schema_migrations')`)
+	if err != nil {
+		return fmt.Errorf("migrate: table columns: %w", err)
+	}
+	columns := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			_ = rows.Close()
+			return fmt.Errorf("migrate: table columns: %w", err)
+		}
+		columns[name] = true
+	}
+	if err := rows.Close(); err != nil {
+		return fmt.Errorf("migrate: table columns: %w", err)
+	}
+
+	for _, c := range schemaMigrationsColumns {
+		if columns[c.name] {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx,
+			`ALTER TABLE schema_migrations ADD COLUMN `+c.name+` `+c.def,
+		); err != nil {
+			return fmt.Errorf("migrate: add column %s: %w", c.name, err)
+		}
+		logger.Info("db.migrate.bootstrap", "column", c.name)
+	}
+	return nil
+}
+
+// verifyChecksums checks that the applied migrations of ms were not changed since, comparing them with their
+// recorded checksums. Migrations applied before checksums were recorded get the checksum of their current version.
+//
+// Changed migrations fail the check, unless -db.allowMigrationDrift is set.
+func verifyChecksums(ctx context.Context, tx *sql.Tx, ms []migration) error {
+	byVersion := make(map[int]migration, len(ms))
+	for _, m := range ms {
+		byVersion[m.v] = m
+	}
+
+	type record struct {
+		v        int
+		checksum string
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT version, checksum FROM schema_migrations ORDER BY version`)
+	if err != nil {
+		return fmt.Errorf("migrate: applied checksums: %w", err)
+	}
+	var records []record
+	for rows.Next() {
+		var r record
+		if err := rows.Scan(&r.v, &r.checksum); err != nil {
+			_ = rows.Close()
+			return fmt.Errorf("migrate: applied checksums: %w", err)
+		}
+		records = append(records, r)
+	}
+	if err := rows.Close(); err != nil {
+		return fmt.Errorf("migrate: applied checksums: %w", err)
+	}
+
+	for _, r := range records {
+		// Migrations applied by newer releases are not known.
+		m, ok := byVersion[r.v]
+		if !ok {
+			continue
+		}
+		sum := m.checksum()
+		if r.checksum == sum {
+			continue
+		}
+		if r.checksum == "" {
+			if _, err := tx.ExecContext(ctx,
+				`UPDATE schema_migrations SET name = ?, checksum = ? WHERE version = ?`, m.name, sum, m.v,
+			); err != nil {
+				return fmt.Errorf("migrate: record checksum %d: %w", m.v, err)
+			}
+			continue
+		}
+		if !*allowMigrationDrift {
+			return fmt.Errorf("migrate: %d (%s) was changed after it was applied: checksum %s, recorded %s; "+
+				"restore it and make the change in a new migration, or set -db.allowMigrationDrift", m.v, m.name, sum, r.checksum)
+		}
+		logger.Warn("db.migrate.drift", "version", m.v, "name", m.name, "checksum", sum, "recorded", r.checksum)
+	}
+	return nil
+}
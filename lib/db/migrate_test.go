@@ -2,8 +2,13 @@ package db
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"io/fs"
+	"maps"
+	"slices"
+	"strings"
 	"testing"
 	"testing/fstest"
 
@@ -185,6 +190,39 @@ func TestMigrate(t *testing.T) {
 		})
 	})
 
+	t.Run("paired up and down files", func(t *testing.T) {
+		fs := fstest.MapFS{
+			"migrations/001_init.sql":           &fstest.MapFile{Data: []byte(`CREATE TABLE users(id INTEGER PRIMARY KEY);`)},
+			"migrations/002_add_posts.up.sql":   &fstest.MapFile{Data: []byte(`CREATE TABLE posts(id INTEGER PRIMARY KEY);`)},
+			"migrations/002_add_posts.down.sql": &fstest.MapFile{Data: []byte(`DROP TABLE posts;`)},
+		}
+
+		f(fs, false, func(t *testing.T, db *sql.DB) {
+			var count int
+			if err := db.QueryRow(`SELECT COUNT(*) FROM posts`).Scan(&count); err != nil {
+				t.Fatalf("query posts: %v", err)
+			}
+		})
+	})
+
+	t.Run("down script without up script", func(t *testing.T) {
+		fs := fstest.MapFS{
+			"migrations/001_init.sql":           &fstest.MapFile{Data: []byte(`CREATE TABLE users(id INTEGER PRIMARY KEY);`)},
+			"migrations/002_add_posts.down.sql": &fstest.MapFile{Data: []byte(`DROP TABLE posts;`)},
+		}
+
+		f(fs, true, nil)
+	})
+
+	t.Run("down script paired with a single-file migration", func(t *testing.T) {
+		fs := fstest.MapFS{
+			"migrations/001_init.sql":      &fstest.MapFile{Data: []byte(`CREATE TABLE users(id INTEGER PRIMARY KEY);`)},
+			"migrations/001_init.down.sql": &fstest.MapFile{Data: []byte(`DROP TABLE users;`)},
+		}
+
+		f(fs, true, nil)
+	})
+
 	t.Run("skip already applied migrations", func(t *testing.T) {
 		fs := fstest.MapFS{
 			"migrations/001_init.sql":         &fstest.MapFile{Data: []byte(`CREATE TABLE users(id INTEGER PRIMARY KEY);`)},
@@ -228,3 +266,239 @@ func TestMigrate(t *testing.T) {
 		}
 	})
 }
+
+func TestMigrateDown(t *testing.T) {
+	// The down script of 003 alters the table dropped by the down script of 002, so it fails unless the scripts
+	// run in reverse order.
+	fs := fstest.MapFS{
+		"migrations/001_init.sql":           &fstest.MapFile{Data: []byte(`CREATE TABLE users(id INTEGER PRIMARY KEY);`)},
+		"migrations/002_add_posts.up.sql":   &fstest.MapFile{Data: []byte(`CREATE TABLE posts(id INTEGER PRIMARY KEY);`)},
+		"migrations/002_add_posts.down.sql": &fstest.MapFile{Data: []byte(`DROP TABLE posts;`)},
+		"migrations/003_add_title.up.sql":   &fstest.MapFile{Data: []byte(`ALTER TABLE posts ADD COLUMN title TEXT;`)},
+		"migrations/003_add_title.down.sql": &fstest.MapFile{Data: []byte(`ALTER TABLE posts DROP COLUMN title;`)},
+		"migrations/004_add_tags.up.sql":    &fstest.MapFile{Data: []byte(`CREATE TABLE tags(id INTEGER PRIMARY KEY);`)},
+		"migrations/004_add_tags.down.sql":  &fstest.MapFile{Data: []byte(`DROP TABLE tags;`)},
+	}
+
+	f := func(dir fstest.MapFS, to int, wantErr bool, wantVersion int, wantTables ...string) {
+		t.Helper()
+
+		db, err := sql.Open("sqlite3", ":memory:")
+		if err != nil {
+			t.Fatalf("open db: %v", err)
+		}
+		defer func() {
+			if err := db.Close(); err != nil {
+				t.Fatalf("close db: %v", err)
+			}
+		}()
+		db.SetMaxOpenConns(1)
+
+		ctx := context.Background()
+		if err := Migrate(ctx, db, dir); err != nil {
+			t.Fatalf("migrate: %v", err)
+		}
+		err = MigrateDown(ctx, db, dir, to)
+		if wantErr != (err != nil) {
+			t.Fatalf("MigrateDown(%d) error = %v; want error: %v", to, err, wantErr)
+		}
+
+		var version int
+		if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+			t.Fatalf("query version: %v", err)
+		}
+		if version != wantVersion {
+			t.Fatalf("version = %d; want %d", version, wantVersion)
+		}
+		var tables []string
+		rows, err := db.Query(`SELECT name FROM sqlite_master WHERE type = 'table' AND name != 'schema_migrations' ORDER BY name`)
+		if err != nil {
+			t.Fatalf("query tables: %v", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				t.Fatalf("scan table: %v", err)
+			}
+			tables = append(tables, name)
+		}
+		if !slices.Equal(tables, wantTables) {
+			t.Fatalf("tables = %q; want %q", tables, wantTables)
+		}
+	}
+
+	f(fs, 4, false, 4, "posts", "tags", "users")
+	f(fs, 9, false, 4, "posts", "tags", "users")
+	f(fs, 3, false, 3, "posts", "users")
+	f(fs, 1, false, 1, "users")
+
+	// 001 has no down script, so nothing is rolled back.
+	f(fs, 0, true, 4, "posts", "tags", "users")
+	f(fs, -1, true, 4, "posts", "tags", "users")
+
+	// Failed down scripts roll back the whole transaction.
+	failing := maps.Clone(fs)
+	failing["migrations/002_add_posts.down.sql"] = &fstest.MapFile{Data: []byte(`DROP TABLE missing;`)}
+	f(failing, 1, true, 4, "posts", "tags", "users")
+}
+
+func TestMigrateChecksums(t *testing.T) {
+	initFile := &fstest.MapFile{Data: []byte(`CREATE TABLE users(id INTEGER PRIMARY KEY);`)}
+	postsFile := &fstest.MapFile{Data: []byte(`CREATE TABLE posts(id INTEGER PRIMARY KEY);`)}
+	fs := fstest.MapFS{
+		"migrations/001_init.sql":      initFile,
+		"migrations/002_add_posts.sql": postsFile,
+	}
+	edited := fstest.MapFS{
+		"migrations/001_init.sql":      &fstest.MapFile{Data: []byte(`CREATE TABLE users(id INTEGER PRIMARY KEY, name TEXT);`)},
+		"migrations/002_add_posts.sql": postsFile,
+	}
+	sum := func(f *fstest.MapFile) string {
+		s := sha256.Sum256(f.Data)
+		return hex.EncodeToString(s[:])
+	}
+
+	open := func(t *testing.T) *sql.DB {
+		t.Helper()
+		db, err := sql.Open("sqlite3", ":memory:")
+		if err != nil {
+			t.Fatalf("open db: %v", err)
+		}
+		t.Cleanup(func() {
+			if err := db.Close(); err != nil {
+				t.Fatalf("close db: %v", err)
+			}
+		})
+		db.SetMaxOpenConns(1)
+		return db
+	}
+	type record struct {
+		version  int
+		name     string
+		checksum string
+	}
+	f := func(t *testing.T, db *sql.DB, want ...record) {
+		t.Helper()
+		rows, err := db.Query(`SELECT version, name, checksum, applied_at, duration_ms FROM schema_migrations ORDER BY version`)
+		if err != nil {
+			t.Fatalf("query migrations: %v", err)
+		}
+		defer rows.Close()
+		var got []record
+		for rows.Next() {
+			var r record
+			var appliedAt, durationMs int64
+			if err := rows.Scan(&r.version, &r.name, &r.checksum, &appliedAt, &durationMs); err != nil {
+				t.Fatalf("scan migration: %v", err)
+			}
+			if appliedAt < 0 || durationMs < 0 {
+				t.Fatalf("unexpected applied_at %d and duration_ms %d of version %d", appliedAt, durationMs, r.version)
+			}
+			got = append(got, r)
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("migrations = %+v; want %+v", got, want)
+		}
+	}
+	ctx := context.Background()
+
+	t.Run("checksums are recorded", func(t *testing.T) {
+		db := open(t)
+		if err := Migrate(ctx, db, fs); err != nil {
+			t.Fatalf("migrate: %v", err)
+		}
+		f(t, db, record{1, "001_init.sql", sum(initFile)}, record{2, "002_add_posts.sql", sum(postsFile)})
+
+		var appliedAt int64
+		if err := db.QueryRow(`SELECT applied_at FROM schema_migrations WHERE version = 1`).Scan(&appliedAt); err != nil {
+			t.Fatalf("query applied_at: %v", err)
+		}
+		if appliedAt == 0 {
+			t.Fatalf("applied_at of version 1 is not recorded")
+		}
+	})
+
+	t.Run("edited migrations fail", func(t *testing.T) {
+		db := open(t)
+		if err := Migrate(ctx, db, fstest.MapFS{"migrations/001_init.sql": initFile}); err != nil {
+			t.Fatalf("migrate: %v", err)
+		}
+		// Nothing is applied when migrations drift.
+		if err := Migrate(ctx, db, edited); err == nil || !strings.Contains(err.Error(), "001_init.sql") {
+			t.Fatalf("Migrate() error = %v; want an error about 001_init.sql", err)
+		}
+		f(t, db, record{1, "001_init.sql", sum(initFile)})
+	})
+
+	t.Run("edited migrations are allowed with the flag", func(t *testing.T) {
+		*allowMigrationDrift = true
+		defer func() { *allowMigrationDrift = false }()
+
+		db := open(t)
+		if err := Migrate(ctx, db, fstest.MapFS{"migrations/001_init.sql": initFile}); err != nil {
+			t.Fatalf("migrate: %v", err)
+		}
+		if err := Migrate(ctx, db, edited); err != nil {
+			t.Fatalf("migrate: %v", err)
+		}
+		// The recorded checksum is kept, so the drift is reported until the migration is restored.
+		f(t, db, record{1, "001_init.sql", sum(initFile)}, record{2, "002_add_posts.sql", sum(postsFile)})
+	})
+
+	// The down script of 002 reverts its recorded up script, which the edited one no longer matches.
+	downFile := &fstest.MapFile{Data: []byte(`DROP TABLE posts;`)}
+	reversible := fstest.MapFS{
+		"migrations/001_init.sql":           initFile,
+		"migrations/002_add_posts.up.sql":   postsFile,
+		"migrations/002_add_posts.down.sql": downFile,
+	}
+	editedReversible := fstest.MapFS{
+		"migrations/001_init.sql":           initFile,
+		"migrations/002_add_posts.up.sql":   &fstest.MapFile{Data: []byte(`CREATE TABLE posts(id INTEGER PRIMARY KEY, title TEXT);`)},
+		"migrations/002_add_posts.down.sql": downFile,
+	}
+
+	t.Run("edited migrations are not rolled back", func(t *testing.T) {
+		db := open(t)
+		if err := Migrate(ctx, db, reversible); err != nil {
+			t.Fatalf("migrate: %v", err)
+		}
+		if err := MigrateDown(ctx, db, editedReversible, 1); err == nil || !strings.Contains(err.Error(), "002_add_posts.up.sql") {
+			t.Fatalf("MigrateDown() error = %v; want an error about 002_add_posts.up.sql", err)
+		}
+		f(t, db, record{1, "001_init.sql", sum(initFile)}, record{2, "002_add_posts.up.sql", sum(postsFile)})
+	})
+
+	t.Run("edited migrations are rolled back with the flag", func(t *testing.T) {
+		*allowMigrationDrift = true
+		defer func() { *allowMigrationDrift = false }()
+
+		db := open(t)
+		if err := Migrate(ctx, db, reversible); err != nil {
+			t.Fatalf("migrate: %v", err)
+		}
+		if err := MigrateDown(ctx, db, editedReversible, 1); err != nil {
+			t.Fatalf("migrate down: %v", err)
+		}
+		f(t, db, record{1, "001_init.sql", sum(initFile)})
+	})
+
+	t.Run("tables of older releases are upgraded", func(t *testing.T) {
+		db := open(t)
+		if _, err := db.Exec(`CREATE TABLE schema_migrations(version INTEGER PRIMARY KEY);
+			CREATE TABLE users(id INTEGER PRIMARY KEY);
+			INSERT INTO schema_migrations(version) VALUES (1);`); err != nil {
+			t.Fatalf("create legacy table: %v", err)
+		}
+		if err := Migrate(ctx, db, fs); err != nil {
+			t.Fatalf("migrate: %v", err)
+		}
+		f(t, db, record{1, "001_init.sql", sum(initFile)}, record{2, "002_add_posts.sql", sum(postsFile)})
+
+		// The backfilled checksums are verified from then on.
+		if err := Migrate(ctx, db, edited); err == nil {
+			t.Fatalf("expected an error for the edited migration")
+		}
+	})
+}
@@ -4,10 +4,13 @@ import (
 	"context"
 	"database/sql"
 	"database/sql/driver"
+	"regexp"
 	"strings"
 	"time"
 
 	"github.com/AltSoyuz/adequate/lib/logger"
+	"github.com/AltSoyuz/adequate/lib/reqtrace"
+	"github.com/AltSoyuz/adequate/lib/tracing"
 	"github.com/mattn/go-sqlite3"
 )
 
@@ -49,6 +52,7 @@ func (c *tracedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver
 	if !ok {
 		return nil, driver.ErrSkip
 	}
+	recordWait(ctx, time.Now())
 	return cbtx.BeginTx(ctx, opts)
 }
 
@@ -58,8 +62,14 @@ func (c *tracedConn) ExecContext(ctx context.Context, q string, args []driver.Na
 		return nil, driver.ErrSkip
 	}
 	start := time.Now()
+	recordWait(ctx, start)
+	_, span := startSpan(ctx, q)
 	res, err := ce.ExecContext(ctx, q, args)
-	c.log("exec", q, args, time.Since(start), err)
+	dur := time.Since(start)
+	reqtrace.FromContext(ctx).Record(reqtrace.PhaseDBQuery, queryName(q), start, dur)
+	span.SetError(err)
+	span.End()
+	c.log(ctx, "exec", q, args, dur, err)
 	return res, err
 }
 
@@ -69,12 +79,75 @@ func (c *tracedConn) QueryContext(ctx context.Context, q string, args []driver.N
 		return nil, driver.ErrSkip
 	}
 	start := time.Now()
+	recordWait(ctx, start)
+	_, span := startSpan(ctx, q)
 	rows, err := cq.QueryContext(ctx, q, args)
-	c.log("query", q, args, time.Since(start), err)
-	return rows, err
+	c.log(ctx, "query", q, args, time.Since(start), err)
+	tl := reqtrace.FromContext(ctx)
+	if tl == nil && span == nil {
+		return rows, err
+	}
+	if rows == nil {
+		tl.Record(reqtrace.PhaseDBQuery, queryName(q), start, time.Since(start))
+		span.SetError(err)
+		span.End()
+		return rows, err
+	}
+	// SQLite steps through the results lazily, so the query lasts until its rows are closed.
+	return &tracedRows{Rows: rows, tl: tl, span: span, name: queryName(q), start: start}, err
+}
+
+type tracedRows struct {
+	driver.Rows
+	tl    *reqtrace.Timeline
+	span  *tracing.Span
+	name  string
+	start time.Time
+}
+
+func (r *tracedRows) Close() error {
+	err := r.Rows.Close()
+	r.tl.Record(reqtrace.PhaseDBQuery, r.name, r.start, time.Since(r.start))
+	r.span.End()
+	return err
+}
+
+// startSpan starts a client span for the statement q, if ctx belongs to a traced operation.
+//
+// Arguments are never recorded, since they may hold personal data.
+func startSpan(ctx context.Context, q string) (context.Context, *tracing.Span) {
+	return tracing.ChildOf(ctx, queryName(q), tracing.KindClient,
+		tracing.String("db.system.name", "sqlite"),
+		tracing.String("db.query.text", compact(q)),
+	)
+}
+
+// recordWait records the time between the call issued on the pool and its arrival on the connection.
+func recordWait(ctx context.Context, start time.Time) {
+	issued, ok := reqtrace.Issued(ctx)
+	if !ok {
+		return
+	}
+	reqtrace.FromContext(ctx).Record(reqtrace.PhaseDBWait, "", issued, start.Sub(issued))
+}
+
+var queryNameRe = regexp.MustCompile(`^-- name: (\w+)`)
+
+const maxQueryDetailLen = 80
+
+// queryName returns the sqlc query name of q, or the start of the compacted statement.
+func queryName(q string) string {
+	if m := queryNameRe.FindStringSubmatch(q); m != nil {
+		return m[1]
+	}
+	q = compact(q)
+	if len(q) > maxQueryDetailLen {
+		q = q[:maxQueryDetailLen] + "..."
+	}
+	return q
 }
 
-func (c *tracedConn) log(kind, sqlq string, args []driver.NamedValue, dur time.Duration, err error) {
+func (c *tracedConn) log(ctx context.Context, kind, sqlq string, args []driver.NamedValue, dur time.Duration, err error) {
 	if c.t == nil {
 		return
 	}
@@ -82,7 +155,7 @@ func (c *tracedConn) log(kind, sqlq string, args []driver.NamedValue, dur time.D
 	sqlq = compact(sqlq)
 
 	if err != nil {
-		logger.ErrorSkipframes(2, "db.query.error",
+		logger.ErrorCtxSkipframes(ctx, 2, "db.query.error",
 			"kind", kind,
 			"sql", sqlq,
 			"args", maskArgs(args, c.t.MaskArgs),
@@ -99,7 +172,7 @@ func (c *tracedConn) log(kind, sqlq string, args []driver.NamedValue, dur time.D
 		return
 	}
 
-	logger.WarnSkipframes(2, "db.query.slow",
+	logger.WarnCtxSkipframes(ctx, 2, "db.query.slow",
 		"kind", kind,
 		"sql", sqlq,
 		"dur", dur,
@@ -127,6 +200,33 @@ func maskArgs(args []driver.NamedValue, mask bool) any {
 	return args
 }
 
+// TracedDB wraps a *sql.DB, so request timelines record how long each call waited for a pool connection.
+//
+// Use it as the DBTX of generated queries.
+type TracedDB struct {
+	*sql.DB
+}
+
+func (db TracedDB) ExecContext(ctx context.Context, q string, args ...any) (sql.Result, error) {
+	return db.DB.ExecContext(reqtrace.WithIssued(ctx), q, args...)
+}
+
+func (db TracedDB) PrepareContext(ctx context.Context, q string) (*sql.Stmt, error) {
+	return db.DB.PrepareContext(reqtrace.WithIssued(ctx), q)
+}
+
+func (db TracedDB) QueryContext(ctx context.Context, q string, args ...any) (*sql.Rows, error) {
+	return db.DB.QueryContext(reqtrace.WithIssued(ctx), q, args...)
+}
+
+func (db TracedDB) QueryRowContext(ctx context.Context, q string, args ...any) *sql.Row {
+	return db.DB.QueryRowContext(reqtrace.WithIssued(ctx), q, args...)
+}
+
+func (db TracedDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return db.DB.BeginTx(reqtrace.WithIssued(ctx), opts)
+}
+
 const TracedDriverName = "sqlite3-traced"
 
 func RegisterTracedDriver(tr *Tracer) {
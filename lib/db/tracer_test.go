@@ -4,8 +4,11 @@ import (
 	"context"
 	"database/sql/driver"
 	"errors"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/AltSoyuz/adequate/lib/reqtrace"
 )
 
 func TestCompact(t *testing.T) {
@@ -235,3 +238,35 @@ func TestTracedConnPrepareClose(t *testing.T) {
 		}
 	})
 }
+
+func TestQueryName(t *testing.T) {
+	f := func(q, want string) {
+		t.Helper()
+		if got := queryName(q); got != want {
+			t.Fatalf("queryName(%q) = %q; want %q", q, got, want)
+		}
+	}
+
+	f("-- name: GetMaintenance :one\nSELECT id FROM maintenance", "GetMaintenance")
+	f("SELECT\n  version\nFROM schema_migrations", "SELECT version FROM schema_migrations")
+	f("SELECT "+strings.Repeat("x, ", 40)+"y FROM t", "SELECT "+strings.Repeat("x, ", 24)+"x...")
+}
+
+func TestTracedConnTimeline(t *testing.T) {
+	tl := reqtrace.New(time.Now())
+	ctx := reqtrace.WithIssued(reqtrace.NewContext(context.Background(), tl))
+	tc := &tracedConn{base: &fakeConn{}, t: &Tracer{}}
+
+	if _, err := tc.ExecContext(ctx, "-- name: SetMaintenance :exec\nINSERT INTO maintenance VALUES (1)", nil); err != nil {
+		t.Fatalf("ExecContext failed: %v", err)
+	}
+
+	if _, n := tl.Total(reqtrace.PhaseDBWait); n != 1 {
+		t.Fatalf("got %d db.wait spans; want 1", n)
+	}
+	spans := tl.Spans()
+	last := spans[len(spans)-1]
+	if last.Phase != reqtrace.PhaseDBQuery || last.Detail != "SetMaintenance" {
+		t.Fatalf("unexpected span %+v", last)
+	}
+}
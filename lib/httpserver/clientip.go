@@ -0,0 +1,100 @@
+package httpserver
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+var trustedProxies = flag.String("http.trustedProxies", "", "Comma-separated IPs or CIDRs of the reverse proxies in front of the app, "+
+	"such as 10.0.0.0/8. The client IP of their requests is read from the X-Forwarded-For header")
+
+// trustedProxyPrefixes holds the parsed -http.trustedProxies, set by ServeWithListener before serving requests.
+var trustedProxyPrefixes []netip.Prefix
+
+// initTrustedProxies parses -http.trustedProxies for ClientIP.
+func initTrustedProxies() error {
+	prefixes, err := ParseIPPrefixes(*trustedProxies)
+	if err != nil {
+		return fmt.Errorf("cannot parse -http.trustedProxies: %w", err)
+	}
+	trustedProxyPrefixes = prefixes
+	return nil
+}
+
+// ClientIP returns the IP of the client of r.
+//
+// Requests from -http.trustedProxies are attributed to the last address of their X-Forwarded-For header which is
+// not a trusted proxy, so clients cannot pick their IP by sending the header themselves.
+// Other requests are attributed to their peer address.
+func ClientIP(r *http.Request) string {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		ip = host
+	}
+	if !isTrustedProxy(ip) {
+		return ip
+	}
+
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// The hops before are not reliable, so the request is attributed to the last proxy which forwarded it.
+			break
+		}
+		ip = addr.Unmap().String()
+		if !isTrustedProxy(ip) {
+			break
+		}
+	}
+	return ip
+}
+
+func isTrustedProxy(ip string) bool {
+	if len(trustedProxyPrefixes) == 0 {
+		return false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range trustedProxyPrefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseIPPrefixes parses a comma-separated list of IPs and CIDRs, such as 10.0.0.1,192.168.0.0/16.
+func ParseIPPrefixes(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			addr, err := netip.ParseAddr(v)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(v)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
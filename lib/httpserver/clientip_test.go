@@ -0,0 +1,71 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	f := func(proxies, remoteAddr string, forwardedFor []string, want string) {
+		t.Helper()
+		*trustedProxies = proxies
+		if err := initTrustedProxies(); err != nil {
+			t.Fatalf("cannot init trusted proxies %q: %v", proxies, err)
+		}
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remoteAddr
+		for _, v := range forwardedFor {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		if got := ClientIP(r); got != want {
+			t.Fatalf("ClientIP(%q, %q) with proxies %q = %q; want %q", remoteAddr, forwardedFor, proxies, got, want)
+		}
+	}
+	defer func() {
+		*trustedProxies = ""
+		trustedProxyPrefixes = nil
+	}()
+
+	// Without trusted proxies, the header is ignored.
+	f("", "1.2.3.4:5678", nil, "1.2.3.4")
+	f("", "1.2.3.4:5678", []string{"9.9.9.9"}, "1.2.3.4")
+	f("", "pipe", nil, "pipe")
+
+	// Requests from other peers than the trusted proxies cannot pick their IP.
+	f("10.0.0.0/8", "1.2.3.4:5678", []string{"9.9.9.9"}, "1.2.3.4")
+
+	f("10.0.0.0/8", "10.0.0.1:5678", []string{"9.9.9.9"}, "9.9.9.9")
+	f("10.0.0.0/8", "10.0.0.1:5678", nil, "10.0.0.1")
+	f("10.0.0.0/8,192.168.0.1", "10.0.0.1:5678", []string{"9.9.9.9, 192.168.0.1"}, "9.9.9.9")
+	f("10.0.0.0/8", "10.0.0.1:5678", []string{"6.6.6.6", "9.9.9.9, 10.0.0.2"}, "9.9.9.9")
+	// Clients prepend spoofed hops, which are before the hop added by the proxy.
+	f("10.0.0.0/8", "10.0.0.1:5678", []string{"6.6.6.6, 9.9.9.9"}, "9.9.9.9")
+	f("10.0.0.0/8", "10.0.0.1:5678", []string{"6.6.6.6, garbage, 9.9.9.9"}, "9.9.9.9")
+	f("10.0.0.0/8", "10.0.0.1:5678", []string{"garbage"}, "10.0.0.1")
+	f("10.0.0.0/8", "10.0.0.1:5678", []string{"10.0.0.3, 10.0.0.2"}, "10.0.0.3")
+	f("::1", "[::1]:5678", []string{"2001:db8::1"}, "2001:db8::1")
+}
+
+func TestParseIPPrefixes(t *testing.T) {
+	f := func(s string, wantErr bool, want ...string) {
+		t.Helper()
+		prefixes, err := ParseIPPrefixes(s)
+		if wantErr != (err != nil) {
+			t.Fatalf("ParseIPPrefixes(%q) error = %v; want error: %v", s, err, wantErr)
+		}
+		if len(prefixes) != len(want) {
+			t.Fatalf("ParseIPPrefixes(%q) = %v; want %v", s, prefixes, want)
+		}
+		for i, p := range prefixes {
+			if p.String() != want[i] {
+				t.Fatalf("ParseIPPrefixes(%q) = %v; want %v", s, prefixes, want)
+			}
+		}
+	}
+
+	f("", false)
+	f("10.0.0.1, 192.168.1.7/16,::1", false, "10.0.0.1/32", "192.168.0.0/16", "::1/128")
+	f("10.0.0.300", true)
+	f("10.0.0.0/33", true)
+}
@@ -0,0 +1,119 @@
+package httpserver
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/AltSoyuz/adequate/lib/metrics"
+)
+
+var csrfTrustedOrigins = flag.String("http.csrfTrustedOrigins", "", "Comma-separated origins, such as https://admin.example.com, "+
+	"which are allowed to send cross-origin mutating requests")
+
+// Cookie and header of the double-submit CSRF token.
+//
+// The cookie is readable by scripts, so the UI can echo it in the header of mutating requests.
+const (
+	CSRFCookie = "adequate_csrf"
+	CSRFHeader = "X-CSRF-Token"
+)
+
+var csrfRejectedRequests = metrics.NewCounter("http_csrf_rejected_requests_total")
+
+// CSRFOptions configures CSRF.
+type CSRFOptions struct {
+	// AuthCookie is the cookie authenticating requests. Mutating requests carrying it must send the CSRF token.
+	AuthCookie string
+	// Secure sets the Secure attribute of the token cookie.
+	Secure bool
+}
+
+// CSRF returns a middleware protecting cookie-authenticated mutations from cross-site request forgery.
+//
+// Mutating requests are rejected with a 403 problem+json response if browsers report them as cross-origin
+// through the Sec-Fetch-Site or Origin headers, unless they come from -http.csrfTrustedOrigins.
+// Mutating requests carrying opts.AuthCookie must also send the value of CSRFCookie in CSRFHeader.
+// Requests authenticated with a bearer token carry no ambient credentials, so they are exempt.
+//
+// Responses to safe requests set CSRFCookie if the client does not have it yet.
+func CSRF(opts CSRFOptions) (Middleware, error) {
+	cop := http.NewCrossOriginProtection()
+	for _, origin := range strings.Split(*csrfTrustedOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin == "" {
+			continue
+		}
+		if err := cop.AddTrustedOrigin(origin); err != nil {
+			return nil, fmt.Errorf("cannot parse -http.csrfTrustedOrigins: %w", err)
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, _ := r.Cookie(CSRFCookie)
+			if IsSafeMethod(r.Method) {
+				if token == nil || token.Value == "" {
+					setCSRFCookie(w, opts.Secure)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			if hasBearerToken(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if err := cop.Check(r); err != nil {
+				rejectCSRF(w, r, "Cross-origin requests are not allowed")
+				return
+			}
+			if _, err := r.Cookie(opts.AuthCookie); err == nil && opts.AuthCookie != "" {
+				got := r.Header.Get(CSRFHeader)
+				if token == nil || token.Value == "" || got == "" ||
+					subtle.ConstantTimeCompare([]byte(got), []byte(token.Value)) != 1 {
+					rejectCSRF(w, r, "Missing or invalid "+CSRFHeader+" header")
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+func rejectCSRF(w http.ResponseWriter, r *http.Request, detail string) {
+	csrfRejectedRequests.Inc()
+	WriteProblem(w, r, Problem{
+		Status: http.StatusForbidden,
+		Detail: detail,
+	})
+}
+
+func setCSRFCookie(w http.ResponseWriter, secure bool) {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     CSRFCookie,
+		Value:    base64.RawURLEncoding.EncodeToString(b),
+		Path:     "/",
+		Secure:   secure,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func hasBearerToken(r *http.Request) bool {
+	scheme, _, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	return ok && strings.EqualFold(scheme, "Bearer")
+}
+
+// IsSafeMethod reports whether requests with method do not change state, as GET, HEAD and OPTIONS requests.
+func IsSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCSRF(t *testing.T) {
+	mw, err := CSRF(CSRFOptions{AuthCookie: "session"})
+	if err != nil {
+		t.Fatalf("cannot create CSRF middleware: %v", err)
+	}
+	h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	f := func(method string, headers map[string]string, cookies map[string]string, wantStatus int) {
+		t.Helper()
+		req := httptest.NewRequest(method, "http://example.com/api/items", nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		for k, v := range cookies {
+			req.AddCookie(&http.Cookie{Name: k, Value: v})
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != wantStatus {
+			t.Fatalf("%s with headers %v and cookies %v: status = %d; want %d", method, headers, cookies, w.Code, wantStatus)
+		}
+		if wantStatus == http.StatusForbidden && w.Header().Get("Content-Type") != "application/problem+json" {
+			t.Fatalf("rejected requests must get a problem+json response; got %q", w.Header().Get("Content-Type"))
+		}
+	}
+	session := map[string]string{"session": "s1", CSRFCookie: "tok"}
+
+	// safe methods are always allowed
+	f(http.MethodGet, map[string]string{"Sec-Fetch-Site": "cross-site"}, session, http.StatusNoContent)
+
+	// requests without cookies only need to be same-origin
+	f(http.MethodPost, nil, nil, http.StatusNoContent)
+	f(http.MethodPost, map[string]string{"Sec-Fetch-Site": "same-origin"}, nil, http.StatusNoContent)
+	f(http.MethodPost, map[string]string{"Sec-Fetch-Site": "cross-site"}, nil, http.StatusForbidden)
+	f(http.MethodPost, map[string]string{"Origin": "https://evil.example"}, nil, http.StatusForbidden)
+
+	// cookie-authenticated requests must echo the token
+	f(http.MethodPost, nil, session, http.StatusForbidden)
+	f(http.MethodPost, map[string]string{CSRFHeader: "other"}, session, http.StatusForbidden)
+	f(http.MethodDelete, map[string]string{CSRFHeader: "tok"}, session, http.StatusNoContent)
+	f(http.MethodPut, map[string]string{CSRFHeader: ""}, map[string]string{"session": "s1", CSRFCookie: ""}, http.StatusForbidden)
+	f(http.MethodPut, map[string]string{CSRFHeader: "tok", "Sec-Fetch-Site": "cross-site"}, session, http.StatusForbidden)
+
+	// bearer tokens carry no ambient credentials
+	f(http.MethodPost, map[string]string{"Authorization": "Bearer adq_x", "Sec-Fetch-Site": "cross-site"}, session, http.StatusNoContent)
+	f(http.MethodPost, map[string]string{"Authorization": "Basic eDp5"}, session, http.StatusForbidden)
+
+	t.Run("safe requests get a token cookie", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/app/", nil))
+		cookies := w.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Name != CSRFCookie || cookies[0].Value == "" || cookies[0].HttpOnly {
+			t.Fatalf("unexpected cookies %+v", cookies)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/app/", nil)
+		req.AddCookie(cookies[0])
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if len(w.Result().Cookies()) != 0 {
+			t.Fatalf("the token cookie must not be replaced")
+		}
+	})
+
+	t.Run("trusted origins", func(t *testing.T) {
+		defer func(v string) { *csrfTrustedOrigins = v }(*csrfTrustedOrigins)
+		*csrfTrustedOrigins = "https://admin.example.com"
+		mw, err := CSRF(CSRFOptions{AuthCookie: "session"})
+		if err != nil {
+			t.Fatalf("cannot create CSRF middleware: %v", err)
+		}
+		h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/api/items", nil)
+		req.Header.Set("Origin", "https://admin.example.com")
+		req.Header.Set("Sec-Fetch-Site", "cross-site")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d; want %d", w.Code, http.StatusOK)
+		}
+
+		*csrfTrustedOrigins = "not a url"
+		if _, err := CSRF(CSRFOptions{}); err == nil {
+			t.Fatalf("expected error for invalid trusted origin")
+		}
+	})
+}
@@ -0,0 +1,149 @@
+package httpserver
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// ETag returns a strong entity tag for the given response body.
+func ETag(b []byte) string {
+	sum := sha256.Sum256(b)
+	return `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+}
+
+// VersionETag returns a strong entity tag for a caller-supplied version of a resource,
+// such as a row's updated_at, so the tag can be checked without encoding the resource.
+func VersionETag(version string) string {
+	return ETag([]byte("version:" + version))
+}
+
+// WriteJSONETag is like WriteJSON, but tags 200 responses with an ETag computed
+// from the encoded body and honors the If-None-Match request header.
+func WriteJSONETag(w http.ResponseWriter, r *http.Request, status int, v any) {
+	b, err := encodeJSON(r, v)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if status == http.StatusOK {
+		etag := ETag(b)
+		w.Header().Set("ETag", etag)
+		if noneMatchFailed(w, r, etag) {
+			return
+		}
+	}
+	writeJSONBytes(w, r, status, b)
+}
+
+// WriteJSONVersion is like WriteJSONETag, but computes the ETag from version with VersionETag.
+//
+// v is not encoded when the client already has the current version.
+func WriteJSONVersion(w http.ResponseWriter, r *http.Request, status int, version string, v any) {
+	if status == http.StatusOK {
+		etag := VersionETag(version)
+		w.Header().Set("ETag", etag)
+		if noneMatchFailed(w, r, etag) {
+			return
+		}
+	}
+	WriteJSON(w, r, status, v)
+}
+
+// CheckIfMatch evaluates the If-Match request header against etag, the current tag of the resource.
+// Pass an empty etag if the resource does not exist.
+//
+// It returns true if the request may proceed. Otherwise it writes 412 Precondition Failed and returns false.
+// Use it in PUT/PATCH/DELETE handlers for optimistic concurrency control.
+func CheckIfMatch(w http.ResponseWriter, r *http.Request, etag string) bool {
+	v := r.Header.Get("If-Match")
+	if v == "" {
+		return true
+	}
+	for _, t := range parseETagList(v) {
+		if t == "*" && etag != "" {
+			return true
+		}
+		// If-Match uses the strong comparison, so weak tags never match.
+		if t == etag && !strings.HasPrefix(t, "W/") {
+			return true
+		}
+	}
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	WriteJSON(w, r, http.StatusPreconditionFailed, ErrResponse{Error: "the resource was modified; fetch it again and retry"})
+	return false
+}
+
+// noneMatchFailed evaluates the If-None-Match request header against etag.
+//
+// If the client already has the current representation, it writes 304 Not Modified
+// for GET and HEAD requests and 412 Precondition Failed for other methods, and returns true.
+func noneMatchFailed(w http.ResponseWriter, r *http.Request, etag string) bool {
+	v := r.Header.Get("If-None-Match")
+	if v == "" {
+		return false
+	}
+	matched := false
+	for _, t := range parseETagList(v) {
+		// If-None-Match uses the weak comparison.
+		if t == "*" || strings.TrimPrefix(t, "W/") == strings.TrimPrefix(etag, "W/") {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return false
+	}
+
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		if rid := r.Header.Get("X-Request-Id"); rid != "" {
+			w.Header().Set("X-Request-Id", rid)
+		}
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	WriteProblem(w, r, Problem{Status: http.StatusPreconditionFailed, Detail: "precondition failed: If-None-Match"})
+	return true
+}
+
+// parseETagList parses a comma-separated list of entity tags as found in If-Match and If-None-Match headers.
+//
+// Malformed entries are skipped.
+func parseETagList(s string) []string {
+	var tags []string
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return tags
+		}
+		if s[0] == '*' {
+			tags = append(tags, "*")
+			s = s[1:]
+			continue
+		}
+
+		prefix := ""
+		if strings.HasPrefix(s, "W/") {
+			prefix = "W/"
+			s = s[2:]
+		}
+		if !strings.HasPrefix(s, `"`) {
+			// Skip the malformed entry.
+			n := strings.IndexByte(s, ',')
+			if n < 0 {
+				return tags
+			}
+			s = s[n+1:]
+			continue
+		}
+		n := strings.IndexByte(s[1:], '"')
+		if n < 0 {
+			return tags
+		}
+		tags = append(tags, prefix+s[:n+2])
+		s = s[n+2:]
+	}
+}
@@ -0,0 +1,123 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseETagList(t *testing.T) {
+	f := func(s string, want []string) {
+		t.Helper()
+		got := parseETagList(s)
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("parseETagList(%q) = %q; want %q", s, got, want)
+		}
+	}
+
+	t.Run("empty", func(t *testing.T) { f("", nil) })
+	t.Run("star", func(t *testing.T) { f("*", []string{"*"}) })
+	t.Run("single", func(t *testing.T) { f(`"abc"`, []string{`"abc"`}) })
+	t.Run("list", func(t *testing.T) { f(`"a", W/"b" ,"c"`, []string{`"a"`, `W/"b"`, `"c"`}) })
+	t.Run("comma inside tag", func(t *testing.T) { f(`"a,b", "c"`, []string{`"a,b"`, `"c"`}) })
+	t.Run("malformed entry is skipped", func(t *testing.T) { f(`abc, "d"`, []string{`"d"`}) })
+	t.Run("unterminated tag", func(t *testing.T) { f(`"a", "b`, []string{`"a"`}) })
+}
+
+func TestWriteJSONETag(t *testing.T) {
+	v := map[string]int{"version": 1}
+	b, err := encodeJSON(httptest.NewRequest(http.MethodGet, "/", nil), v)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	etag := ETag(b)
+
+	f := func(method, ifNoneMatch string, wantStatus int) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, "/", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		WriteJSONETag(w, req, http.StatusOK, v)
+		res := w.Result()
+		if res.StatusCode != wantStatus {
+			t.Fatalf("status = %d; want %d", res.StatusCode, wantStatus)
+		}
+		if got := res.Header.Get("ETag"); got != etag {
+			t.Fatalf("ETag = %q; want %q", got, etag)
+		}
+		if wantStatus == http.StatusNotModified && w.Body.Len() != 0 {
+			t.Fatalf("304 response must have no body; got %q", w.Body.String())
+		}
+		return w
+	}
+
+	t.Run("no precondition", func(t *testing.T) { f(http.MethodGet, "", http.StatusOK) })
+	t.Run("matching tag", func(t *testing.T) { f(http.MethodGet, etag, http.StatusNotModified) })
+	t.Run("weak matching tag", func(t *testing.T) { f(http.MethodGet, "W/"+etag, http.StatusNotModified) })
+	t.Run("tag in list", func(t *testing.T) { f(http.MethodGet, `"other", `+etag, http.StatusNotModified) })
+	t.Run("star", func(t *testing.T) { f(http.MethodHead, "*", http.StatusNotModified) })
+	t.Run("stale tag", func(t *testing.T) { f(http.MethodGet, `"stale"`, http.StatusOK) })
+	t.Run("unsafe method", func(t *testing.T) {
+		w := f(http.MethodPut, etag, http.StatusPreconditionFailed)
+		if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+			t.Fatalf("Content-Type = %q; want %q", ct, "application/problem+json")
+		}
+		if !strings.Contains(w.Body.String(), `"detail":"precondition failed: If-None-Match"`) {
+			t.Fatalf("unexpected 412 body %q", w.Body.String())
+		}
+	})
+}
+
+func TestWriteJSONVersion(t *testing.T) {
+	etag := VersionETag("2025-01-02T03:04:05Z")
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("If-None-Match", etag)
+	w := httptest.NewRecorder()
+	WriteJSONVersion(w, req, http.StatusOK, "2025-01-02T03:04:05Z", map[string]string{"a": "b"})
+	if w.Code != http.StatusNotModified {
+		t.Fatalf("status = %d; want %d", w.Code, http.StatusNotModified)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	WriteJSONVersion(w, req, http.StatusOK, "2025-01-02T03:04:06Z", map[string]string{"a": "b"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d; want %d", w.Code, http.StatusOK)
+	}
+	if got := w.Header().Get("ETag"); got == etag {
+		t.Fatalf("ETag must change with the version")
+	}
+}
+
+func TestCheckIfMatch(t *testing.T) {
+	etag := VersionETag("1")
+
+	f := func(ifMatch, current string, wantOK bool) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPut, "/", nil)
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		w := httptest.NewRecorder()
+		ok := CheckIfMatch(w, req, current)
+		if ok != wantOK {
+			t.Fatalf("CheckIfMatch(%q, %q) = %v; want %v", ifMatch, current, ok, wantOK)
+		}
+		if !ok && w.Code != http.StatusPreconditionFailed {
+			t.Fatalf("status = %d; want %d", w.Code, http.StatusPreconditionFailed)
+		}
+	}
+
+	t.Run("no precondition", func(t *testing.T) { f("", etag, true) })
+	t.Run("matching tag", func(t *testing.T) { f(etag, etag, true) })
+	t.Run("stale tag", func(t *testing.T) { f(VersionETag("0"), etag, false) })
+	t.Run("weak tag never matches", func(t *testing.T) { f("W/"+etag, etag, false) })
+	t.Run("star with existing resource", func(t *testing.T) { f("*", etag, true) })
+	t.Run("star with missing resource", func(t *testing.T) { f("*", "", false) })
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"flag"
 	"io"
 	"net"
 	"net/http"
@@ -13,6 +14,30 @@ import (
 
 	"github.com/AltSoyuz/adequate/lib/buildinfo"
 	"github.com/AltSoyuz/adequate/lib/logger"
+	"github.com/AltSoyuz/adequate/lib/metrics"
+	"github.com/AltSoyuz/adequate/lib/reqtrace"
+)
+
+var (
+	enableH2C = flag.Bool("http.h2c", false, "Whether to accept HTTP/2 over cleartext (h2c) in addition to HTTP/1.1. "+
+		"Enable it only behind a trusted h2c-capable proxy")
+	maxConcurrentStreams = flag.Int("http.maxConcurrentStreams", 250, "The maximum number of concurrent HTTP/2 streams per connection")
+	maxReadFrameSize     = flag.Int("http.maxReadFrameSize", 0, "The maximum HTTP/2 frame size in bytes the server is willing to read. "+
+		"Zero means Go's default of 1MiB")
+	maxDecoderHeaderTableSize = flag.Int("http.maxDecoderHeaderTableSize", 0, "The HTTP/2 HPACK decoder table size in bytes. "+
+		"Zero means Go's default of 4KiB")
+	maxEncoderHeaderTableSize = flag.Int("http.maxEncoderHeaderTableSize", 0, "The HTTP/2 HPACK encoder table size limit in bytes. "+
+		"Zero means Go's default of 4KiB")
+	maxReceiveBufferPerConnection = flag.Int("http.maxReceiveBufferPerConnection", 0, "The HTTP/2 flow control window size in bytes for a connection. "+
+		"Zero means Go's default of 1MiB")
+	maxReceiveBufferPerStream = flag.Int("http.maxReceiveBufferPerStream", 0, "The HTTP/2 flow control window size in bytes for a single stream. "+
+		"Zero means Go's default of 1MiB")
+)
+
+var (
+	http1Requests      = metrics.NewCounter(`http_requests_total{proto="http1"}`)
+	http2Requests      = metrics.NewCounter(`http_requests_total{proto="http2"}`)
+	otherProtoRequests = metrics.NewCounter(`http_requests_total{proto="other"}`)
 )
 
 // Serve starts HTTP servers on the given addresses with the provided handler.
@@ -36,16 +61,50 @@ func Serve(ctx context.Context, addr string, handler http.Handler) error {
 func ServeWithListener(ctx context.Context, ln net.Listener, handler http.Handler) error {
 	logger.InfoSkipframes(2, "listening", "addr", ln.Addr().String())
 
+	if err := initTrustedProxies(); err != nil {
+		return err
+	}
+
 	srv := &http.Server{
 		Handler:           wrapHandlerWithBuiltins(handler),
 		ReadHeaderTimeout: 5 * time.Second,
 		IdleTimeout:       60 * time.Second,
 		ErrorLog:          logger.StdErrorLogger(),
+		Protocols:         newProtocols(),
+		HTTP2:             newHTTP2Config(),
 	}
 
 	return serveWithShutdown(ctx, srv, ln)
 }
 
+// newProtocols returns the protocols accepted by the server according to -http.h2c.
+func newProtocols() *http.Protocols {
+	var p http.Protocols
+	p.SetHTTP1(true)
+	p.SetHTTP2(true)
+	if *enableH2C {
+		p.SetUnencryptedHTTP2(true)
+	}
+	return &p
+}
+
+// newHTTP2Config returns HTTP/2 limits from the -http.max* flags.
+//
+// Zero values make net/http fall back to its own defaults.
+func newHTTP2Config() *http.HTTP2Config {
+	return &http.HTTP2Config{
+		MaxConcurrentStreams:          *maxConcurrentStreams,
+		MaxReadFrameSize:              *maxReadFrameSize,
+		MaxDecoderHeaderTableSize:     *maxDecoderHeaderTableSize,
+		MaxEncoderHeaderTableSize:     *maxEncoderHeaderTableSize,
+		MaxReceiveBufferPerConnection: *maxReceiveBufferPerConnection,
+		MaxReceiveBufferPerStream:     *maxReceiveBufferPerStream,
+		CountError: func(errType string) {
+			metrics.GetOrCreateCounter(`http2_errors_total{type="` + errType + `"}`).Inc()
+		},
+	}
+}
+
 // serveWithShutdown gère le cycle de vie d'un serveur HTTP avec shutdown gracieux.
 func serveWithShutdown(ctx context.Context, srv *http.Server, ln net.Listener) error {
 	errCh := make(chan error, 1)
@@ -87,11 +146,34 @@ type ErrResponse struct {
 }
 
 func WriteJSON(w http.ResponseWriter, r *http.Request, status int, v any) {
-	var buf bytes.Buffer
-	if err := json.NewEncoder(&buf).Encode(v); err != nil {
+	b, err := encodeJSON(r, v)
+	if err != nil {
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
+	writeJSONBytes(w, r, status, b)
+}
+
+func encodeJSON(r *http.Request, v any) ([]byte, error) {
+	start := time.Now()
+	var buf bytes.Buffer
+	err := json.NewEncoder(&buf).Encode(v)
+	reqtrace.FromContext(r.Context()).Record(reqtrace.PhaseJSONEncode, "", start, time.Since(start))
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeJSONBytes(w http.ResponseWriter, r *http.Request, status int, b []byte) {
+	setJSONHeaders(w, r)
+	start := time.Now()
+	w.WriteHeader(status)
+	_, _ = w.Write(b)
+	reqtrace.FromContext(r.Context()).Record(reqtrace.PhaseWrite, "", start, time.Since(start))
+}
+
+func setJSONHeaders(w http.ResponseWriter, r *http.Request) {
 	h := w.Header()
 	h.Set("Content-Type", "application/json; charset=utf-8")
 	h.Set("X-Content-Type-Options", "nosniff")
@@ -100,9 +182,6 @@ func WriteJSON(w http.ResponseWriter, r *http.Request, status int, v any) {
 	if rid != "" {
 		h.Set("X-Request-Id", rid)
 	}
-
-	w.WriteHeader(status)
-	_, _ = w.Write(buf.Bytes())
 }
 
 func WriteError(w http.ResponseWriter, r *http.Request, status int, err error) {
@@ -115,10 +194,7 @@ func WriteError(w http.ResponseWriter, r *http.Request, status int, err error) {
 			"path", r.URL.Path,
 			"err", err.Error(), // flatten error
 		}
-		if rid := r.Header.Get("X-Request-Id"); rid != "" {
-			args = append(args, "rid", rid) // non-empty
-		}
-		logger.Error("http error", args...)
+		logger.ErrorCtx(r.Context(), "http error", args...)
 	}
 
 	WriteJSON(w, r, status, ErrResponse{Error: msg})
@@ -174,22 +250,40 @@ func SPAFileServer(staticDir string) http.HandlerFunc {
 
 func wrapHandlerWithBuiltins(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		countProto(r)
+
 		switch {
 		case r.Method == http.MethodGet && r.URL.Path == "/api/healthz":
 			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 			w.WriteHeader(http.StatusOK)
 			_, _ = w.Write([]byte("OK"))
 			return
+		case r.Method == http.MethodGet && r.URL.Path == "/api/readyz":
+			serveReadyz(w, r)
+			return
 		case r.Method == http.MethodGet && r.URL.Path == "/api/version":
+			setStatusHeaders(w)
 			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 			w.WriteHeader(http.StatusOK)
 			_, _ = w.Write([]byte(buildinfo.Version))
 			return
 		case r.Method == http.MethodGet && r.URL.Path == "/api/metrics":
 			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			metrics.WritePrometheus(w)
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		traceRequest(next, w, r)
 	})
 }
+
+func countProto(r *http.Request) {
+	switch r.ProtoMajor {
+	case 1:
+		http1Requests.Inc()
+	case 2:
+		http2Requests.Inc()
+	default:
+		otherProtoRequests.Inc()
+	}
+}
@@ -337,3 +337,82 @@ func TestServeGracefulShutdown(t *testing.T) {
 		t.Fatal("serve did not return after cancel")
 	}
 }
+
+func TestServeH2C(t *testing.T) {
+	f := func(h2c bool, wantProtoMajor int) {
+		t.Helper()
+
+		origH2C := *enableH2C
+		*enableH2C = h2c
+		defer func() { *enableH2C = origH2C }()
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(r.Proto))
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- ServeWithListener(ctx, ln, handler)
+		}()
+		defer func() {
+			cancel()
+			if err := <-done; err != nil {
+				t.Fatalf("serve returned unexpected error: %v", err)
+			}
+		}()
+
+		var protocols http.Protocols
+		protocols.SetHTTP1(true)
+		if h2c {
+			protocols.SetHTTP1(false)
+			protocols.SetUnencryptedHTTP2(true)
+		}
+		tr := &http.Transport{Protocols: &protocols}
+		defer tr.CloseIdleConnections()
+		client := &http.Client{Transport: tr, Timeout: 5 * time.Second}
+
+		http2Before := http2Requests.Get()
+		resp, err := client.Get("http://" + ln.Addr().String() + "/")
+		if err != nil {
+			t.Fatalf("client error: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+
+		if resp.ProtoMajor != wantProtoMajor {
+			t.Fatalf("unexpected response proto %q (%s); want major %d", resp.Proto, body, wantProtoMajor)
+		}
+		if wantProtoMajor == 2 && http2Requests.Get() != http2Before+1 {
+			t.Fatalf("http2 requests counter was not incremented")
+		}
+	}
+
+	t.Run("http1 by default", func(t *testing.T) { f(false, 1) })
+	t.Run("h2c when enabled", func(t *testing.T) { f(true, 2) })
+}
+
+func TestNewHTTP2Config(t *testing.T) {
+	origStreams := *maxConcurrentStreams
+	origFrame := *maxReadFrameSize
+	defer func() {
+		*maxConcurrentStreams = origStreams
+		*maxReadFrameSize = origFrame
+	}()
+
+	*maxConcurrentStreams = 10
+	*maxReadFrameSize = 1 << 16
+
+	cfg := newHTTP2Config()
+	if cfg.MaxConcurrentStreams != 10 {
+		t.Fatalf("MaxConcurrentStreams = %d; want %d", cfg.MaxConcurrentStreams, 10)
+	}
+	if cfg.MaxReadFrameSize != 1<<16 {
+		t.Fatalf("MaxReadFrameSize = %d; want %d", cfg.MaxReadFrameSize, 1<<16)
+	}
+}
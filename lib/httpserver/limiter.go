@@ -0,0 +1,253 @@
+package httpserver
+
+import (
+	"container/list"
+	"flag"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AltSoyuz/adequate/lib/metrics"
+	"github.com/AltSoyuz/adequate/lib/reqtrace"
+)
+
+var (
+	maxConcurrentRequests = flag.Int("http.maxConcurrentRequests", 0, "The maximum number of API requests processed concurrently. "+
+		"The limit adapts between -http.minConcurrentRequests and this value, shrinking while requests are slower than "+
+		"-http.maxQueueDuration. Requests over the limit wait in a bounded queue. Zero disables the limit")
+	minConcurrentRequests = flag.Int("http.minConcurrentRequests", 1, "The minimum number of API requests processed concurrently "+
+		"when the limit of -http.maxConcurrentRequests shrinks under load")
+	maxQueuedRequests = flag.Int("http.maxQueuedRequests", 100, "The maximum number of API requests waiting for a free slot "+
+		"when -http.maxConcurrentRequests is reached. Requests over the limit are rejected with 503")
+	maxQueueDuration = flag.Duration("http.maxQueueDuration", 5*time.Second, "The maximum duration an API request waits for a free slot "+
+		"when -http.maxConcurrentRequests is reached. Requests waiting longer are rejected with 503")
+)
+
+var (
+	limiterInFlight        = metrics.NewGauge("http_limiter_in_flight", nil)
+	limiterQueueDepth      = metrics.NewGauge("http_limiter_queue_depth", nil)
+	limiterLimit           = metrics.NewGauge("http_limiter_limit", nil)
+	limiterRejectedFull    = metrics.NewCounter(`http_limiter_rejected_total{reason="queue_full"}`)
+	limiterRejectedTimeout = metrics.NewCounter(`http_limiter_rejected_total{reason="timeout"}`)
+)
+
+// Priority is the class of a request for the concurrency limiter.
+type Priority int
+
+const (
+	// PriorityNormal requests wait in the queue when all the slots are busy.
+	PriorityNormal Priority = iota
+	// PriorityHigh requests may also take a slot reserved above the limit, so they get through when the normal
+	// slots are busy. They are still limited, since they are classified before the request is authenticated.
+	PriorityHigh
+	// PriorityCritical requests bypass the limiter.
+	PriorityCritical
+)
+
+// reservedSlots is the number of slots over the limit which only PriorityHigh requests take.
+const reservedSlots = 1
+
+// DefaultPriority keeps non-API traffic out of the limiter, and gives admin requests a reserved slot,
+// so admins can act on an overloaded server.
+//
+// Health, version and metrics endpoints are served before the limiter, so they are never limited.
+func DefaultPriority(r *http.Request) Priority {
+	if !strings.HasPrefix(r.URL.Path, "/api/") {
+		return PriorityCritical
+	}
+	if strings.HasPrefix(r.URL.Path, "/api/admin/") {
+		return PriorityHigh
+	}
+	return PriorityNormal
+}
+
+// LimitConcurrency returns next limited by the -http.*ConcurrentRequests and -http.maxQueue* flags,
+// or next if -http.maxConcurrentRequests is zero.
+func LimitConcurrency(next http.Handler) http.Handler {
+	if *maxConcurrentRequests <= 0 {
+		return next
+	}
+	return NewLimiter(*minConcurrentRequests, *maxConcurrentRequests, *maxQueuedRequests, *maxQueueDuration).Handler(next)
+}
+
+// limitBackoff is the factor applied to the limit of a Limiter when requests are slower than its wait deadline.
+const limitBackoff = 0.9
+
+// Limiter limits the number of concurrently processed requests.
+//
+// The limit adapts to the latency of requests with AIMD: it decreases multiplicatively when a request holds its slot
+// longer than the wait deadline or a queued request times out, since the queue cannot drain in time, and it increases
+// additively by about one per limit of fast requests otherwise.
+//
+// Requests over the limit wait in a bounded queue. They are rejected with 503
+// and a Retry-After header when the queue is full or when they waited too long.
+type Limiter struct {
+	// Classify returns the priority of r. DefaultPriority is used if nil.
+	Classify func(r *http.Request) Priority
+
+	minLimit  float64
+	maxLimit  float64
+	maxQueued int
+	maxWait   time.Duration
+
+	mu       sync.Mutex
+	limit    float64
+	inFlight int
+	// waiters holds the channels of queued requests, closed when they are given a slot.
+	waiters list.List
+}
+
+// NewLimiter returns a limiter processing between minConcurrent and maxConcurrent requests at once,
+// with up to maxQueued requests waiting for at most maxWait.
+//
+// The limit starts at maxConcurrent.
+func NewLimiter(minConcurrent, maxConcurrent, maxQueued int, maxWait time.Duration) *Limiter {
+	minConcurrent = max(1, min(minConcurrent, maxConcurrent))
+	limiterLimit.Set(float64(maxConcurrent))
+	return &Limiter{
+		minLimit:  float64(minConcurrent),
+		maxLimit:  float64(maxConcurrent),
+		maxQueued: maxQueued,
+		maxWait:   maxWait,
+		limit:     float64(maxConcurrent),
+	}
+}
+
+// Handler wraps next with the limiter.
+func (l *Limiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		classify := l.Classify
+		if classify == nil {
+			classify = DefaultPriority
+		}
+		prio := classify(r)
+		if prio == PriorityCritical {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		ok := l.acquire(w, r, prio)
+		reqtrace.FromContext(r.Context()).Record(reqtrace.PhaseQueue, "", start, time.Since(start))
+		if !ok {
+			return
+		}
+		limiterInFlight.Inc()
+		start = time.Now()
+		defer func() {
+			limiterInFlight.Dec()
+			l.release(time.Since(start) > l.maxWait)
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acquire takes a slot for r. It writes a 503 response and returns false if it cannot.
+func (l *Limiter) acquire(w http.ResponseWriter, r *http.Request, prio Priority) bool {
+	l.mu.Lock()
+	limit := l.currentLimit()
+	if prio == PriorityHigh {
+		limit += reservedSlots
+	}
+	if l.inFlight < limit {
+		l.inFlight++
+		l.mu.Unlock()
+		return true
+	}
+	if l.waiters.Len() >= l.maxQueued {
+		l.mu.Unlock()
+		limiterRejectedFull.Inc()
+		l.reject(w, r)
+		return false
+	}
+	ready := make(chan struct{})
+	e := l.waiters.PushBack(ready)
+	l.mu.Unlock()
+
+	limiterQueueDepth.Inc()
+	defer limiterQueueDepth.Dec()
+
+	t := time.NewTimer(l.maxWait)
+	defer t.Stop()
+
+	select {
+	case <-ready:
+		return true
+	case <-t.C:
+	case <-r.Context().Done():
+	}
+
+	l.mu.Lock()
+	select {
+	case <-ready:
+		// The slot was given right before the deadline.
+		if r.Context().Err() != nil {
+			// The slot was not used, so it says nothing about the latency of requests.
+			l.freeLocked()
+			l.mu.Unlock()
+			return false
+		}
+		l.mu.Unlock()
+		return true
+	default:
+	}
+	l.waiters.Remove(e)
+	if r.Context().Err() != nil {
+		// The client is gone; there is nobody to answer to.
+		l.mu.Unlock()
+		return false
+	}
+	l.backoffLocked()
+	l.mu.Unlock()
+
+	limiterRejectedTimeout.Inc()
+	l.reject(w, r)
+	return false
+}
+
+// release frees a slot, adapting the limit to whether its request was slow, and gives free slots to queued requests.
+func (l *Limiter) release(slow bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if slow {
+		l.backoffLocked()
+	} else {
+		l.limit = min(l.maxLimit, l.limit+1/l.limit)
+		limiterLimit.Set(l.limit)
+	}
+	l.freeLocked()
+}
+
+// freeLocked frees a slot without adapting the limit, and gives free slots to queued requests. l.mu must be held.
+func (l *Limiter) freeLocked() {
+	l.inFlight--
+	for l.waiters.Len() > 0 && l.inFlight < l.currentLimit() {
+		l.inFlight++
+		close(l.waiters.Remove(l.waiters.Front()).(chan struct{}))
+	}
+}
+
+func (l *Limiter) backoffLocked() {
+	l.limit = max(l.minLimit, l.limit*limitBackoff)
+	limiterLimit.Set(l.limit)
+}
+
+// currentLimit returns the number of requests which may be processed at once. l.mu must be held.
+func (l *Limiter) currentLimit() int {
+	return int(l.limit)
+}
+
+func (l *Limiter) reject(w http.ResponseWriter, r *http.Request) {
+	retryAfter := int(math.Ceil(l.maxWait.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	// Do not use WriteError here, since it logs every rejection, which may flood the log under load.
+	WriteJSON(w, r, http.StatusServiceUnavailable, ErrResponse{Error: "server is overloaded, retry later"})
+}
@@ -0,0 +1,176 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	started := make(chan struct{}, 2)
+	proceed := make(chan struct{})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/block") {
+			started <- struct{}{}
+			<-proceed
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	l := NewLimiter(1, 1, 1, 500*time.Millisecond)
+	h := l.Handler(next)
+
+	do := func(path string) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Result()
+	}
+
+	// Occupy the only slot.
+	blockedDone := make(chan int, 1)
+	go func() { blockedDone <- do("/api/block").StatusCode }()
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("blocking handler not started")
+	}
+
+	t.Run("critical requests bypass the limiter", func(t *testing.T) {
+		if res := do("/index.html"); res.StatusCode != http.StatusOK {
+			t.Fatalf("static status = %d; want %d", res.StatusCode, http.StatusOK)
+		}
+	})
+
+	t.Run("admin requests take the reserved slot", func(t *testing.T) {
+		start := time.Now()
+		if res := do("/api/admin/stats"); res.StatusCode != http.StatusOK {
+			t.Fatalf("admin status = %d; want %d", res.StatusCode, http.StatusOK)
+		}
+		if d := time.Since(start); d > 50*time.Millisecond {
+			t.Fatalf("admin request waited %s", d)
+		}
+	})
+
+	// Occupy the reserved slot too.
+	adminDone := make(chan int, 1)
+	go func() { adminDone <- do("/api/admin/block").StatusCode }()
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("blocking admin handler not started")
+	}
+
+	t.Run("admin requests are limited", func(t *testing.T) {
+		if res := do("/api/admin/stats"); res.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("admin status = %d; want %d", res.StatusCode, http.StatusServiceUnavailable)
+		}
+	})
+
+	t.Run("queued request times out", func(t *testing.T) {
+		before := limiterRejectedTimeout.Get()
+		res := do("/api/wait")
+		if res.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("status = %d; want %d", res.StatusCode, http.StatusServiceUnavailable)
+		}
+		if ra := res.Header.Get("Retry-After"); ra != "1" {
+			t.Fatalf("Retry-After = %q; want %q", ra, "1")
+		}
+		if limiterRejectedTimeout.Get() != before+1 {
+			t.Fatal("timeout rejection was not counted")
+		}
+	})
+
+	t.Run("full queue rejects right away", func(t *testing.T) {
+		queuedDone := make(chan int, 1)
+		go func() { queuedDone <- do("/api/queued").StatusCode }()
+
+		// Wait until the request above is queued.
+		deadline := time.Now().Add(time.Second)
+		for queued(l) == 0 {
+			if time.Now().After(deadline) {
+				t.Fatal("request was not queued")
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		before := limiterRejectedFull.Get()
+		res := do("/api/overflow")
+		if res.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("status = %d; want %d", res.StatusCode, http.StatusServiceUnavailable)
+		}
+		if limiterRejectedFull.Get() != before+1 {
+			t.Fatal("queue full rejection was not counted")
+		}
+
+		// Release the slot, so the queued request is served.
+		close(proceed)
+		if code := <-queuedDone; code != http.StatusOK {
+			t.Fatalf("queued request status = %d; want %d", code, http.StatusOK)
+		}
+	})
+
+	if code := <-blockedDone; code != http.StatusOK {
+		t.Fatalf("blocking request status = %d; want %d", code, http.StatusOK)
+	}
+	if code := <-adminDone; code != http.StatusOK {
+		t.Fatalf("blocking admin request status = %d; want %d", code, http.StatusOK)
+	}
+	if n := inFlight(l); n != 0 {
+		t.Fatalf("slots in use after all requests = %d; want 0", n)
+	}
+}
+
+func TestLimiterAdaptive(t *testing.T) {
+	const maxWait = 20 * time.Millisecond
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/slow" {
+			time.Sleep(2 * maxWait)
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	l := NewLimiter(2, 4, 10, maxWait)
+	h := l.Handler(next)
+
+	f := func(path string, n, wantLimit int) {
+		t.Helper()
+		for range n {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d; want %d", w.Code, http.StatusOK)
+			}
+		}
+		l.mu.Lock()
+		limit := l.currentLimit()
+		l.mu.Unlock()
+		if limit != wantLimit {
+			t.Fatalf("limit after %d requests to %s = %d; want %d", n, path, limit, wantLimit)
+		}
+	}
+
+	// The limit starts at the maximum, which fast requests do not exceed.
+	f("/api/fast", 10, 4)
+
+	// Requests slower than the wait deadline shrink the limit multiplicatively, down to the minimum.
+	f("/api/slow", 1, 3)
+	f("/api/slow", 10, 2)
+
+	// Fast requests grow it back additively.
+	f("/api/fast", 1, 2)
+	f("/api/fast", 10, 4)
+}
+
+func queued(l *Limiter) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.waiters.Len()
+}
+
+func inFlight(l *Limiter) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inFlight
+}
@@ -0,0 +1,178 @@
+package httpserver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AltSoyuz/adequate/lib/keys"
+)
+
+// testRing returns a keyring with a key per byte of secrets, the first one active.
+func testRing(t *testing.T, secrets ...byte) *keys.Ring {
+	t.Helper()
+	var ks []keys.Key
+	for i, b := range secrets {
+		ks = append(ks, keys.Key{
+			ID:        fmt.Sprintf("k%d", b),
+			Secret:    bytes.Repeat([]byte{b}, keys.SecretSize),
+			CreatedAt: time.Unix(int64(len(secrets)-i), 0),
+		})
+	}
+	r, err := keys.NewRing(ks[0], ks[1:]...)
+	if err != nil {
+		t.Fatalf("cannot create keyring: %v", err)
+	}
+	return r
+}
+
+type pageCursor struct {
+	CreatedAt int64 `json:"c"`
+	ID        int64 `json:"i"`
+}
+
+func TestCursorsRoundTrip(t *testing.T) {
+	c := NewCursors(testRing(t, 1), "items")
+
+	s, err := c.Encode(pageCursor{CreatedAt: 1700000000, ID: 42})
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	var got pageCursor
+	if err := c.Decode(s, &got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.CreatedAt != 1700000000 || got.ID != 42 {
+		t.Fatalf("decoded cursor = %+v; want {CreatedAt:1700000000 ID:42}", got)
+	}
+}
+
+func TestCursorsDecodeInvalid(t *testing.T) {
+	c := NewCursors(testRing(t, 1), "items")
+	valid, err := c.Encode(pageCursor{ID: 1})
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	payload, sig, _ := strings.Cut(valid, ".")
+
+	forged, err := NewCursors(testRing(t, 1), "other").Encode(pageCursor{ID: 1})
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	otherKey, err := NewCursors(testRing(t, 2), "items").Encode(pageCursor{ID: 1})
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	f := func(s string) {
+		t.Helper()
+		var v pageCursor
+		if err := c.Decode(s, &v); !errors.Is(err, ErrInvalidCursor) {
+			t.Fatalf("Decode(%q) err = %v; want %v", s, err, ErrInvalidCursor)
+		}
+	}
+
+	t.Run("no signature", func(t *testing.T) { f(payload) })
+	t.Run("bad base64", func(t *testing.T) { f("!!!." + sig) })
+	t.Run("tampered payload", func(t *testing.T) { f("eyJpIjoyfQ." + sig) })
+	t.Run("other scope", func(t *testing.T) { f(forged) })
+	t.Run("other key", func(t *testing.T) { f(otherKey) })
+}
+
+func TestCursorsKeyRotation(t *testing.T) {
+	s, err := NewCursors(testRing(t, 1), "items").Encode(pageCursor{ID: 42})
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	var got pageCursor
+	if err := NewCursors(testRing(t, 2, 1), "items").Decode(s, &got); err != nil || got.ID != 42 {
+		t.Fatalf("decode with a rotated keyring = %+v, %v; want ID 42", got, err)
+	}
+	if err := NewCursors(testRing(t, 2), "items").Decode(s, &got); !errors.Is(err, ErrInvalidCursor) {
+		t.Fatalf("decode after dropping the key err = %v; want %v", err, ErrInvalidCursor)
+	}
+}
+
+func TestParsePage(t *testing.T) {
+	c := NewCursors(testRing(t, 1), "items")
+	cursor, err := c.Encode(pageCursor{ID: 7})
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	f := func(query string, wantLimit int, wantOK, wantErr bool, wantID int64) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/items?"+query, nil)
+		var after pageCursor
+		limit, ok, err := c.ParsePage(req, 20, 100, &after)
+		if wantErr {
+			if err == nil {
+				t.Fatalf("expected error for query %q", query)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("unexpected error for query %q: %v", query, err)
+		}
+		if limit != wantLimit || ok != wantOK || after.ID != wantID {
+			t.Fatalf("ParsePage(%q) = (%d, %v, %+v); want (%d, %v, ID %d)", query, limit, ok, after, wantLimit, wantOK, wantID)
+		}
+	}
+
+	t.Run("defaults", func(t *testing.T) { f("", 20, false, false, 0) })
+	t.Run("limit", func(t *testing.T) { f("limit=5", 5, false, false, 0) })
+	t.Run("limit too big", func(t *testing.T) { f("limit=101", 0, false, true, 0) })
+	t.Run("limit zero", func(t *testing.T) { f("limit=0", 0, false, true, 0) })
+	t.Run("limit not a number", func(t *testing.T) { f("limit=ten", 0, false, true, 0) })
+	t.Run("cursor", func(t *testing.T) { f("cursor="+cursor, 20, true, false, 7) })
+	t.Run("bad cursor", func(t *testing.T) { f("cursor=abc", 0, false, true, 0) })
+}
+
+func TestNewPage(t *testing.T) {
+	c := NewCursors(testRing(t, 1), "items")
+	key := func(id int) any { return pageCursor{ID: int64(id)} }
+
+	t.Run("last page", func(t *testing.T) {
+		p, err := NewPage(c, []int{1, 2}, 2, key)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(p.Items) != 2 || p.NextCursor != "" {
+			t.Fatalf("page = %+v; want 2 items and no next cursor", p)
+		}
+	})
+
+	t.Run("more pages", func(t *testing.T) {
+		p, err := NewPage(c, []int{1, 2, 3}, 2, key)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(p.Items) != 2 {
+			t.Fatalf("got %d items; want %d", len(p.Items), 2)
+		}
+		var next pageCursor
+		if err := c.Decode(p.NextCursor, &next); err != nil {
+			t.Fatalf("decode next cursor: %v", err)
+		}
+		if next.ID != 2 {
+			t.Fatalf("next cursor ID = %d; want %d", next.ID, 2)
+		}
+	})
+
+	t.Run("empty page encodes items as an array", func(t *testing.T) {
+		p, err := NewPage[int](c, nil, 2, key)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if p.Items == nil {
+			t.Fatal("items must not be nil")
+		}
+	})
+}
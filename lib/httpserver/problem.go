@@ -0,0 +1,35 @@
+package httpserver
+
+import (
+	"net/http"
+)
+
+// Problem is an RFC 9457 problem details object.
+type Problem struct {
+	// Type is a URI reference identifying the problem type. It defaults to about:blank.
+	Type string `json:"type"`
+	// Title is a short summary of the problem type. It defaults to the status text.
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	// Detail explains this occurrence of the problem.
+	Detail string `json:"detail,omitempty"`
+}
+
+// WriteProblem writes p as an application/problem+json response with p.Status.
+func WriteProblem(w http.ResponseWriter, r *http.Request, p Problem) {
+	if p.Type == "" {
+		p.Type = "about:blank"
+	}
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+	b, err := encodeJSON(r, p)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	setJSONHeaders(w, r)
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	_, _ = w.Write(b)
+}
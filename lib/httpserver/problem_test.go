@@ -0,0 +1,46 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteProblem(t *testing.T) {
+	f := func(p Problem, want Problem) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/api/items", nil)
+		w := httptest.NewRecorder()
+		WriteProblem(w, req, p)
+
+		if w.Code != want.Status {
+			t.Fatalf("status = %d; want %d", w.Code, want.Status)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+			t.Fatalf("content-type = %q; want %q", ct, "application/problem+json")
+		}
+		var got Problem
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatalf("cannot decode body %q: %v", w.Body.String(), err)
+		}
+		if got != want {
+			t.Fatalf("problem = %+v; want %+v", got, want)
+		}
+	}
+
+	// defaults
+	f(Problem{Status: http.StatusServiceUnavailable, Detail: "read-only"}, Problem{
+		Type:   "about:blank",
+		Title:  "Service Unavailable",
+		Status: http.StatusServiceUnavailable,
+		Detail: "read-only",
+	})
+
+	// custom type and title
+	f(Problem{Type: "/problems/quota", Title: "Quota exceeded", Status: http.StatusTooManyRequests}, Problem{
+		Type:   "/problems/quota",
+		Title:  "Quota exceeded",
+		Status: http.StatusTooManyRequests,
+	})
+}
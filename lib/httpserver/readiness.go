@@ -0,0 +1,87 @@
+package httpserver
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const readinessTimeout = 2 * time.Second
+
+// ReadinessCheck reports the state of a component for /api/readyz.
+//
+// The returned string is reported as is. A non-nil error makes the server not ready.
+type ReadinessCheck func(ctx context.Context) (string, error)
+
+// Readiness is the /api/readyz response body.
+type Readiness struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+var (
+	statusMu      sync.Mutex
+	readyChecks   = map[string]ReadinessCheck{}
+	statusHeaders = map[string]func() string{}
+)
+
+// AddReadinessCheck registers a check run on every /api/readyz request under the given name.
+func AddReadinessCheck(name string, check ReadinessCheck) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	readyChecks[name] = check
+}
+
+// AddStatusHeader makes /api/version and /api/readyz responses carry the header with the value
+// returned by value. The header is omitted when value returns an empty string.
+func AddStatusHeader(header string, value func() string) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	statusHeaders[header] = value
+}
+
+func setStatusHeaders(w http.ResponseWriter) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	for header, value := range statusHeaders {
+		if v := value(); v != "" {
+			w.Header().Set(header, v)
+		}
+	}
+}
+
+// serveReadyz runs the registered readiness checks and answers 503 if any of them fails.
+func serveReadyz(w http.ResponseWriter, r *http.Request) {
+	statusMu.Lock()
+	names := make([]string, 0, len(readyChecks))
+	for name := range readyChecks {
+		names = append(names, name)
+	}
+	checks := make([]ReadinessCheck, 0, len(names))
+	sort.Strings(names)
+	for _, name := range names {
+		checks = append(checks, readyChecks[name])
+	}
+	statusMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	resp := Readiness{Status: "ready", Checks: map[string]string{}}
+	status := http.StatusOK
+	for i, check := range checks {
+		v, err := check(ctx)
+		if err != nil {
+			v = err.Error()
+			resp.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+		}
+		resp.Checks[names[i]] = v
+	}
+
+	setStatusHeaders(w)
+	w.Header().Set("Cache-Control", "no-store")
+	WriteJSON(w, r, status, resp)
+}
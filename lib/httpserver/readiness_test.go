@@ -0,0 +1,63 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadyz(t *testing.T) {
+	h := wrapHandlerWithBuiltins(http.NotFoundHandler())
+
+	var dbErr error
+	AddReadinessCheck("test_db", func(ctx context.Context) (string, error) {
+		return "ok", dbErr
+	})
+	AddReadinessCheck("test_mode", func(ctx context.Context) (string, error) {
+		return "read_only", nil
+	})
+	AddStatusHeader("X-Test-Mode", func() string { return "read_only" })
+
+	f := func(wantStatus int, want Readiness) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/readyz", nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		if w.Code != wantStatus {
+			t.Fatalf("status = %d; want %d", w.Code, wantStatus)
+		}
+		if got := w.Header().Get("X-Test-Mode"); got != "read_only" {
+			t.Fatalf("X-Test-Mode = %q; want %q", got, "read_only")
+		}
+		var got Readiness
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatalf("cannot decode body %q: %v", w.Body.String(), err)
+		}
+		if got.Status != want.Status {
+			t.Fatalf("readiness status = %q; want %q", got.Status, want.Status)
+		}
+		for name, v := range want.Checks {
+			if got.Checks[name] != v {
+				t.Fatalf("check %q = %q; want %q", name, got.Checks[name], v)
+			}
+		}
+	}
+
+	f(http.StatusOK, Readiness{Status: "ready", Checks: map[string]string{"test_db": "ok", "test_mode": "read_only"}})
+
+	dbErr = errors.New("database is locked")
+	f(http.StatusServiceUnavailable, Readiness{Status: "unavailable", Checks: map[string]string{"test_db": "database is locked", "test_mode": "read_only"}})
+
+	t.Run("version reports status headers", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/version", nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if got := w.Header().Get("X-Test-Mode"); got != "read_only" {
+			t.Fatalf("X-Test-Mode = %q; want %q", got, "read_only")
+		}
+	})
+}
@@ -0,0 +1,237 @@
+package httpserver
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/AltSoyuz/adequate/lib/logger"
+	"github.com/AltSoyuz/adequate/lib/tracing"
+)
+
+// Route describes an API endpoint registered through a Router.
+//
+// The request and response types are used for generating the API contract, e.g. an OpenAPI document.
+type Route struct {
+	Method      string
+	Path        string
+	Name        string
+	Summary     string
+	PathParams  []string
+	QueryParams []Param
+
+	// Request is the type of the JSON request body, or nil if the endpoint takes no body.
+	Request reflect.Type
+	// Response is the type of the JSON response body, or nil if the endpoint returns no body.
+	Response reflect.Type
+	// Status is the status code of successful responses.
+	Status int
+	// Permission is the permission required to use the route, if any.
+	Permission string
+
+	// Version is the API version the route belongs to, if it was registered through Router.Version.
+	Version string
+	// Deprecated is set for routes of deprecated API versions.
+	Deprecated bool
+}
+
+// Param describes a query param of a Route.
+type Param struct {
+	Name        string
+	Description string
+}
+
+// RouteOption configures a Route.
+type RouteOption func(*Route)
+
+// Name sets the route name, which identifies the endpoint in the API contract.
+func Name(name string) RouteOption {
+	return func(rt *Route) { rt.Name = name }
+}
+
+// Summary sets the one-line description of the route.
+func Summary(summary string) RouteOption {
+	return func(rt *Route) { rt.Summary = summary }
+}
+
+// Query declares a query param accepted by the route.
+func Query(name, description string) RouteOption {
+	return func(rt *Route) { rt.QueryParams = append(rt.QueryParams, Param{Name: name, Description: description}) }
+}
+
+// Request declares T as the type of the JSON request body.
+func Request[T any]() RouteOption {
+	return func(rt *Route) { rt.Request = reflect.TypeFor[T]() }
+}
+
+// Response declares T as the type of the JSON response body sent with status.
+func Response[T any](status int) RouteOption {
+	return func(rt *Route) {
+		rt.Response = reflect.TypeFor[T]()
+		rt.Status = status
+	}
+}
+
+// Status sets the status code of successful responses, for routes without a response body.
+func Status(status int) RouteOption {
+	return func(rt *Route) { rt.Status = status }
+}
+
+// Permission requires the given permission for using the route; see Router.SetAuthorizer.
+func Permission(name string) RouteOption {
+	return func(rt *Route) { rt.Permission = name }
+}
+
+// Middleware wraps a handler with extra behavior.
+type Middleware func(http.Handler) http.Handler
+
+// Authorizer returns the middleware rejecting requests which do not hold permission.
+type Authorizer func(permission string) Middleware
+
+// Router registers API handlers on a http.ServeMux and keeps track of their description.
+//
+// Routers created with Group or Version share the mux and the route list of their parent.
+type Router struct {
+	shared *routerState
+	prefix string
+	mws    []Middleware
+	// version is the API version of the routes registered through the router, if any.
+	version *APIVersion
+}
+
+type routerState struct {
+	mux            *http.ServeMux
+	routes         []Route
+	versions       map[string]*APIVersion
+	defaultVersion string
+	authorizer     Authorizer
+}
+
+// NewRouter returns an empty router.
+func NewRouter() *Router {
+	return &Router{shared: &routerState{
+		mux:      http.NewServeMux(),
+		versions: map[string]*APIVersion{},
+	}}
+}
+
+// Group returns a router registering routes under prefix, wrapped with mws.
+//
+// The middlewares of rt wrap the middlewares of the group.
+func (rt *Router) Group(prefix string, mws ...Middleware) *Router {
+	return &Router{
+		shared:  rt.shared,
+		prefix:  rt.prefix + prefix,
+		mws:     append(append([]Middleware(nil), rt.mws...), mws...),
+		version: rt.version,
+	}
+}
+
+// SetAuthorizer sets the authorizer checking the permission of routes registered with the Permission option.
+//
+// It must be called before registering such routes.
+func (rt *Router) SetAuthorizer(a Authorizer) {
+	rt.shared.authorizer = a
+}
+
+// Handle registers h for requests with the given method and path.
+//
+// path is relative to the router prefix and follows http.ServeMux patterns,
+// so it may contain wildcards such as /api/items/{id}.
+//
+// The permission of the route is checked after the router middlewares, so they can authenticate the request.
+func (rt *Router) Handle(method, path string, h http.Handler, opts ...RouteOption) {
+	path = rt.prefix + path
+	r := Route{
+		Method:     method,
+		Path:       path,
+		PathParams: pathParams(path),
+		Status:     http.StatusOK,
+	}
+	if rt.version != nil {
+		r.Version = rt.version.Name
+		r.Deprecated = rt.version.deprecated()
+	}
+	for _, opt := range opts {
+		opt(&r)
+	}
+	if r.Name == "" {
+		r.Name = defaultRouteName(method, path)
+	}
+
+	if r.Permission != "" {
+		if rt.shared.authorizer == nil {
+			panic("BUG: route " + method + " " + path + " requires a permission, but the router has no authorizer")
+		}
+		h = rt.shared.authorizer(r.Permission)(h)
+	}
+	for i := len(rt.mws) - 1; i >= 0; i-- {
+		h = rt.mws[i](h)
+	}
+	rt.shared.mux.Handle(method+" "+path, withRoute(method, path, h))
+	rt.shared.routes = append(rt.shared.routes, r)
+}
+
+// HandleFunc is like Handle, but takes a handler function.
+func (rt *Router) HandleFunc(method, path string, h http.HandlerFunc, opts ...RouteOption) {
+	rt.Handle(method, path, h, opts...)
+}
+
+// Mount registers h for the given http.ServeMux pattern without describing it in Routes.
+//
+// Use it for handlers outside the API contract, such as static files.
+// The pattern is not relative to the router prefix, and the router middlewares are not applied.
+func (rt *Router) Mount(pattern string, h http.Handler) {
+	rt.shared.mux.Handle(pattern, h)
+}
+
+// Routes returns the routes registered with Handle, in registration order.
+func (rt *Router) Routes() []Route {
+	return append([]Route(nil), rt.shared.routes...)
+}
+
+// ServeHTTP serves r with the route matching its path, after negotiating the version of unversioned /api/ paths.
+//
+// Paths already rewritten by NegotiateVersion are versioned, so they are not rewritten twice.
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt.NegotiateVersion(rt.shared.mux).ServeHTTP(w, r)
+}
+
+// withRoute names the server span of requests after their route, to keep span names low-cardinality,
+// and adds the route to the log fields of the request context.
+func withRoute(method, path string, next http.Handler) http.Handler {
+	name := method + " " + path
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		span := tracing.SpanFromContext(r.Context())
+		span.SetName(name)
+		span.SetAttributes(tracing.String("http.route", path))
+		next.ServeHTTP(w, r.WithContext(logger.With(r.Context(), "route", path)))
+	})
+}
+
+var pathParamRe = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)(?:\.\.\.)?\}`)
+
+func pathParams(path string) []string {
+	var names []string
+	for _, m := range pathParamRe.FindAllStringSubmatch(path, -1) {
+		names = append(names, m[1])
+	}
+	return names
+}
+
+// defaultRouteName derives a camelCase name from the method and path,
+// e.g. "GET /api/migrations/{id}" gives "getMigrationsId".
+func defaultRouteName(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	for _, seg := range strings.FieldsFunc(path, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	}) {
+		if seg == "api" {
+			continue
+		}
+		b.WriteString(strings.ToUpper(seg[:1]) + seg[1:])
+	}
+	return b.String()
+}
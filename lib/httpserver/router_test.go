@@ -0,0 +1,161 @@
+package httpserver
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/AltSoyuz/adequate/lib/logger"
+)
+
+func TestRouter(t *testing.T) {
+	type itemResp struct {
+		ID string `json:"id"`
+	}
+	type itemReq struct {
+		Name string `json:"name"`
+	}
+
+	rt := NewRouter()
+	rt.HandleFunc(http.MethodGet, "/api/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		WriteJSON(w, r, http.StatusOK, itemResp{ID: r.PathValue("id")})
+	}, Response[itemResp](http.StatusOK))
+	rt.HandleFunc(http.MethodPost, "/api/items", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}, Name("createItem"), Summary("Creates an item"), Request[itemReq](), Response[itemResp](http.StatusCreated))
+	rt.HandleFunc(http.MethodDelete, "/api/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}, Status(http.StatusNoContent))
+	rt.Mount("/", http.NotFoundHandler())
+
+	t.Run("routes are served", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/items/42", nil)
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d; want %d", w.Code, http.StatusOK)
+		}
+		if body := w.Body.String(); body != "{\"id\":\"42\"}\n" {
+			t.Fatalf("body = %q; want %q", body, "{\"id\":\"42\"}\n")
+		}
+	})
+
+	t.Run("routes are described", func(t *testing.T) {
+		routes := rt.Routes()
+		if len(routes) != 3 {
+			t.Fatalf("got %d routes; want 3 (mounted handlers must not be described)", len(routes))
+		}
+
+		get := routes[0]
+		if get.Name != "getItemsId" {
+			t.Fatalf("default name = %q; want %q", get.Name, "getItemsId")
+		}
+		if !reflect.DeepEqual(get.PathParams, []string{"id"}) {
+			t.Fatalf("path params = %q; want %q", get.PathParams, []string{"id"})
+		}
+		if get.Request != nil {
+			t.Fatalf("unexpected request type %v", get.Request)
+		}
+
+		post := routes[1]
+		if post.Name != "createItem" || post.Summary != "Creates an item" {
+			t.Fatalf("unexpected route %+v", post)
+		}
+		if post.Request != reflect.TypeFor[itemReq]() || post.Response != reflect.TypeFor[itemResp]() {
+			t.Fatalf("unexpected types: request %v, response %v", post.Request, post.Response)
+		}
+		if post.Status != http.StatusCreated {
+			t.Fatalf("status = %d; want %d", post.Status, http.StatusCreated)
+		}
+
+		del := routes[2]
+		if del.Response != nil || del.Status != http.StatusNoContent {
+			t.Fatalf("unexpected route without body %+v", del)
+		}
+	})
+}
+
+func TestDefaultRouteName(t *testing.T) {
+	f := func(method, path, want string) {
+		t.Helper()
+		if got := defaultRouteName(method, path); got != want {
+			t.Fatalf("defaultRouteName(%q, %q) = %q; want %q", method, path, got, want)
+		}
+	}
+
+	f("GET", "/api/migrations", "getMigrations")
+	f("GET", "/api/migrations/version", "getMigrationsVersion")
+	f("DELETE", "/api/items/{id}", "deleteItemsId")
+	f("GET", "/api/files/{path...}", "getFilesPath")
+}
+
+func TestRouterPermission(t *testing.T) {
+	rt := NewRouter()
+	rt.SetAuthorizer(func(permission string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !strings.Contains(r.Header.Get("X-Permissions"), permission) {
+					WriteError(w, r, http.StatusForbidden, nil)
+					return
+				}
+				next.ServeHTTP(w, r)
+			})
+		}
+	})
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+	rt.HandleFunc(http.MethodGet, "/api/public", ok)
+	rt.HandleFunc(http.MethodGet, "/api/items", ok, Permission("items:read"))
+
+	f := func(path, permissions string, want int) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-Permissions", permissions)
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Fatalf("GET %s with permissions %q: status = %d; want %d", path, permissions, w.Code, want)
+		}
+	}
+	f("/api/public", "", http.StatusNoContent)
+	f("/api/items", "", http.StatusForbidden)
+	f("/api/items", "items:read", http.StatusNoContent)
+
+	if p := rt.Routes()[1].Permission; p != "items:read" {
+		t.Fatalf("route permission = %q; want %q", p, "items:read")
+	}
+
+	t.Run("missing authorizer", func(t *testing.T) {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("registering a route with a permission must panic without authorizer")
+			}
+		}()
+		NewRouter().HandleFunc(http.MethodGet, "/api/items", ok, Permission("items:read"))
+	})
+}
+
+func TestRequestLogFields(t *testing.T) {
+	var buf bytes.Buffer
+	logger.SetOutput(&buf)
+	defer logger.ResetOutput()
+
+	rt := NewRouter()
+	rt.HandleFunc(http.MethodGet, "/api/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		logger.InfoCtx(r.Context(), "item fetched", "id", r.PathValue("id"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	h := wrapHandlerWithBuiltins(rt)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/items/42", nil)
+	req.Header.Set("X-Request-Id", "rid-1")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	want := "item fetched id=42 rid=rid-1 trace_id=4bf92f3577b34da6a3ce929d0e0e4736 route=/api/items/{id}\n"
+	if got := buf.String(); !strings.HasSuffix(got, want) {
+		t.Fatalf("log output %q; want suffix %q", got, want)
+	}
+}
@@ -0,0 +1,93 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestURLSignerVerify(t *testing.T) {
+	oldSigner := NewURLSigner(testRing(t, 1))
+	// The rotated signer signs with the new key and still verifies the URLs signed with the old one.
+	signer := NewURLSigner(testRing(t, 2, 1))
+	expires := time.Now().Add(time.Hour)
+
+	sign := func(s *URLSigner, rawURL, purpose string, expires time.Time) string {
+		t.Helper()
+		signed, err := s.Sign(rawURL, purpose, expires)
+		if err != nil {
+			t.Fatalf("Sign(%q): %v", rawURL, err)
+		}
+		return signed
+	}
+	f := func(signed, purpose string, wantErr error) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, signed, nil)
+		if err := signer.Verify(req, purpose); !errors.Is(err, wantErr) {
+			t.Fatalf("Verify(%q, %q) err = %v; want %v", signed, purpose, err, wantErr)
+		}
+	}
+	// tamper returns signed with the query param name set to value.
+	tamper := func(signed, name, value string) string {
+		u, _ := url.Parse(signed)
+		q := u.Query()
+		q.Set(name, value)
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+
+	signed := sign(signer, "/downloads/42?name=report.csv", "download", expires)
+	if !strings.Contains(signed, "kid=k2") {
+		t.Fatalf("unexpected signed URL %q; want it signed with the first key", signed)
+	}
+	f(signed, "download", nil)
+	f(sign(oldSigner, "/downloads/42?name=report.csv", "download", expires), "download", nil)
+	f(sign(signer, "https://example.com/confirm", "confirm-email", expires), "confirm-email", nil)
+
+	f(signed, "confirm-email", ErrInvalidSignature)
+	f(strings.Replace(signed, "/downloads/42", "/downloads/43", 1), "download", ErrInvalidSignature)
+	f(tamper(signed, "name", "secret.csv"), "download", ErrInvalidSignature)
+	f(tamper(signed, "user", "admin"), "download", ErrInvalidSignature)
+	f(tamper(signed, SignedURLExpiresParam, "9999999999"), "download", ErrInvalidSignature)
+	f(tamper(signed, SignedURLKeyIDParam, "k3"), "download", ErrInvalidSignature)
+	f(tamper(signed, SignedURLSignatureParam, ""), "download", ErrInvalidSignature)
+	f("/downloads/42?name=report.csv", "download", ErrInvalidSignature)
+
+	f(sign(signer, "/downloads/42", "download", time.Now().Add(-time.Second)), "download", ErrURLExpired)
+
+	// URLs signed with dropped keys are rejected.
+	dropped := NewURLSigner(testRing(t, 3))
+	f(sign(dropped, "/downloads/42", "download", expires), "download", ErrInvalidSignature)
+}
+
+func TestURLSignerMiddleware(t *testing.T) {
+	signer := NewURLSigner(testRing(t, 1))
+	h := signer.Middleware("download")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	f := func(target string, wantStatus int) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != wantStatus {
+			t.Fatalf("GET %s: status = %d; want %d, body: %s", target, rec.Code, wantStatus, rec.Body)
+		}
+	}
+
+	signed, err := signer.Sign("/downloads/42", "download", time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	f(signed, http.StatusNoContent)
+	f("/downloads/42", http.StatusForbidden)
+	expired, err := signer.Sign("/downloads/42", "download", time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	f(expired, http.StatusForbidden)
+}
@@ -0,0 +1,181 @@
+package httpserver
+
+import (
+	"errors"
+	"flag"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/AltSoyuz/adequate/lib/logger"
+	"github.com/AltSoyuz/adequate/lib/metrics"
+	"github.com/AltSoyuz/adequate/lib/reqtrace"
+	"github.com/AltSoyuz/adequate/lib/tracing"
+)
+
+var (
+	slowRequestThreshold = flag.Duration("http.slowRequestThreshold", 500*time.Millisecond, "Requests lasting longer are logged with their per-phase breakdown. "+
+		"Zero disables logging of slow requests")
+	slowRequestsKept = flag.Int("http.slowRequestsKept", 20, "The number of slowest requests kept for the slow requests admin endpoint")
+)
+
+var slowRequestsTotal = metrics.NewCounter("http_slow_requests_total")
+
+// SlowRequest is a request kept by the slow request log.
+type SlowRequest struct {
+	Method     string          `json:"method"`
+	Path       string          `json:"path"`
+	Status     int             `json:"status"`
+	RequestID  string          `json:"request_id,omitempty"`
+	Start      time.Time       `json:"start"`
+	DurationMs float64         `json:"duration_ms"`
+	Spans      []reqtrace.Span `json:"spans"`
+	// DroppedSpans is the number of phases which were not kept, for requests issuing many queries.
+	DroppedSpans int `json:"dropped_spans,omitempty"`
+}
+
+// slowLog keeps the slowest requests, sorted by decreasing duration.
+type slowLog struct {
+	mu   sync.Mutex
+	reqs []SlowRequest
+}
+
+var slowRequests = &slowLog{}
+
+// add keeps the request built by req if it is among the max slowest requests.
+func (sl *slowLog) add(max int, dur time.Duration, req func() SlowRequest) {
+	if max <= 0 {
+		return
+	}
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	if len(sl.reqs) >= max && ms(dur) <= sl.reqs[len(sl.reqs)-1].DurationMs {
+		return
+	}
+	sl.reqs = append(sl.reqs, req())
+	sort.SliceStable(sl.reqs, func(i, j int) bool { return sl.reqs[i].DurationMs > sl.reqs[j].DurationMs })
+	if len(sl.reqs) > max {
+		sl.reqs = sl.reqs[:max]
+	}
+}
+
+func (sl *slowLog) list() []SlowRequest {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	return append([]SlowRequest{}, sl.reqs...)
+}
+
+// SlowRequestsHandler returns the slowest requests seen since the start, slowest first.
+func SlowRequestsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		WriteJSON(w, r, http.StatusOK, slowRequests.list())
+	}
+}
+
+// traceRequest serves r with next, recording its timeline and its server span.
+//
+// The request ID and trace ID are added to the log fields of the request context.
+//
+// Requests lasting longer than -http.slowRequestThreshold are logged with their per-phase breakdown,
+// and the slowest ones are kept for SlowRequestsHandler.
+func traceRequest(next http.Handler, w http.ResponseWriter, r *http.Request) {
+	tl := reqtrace.New(time.Now())
+	ctx := reqtrace.NewContext(r.Context(), tl)
+	if sc, ok := tracing.Extract(r.Header); ok {
+		ctx = tracing.ContextWithRemote(ctx, sc)
+	}
+	ctx, span := tracing.Start(ctx, r.Method, tracing.KindServer,
+		tracing.String("http.request.method", r.Method),
+		tracing.String("url.path", r.URL.Path),
+		tracing.String("user_agent.original", r.UserAgent()),
+	)
+	defer span.End()
+
+	rid := r.Header.Get("X-Request-Id")
+	if rid != "" {
+		ctx = logger.With(ctx, "rid", rid)
+	}
+	ctx = logger.With(ctx, "trace_id", span.SpanContext().TraceID.String())
+
+	sw := &statusWriter{ResponseWriter: w}
+	next.ServeHTTP(sw, r.WithContext(ctx))
+	dur := time.Since(tl.Start())
+
+	status := sw.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	span.SetAttributes(tracing.Int("http.response.status_code", int64(status)))
+	if status >= 500 {
+		span.SetError(errors.New(http.StatusText(status)))
+	}
+
+	slowRequests.add(*slowRequestsKept, dur, func() SlowRequest {
+		return SlowRequest{
+			Method:       r.Method,
+			Path:         r.URL.Path,
+			Status:       status,
+			RequestID:    rid,
+			Start:        tl.Start().UTC(),
+			DurationMs:   ms(dur),
+			Spans:        tl.Spans(),
+			DroppedSpans: tl.Dropped(),
+		}
+	})
+
+	if *slowRequestThreshold <= 0 || dur < *slowRequestThreshold {
+		return
+	}
+	slowRequestsTotal.Inc()
+
+	queue, _ := tl.Total(reqtrace.PhaseQueue)
+	dbWait, _ := tl.Total(reqtrace.PhaseDBWait)
+	dbQuery, queries := tl.Total(reqtrace.PhaseDBQuery)
+	encode, _ := tl.Total(reqtrace.PhaseJSONEncode)
+	write, _ := tl.Total(reqtrace.PhaseWrite)
+	args := []any{
+		"method", r.Method,
+		"path", r.URL.Path,
+		"status", status,
+		"dur", dur,
+		"queue", queue,
+		"db_wait", dbWait,
+		"db_query", dbQuery,
+		"db_queries", queries,
+		"json_encode", encode,
+		"write", write,
+		"other", max(dur-queue-dbWait-dbQuery-encode-write, 0),
+	}
+	logger.WarnCtx(ctx, "http.request.slow", args...)
+}
+
+// statusWriter remembers the response status code.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. for flushing streams.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,90 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/AltSoyuz/adequate/lib/reqtrace"
+)
+
+func TestSlowLog(t *testing.T) {
+	var sl slowLog
+	add := func(path string, dur time.Duration) {
+		sl.add(3, dur, func() SlowRequest {
+			return SlowRequest{Path: path, DurationMs: ms(dur)}
+		})
+	}
+
+	add("/a", 10*time.Millisecond)
+	add("/b", 30*time.Millisecond)
+	add("/c", 20*time.Millisecond)
+	add("/d", 5*time.Millisecond)
+	add("/e", 40*time.Millisecond)
+
+	var got []string
+	for _, req := range sl.list() {
+		got = append(got, req.Path)
+	}
+	want := []string{"/e", "/b", "/c"}
+	if len(got) != len(want) {
+		t.Fatalf("kept %q; want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("kept %q; want %q", got, want)
+		}
+	}
+}
+
+func TestTraceRequest(t *testing.T) {
+	h := wrapHandlerWithBuiltins(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tl := reqtrace.FromContext(r.Context())
+		if tl == nil {
+			t.Fatalf("missing request timeline")
+		}
+		tl.Record(reqtrace.PhaseDBQuery, "GetSlowThing", time.Now(), 5*time.Millisecond)
+		WriteJSON(w, r, http.StatusTeapot, map[string]string{"a": "b"})
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/slow-thing", nil)
+	req.Header.Set("X-Request-Id", "rid-slow")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	var found *SlowRequest
+	for _, sr := range slowRequests.list() {
+		if sr.RequestID == "rid-slow" {
+			found = &sr
+			break
+		}
+	}
+	if found == nil {
+		t.Fatalf("request was not kept in the slow requests log")
+	}
+	if found.Status != http.StatusTeapot || found.Path != "/api/slow-thing" {
+		t.Fatalf("unexpected slow request %+v", found)
+	}
+	phases := map[string]bool{}
+	for _, s := range found.Spans {
+		phases[s.Phase] = true
+	}
+	for _, p := range []string{reqtrace.PhaseDBQuery, reqtrace.PhaseJSONEncode, reqtrace.PhaseWrite} {
+		if !phases[p] {
+			t.Fatalf("missing %s span in %+v", p, found.Spans)
+		}
+	}
+
+	t.Run("handler lists slow requests", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		SlowRequestsHandler()(w, httptest.NewRequest(http.MethodGet, "/api/admin/slow-requests", nil))
+		var list []SlowRequest
+		if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+			t.Fatalf("cannot decode body %q: %v", w.Body.String(), err)
+		}
+		if len(list) == 0 {
+			t.Fatalf("empty slow requests list")
+		}
+	})
+}
@@ -0,0 +1,139 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"iter"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AltSoyuz/adequate/lib/logger"
+	"github.com/AltSoyuz/adequate/lib/reqtrace"
+)
+
+// StreamErrorTrailer is the trailer set when a streamed response fails after its headers were sent.
+const StreamErrorTrailer = "X-Stream-Error"
+
+const (
+	streamFlushRows     = 128
+	streamFlushInterval = time.Second
+)
+
+// StreamNDJSON writes the rows produced by seq as newline-delimited JSON, without buffering the whole payload.
+//
+// If seq fails before the first row, a regular JSON error response with status 500 is written.
+// If it fails later, a final {"error":"..."} line is written and the StreamErrorTrailer trailer is set.
+// Streaming stops when the client goes away. The returned error is the one that stopped the stream, if any.
+func StreamNDJSON[T any](w http.ResponseWriter, r *http.Request, seq iter.Seq2[T, error]) error {
+	return stream(w, r, seq, false)
+}
+
+// StreamJSONArray writes the rows produced by seq as a JSON array, without buffering the whole payload.
+//
+// If seq fails before the first row, a regular JSON error response with status 500 is written.
+// If it fails later, the array is left unterminated, so clients cannot mistake the truncated
+// payload for a complete one, and the StreamErrorTrailer trailer is set.
+// Streaming stops when the client goes away. The returned error is the one that stopped the stream, if any.
+func StreamJSONArray[T any](w http.ResponseWriter, r *http.Request, seq iter.Seq2[T, error]) error {
+	return stream(w, r, seq, true)
+}
+
+func stream[T any](w http.ResponseWriter, r *http.Request, seq iter.Seq2[T, error], array bool) error {
+	ctx := r.Context()
+	rc := http.NewResponseController(w)
+
+	started := false
+	start := func() {
+		started = true
+		contentType := "application/x-ndjson"
+		if array {
+			contentType = "application/json; charset=utf-8"
+		}
+		setJSONHeaders(w, r)
+		h := w.Header()
+		h.Set("Content-Type", contentType)
+		h.Set("Trailer", StreamErrorTrailer)
+		w.WriteHeader(http.StatusOK)
+	}
+
+	var (
+		n         int
+		lastFlush = time.Now()
+	)
+	defer func(start time.Time) {
+		reqtrace.FromContext(ctx).Record(reqtrace.PhaseWrite, strconv.Itoa(n)+" rows", start, time.Since(start))
+	}(lastFlush)
+	for row, err := range seq {
+		if err != nil {
+			return streamFailed(w, r, started, array, err)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		b, err := json.Marshal(row)
+		if err != nil {
+			return streamFailed(w, r, started, array, err)
+		}
+
+		if !started {
+			start()
+			if array {
+				b = append([]byte("[\n"), b...)
+			}
+		} else if array {
+			b = append([]byte(",\n"), b...)
+		}
+		if !array {
+			b = append(b, '\n')
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+
+		n++
+		if n%streamFlushRows == 0 || time.Since(lastFlush) >= streamFlushInterval {
+			if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				return err
+			}
+			lastFlush = time.Now()
+		}
+	}
+
+	if !started {
+		start()
+		if array {
+			_, err := w.Write([]byte("[]\n"))
+			return err
+		}
+		return nil
+	}
+	if array {
+		_, err := w.Write([]byte("\n]\n"))
+		return err
+	}
+	return nil
+}
+
+func streamFailed(w http.ResponseWriter, r *http.Request, started, array bool, err error) error {
+	if !started {
+		WriteError(w, r, http.StatusInternalServerError, err)
+		return err
+	}
+
+	args := []any{
+		"method", r.Method,
+		"path", r.URL.Path,
+		"err", err.Error(),
+	}
+	logger.ErrorCtx(r.Context(), "http stream error", args...)
+
+	if !array {
+		if b, merr := json.Marshal(ErrResponse{Error: err.Error()}); merr == nil {
+			_, _ = w.Write(append(b, '\n'))
+		}
+	}
+	w.Header().Set(StreamErrorTrailer, err.Error())
+	return err
+}
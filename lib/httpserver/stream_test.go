@@ -0,0 +1,136 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"iter"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type streamRow struct {
+	ID int `json:"id"`
+}
+
+// rowsSeq yields n rows, then failErr if it is non-nil.
+func rowsSeq(n int, failErr error) iter.Seq2[streamRow, error] {
+	return func(yield func(streamRow, error) bool) {
+		for i := 1; i <= n; i++ {
+			if !yield(streamRow{ID: i}, nil) {
+				return
+			}
+		}
+		if failErr != nil {
+			yield(streamRow{}, failErr)
+		}
+	}
+}
+
+func TestStreamNDJSON(t *testing.T) {
+	f := func(seq iter.Seq2[streamRow, error], wantStatus int, wantBody, wantTrailer string) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		w := httptest.NewRecorder()
+		_ = StreamNDJSON(w, req, seq)
+		res := w.Result()
+		if res.StatusCode != wantStatus {
+			t.Fatalf("status = %d; want %d", res.StatusCode, wantStatus)
+		}
+		if got := w.Body.String(); got != wantBody {
+			t.Fatalf("body = %q; want %q", got, wantBody)
+		}
+		if got := res.Trailer.Get(StreamErrorTrailer); got != wantTrailer {
+			t.Fatalf("trailer = %q; want %q", got, wantTrailer)
+		}
+	}
+
+	t.Run("rows", func(t *testing.T) {
+		f(rowsSeq(2, nil), http.StatusOK, "{\"id\":1}\n{\"id\":2}\n", "")
+	})
+	t.Run("no rows", func(t *testing.T) {
+		f(rowsSeq(0, nil), http.StatusOK, "", "")
+	})
+	t.Run("error before first row", func(t *testing.T) {
+		f(rowsSeq(0, errors.New("boom")), http.StatusInternalServerError, "{\"error\":\"boom\"}\n", "")
+	})
+	t.Run("error mid-stream", func(t *testing.T) {
+		f(rowsSeq(1, errors.New("boom")), http.StatusOK, "{\"id\":1}\n{\"error\":\"boom\"}\n", "boom")
+	})
+}
+
+func TestStreamJSONArray(t *testing.T) {
+	f := func(seq iter.Seq2[streamRow, error], wantBody, wantTrailer string) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		w := httptest.NewRecorder()
+		_ = StreamJSONArray(w, req, seq)
+		res := w.Result()
+		if got := w.Body.String(); got != wantBody {
+			t.Fatalf("body = %q; want %q", got, wantBody)
+		}
+		if got := res.Trailer.Get(StreamErrorTrailer); got != wantTrailer {
+			t.Fatalf("trailer = %q; want %q", got, wantTrailer)
+		}
+	}
+
+	t.Run("rows", func(t *testing.T) {
+		f(rowsSeq(3, nil), "[\n{\"id\":1},\n{\"id\":2},\n{\"id\":3}\n]\n", "")
+	})
+	t.Run("no rows", func(t *testing.T) {
+		f(rowsSeq(0, nil), "[]\n", "")
+	})
+	t.Run("error mid-stream leaves the array unterminated", func(t *testing.T) {
+		f(rowsSeq(2, errors.New("boom")), "[\n{\"id\":1},\n{\"id\":2}", "boom")
+	})
+
+	t.Run("output is valid JSON", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		w := httptest.NewRecorder()
+		if err := StreamJSONArray(w, req, rowsSeq(300, nil)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var rows []streamRow
+		if err := json.Unmarshal(w.Body.Bytes(), &rows); err != nil {
+			t.Fatalf("cannot decode body: %v", err)
+		}
+		if len(rows) != 300 {
+			t.Fatalf("got %d rows; want %d", len(rows), 300)
+		}
+		if !w.Flushed {
+			t.Fatalf("expected the response to be flushed")
+		}
+	})
+}
+
+func TestStreamClientDisconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	produced := 0
+	seq := func(yield func(streamRow, error) bool) {
+		for i := 1; ; i++ {
+			produced++
+			if i == 3 {
+				cancel()
+			}
+			if !yield(streamRow{ID: i}, nil) {
+				return
+			}
+		}
+	}
+
+	err := StreamNDJSON(w, req, seq)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got err %v; want %v", err, context.Canceled)
+	}
+	if produced != 3 {
+		t.Fatalf("iterator produced %d rows after disconnect; want it stopped at %d", produced, 3)
+	}
+	if got := strings.Count(w.Body.String(), "\n"); got != 2 {
+		t.Fatalf("got %d rows written; want %d", got, 2)
+	}
+}
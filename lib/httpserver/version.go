@@ -0,0 +1,143 @@
+package httpserver
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AltSoyuz/adequate/lib/metrics"
+)
+
+// VersionHeader is the request header selecting the API version of unversioned /api/ paths.
+//
+// It is also set on responses to tell the version which served the request.
+const VersionHeader = "API-Version"
+
+// APIVersion describes a version of the API, served under /api/{Name}.
+type APIVersion struct {
+	Name string
+
+	// Deprecated is the time the version was deprecated at. The zero value means the version is not deprecated.
+	Deprecated time.Time
+	// Sunset is the time the version stops being served, if known.
+	Sunset time.Time
+	// Link points to the migration guide of a deprecated version, if any.
+	Link string
+}
+
+var versionNameRe = regexp.MustCompile(`^v[0-9]+[a-z0-9]*$`)
+
+func (v *APIVersion) deprecated() bool {
+	return !v.Deprecated.IsZero()
+}
+
+// Version returns a group registering routes under /api/{v.Name}, wrapped with mws.
+//
+// Responses of deprecated versions carry the Deprecation, Sunset and Link headers,
+// and their requests are counted in http_deprecated_requests_total.
+func (rt *Router) Version(v APIVersion, mws ...Middleware) *Router {
+	if !versionNameRe.MatchString(v.Name) {
+		panic(fmt.Errorf("BUG: invalid API version name %q", v.Name))
+	}
+	if _, ok := rt.shared.versions[v.Name]; ok {
+		panic(fmt.Errorf("BUG: API version %q is already registered", v.Name))
+	}
+	rt.shared.versions[v.Name] = &v
+
+	all := append([]Middleware(nil), rt.mws...)
+	if v.deprecated() {
+		all = append(all, deprecationMiddleware(&v))
+	}
+	return &Router{
+		shared:  rt.shared,
+		prefix:  "/api/" + v.Name,
+		mws:     append(all, mws...),
+		version: &v,
+	}
+}
+
+// SetDefaultVersion sets the version serving unversioned /api/ paths when the request has no VersionHeader.
+//
+// It lets clients written before versioning keep using /api/... paths.
+func (rt *Router) SetDefaultVersion(name string) {
+	if _, ok := rt.shared.versions[name]; !ok {
+		panic(fmt.Errorf("BUG: unknown API version %q", name))
+	}
+	rt.shared.defaultVersion = name
+}
+
+// negotiateVersion rewrites unversioned /api/ paths to the version requested with VersionHeader,
+// or to the default version.
+//
+// Paths matching a route registered outside of versions, such as /api/openapi.json, are left untouched.
+func (rt *Router) negotiateVersion(w http.ResponseWriter, r *http.Request) (*http.Request, error) {
+	rest, ok := strings.CutPrefix(r.URL.Path, "/api/")
+	if !ok || len(rt.shared.versions) == 0 {
+		return r, nil
+	}
+	seg, _, _ := strings.Cut(rest, "/")
+	if _, ok := rt.shared.versions[seg]; ok {
+		w.Header().Set(VersionHeader, seg)
+		return r, nil
+	}
+	if _, pattern := rt.shared.mux.Handler(r); pattern != "" && !strings.HasSuffix(pattern, "/") {
+		return r, nil
+	}
+
+	name := r.Header.Get(VersionHeader)
+	if name == "" {
+		name = rt.shared.defaultVersion
+		if name == "" {
+			return r, nil
+		}
+	} else if _, ok := rt.shared.versions[name]; !ok {
+		return r, fmt.Errorf("unsupported %s %q", VersionHeader, name)
+	}
+
+	w.Header().Set(VersionHeader, name)
+	r2 := r.WithContext(r.Context())
+	u := *r.URL
+	u.Path = "/api/" + name + "/" + rest
+	if rawRest, ok := strings.CutPrefix(u.RawPath, "/api/"); ok {
+		// Keep the escaping of the path, e.g. of %2F in path values.
+		u.RawPath = "/api/" + name + "/" + rawRest
+	}
+	r2.URL = &u
+	return r2, nil
+}
+
+// NegotiateVersion returns next with unversioned /api/ paths rewritten like rt does; see SetDefaultVersion.
+//
+// Wrap the middlewares of the app with it, so they all see the versioned path the router serves.
+func (rt *Router) NegotiateVersion(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, err := rt.negotiateVersion(w, r)
+		if err != nil {
+			WriteJSON(w, r, http.StatusBadRequest, ErrResponse{Error: err.Error()})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func deprecationMiddleware(v *APIVersion) Middleware {
+	requests := metrics.GetOrCreateCounter(fmt.Sprintf(`http_deprecated_requests_total{version=%q}`, v.Name))
+	deprecation := "@" + strconv.FormatInt(v.Deprecated.Unix(), 10)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Inc()
+			h := w.Header()
+			h.Set("Deprecation", deprecation)
+			if !v.Sunset.IsZero() {
+				h.Set("Sunset", v.Sunset.UTC().Format(http.TimeFormat))
+			}
+			if v.Link != "" {
+				h.Add("Link", "<"+v.Link+`>; rel="deprecation"`)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
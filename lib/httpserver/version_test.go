@@ -0,0 +1,198 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/AltSoyuz/adequate/lib/metrics"
+)
+
+func TestRouterVersions(t *testing.T) {
+	deprecatedAt := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	sunsetAt := time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC)
+
+	rt := NewRouter()
+	var groupCalls int
+	v1 := rt.Version(APIVersion{
+		Name:       "v1test",
+		Deprecated: deprecatedAt,
+		Sunset:     sunsetAt,
+		Link:       "https://example.com/migrate-to-v2",
+	}, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			groupCalls++
+			next.ServeHTTP(w, r)
+		})
+	})
+	v2 := rt.Version(APIVersion{Name: "v2test"})
+	rt.SetDefaultVersion("v2test")
+
+	served := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(name + " " + r.URL.Path))
+		}
+	}
+	v1.HandleFunc(http.MethodGet, "/items", served("v1"))
+	v2.HandleFunc(http.MethodGet, "/items", served("v2"))
+	rt.Mount("GET /api/openapi.json", served("spec"))
+	rt.Mount("/", served("spa"))
+
+	f := func(path, header string, wantStatus int, wantBody, wantVersion string) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if header != "" {
+			req.Header.Set(VersionHeader, header)
+		}
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, req)
+		if w.Code != wantStatus {
+			t.Fatalf("GET %s (%s=%q): status = %d; want %d", path, VersionHeader, header, w.Code, wantStatus)
+		}
+		if wantBody != "" && w.Body.String() != wantBody {
+			t.Fatalf("GET %s (%s=%q): body = %q; want %q", path, VersionHeader, header, w.Body.String(), wantBody)
+		}
+		if got := w.Header().Get(VersionHeader); got != wantVersion {
+			t.Fatalf("GET %s (%s=%q): %s response header = %q; want %q", path, VersionHeader, header, VersionHeader, got, wantVersion)
+		}
+	}
+
+	// versioned paths
+	f("/api/v1test/items", "", http.StatusOK, "v1 /api/v1test/items", "v1test")
+	f("/api/v2test/items", "", http.StatusOK, "v2 /api/v2test/items", "v2test")
+
+	// the path wins over the header
+	f("/api/v1test/items", "v2test", http.StatusOK, "v1 /api/v1test/items", "v1test")
+
+	// unversioned paths are negotiated
+	f("/api/items", "", http.StatusOK, "v2 /api/v2test/items", "v2test")
+	f("/api/items", "v1test", http.StatusOK, "v1 /api/v1test/items", "v1test")
+	f("/api/items", "v9", http.StatusBadRequest, "", "")
+
+	// unversioned routes and non-API paths are left untouched
+	f("/api/openapi.json", "", http.StatusOK, "spec /api/openapi.json", "")
+	f("/app/items", "", http.StatusOK, "spa /app/items", "")
+
+	if groupCalls != 3 {
+		t.Fatalf("group middleware called %d times; want 3", groupCalls)
+	}
+
+	t.Run("deprecated versions", func(t *testing.T) {
+		requests := metrics.GetOrCreateCounter(`http_deprecated_requests_total{version="v1test"}`)
+		before := requests.Get()
+
+		req := httptest.NewRequest(http.MethodGet, "/api/items", nil)
+		req.Header.Set(VersionHeader, "v1test")
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, req)
+
+		h := w.Header()
+		if got, want := h.Get("Deprecation"), "@1767225600"; got != want {
+			t.Fatalf("Deprecation = %q; want %q", got, want)
+		}
+		if got, want := h.Get("Sunset"), "Wed, 01 Jul 2026 00:00:00 GMT"; got != want {
+			t.Fatalf("Sunset = %q; want %q", got, want)
+		}
+		if got, want := h.Get("Link"), `<https://example.com/migrate-to-v2>; rel="deprecation"`; got != want {
+			t.Fatalf("Link = %q; want %q", got, want)
+		}
+		if got := requests.Get() - before; got != 1 {
+			t.Fatalf("deprecated requests counter increased by %d; want 1", got)
+		}
+
+		req = httptest.NewRequest(http.MethodGet, "/api/items", nil)
+		w = httptest.NewRecorder()
+		rt.ServeHTTP(w, req)
+		if got := w.Header().Get("Deprecation"); got != "" {
+			t.Fatalf("unexpected Deprecation header %q for a current version", got)
+		}
+	})
+
+	t.Run("routes are described", func(t *testing.T) {
+		routes := rt.Routes()
+		if len(routes) != 2 {
+			t.Fatalf("got %d routes; want 2", len(routes))
+		}
+		if r := routes[0]; r.Path != "/api/v1test/items" || r.Version != "v1test" || !r.Deprecated {
+			t.Fatalf("unexpected route %+v", r)
+		}
+		if r := routes[1]; r.Path != "/api/v2test/items" || r.Version != "v2test" || r.Deprecated {
+			t.Fatalf("unexpected route %+v", r)
+		}
+	})
+}
+
+func TestNegotiateVersion(t *testing.T) {
+	rt := NewRouter()
+	v1 := rt.Version(APIVersion{Name: "v1test"})
+	rt.SetDefaultVersion("v1test")
+	v1.HandleFunc(http.MethodGet, "/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.PathValue("id")))
+	})
+
+	// seen is the path seen by a middleware wrapped with NegotiateVersion.
+	var seen string
+	h := rt.NegotiateVersion(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.URL.EscapedPath()
+		rt.ServeHTTP(w, r)
+	}))
+
+	f := func(path, wantSeen, wantBody string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s: status = %d; want %d", path, w.Code, http.StatusOK)
+		}
+		if seen != wantSeen {
+			t.Fatalf("GET %s: middleware saw %q; want %q", path, seen, wantSeen)
+		}
+		if w.Body.String() != wantBody {
+			t.Fatalf("GET %s: body = %q; want %q", path, w.Body.String(), wantBody)
+		}
+		if got := w.Header().Get(VersionHeader); got != "v1test" {
+			t.Fatalf("GET %s: %s response header = %q; want %q", path, VersionHeader, got, "v1test")
+		}
+	}
+
+	// middlewares see the versioned path
+	f("/api/items/42", "/api/v1test/items/42", "42")
+	f("/api/v1test/items/42", "/api/v1test/items/42", "42")
+
+	// escaped paths keep their escaping
+	f("/api/items/a%2Fb", "/api/v1test/items/a%2Fb", "a/b")
+	f("/api/items/a%20b", "/api/v1test/items/a%20b", "a b")
+}
+
+func TestRouterGroup(t *testing.T) {
+	var calls []string
+	mw := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	rt := NewRouter()
+	admin := rt.Group("/api/admin", mw("outer")).Group("/users", mw("inner"))
+	admin.HandleFunc(http.MethodGet, "/{id}", func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, "handler "+r.PathValue("id"))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/users/42", nil)
+	w := httptest.NewRecorder()
+	rt.ServeHTTP(w, req)
+
+	if got, want := len(calls), 3; got != want {
+		t.Fatalf("calls = %q; want %d calls", calls, want)
+	}
+	if calls[0] != "outer" || calls[1] != "inner" || calls[2] != "handler 42" {
+		t.Fatalf("calls = %q; want outer, inner, handler 42", calls)
+	}
+	if got := rt.Routes()[0].Path; got != "/api/admin/users/{id}" {
+		t.Fatalf("route path = %q; want %q", got, "/api/admin/users/{id}")
+	}
+}
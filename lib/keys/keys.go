@@ -0,0 +1,222 @@
+// Package keys manages the secret keys of the app.
+//
+// A keyring holds one active key, which signs and encrypts new data, and retired keys, which only verify and decrypt
+// the data they protected, so keys can be rotated without invalidating links, cursors or encrypted columns.
+//
+// Consumers never use the key secrets directly: they derive their own key for a purpose with Key.Derive,
+// so a secret is never used by two algorithms.
+package keys
+
+import (
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+)
+
+var (
+	ringFile = flag.String("keys.file", "", "Path to the keyring file, created with the keys generate command and rotated with keys rotate. "+
+		"A random keyring is generated at startup if neither -keys.file nor -keys.ring is set, "+
+		"so signed data does not survive restarts and encryption is disabled")
+	ringJSON = flag.String("keys.ring", "", "Keyring in the JSON format of -keys.file, e.g. set from the keys_ring environment variable. "+
+		"It takes precedence over -keys.file")
+)
+
+// SecretSize is the size of key secrets in bytes.
+const SecretSize = 32
+
+// Key is a secret key of a keyring.
+type Key struct {
+	// ID identifies the key in the data it protects, so they are verified or decrypted with the same key.
+	ID        string    `json:"id"`
+	Secret    []byte    `json:"secret"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Derive returns the SecretSize-byte key of k for purpose, e.g. signing cursors.
+//
+// Keys derived for different purposes are independent, so data protected for one purpose is not valid for another.
+func (k Key) Derive(purpose string) []byte {
+	b, err := hkdf.Key(sha256.New, k.Secret, nil, purpose, SecretSize)
+	if err != nil {
+		// Only happens for lengths above 255 hash sizes.
+		panic(fmt.Errorf("BUG: cannot derive key: %w", err))
+	}
+	return b
+}
+
+// NewKey returns a random key.
+func NewKey() (Key, error) {
+	id := make([]byte, 4)
+	secret := make([]byte, SecretSize)
+	if _, err := rand.Read(id); err != nil {
+		return Key{}, fmt.Errorf("cannot generate key ID: %w", err)
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return Key{}, fmt.Errorf("cannot generate key secret: %w", err)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	return Key{ID: now.Format("20060102") + "-" + hex.EncodeToString(id), Secret: secret, CreatedAt: now}, nil
+}
+
+// Ring is a keyring. It is immutable, so it is safe for concurrent use.
+type Ring struct {
+	// keys holds the active key first, then the retired keys, newest first.
+	keys      []Key
+	ephemeral bool
+}
+
+// ringFileData is the JSON format of keyring files.
+type ringFileData struct {
+	Active string `json:"active"`
+	Keys   []Key  `json:"keys"`
+}
+
+// NewRing returns a keyring with the active key and the retired keys.
+func NewRing(active Key, retired ...Key) (*Ring, error) {
+	keys := append([]Key{active}, retired...)
+	seen := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		if k.ID == "" {
+			return nil, errors.New("keys must have an ID")
+		}
+		if seen[k.ID] {
+			return nil, fmt.Errorf("duplicate key %q", k.ID)
+		}
+		seen[k.ID] = true
+		if len(k.Secret) != SecretSize {
+			return nil, fmt.Errorf("key %q must have a %d-byte secret; got %d bytes", k.ID, SecretSize, len(k.Secret))
+		}
+	}
+	slices.SortStableFunc(keys[1:], func(a, b Key) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	return &Ring{keys: keys}, nil
+}
+
+// Generate returns a keyring with a single random key.
+func Generate() (*Ring, error) {
+	k, err := NewKey()
+	if err != nil {
+		return nil, err
+	}
+	return NewRing(k)
+}
+
+// Parse parses a keyring in the JSON format of keyring files.
+func Parse(data []byte) (*Ring, error) {
+	var f ringFileData
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("cannot parse keyring: %w", err)
+	}
+	i := slices.IndexFunc(f.Keys, func(k Key) bool { return k.ID == f.Active })
+	if f.Active == "" || i < 0 {
+		return nil, fmt.Errorf("the active key %q is not in the keyring", f.Active)
+	}
+	retired := slices.Delete(slices.Clone(f.Keys), i, i+1)
+	return NewRing(f.Keys[i], retired...)
+}
+
+// Load reads the keyring file at path.
+func Load(path string) (*Ring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read keyring: %w", err)
+	}
+	r, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return r, nil
+}
+
+// Init returns the keyring of -keys.ring or -keys.file.
+//
+// If neither is set, it returns an ephemeral keyring with a random key.
+func Init() (*Ring, error) {
+	switch {
+	case *ringJSON != "":
+		r, err := Parse([]byte(*ringJSON))
+		if err != nil {
+			return nil, fmt.Errorf("invalid -keys.ring: %w", err)
+		}
+		return r, nil
+	case *ringFile != "":
+		return Load(*ringFile)
+	}
+	r, err := Generate()
+	if err != nil {
+		return nil, err
+	}
+	r.ephemeral = true
+	return r, nil
+}
+
+// Marshal returns r in the JSON format of keyring files.
+func (r *Ring) Marshal() ([]byte, error) {
+	return json.MarshalIndent(ringFileData{Active: r.keys[0].ID, Keys: r.keys}, "", "  ")
+}
+
+// Save writes r to the keyring file at path, readable by its owner only.
+//
+// The file is replaced atomically, so a crash cannot leave a truncated keyring behind.
+func (r *Ring) Save(path string) error {
+	data, err := r.Marshal()
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("cannot write keyring: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("cannot write keyring: %w", err)
+	}
+	return nil
+}
+
+// Rotate returns a copy of r with a new active key, keeping the current keys for verification.
+//
+// If keep is positive, only the keep newest retired keys are kept. Data protected by the dropped keys becomes invalid.
+func (r *Ring) Rotate(keep int) (*Ring, error) {
+	k, err := NewKey()
+	if err != nil {
+		return nil, err
+	}
+	retired := r.keys
+	if keep > 0 && len(retired) > keep {
+		retired = retired[:keep]
+	}
+	return NewRing(k, retired...)
+}
+
+// Active returns the key signing and encrypting new data.
+func (r *Ring) Active() Key {
+	return r.keys[0]
+}
+
+// Lookup returns the key with the given ID, which may be retired.
+func (r *Ring) Lookup(id string) (Key, bool) {
+	for _, k := range r.keys {
+		if k.ID == id {
+			return k, true
+		}
+	}
+	return Key{}, false
+}
+
+// Keys returns the keys of r, the active one first, then the retired ones, newest first.
+func (r *Ring) Keys() []Key {
+	return slices.Clone(r.keys)
+}
+
+// Ephemeral reports whether r was generated at startup, so the data it protects does not survive restarts.
+func (r *Ring) Ephemeral() bool {
+	return r.ephemeral
+}
@@ -0,0 +1,144 @@
+package keys
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testKey(id string, b byte, created time.Time) Key {
+	return Key{ID: id, Secret: bytes.Repeat([]byte{b}, SecretSize), CreatedAt: created}
+}
+
+func TestDerive(t *testing.T) {
+	k := testKey("k1", 1, time.Time{})
+	a, b := k.Derive("cursors"), k.Derive("signed-urls")
+	if len(a) != SecretSize || bytes.Equal(a, b) || bytes.Equal(a, k.Secret) {
+		t.Fatalf("derived keys must be distinct from each other and from the secret; got %x and %x", a, b)
+	}
+	if !bytes.Equal(a, k.Derive("cursors")) {
+		t.Fatalf("derived keys must be deterministic")
+	}
+	if bytes.Equal(a, testKey("k2", 2, time.Time{}).Derive("cursors")) {
+		t.Fatalf("keys derived from different secrets must differ")
+	}
+}
+
+func TestParse(t *testing.T) {
+	f := func(data string, wantActive string, wantIDs ...string) {
+		t.Helper()
+		r, err := Parse([]byte(data))
+		if err != nil {
+			t.Fatalf("Parse(%s): %v", data, err)
+		}
+		if r.Active().ID != wantActive {
+			t.Fatalf("active key %q; want %q", r.Active().ID, wantActive)
+		}
+		var ids []string
+		for _, k := range r.Keys() {
+			ids = append(ids, k.ID)
+		}
+		if strings.Join(ids, ",") != strings.Join(wantIDs, ",") {
+			t.Fatalf("keys %q; want %q", ids, wantIDs)
+		}
+	}
+	secret := `"AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE="`
+
+	f(`{"active":"a","keys":[{"id":"a","secret":`+secret+`}]}`, "a", "a")
+	// Retired keys are sorted newest first, whatever their order in the file.
+	f(`{"active":"b","keys":[
+		{"id":"a","secret":`+secret+`,"created_at":"2026-01-01T00:00:00Z"},
+		{"id":"c","secret":`+secret+`,"created_at":"2026-03-01T00:00:00Z"},
+		{"id":"b","secret":`+secret+`,"created_at":"2026-02-01T00:00:00Z"}
+	]}`, "b", "b", "c", "a")
+}
+
+func TestParseInvalid(t *testing.T) {
+	f := func(data string) {
+		t.Helper()
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Fatalf("expected error for keyring %s", data)
+		}
+	}
+	secret := `"AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE="`
+
+	f(``)
+	f(`{"keys":[]}`)
+	f(`{"active":"a","keys":[]}`)
+	f(`{"active":"b","keys":[{"id":"a","secret":` + secret + `}]}`)
+	f(`{"active":"a","keys":[{"id":"a","secret":"AQEB"}]}`)
+	f(`{"active":"a","keys":[{"id":"a","secret":"not base64!"}]}`)
+	f(`{"active":"a","keys":[{"id":"a","secret":` + secret + `},{"id":"a","secret":` + secret + `}]}`)
+	f(`{"active":"a","keys":[{"id":"a","secret":` + secret + `},{"id":"","secret":` + secret + `}]}`)
+}
+
+func TestRotate(t *testing.T) {
+	r, err := Generate()
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	first := r.Active()
+	if len(first.Secret) != SecretSize || first.ID == "" || first.CreatedAt.IsZero() {
+		t.Fatalf("unexpected generated key %+v", first)
+	}
+
+	rotated, err := r.Rotate(0)
+	if err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	if rotated.Active().ID == first.ID || len(rotated.Keys()) != 2 {
+		t.Fatalf("unexpected rotated keyring %+v", rotated.Keys())
+	}
+	if k, ok := rotated.Lookup(first.ID); !ok || !bytes.Equal(k.Secret, first.Secret) {
+		t.Fatalf("the rotated keyring must keep the previous key for verification")
+	}
+	if r.Active().ID != first.ID || len(r.Keys()) != 1 {
+		t.Fatalf("Rotate must not change the original keyring")
+	}
+
+	// keep drops the oldest retired keys.
+	again, err := rotated.Rotate(1)
+	if err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	keys := again.Keys()
+	if len(keys) != 2 || keys[1].ID != rotated.Active().ID {
+		t.Fatalf("unexpected keys after rotation keeping 1 key: %+v", keys)
+	}
+	if _, ok := again.Lookup(first.ID); ok {
+		t.Fatalf("the oldest key must be dropped")
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	r, err := Generate()
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	r, err = r.Rotate(0)
+	if err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := r.Save(path); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	want, got := r.Keys(), loaded.Keys()
+	if len(got) != len(want) {
+		t.Fatalf("loaded %d keys; want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].ID != want[i].ID || !bytes.Equal(got[i].Secret, want[i].Secret) || !got[i].CreatedAt.Equal(want[i].CreatedAt) {
+			t.Fatalf("loaded key %+v; want %+v", got[i], want[i])
+		}
+	}
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Fatalf("expected error for a missing keyring file")
+	}
+}
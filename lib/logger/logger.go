@@ -1,6 +1,7 @@
 package logger
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io"
@@ -67,6 +68,64 @@ func PanicSkipframes(skipframes int, msg string, kv ...any) {
 	printLogSkipframes(skipframes, "PANIC", msg, kv...)
 }
 
+type fieldsKey struct{}
+
+// With returns a copy of ctx carrying the key=value pairs kv.
+//
+// The *Ctx functions append the pairs carried by their ctx to every line they log,
+// so fields such as the request ID are set once by the middleware serving the request.
+func With(ctx context.Context, kv ...any) context.Context {
+	kv = kv[:len(kv)-len(kv)%2]
+	if len(kv) == 0 {
+		return ctx
+	}
+	fields := Fields(ctx)
+	fields = append(fields[:len(fields):len(fields)], kv...)
+	return context.WithValue(ctx, fieldsKey{}, fields)
+}
+
+// Fields returns the key=value pairs carried by ctx.
+func Fields(ctx context.Context) []any {
+	if ctx == nil {
+		return nil
+	}
+	fields, _ := ctx.Value(fieldsKey{}).([]any)
+	return fields
+}
+
+func InfoCtx(ctx context.Context, msg string, kv ...any) {
+	printLogSkipframes(0, "INFO", msg, withFields(ctx, kv)...)
+}
+
+func WarnCtx(ctx context.Context, msg string, kv ...any) {
+	printLogSkipframes(0, "WARN", msg, withFields(ctx, kv)...)
+}
+
+func ErrorCtx(ctx context.Context, msg string, kv ...any) {
+	printLogSkipframes(0, "ERROR", msg, withFields(ctx, kv)...)
+}
+
+// WarnCtxSkipframes is like WarnSkipframes, but adds the fields carried by ctx.
+func WarnCtxSkipframes(ctx context.Context, skipframes int, msg string, kv ...any) {
+	printLogSkipframes(skipframes, "WARN", msg, withFields(ctx, kv)...)
+}
+
+// ErrorCtxSkipframes is like ErrorSkipframes, but adds the fields carried by ctx.
+func ErrorCtxSkipframes(ctx context.Context, skipframes int, msg string, kv ...any) {
+	printLogSkipframes(skipframes, "ERROR", msg, withFields(ctx, kv)...)
+}
+
+// withFields appends the fields carried by ctx after kv.
+func withFields(ctx context.Context, kv []any) []any {
+	fields := Fields(ctx)
+	if len(fields) == 0 {
+		return kv
+	}
+	// Drop the trailing odd key, so it does not pair with the first field.
+	kv = kv[:len(kv)-len(kv)%2]
+	return append(kv[:len(kv):len(kv)], fields...)
+}
+
 var stdErrorLogger = log.New(&logWriter{}, "", 0)
 
 func StdErrorLogger() *log.Logger { return stdErrorLogger }
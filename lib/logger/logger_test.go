@@ -2,6 +2,7 @@ package logger
 
 import (
 	"bytes"
+	"context"
 	"strings"
 	"testing"
 )
@@ -305,3 +306,41 @@ func TestInit(t *testing.T) {
 		t.Fatal("expected WARN to be logged at WARN level")
 	}
 }
+
+func TestContextFields(t *testing.T) {
+	f := func(ctx context.Context, kv []any, expected string) {
+		t.Helper()
+
+		var buf bytes.Buffer
+		SetOutput(&buf)
+		defer ResetOutput()
+
+		InfoCtx(ctx, "test", kv...)
+
+		output := strings.TrimSpace(buf.String())
+		if !strings.HasSuffix(output, " test"+expected) {
+			t.Fatalf("expected output ending with %q; got %q", "test"+expected, output)
+		}
+	}
+
+	ctx := context.Background()
+	f(ctx, nil, "")
+	f(ctx, []any{"k", "v"}, " k=v")
+
+	ctx = With(ctx, "rid", "r1")
+	f(ctx, nil, " rid=r1")
+	f(ctx, []any{"k", "v"}, " k=v rid=r1")
+
+	t.Run("fields are appended in order", func(t *testing.T) {
+		child := With(ctx, "route", "/api/items")
+		f(child, []any{"k", "v"}, " k=v rid=r1 route=/api/items")
+
+		// The parent context is not modified.
+		f(ctx, nil, " rid=r1")
+	})
+
+	t.Run("trailing keys do not shift fields", func(t *testing.T) {
+		f(With(ctx, "user_id"), []any{"k"}, " rid=r1")
+		f(With(ctx, "a", "b", "c"), nil, " rid=r1 a=b")
+	})
+}
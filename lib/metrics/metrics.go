@@ -0,0 +1,193 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Counter is a monotonically increasing counter.
+type Counter struct {
+	n atomic.Uint64
+}
+
+// Inc increments c.
+func (c *Counter) Inc() { c.n.Add(1) }
+
+// Add adds n to c.
+func (c *Counter) Add(n int) { c.n.Add(uint64(n)) }
+
+// Get returns the current value of c.
+func (c *Counter) Get() uint64 { return c.n.Load() }
+
+func (c *Counter) marshalTo(name string, w io.Writer) {
+	fmt.Fprintf(w, "%s %d\n", name, c.Get())
+}
+
+// Gauge is a value that can go up and down.
+//
+// If the gauge is created with a callback, the callback is called
+// every time the value is exported.
+type Gauge struct {
+	bits atomic.Uint64
+	f    func() float64
+}
+
+// Set sets g to v. It panics if g has a callback.
+func (g *Gauge) Set(v float64) {
+	if g.f != nil {
+		panic(fmt.Errorf("BUG: cannot call Set on gauge created with callback"))
+	}
+	g.bits.Store(math.Float64bits(v))
+}
+
+// Add adds delta to g. It panics if g has a callback.
+func (g *Gauge) Add(delta float64) {
+	if g.f != nil {
+		panic(fmt.Errorf("BUG: cannot call Add on gauge created with callback"))
+	}
+	for {
+		old := g.bits.Load()
+		n := math.Float64bits(math.Float64frombits(old) + delta)
+		if g.bits.CompareAndSwap(old, n) {
+			return
+		}
+	}
+}
+
+// Inc increments g by one.
+func (g *Gauge) Inc() { g.Add(1) }
+
+// Dec decrements g by one.
+func (g *Gauge) Dec() { g.Add(-1) }
+
+// Get returns the current value of g.
+func (g *Gauge) Get() float64 {
+	if g.f != nil {
+		return g.f()
+	}
+	return math.Float64frombits(g.bits.Load())
+}
+
+func (g *Gauge) marshalTo(name string, w io.Writer) {
+	fmt.Fprintf(w, "%s %s\n", name, strconv.FormatFloat(g.Get(), 'g', -1, 64))
+}
+
+type metric interface {
+	marshalTo(name string, w io.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = map[string]metric{}
+)
+
+// NewCounter registers and returns a new counter with the given name.
+//
+// name may contain labels in Prometheus format, e.g. `foo_total{bar="baz"}`.
+// It panics if a metric with the same name is already registered.
+func NewCounter(name string) *Counter {
+	c := &Counter{}
+	mustRegister(name, c)
+	return c
+}
+
+// GetOrCreateCounter returns the counter with the given name, registering it if needed.
+//
+// It is slower than NewCounter, so prefer NewCounter for metrics known at init time.
+func GetOrCreateCounter(name string) *Counter {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if m, ok := registry[name]; ok {
+		c, ok := m.(*Counter)
+		if !ok {
+			panic(fmt.Errorf("BUG: metric %q is not a counter", name))
+		}
+		return c
+	}
+	mustValidateName(name)
+	c := &Counter{}
+	registry[name] = c
+	return c
+}
+
+// NewGauge registers and returns a new gauge with the given name.
+//
+// If f is non-nil, it is called for obtaining the gauge value on export.
+// It panics if a metric with the same name is already registered.
+func NewGauge(name string, f func() float64) *Gauge {
+	g := &Gauge{f: f}
+	mustRegister(name, g)
+	return g
+}
+
+// GetOrCreateGauge returns the gauge with the given name, registering it if needed.
+func GetOrCreateGauge(name string) *Gauge {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if m, ok := registry[name]; ok {
+		g, ok := m.(*Gauge)
+		if !ok {
+			panic(fmt.Errorf("BUG: metric %q is not a gauge", name))
+		}
+		return g
+	}
+	mustValidateName(name)
+	g := &Gauge{}
+	registry[name] = g
+	return g
+}
+
+func mustRegister(name string, m metric) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, ok := registry[name]; ok {
+		panic(fmt.Errorf("BUG: metric %q is already registered", name))
+	}
+	mustValidateName(name)
+	registry[name] = m
+}
+
+func mustValidateName(name string) {
+	base := name
+	if n := strings.IndexByte(name, '{'); n >= 0 {
+		if !strings.HasSuffix(name, "}") {
+			panic(fmt.Errorf("BUG: missing closing brace in metric name %q", name))
+		}
+		base = name[:n]
+	}
+	if base == "" {
+		panic(fmt.Errorf("BUG: empty metric name in %q", name))
+	}
+	for i, r := range base {
+		ok := r == '_' || r == ':' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (i > 0 && r >= '0' && r <= '9')
+		if !ok {
+			panic(fmt.Errorf("BUG: invalid char %q in metric name %q", r, name))
+		}
+	}
+}
+
+// WritePrometheus writes all the registered metrics to w in Prometheus text exposition format.
+func WritePrometheus(w io.Writer) {
+	registryMu.Lock()
+	names := make([]string, 0, len(registry))
+	ms := make(map[string]metric, len(registry))
+	for name, m := range registry {
+		names = append(names, name)
+		ms[name] = m
+	}
+	registryMu.Unlock()
+
+	sort.Strings(names)
+	for _, name := range names {
+		ms[name].marshalTo(name, w)
+	}
+}
@@ -0,0 +1,81 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCounter(t *testing.T) {
+	c := NewCounter(`test_counter_total{kind="a"}`)
+	c.Inc()
+	c.Add(2)
+	if n := c.Get(); n != 3 {
+		t.Fatalf("unexpected counter value; got %d; want %d", n, 3)
+	}
+
+	if c2 := GetOrCreateCounter(`test_counter_total{kind="a"}`); c2 != c {
+		t.Fatalf("GetOrCreateCounter must return the registered counter")
+	}
+}
+
+func TestGauge(t *testing.T) {
+	g := GetOrCreateGauge("test_gauge")
+	g.Inc()
+	g.Inc()
+	g.Dec()
+	g.Add(1.5)
+	if v := g.Get(); v != 2.5 {
+		t.Fatalf("unexpected gauge value; got %v; want %v", v, 2.5)
+	}
+
+	gf := NewGauge("test_gauge_func", func() float64 { return 42 })
+	if v := gf.Get(); v != 42 {
+		t.Fatalf("unexpected gauge value; got %v; want %v", v, 42)
+	}
+}
+
+func TestWritePrometheus(t *testing.T) {
+	NewCounter(`test_write_total{proto="h2"}`).Add(7)
+	NewGauge("test_write_gauge", func() float64 { return 0.5 })
+
+	var buf bytes.Buffer
+	WritePrometheus(&buf)
+	out := buf.String()
+
+	f := func(line string) {
+		t.Helper()
+		if !strings.Contains(out, line+"\n") {
+			t.Fatalf("expected %q in output; got %q", line, out)
+		}
+	}
+	f(`test_write_total{proto="h2"} 7`)
+	f(`test_write_gauge 0.5`)
+}
+
+func TestInvalidName(t *testing.T) {
+	f := func(name string) {
+		t.Helper()
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatalf("expected panic for name %q", name)
+			}
+		}()
+		NewCounter(name)
+	}
+
+	t.Run("empty", func(t *testing.T) { f("") })
+	t.Run("bad char", func(t *testing.T) { f("foo-bar") })
+	t.Run("unclosed labels", func(t *testing.T) { f(`foo{bar="baz"`) })
+	t.Run("leading digit", func(t *testing.T) { f("1foo") })
+}
+
+func TestDuplicateName(t *testing.T) {
+	NewCounter("test_duplicate_total")
+	defer func() {
+		if r := recover(); r == nil {
+			t.Fatal("expected panic on duplicate registration")
+		}
+	}()
+	NewCounter("test_duplicate_total")
+}
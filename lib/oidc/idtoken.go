@@ -0,0 +1,291 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Signing algorithms of ID tokens.
+const (
+	RS256 = "RS256"
+	ES256 = "ES256"
+)
+
+// allowedSkew is the clock difference tolerated with the provider when checking the token expiry.
+const allowedSkew = time.Minute
+
+// minKeysRefreshInterval limits how often tokens signed with unknown keys make the provider keys refetched.
+const minKeysRefreshInterval = time.Minute
+
+// Claims are the verified claims of an ID token.
+type Claims struct {
+	Issuer          string   `json:"iss"`
+	Subject         string   `json:"sub"`
+	Audience        audience `json:"aud"`
+	AuthorizedParty string   `json:"azp"`
+	Expiry          int64    `json:"exp"`
+	IssuedAt        int64    `json:"iat"`
+	Nonce           string   `json:"nonce"`
+
+	raw map[string]any
+}
+
+// String returns the string claim name, e.g. email or preferred_username, or an empty string.
+func (c *Claims) String(name string) string {
+	s, _ := c.raw[name].(string)
+	return s
+}
+
+// audience is the aud claim, which is either a string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	var ss []string
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return errors.New("aud must be a string or an array of strings")
+	}
+	*a = ss
+	return nil
+}
+
+// VerifyIDToken verifies the signature and the claims of the ID token raw, and returns its claims.
+//
+// nonce is the nonce passed to AuthCodeURL. Only RS256 and ES256 signatures are accepted.
+func (p *Provider) VerifyIDToken(ctx context.Context, raw, nonce string) (*Claims, error) {
+	md, err := p.Metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed ID token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed ID token header: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed ID token signature")
+	}
+	key, err := p.key(ctx, header.Kid, header.Alg)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	var c Claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return nil, fmt.Errorf("malformed ID token claims: %w", err)
+	}
+	if err := decodeSegment(parts[1], &c.raw); err != nil {
+		return nil, fmt.Errorf("malformed ID token claims: %w", err)
+	}
+	if err := c.check(md.Issuer, p.cfg.ClientID, nonce, time.Now()); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (c *Claims) check(issuer, clientID, nonce string, now time.Time) error {
+	if c.Issuer != issuer {
+		return fmt.Errorf("ID token has issuer %q; want %q", c.Issuer, issuer)
+	}
+	if !slices.Contains(c.Audience, clientID) {
+		return fmt.Errorf("ID token is not issued for client %q", clientID)
+	}
+	if len(c.Audience) > 1 && c.AuthorizedParty != clientID {
+		return fmt.Errorf("ID token has authorized party %q; want %q", c.AuthorizedParty, clientID)
+	}
+	if c.Subject == "" {
+		return errors.New("ID token lacks a subject")
+	}
+	if c.Expiry == 0 || !now.Before(time.Unix(c.Expiry, 0).Add(allowedSkew)) {
+		return errors.New("ID token expired")
+	}
+	if c.IssuedAt == 0 || time.Unix(c.IssuedAt, 0).After(now.Add(allowedSkew)) {
+		return errors.New("ID token is issued in the future")
+	}
+	if c.Nonce != nonce {
+		return errors.New("ID token nonce does not match")
+	}
+	return nil
+}
+
+func decodeSegment(s string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func verifySignature(alg string, key any, signed, sig []byte) error {
+	digest := sha256.Sum256(signed)
+	switch alg {
+	case RS256:
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("ID token signed with RS256 by a non-RSA key")
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig); err != nil {
+			return errors.New("invalid ID token signature")
+		}
+	case ES256:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve != elliptic.P256() {
+			return errors.New("ID token signed with ES256 by a non-P-256 key")
+		}
+		// JWS encodes ECDSA signatures as the concatenation of r and s, not in ASN.1.
+		if len(sig) != 64 {
+			return errors.New("invalid ID token signature")
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return errors.New("invalid ID token signature")
+		}
+	default:
+		return fmt.Errorf("unsupported ID token signing algorithm %q", alg)
+	}
+	return nil
+}
+
+// key returns the provider key with the given ID, refetching the provider keys if it is unknown,
+// e.g. after a key rotation.
+func (p *Provider) key(ctx context.Context, kid, alg string) (any, error) {
+	if alg != RS256 && alg != ES256 {
+		return nil, fmt.Errorf("unsupported ID token signing algorithm %q", alg)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if k, ok := findKey(p.keys, kid, alg); ok {
+		return k, nil
+	}
+	if time.Since(p.keysFetched) < minKeysRefreshInterval {
+		return nil, fmt.Errorf("unknown ID token signing key %q", kid)
+	}
+	keys, err := p.fetchKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.keys = keys
+	p.keysFetched = time.Now()
+	if k, ok := findKey(p.keys, kid, alg); ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("unknown ID token signing key %q", kid)
+}
+
+// findKey returns the key with the given ID, or the only key usable with alg if the token names no key.
+func findKey(keys map[string]any, kid, alg string) (any, bool) {
+	if kid != "" {
+		k, ok := keys[kid]
+		return k, ok
+	}
+	var found any
+	for _, k := range keys {
+		_, isRSA := k.(*rsa.PublicKey)
+		if isRSA != (alg == RS256) {
+			continue
+		}
+		if found != nil {
+			return nil, false
+		}
+		found = k
+	}
+	return found, found != nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// minRSAKeyBits is the minimum size of RSA keys, as required by RFC 7518 for RS256.
+const minRSAKeyBits = 2048
+
+// fetchKeys fetches the provider signing keys from its JWKS endpoint. It is called with p.mu held.
+func (p *Provider) fetchKeys(ctx context.Context) (map[string]any, error) {
+	if p.md == nil {
+		return nil, errors.New("BUG: provider keys fetched before its metadata")
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := p.getJSON(ctx, p.md.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("cannot fetch provider keys: %w", err)
+	}
+	keys := map[string]any{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		// Keys of other types or curves are skipped, since tokens signed with them are rejected anyway.
+		pub, err := parseJWK(k)
+		if err != nil || pub == nil {
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+	return keys, nil
+}
+
+func parseJWK(k jwk) (any, error) {
+	switch {
+	case k.Kty == "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) > 4 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if pub.N.BitLen() < minRSAKeyBits {
+			return nil, errors.New("RSA key is too small")
+		}
+		return pub, nil
+	case k.Kty == "EC" && k.Crv == "P-256":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != 32 {
+			return nil, errors.New("invalid EC key")
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil || len(y) != 32 {
+			return nil, errors.New("invalid EC key")
+		}
+		return ecdsa.ParseUncompressedPublicKey(elliptic.P256(), append(append([]byte{4}, x...), y...))
+	}
+	return nil, nil
+}
@@ -0,0 +1,207 @@
+// Package oidc implements the relying party of the OpenID Connect authorization code flow with PKCE.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AltSoyuz/adequate/lib/tracing"
+)
+
+// Config configures a Provider.
+type Config struct {
+	// Issuer is the issuer URL of the identity provider, e.g. https://accounts.example.com.
+	// It must match the issuer of the provider metadata verbatim.
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the callback URL of the app, as registered at the identity provider.
+	RedirectURL string
+	// Scopes are requested in addition to openid.
+	Scopes []string
+	// Client sends the requests to the identity provider. A client with a 10s timeout is used if nil.
+	Client *http.Client
+}
+
+// Metadata is the part of the provider metadata used by the relying party.
+type Metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider is an OpenID Connect identity provider, as seen by the app.
+//
+// The provider metadata and signing keys are fetched on first use, so the app starts even if the provider is down.
+type Provider struct {
+	cfg    Config
+	client *http.Client
+
+	mu sync.Mutex
+	md *Metadata
+	// keys are the signing keys of the provider, by key ID.
+	keys        map[string]any
+	keysFetched time.Time
+}
+
+// NewProvider returns a provider for cfg.
+func NewProvider(cfg Config) *Provider {
+	client := cfg.Client
+	if client == nil {
+		client = &http.Client{Transport: &tracing.Transport{}, Timeout: 10 * time.Second}
+	}
+	return &Provider{cfg: cfg, client: client}
+}
+
+// Metadata returns the provider metadata, discovering it on first use.
+func (p *Provider) Metadata(ctx context.Context) (*Metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.md != nil {
+		return p.md, nil
+	}
+
+	var md Metadata
+	if err := p.getJSON(ctx, strings.TrimSuffix(p.cfg.Issuer, "/")+"/.well-known/openid-configuration", &md); err != nil {
+		return nil, fmt.Errorf("cannot discover provider %s: %w", p.cfg.Issuer, err)
+	}
+	// The issuer must match the configured one exactly, trailing slash included, so a provider cannot impersonate
+	// another one; see OpenID Connect Discovery 1.0, section 4.3.
+	if md.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("provider metadata has issuer %q; want %q", md.Issuer, p.cfg.Issuer)
+	}
+	if md.AuthorizationEndpoint == "" || md.TokenEndpoint == "" || md.JWKSURI == "" {
+		return nil, fmt.Errorf("provider metadata of %s lacks an endpoint", p.cfg.Issuer)
+	}
+	p.md = &md
+	return p.md, nil
+}
+
+// AuthCodeURL returns the URL of the provider authorization endpoint, where the user is redirected to log in.
+//
+// state, nonce and verifier must be random values kept until the callback; see NewRandom.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	md, err := p.Metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(append([]string{"openid"}, p.cfg.Scopes...), " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {Challenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(md.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return md.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Tokens are the tokens returned by the provider token endpoint.
+type Tokens struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// Exchange exchanges the authorization code of the callback for tokens.
+//
+// verifier is the PKCE code verifier passed to AuthCodeURL.
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (*Tokens, error) {
+	md, err := p.Metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, md.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	// client_secret_basic, the default client authentication method, requires form-encoding the credentials.
+	req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot exchange code: %w", err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read token response: %w", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		var e struct {
+			Error       string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		_ = json.Unmarshal(body, &e)
+		return nil, fmt.Errorf("token endpoint returned status %d: %s %s", res.StatusCode, e.Error, e.Description)
+	}
+	var t Tokens
+	if err := json.Unmarshal(body, &t); err != nil {
+		return nil, fmt.Errorf("cannot parse token response: %w", err)
+	}
+	if t.IDToken == "" {
+		return nil, errors.New("token response lacks an id_token")
+	}
+	return &t, nil
+}
+
+const maxResponseSize = 1 << 20
+
+func (p *Provider) getJSON(ctx context.Context, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned status %d", u, res.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(res.Body, maxResponseSize)).Decode(v)
+}
+
+// NewRandom returns a random value suitable for the state, the nonce and the PKCE code verifier.
+func NewRandom() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("cannot generate random value: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Challenge returns the S256 PKCE code challenge of verifier.
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
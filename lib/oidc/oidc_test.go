@@ -0,0 +1,263 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChallenge(t *testing.T) {
+	// The example of RFC 7636, appendix B.
+	got := Challenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"; got != want {
+		t.Fatalf("Challenge() = %q; want %q", got, want)
+	}
+}
+
+// testProvider is an identity provider serving discovery, keys and tokens.
+type testProvider struct {
+	srv    *httptest.Server
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+	// issuer is the issuer advertised by the discovery document.
+	issuer string
+	// idToken is returned by the token endpoint.
+	idToken string
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("cannot generate RSA key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("cannot generate EC key: %v", err)
+	}
+	tp := &testProvider{rsaKey: rsaKey, ecKey: ecKey}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 tp.issuer,
+			"authorization_endpoint": tp.srv.URL + "/authorize",
+			"token_endpoint":         tp.srv.URL + "/token",
+			"jwks_uri":               tp.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		ecPub, _ := ecKey.PublicKey.Bytes()
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ecPub[1:33]), "y": b64(ecPub[33:])},
+			{"kty": "oct", "kid": "hmac", "k": b64([]byte("secret"))},
+		}})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != "app" || secret != "s%3Ecret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"invalid_client"}`))
+			return
+		}
+		if r.PostFormValue("code") != "code-1" || r.PostFormValue("code_verifier") != "verifier" ||
+			r.PostFormValue("grant_type") != "authorization_code" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"access_token": "at", "token_type": "Bearer", "id_token": tp.idToken})
+	})
+	tp.srv = httptest.NewServer(mux)
+	t.Cleanup(tp.srv.Close)
+	tp.issuer = tp.srv.URL
+	return tp
+}
+
+func (tp *testProvider) provider() *Provider {
+	return NewProvider(Config{
+		Issuer:       tp.srv.URL,
+		ClientID:     "app",
+		ClientSecret: "s>cret",
+		RedirectURL:  "https://app.example.com/callback",
+	})
+}
+
+func (tp *testProvider) sign(t *testing.T, header, claims map[string]any) string {
+	t.Helper()
+	hb, _ := json.Marshal(header)
+	cb, _ := json.Marshal(claims)
+	signed := b64(hb) + "." + b64(cb)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch header["alg"] {
+	case RS256:
+		var err error
+		sig, err = rsa.SignPKCS1v15(rand.Reader, tp.rsaKey, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatalf("cannot sign token: %v", err)
+		}
+	case ES256:
+		r, s, err := ecdsa.Sign(rand.Reader, tp.ecKey, digest[:])
+		if err != nil {
+			t.Fatalf("cannot sign token: %v", err)
+		}
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + b64(sig)
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	tp := newTestProvider(t)
+	p := tp.provider()
+	now := time.Now().Unix()
+
+	validClaims := func() map[string]any {
+		return map[string]any{
+			"iss":                tp.srv.URL,
+			"sub":                "user-1",
+			"aud":                "app",
+			"exp":                now + 300,
+			"iat":                now,
+			"nonce":              "n-1",
+			"preferred_username": "alice",
+		}
+	}
+	f := func(header, claims map[string]any, wantErr string) {
+		t.Helper()
+		raw := tp.sign(t, header, claims)
+		c, err := p.VerifyIDToken(context.Background(), raw, "n-1")
+		if wantErr == "" {
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if c.Subject != "user-1" || c.String("preferred_username") != "alice" {
+				t.Fatalf("unexpected claims %+v", c)
+			}
+			return
+		}
+		if err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Fatalf("error = %v; want it to contain %q", err, wantErr)
+		}
+	}
+	rs := map[string]any{"alg": RS256, "kid": "rsa"}
+	es := map[string]any{"alg": ES256, "kid": "ec"}
+
+	f(rs, validClaims(), "")
+	f(es, validClaims(), "")
+	// The key is found by algorithm if the token does not name it.
+	f(map[string]any{"alg": ES256}, validClaims(), "")
+
+	with := func(k string, v any) map[string]any {
+		c := validClaims()
+		if v == nil {
+			delete(c, k)
+		} else {
+			c[k] = v
+		}
+		return c
+	}
+	f(rs, with("aud", []string{"app", "other"}), "authorized party")
+	aud := with("aud", []string{"app", "other"})
+	aud["azp"] = "app"
+	f(rs, aud, "")
+	f(rs, with("iss", "https://evil.example.com"), "issuer")
+	f(rs, with("aud", "other"), "not issued for client")
+	f(rs, with("exp", now-120), "expired")
+	f(rs, with("iat", now+600), "future")
+	f(rs, with("nonce", "n-2"), "nonce")
+	f(rs, with("sub", nil), "subject")
+
+	f(map[string]any{"alg": "HS256", "kid": "hmac"}, validClaims(), "unsupported")
+	f(map[string]any{"alg": "none"}, validClaims(), "unsupported")
+	// A token signed by the EC key must not verify with the RSA key, and vice versa.
+	f(map[string]any{"alg": ES256, "kid": "rsa"}, validClaims(), "non-P-256")
+
+	t.Run("tampered claims", func(t *testing.T) {
+		raw := tp.sign(t, rs, validClaims())
+		parts := strings.Split(raw, ".")
+		cb, _ := json.Marshal(with("sub", "admin"))
+		parts[1] = b64(cb)
+		if _, err := p.VerifyIDToken(context.Background(), strings.Join(parts, "."), "n-1"); err == nil || !strings.Contains(err.Error(), "signature") {
+			t.Fatalf("error = %v; want an invalid signature", err)
+		}
+	})
+}
+
+func TestDiscoveryIssuer(t *testing.T) {
+	tp := newTestProvider(t)
+	f := func(configured, advertised string, wantErr bool) {
+		t.Helper()
+		tp.issuer = advertised
+		p := NewProvider(Config{Issuer: configured, ClientID: "app"})
+		_, err := p.Metadata(context.Background())
+		if wantErr != (err != nil) {
+			t.Fatalf("Metadata() with issuer %q advertising %q: error = %v; want error: %v", configured, advertised, err, wantErr)
+		}
+		if err != nil && !strings.Contains(err.Error(), "issuer") {
+			t.Fatalf("error = %v; want an issuer mismatch", err)
+		}
+	}
+
+	f(tp.srv.URL, tp.srv.URL, false)
+	// Issuers with a trailing slash, like those of Auth0, are compared verbatim.
+	f(tp.srv.URL+"/", tp.srv.URL+"/", false)
+	f(tp.srv.URL+"/", tp.srv.URL, true)
+	f(tp.srv.URL, tp.srv.URL+"/", true)
+	f(tp.srv.URL, "https://other.example.com", true)
+}
+
+func TestAuthCodeFlow(t *testing.T) {
+	tp := newTestProvider(t)
+	p := tp.provider()
+	ctx := context.Background()
+
+	u, err := p.AuthCodeURL(ctx, "state-1", "n-1", "verifier")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		t.Fatalf("cannot parse %q: %v", u, err)
+	}
+	q := parsed.Query()
+	if parsed.Path != "/authorize" || q.Get("client_id") != "app" || q.Get("scope") != "openid" || q.Get("state") != "state-1" ||
+		q.Get("nonce") != "n-1" || q.Get("code_challenge") != Challenge("verifier") || q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("unexpected authorization URL %q", u)
+	}
+
+	now := time.Now().Unix()
+	tp.idToken = tp.sign(t, map[string]any{"alg": RS256, "kid": "rsa"}, map[string]any{
+		"iss": tp.srv.URL, "sub": "user-1", "aud": "app", "exp": now + 60, "iat": now, "nonce": "n-1",
+	})
+	tokens, err := p.Exchange(ctx, "code-1", "verifier")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := p.VerifyIDToken(ctx, tokens.IDToken, "n-1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := p.Exchange(ctx, "code-1", "other"); err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Fatalf("error = %v; want invalid_grant", err)
+	}
+}
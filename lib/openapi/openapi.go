@@ -0,0 +1,336 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AltSoyuz/adequate/lib/httpserver"
+)
+
+// Version is the OpenAPI specification version of generated documents.
+const Version = "3.1.0"
+
+// Info is the metadata of the API.
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// Document is an OpenAPI document.
+type Document struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       Info                             `json:"info"`
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components Components                       `json:"components"`
+}
+
+// Components holds the reusable schemas of a Document.
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas"`
+}
+
+// Operation describes a single API endpoint.
+type Operation struct {
+	OperationID string `json:"operationId"`
+	Summary     string `json:"summary,omitempty"`
+	Deprecated  bool   `json:"deprecated,omitempty"`
+	// Permission is the permission required to use the endpoint, if any.
+	Permission  string               `json:"x-permission,omitempty"`
+	Parameters  []Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+// Parameter describes a path or query param.
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBody describes a JSON request body.
+type RequestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+// Response describes a response.
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+// MediaType holds the schema of a body.
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is a JSON Schema, restricted to what can be derived from Go types.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 any                `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	ContentEncoding      string             `json:"contentEncoding,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+const jsonContentType = "application/json"
+
+// Build returns the OpenAPI document describing routes.
+func Build(info Info, routes []httpserver.Route) *Document {
+	g := &generator{
+		schemas: map[string]*Schema{},
+		names:   map[reflect.Type]string{},
+		taken:   map[string]reflect.Type{},
+	}
+	doc := &Document{
+		OpenAPI: Version,
+		Info:    info,
+		Paths:   map[string]map[string]*Operation{},
+	}
+
+	errSchema := g.schemaFor(reflect.TypeFor[httpserver.ErrResponse]())
+	for _, rt := range routes {
+		op := &Operation{
+			OperationID: rt.Name,
+			Summary:     rt.Summary,
+			Deprecated:  rt.Deprecated,
+			Permission:  rt.Permission,
+			Responses:   map[string]*Response{},
+		}
+		for _, name := range rt.PathParams {
+			op.Parameters = append(op.Parameters, Parameter{
+				Name:     name,
+				In:       "path",
+				Required: true,
+				Schema:   &Schema{Type: "string"},
+			})
+		}
+		for _, p := range rt.QueryParams {
+			op.Parameters = append(op.Parameters, Parameter{
+				Name:        p.Name,
+				In:          "query",
+				Description: p.Description,
+				Schema:      &Schema{Type: "string"},
+			})
+		}
+		if rt.Request != nil {
+			op.RequestBody = &RequestBody{
+				Required: true,
+				Content:  map[string]*MediaType{jsonContentType: {Schema: g.schemaFor(rt.Request)}},
+			}
+		}
+
+		res := &Response{Description: http.StatusText(rt.Status)}
+		if rt.Response != nil {
+			res.Content = map[string]*MediaType{jsonContentType: {Schema: g.schemaFor(rt.Response)}}
+		}
+		op.Responses[strconv.Itoa(rt.Status)] = res
+		op.Responses["default"] = &Response{
+			Description: "Error",
+			Content:     map[string]*MediaType{jsonContentType: {Schema: errSchema}},
+		}
+
+		item := doc.Paths[rt.Path]
+		if item == nil {
+			item = map[string]*Operation{}
+			doc.Paths[rt.Path] = item
+		}
+		item[strings.ToLower(rt.Method)] = op
+	}
+
+	doc.Components.Schemas = g.schemas
+	return doc
+}
+
+// Handler serves the document built from the routes returned by routes as JSON.
+//
+// The document is built on the first request, so routes may be registered after Handler is called.
+func Handler(info Info, routes func() []httpserver.Route) http.HandlerFunc {
+	var (
+		once sync.Once
+		b    []byte
+	)
+	return func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() {
+			var err error
+			b, err = Marshal(Build(info, routes()))
+			if err != nil {
+				panic(err)
+			}
+		})
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		_, _ = w.Write(b)
+	}
+}
+
+// Marshal returns the indented JSON encoding of doc, suitable for committing to the repo.
+func Marshal(doc *Document) ([]byte, error) {
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+type generator struct {
+	schemas map[string]*Schema
+	names   map[reflect.Type]string
+	taken   map[string]reflect.Type
+}
+
+var timeType = reflect.TypeFor[time.Time]()
+
+// schemaFor returns the schema of t. Named struct types are stored in the components and referenced.
+func (g *generator) schemaFor(t reflect.Type) *Schema {
+	if t == timeType {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := g.schemaFor(t.Elem())
+		return nullable(s)
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", ContentEncoding: "base64"}
+		}
+		return &Schema{Type: "array", Items: g.schemaFor(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schemaFor(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		name, ok := g.names[t]
+		if !ok {
+			name = g.schemaName(t)
+			g.names[t] = name
+			g.taken[name] = t
+			// Register a placeholder first, so recursive types terminate.
+			g.schemas[name] = &Schema{}
+			*g.schemas[name] = *g.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + name}
+	default:
+		// interface{} and the like accept any JSON value.
+		return &Schema{}
+	}
+}
+
+func (g *generator) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || f.Anonymous {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" && opts == "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		fs := g.schemaFor(f.Type)
+		if hasOption(opts, "string") {
+			fs = &Schema{Type: "string"}
+		}
+		s.Properties[name] = fs
+		if !hasOption(opts, "omitempty") && !hasOption(opts, "omitzero") {
+			s.Required = append(s.Required, name)
+		}
+	}
+	sort.Strings(s.Required)
+	return s
+}
+
+// schemaName returns a unique component name for the named type t.
+//
+// Generic instantiations are named after their type arguments, e.g. Page[pkg.Item] gives PageItem.
+func (g *generator) schemaName(t reflect.Type) string {
+	name := TypeName(t)
+	if prev, ok := g.taken[name]; ok && prev != t {
+		pkg := t.PkgPath()
+		if n := strings.LastIndexByte(pkg, '/'); n >= 0 {
+			pkg = pkg[n+1:]
+		}
+		name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+	}
+	return name
+}
+
+// TypeName returns the name of the named type t without package paths and generic brackets,
+// so it can be used in generated API contracts and clients.
+func TypeName(t reflect.Type) string {
+	name := t.Name()
+	base, args, ok := strings.Cut(name, "[")
+	if !ok {
+		return stripLocalSuffix(name)
+	}
+	var b strings.Builder
+	b.WriteString(base)
+	for _, arg := range strings.Split(strings.TrimSuffix(args, "]"), ",") {
+		if n := strings.LastIndexByte(arg, '.'); n >= 0 {
+			arg = arg[n+1:]
+		}
+		arg = stripLocalSuffix(arg)
+		if arg != "" {
+			b.WriteString(strings.ToUpper(arg[:1]) + arg[1:])
+		}
+	}
+	return b.String()
+}
+
+// stripLocalSuffix strips the ·N suffix the compiler adds to types declared in functions.
+func stripLocalSuffix(name string) string {
+	if n := strings.Index(name, "·"); n >= 0 {
+		return name[:n]
+	}
+	return name
+}
+
+func nullable(s *Schema) *Schema {
+	if s.Ref != "" || s.Type == nil {
+		return s
+	}
+	if typ, ok := s.Type.(string); ok {
+		ns := *s
+		ns.Type = []string{typ, "null"}
+		return &ns
+	}
+	return s
+}
+
+func hasOption(opts, name string) bool {
+	for _, o := range strings.Split(opts, ",") {
+		if o == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/AltSoyuz/adequate/lib/httpserver"
+)
+
+type testItem struct {
+	ID        int64             `json:"id"`
+	Name      string            `json:"name"`
+	Note      *string           `json:"note,omitempty"`
+	Tags      []string          `json:"tags"`
+	Attrs     map[string]int    `json:"attrs,omitempty"`
+	Data      []byte            `json:"data"`
+	CreatedAt time.Time         `json:"created_at"`
+	Parent    *testItem         `json:"parent,omitempty"`
+	Count     int64             `json:"count,string"`
+	Skipped   string            `json:"-"`
+	Extra     map[string]string `json:"extra"`
+}
+
+type testReq struct {
+	Name string `json:"name"`
+}
+
+func TestBuild(t *testing.T) {
+	rt := httpserver.NewRouter()
+	rt.Handle(http.MethodGet, "/api/items/{id}", http.NotFoundHandler(), httpserver.Response[testItem](http.StatusOK))
+	rt.Handle(http.MethodPost, "/api/items", http.NotFoundHandler(),
+		httpserver.Name("createItem"),
+		httpserver.Request[testReq](),
+		httpserver.Response[httpserver.Page[testItem]](http.StatusCreated),
+		httpserver.Query("dry_run", "Validate only"),
+	)
+
+	doc := Build(Info{Title: "Test", Version: "1"}, rt.Routes())
+
+	get := doc.Paths["/api/items/{id}"]["get"]
+	if get == nil {
+		t.Fatalf("missing GET /api/items/{id}")
+	}
+	if get.OperationID != "getItemsId" {
+		t.Fatalf("operationId = %q; want %q", get.OperationID, "getItemsId")
+	}
+	if len(get.Parameters) != 1 || get.Parameters[0].In != "path" || !get.Parameters[0].Required {
+		t.Fatalf("unexpected parameters %+v", get.Parameters)
+	}
+	if ref := get.Responses["200"].Content[jsonContentType].Schema.Ref; ref != "#/components/schemas/testItem" {
+		t.Fatalf("response ref = %q", ref)
+	}
+	if ref := get.Responses["default"].Content[jsonContentType].Schema.Ref; ref != "#/components/schemas/ErrResponse" {
+		t.Fatalf("error response ref = %q", ref)
+	}
+
+	post := doc.Paths["/api/items"]["post"]
+	if post.RequestBody == nil || post.RequestBody.Content[jsonContentType].Schema.Ref != "#/components/schemas/testReq" {
+		t.Fatalf("unexpected request body %+v", post.RequestBody)
+	}
+	if ref := post.Responses["201"].Content[jsonContentType].Schema.Ref; ref != "#/components/schemas/PageTestItem" {
+		t.Fatalf("page response ref = %q", ref)
+	}
+	if len(post.Parameters) != 1 || post.Parameters[0].In != "query" {
+		t.Fatalf("unexpected parameters %+v", post.Parameters)
+	}
+
+	item := doc.Components.Schemas["testItem"]
+	if item == nil {
+		t.Fatalf("missing testItem schema in %v", doc.Components.Schemas)
+	}
+	wantRequired := []string{"count", "created_at", "data", "extra", "id", "name", "tags"}
+	if !reflect.DeepEqual(item.Required, wantRequired) {
+		t.Fatalf("required = %q; want %q", item.Required, wantRequired)
+	}
+
+	f := func(prop string, want *Schema) {
+		t.Helper()
+		got := item.Properties[prop]
+		if !reflect.DeepEqual(got, want) {
+			gb, _ := json.Marshal(got)
+			wb, _ := json.Marshal(want)
+			t.Fatalf("schema of %q = %s; want %s", prop, gb, wb)
+		}
+	}
+	f("id", &Schema{Type: "integer", Format: "int64"})
+	f("note", &Schema{Type: []string{"string", "null"}})
+	f("tags", &Schema{Type: "array", Items: &Schema{Type: "string"}})
+	f("attrs", &Schema{Type: "object", AdditionalProperties: &Schema{Type: "integer", Format: "int64"}})
+	f("data", &Schema{Type: "string", ContentEncoding: "base64"})
+	f("created_at", &Schema{Type: "string", Format: "date-time"})
+	f("parent", &Schema{Ref: "#/components/schemas/testItem"})
+	f("count", &Schema{Type: "string"})
+	if _, ok := item.Properties["Skipped"]; ok {
+		t.Fatalf("fields tagged with json:\"-\" must be skipped")
+	}
+}
+
+func TestBuildDeprecated(t *testing.T) {
+	rt := httpserver.NewRouter()
+	rt.Version(httpserver.APIVersion{Name: "v1", Deprecated: time.Unix(1700000000, 0)}).
+		Handle(http.MethodGet, "/items", http.NotFoundHandler(), httpserver.Name("listItemsV1"))
+	rt.Version(httpserver.APIVersion{Name: "v2"}).
+		Handle(http.MethodGet, "/items", http.NotFoundHandler(), httpserver.Name("listItems"))
+
+	doc := Build(Info{Title: "Test", Version: "1"}, rt.Routes())
+	if op := doc.Paths["/api/v1/items"]["get"]; op == nil || !op.Deprecated {
+		t.Fatalf("GET /api/v1/items must be deprecated: %+v", op)
+	}
+	if op := doc.Paths["/api/v2/items"]["get"]; op == nil || op.Deprecated {
+		t.Fatalf("GET /api/v2/items must not be deprecated: %+v", op)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	rt := httpserver.NewRouter()
+	h := Handler(Info{Title: "Test", Version: "1"}, rt.Routes)
+	// Routes registered after the handler is created must be documented.
+	rt.Handle(http.MethodGet, "/api/things", http.NotFoundHandler())
+
+	req := httptest.NewRequest(http.MethodGet, "/api/openapi.json", nil)
+	w := httptest.NewRecorder()
+	h(w, req)
+
+	if ct := w.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+		t.Fatalf("content-type = %q", ct)
+	}
+	var doc Document
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("cannot decode document: %v", err)
+	}
+	if _, ok := doc.Paths["/api/things"]["get"]; !ok {
+		t.Fatalf("missing GET /api/things in %v", doc.Paths)
+	}
+}
@@ -0,0 +1,96 @@
+package password
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Iterations is the PBKDF2-HMAC-SHA256 iteration count of new hashes, as recommended by OWASP.
+//
+// Hashes with fewer iterations still verify, and NeedsRehash reports them, so they can be upgraded on login.
+const Iterations = 600_000
+
+const (
+	scheme  = "pbkdf2-sha256"
+	saltLen = 16
+	keyLen  = 32
+)
+
+// minIterations rejects hashes which are too cheap to be legitimate.
+const minIterations = 10_000
+
+// ErrMismatch is returned by Verify when the password does not match the hash.
+var ErrMismatch = errors.New("password does not match")
+
+// Hash returns the encoded PBKDF2-HMAC-SHA256 hash of password with a random salt.
+//
+// The encoding is $pbkdf2-sha256$i=<iterations>$<salt>$<key>, with unpadded base64 salt and key.
+func Hash(password string) (string, error) {
+	return hash(password, Iterations)
+}
+
+func hash(password string, iterations int) (string, error) {
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, iterations, keyLen)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("$%s$i=%d$%s$%s", scheme, iterations,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify checks password against the encoded hash.
+//
+// It returns ErrMismatch if the password does not match, and another error if the hash cannot be parsed.
+func Verify(password, encoded string) error {
+	iterations, salt, key, err := parse(encoded)
+	if err != nil {
+		return err
+	}
+	got, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(key))
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(got, key) != 1 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+// NeedsRehash reports whether encoded was hashed with weaker settings than Hash uses.
+func NeedsRehash(encoded string) bool {
+	iterations, _, key, err := parse(encoded)
+	return err != nil || iterations < Iterations || len(key) < keyLen
+}
+
+func parse(encoded string) (iterations int, salt, key []byte, err error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 || parts[0] != "" || parts[1] != scheme || !strings.HasPrefix(parts[2], "i=") {
+		return 0, nil, nil, fmt.Errorf("unsupported password hash format")
+	}
+	iterations, err = strconv.Atoi(strings.TrimPrefix(parts[2], "i="))
+	if err != nil || iterations < minIterations {
+		return 0, nil, nil, fmt.Errorf("invalid password hash iterations %q", parts[2])
+	}
+	salt, err = base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil || len(salt) < 8 {
+		return 0, nil, nil, fmt.Errorf("invalid password hash salt")
+	}
+	key, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil || len(key) < 16 {
+		return 0, nil, nil, fmt.Errorf("invalid password hash key")
+	}
+	return iterations, salt, key, nil
+}
@@ -0,0 +1,70 @@
+package password
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestHashVerify(t *testing.T) {
+	// Use the minimum iterations to keep the test fast.
+	encoded, err := hash("correct horse", minIterations)
+	if err != nil {
+		t.Fatalf("cannot hash password: %v", err)
+	}
+	if !strings.HasPrefix(encoded, "$pbkdf2-sha256$i=10000$") {
+		t.Fatalf("unexpected hash encoding %q", encoded)
+	}
+
+	if err := Verify("correct horse", encoded); err != nil {
+		t.Fatalf("Verify() error = %v; want nil", err)
+	}
+	if err := Verify("wrong horse", encoded); !errors.Is(err, ErrMismatch) {
+		t.Fatalf("Verify() error = %v; want ErrMismatch", err)
+	}
+	if !NeedsRehash(encoded) {
+		t.Fatalf("hashes with %d iterations must be rehashed", minIterations)
+	}
+
+	other, err := hash("correct horse", minIterations)
+	if err != nil {
+		t.Fatalf("cannot hash password: %v", err)
+	}
+	if other == encoded {
+		t.Fatalf("hashes of the same password must use different salts")
+	}
+}
+
+func TestHashDefaults(t *testing.T) {
+	encoded, err := Hash("secret")
+	if err != nil {
+		t.Fatalf("cannot hash password: %v", err)
+	}
+	if err := Verify("secret", encoded); err != nil {
+		t.Fatalf("Verify() error = %v; want nil", err)
+	}
+	if NeedsRehash(encoded) {
+		t.Fatalf("hashes with the default settings must not be rehashed")
+	}
+}
+
+func TestVerifyInvalidHash(t *testing.T) {
+	f := func(encoded string) {
+		t.Helper()
+		err := Verify("secret", encoded)
+		if err == nil || errors.Is(err, ErrMismatch) {
+			t.Fatalf("Verify(%q) error = %v; want a parse error", encoded, err)
+		}
+		if !NeedsRehash(encoded) {
+			t.Fatalf("NeedsRehash(%q) = false; want true", encoded)
+		}
+	}
+
+	f("")
+	f("secret")
+	f("$bcrypt$i=600000$c2FsdHNhbHRzYWx0$a2V5a2V5a2V5a2V5a2V5a2V5")
+	f("$pbkdf2-sha256$600000$c2FsdHNhbHRzYWx0$a2V5a2V5a2V5a2V5a2V5a2V5")
+	f("$pbkdf2-sha256$i=1$c2FsdHNhbHRzYWx0$a2V5a2V5a2V5a2V5a2V5a2V5")
+	f("$pbkdf2-sha256$i=600000$!!$a2V5a2V5a2V5a2V5a2V5a2V5")
+	f("$pbkdf2-sha256$i=600000$c2FsdHNhbHRzYWx0$a2V5")
+}
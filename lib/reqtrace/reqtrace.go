@@ -0,0 +1,152 @@
+package reqtrace
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Phases recorded on request timelines.
+const (
+	PhaseQueue      = "http.queue"
+	PhaseDBWait     = "db.wait"
+	PhaseDBQuery    = "db.query"
+	PhaseJSONEncode = "json.encode"
+	PhaseWrite      = "write"
+)
+
+// Span is a phase of a request, with its offset from the request start.
+type Span struct {
+	Phase  string `json:"phase"`
+	Detail string `json:"detail,omitempty"`
+	// OffsetMs is the start of the phase, in milliseconds since the request start.
+	OffsetMs float64 `json:"offset_ms"`
+	// DurationMs is the duration of the phase in milliseconds.
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// maxSpans bounds the memory used by requests issuing many queries. Later spans are only counted in the totals.
+const maxSpans = 256
+
+// Timeline records the phases of a request.
+//
+// All methods are safe for concurrent use and do nothing on a nil Timeline,
+// so code recording phases does not need to check whether the request is traced.
+type Timeline struct {
+	start time.Time
+
+	mu      sync.Mutex
+	spans   []Span
+	dropped int
+	totals  map[string]time.Duration
+	counts  map[string]int
+}
+
+// New returns a timeline of a request started at start.
+func New(start time.Time) *Timeline {
+	return &Timeline{
+		start:  start,
+		totals: map[string]time.Duration{},
+		counts: map[string]int{},
+	}
+}
+
+type timelineKey struct{}
+
+// NewContext returns a copy of ctx carrying t.
+func NewContext(ctx context.Context, t *Timeline) context.Context {
+	return context.WithValue(ctx, timelineKey{}, t)
+}
+
+// FromContext returns the timeline carried by ctx, or nil.
+func FromContext(ctx context.Context) *Timeline {
+	t, _ := ctx.Value(timelineKey{}).(*Timeline)
+	return t
+}
+
+// Record adds a phase started at start and lasting dur.
+func (t *Timeline) Record(phase, detail string, start time.Time, dur time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.totals[phase] += dur
+	t.counts[phase]++
+	if len(t.spans) >= maxSpans {
+		t.dropped++
+		return
+	}
+	t.spans = append(t.spans, Span{
+		Phase:      phase,
+		Detail:     detail,
+		OffsetMs:   ms(start.Sub(t.start)),
+		DurationMs: ms(dur),
+	})
+}
+
+// Start returns the start time of the request.
+func (t *Timeline) Start() time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return t.start
+}
+
+// Spans returns the recorded phases, sorted by offset.
+func (t *Timeline) Spans() []Span {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	spans := append([]Span(nil), t.spans...)
+	t.mu.Unlock()
+
+	sort.SliceStable(spans, func(i, j int) bool { return spans[i].OffsetMs < spans[j].OffsetMs })
+	return spans
+}
+
+// Total returns the summed duration and the number of the recorded phases named phase.
+func (t *Timeline) Total(phase string) (time.Duration, int) {
+	if t == nil {
+		return 0, 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.totals[phase], t.counts[phase]
+}
+
+// Dropped returns the number of spans which were counted in the totals but not kept.
+func (t *Timeline) Dropped() int {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.dropped
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+type issuedKey struct{}
+
+// WithIssued returns a copy of ctx remembering that a database call was issued now.
+//
+// The database driver reads it when the call reaches a connection, so the time
+// spent waiting for a free connection in the pool can be recorded as PhaseDBWait.
+func WithIssued(ctx context.Context) context.Context {
+	if FromContext(ctx) == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, issuedKey{}, time.Now())
+}
+
+// Issued returns the time set with WithIssued, if any.
+func Issued(ctx context.Context) (time.Time, bool) {
+	t, ok := ctx.Value(issuedKey{}).(time.Time)
+	return t, ok
+}
@@ -0,0 +1,70 @@
+package reqtrace
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTimeline(t *testing.T) {
+	start := time.Now()
+	tl := New(start)
+	ctx := NewContext(context.Background(), tl)
+
+	FromContext(ctx).Record(PhaseDBQuery, "GetItem", start.Add(3*time.Millisecond), 2*time.Millisecond)
+	FromContext(ctx).Record(PhaseDBWait, "", start.Add(time.Millisecond), 2*time.Millisecond)
+	FromContext(ctx).Record(PhaseDBQuery, "ListItems", start.Add(6*time.Millisecond), 4*time.Millisecond)
+
+	spans := tl.Spans()
+	want := []Span{
+		{Phase: PhaseDBWait, OffsetMs: 1, DurationMs: 2},
+		{Phase: PhaseDBQuery, Detail: "GetItem", OffsetMs: 3, DurationMs: 2},
+		{Phase: PhaseDBQuery, Detail: "ListItems", OffsetMs: 6, DurationMs: 4},
+	}
+	if len(spans) != len(want) {
+		t.Fatalf("got %d spans; want %d", len(spans), len(want))
+	}
+	for i := range want {
+		if spans[i] != want[i] {
+			t.Fatalf("span #%d = %+v; want %+v", i, spans[i], want[i])
+		}
+	}
+
+	total, n := tl.Total(PhaseDBQuery)
+	if total != 6*time.Millisecond || n != 2 {
+		t.Fatalf("db.query total = %s over %d spans; want 6ms over 2 spans", total, n)
+	}
+}
+
+func TestTimelineMaxSpans(t *testing.T) {
+	tl := New(time.Now())
+	for i := 0; i < maxSpans+10; i++ {
+		tl.Record(PhaseDBQuery, "", time.Now(), time.Millisecond)
+	}
+	if n := len(tl.Spans()); n != maxSpans {
+		t.Fatalf("got %d spans; want %d", n, maxSpans)
+	}
+	if n := tl.Dropped(); n != 10 {
+		t.Fatalf("got %d dropped spans; want 10", n)
+	}
+	if _, n := tl.Total(PhaseDBQuery); n != maxSpans+10 {
+		t.Fatalf("got %d counted spans; want %d", n, maxSpans+10)
+	}
+}
+
+func TestNilTimeline(t *testing.T) {
+	ctx := context.Background()
+	tl := FromContext(ctx)
+	if tl != nil {
+		t.Fatalf("unexpected timeline in empty context")
+	}
+
+	// recording on untraced requests must be a no-op
+	tl.Record(PhaseWrite, "", time.Now(), time.Millisecond)
+	if spans := tl.Spans(); spans != nil {
+		t.Fatalf("unexpected spans %v", spans)
+	}
+	if _, ok := Issued(WithIssued(ctx)); ok {
+		t.Fatalf("WithIssued must not mark untraced contexts")
+	}
+}
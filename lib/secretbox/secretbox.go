@@ -0,0 +1,89 @@
+// Package secretbox encrypts small secrets at rest, e.g. TOTP secrets, with AES-256-GCM.
+package secretbox
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"github.com/AltSoyuz/adequate/lib/keys"
+)
+
+// version starts sealed secrets, so their format can evolve.
+//
+// Version 2 secrets are followed by the length and the ID of their key, then the nonce and the ciphertext.
+const version = 2
+
+// ErrOpen is returned for secrets which were not sealed by a key of the keyring, or were altered.
+var ErrOpen = errors.New("secretbox: cannot open sealed secret")
+
+// Box seals secrets with the active key of a keyring, and opens them with the key which sealed them,
+// so secrets sealed before a key rotation can still be opened.
+type Box struct {
+	ring    *keys.Ring
+	purpose string
+}
+
+// New returns a box for secrets of purpose, e.g. totp-secrets, with keys derived from ring.
+func New(ring *keys.Ring, purpose string) *Box {
+	return &Box{ring: ring, purpose: purpose}
+}
+
+func (b *Box) aead(k keys.Key) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(k.Derive("secretbox:" + b.purpose))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Seal encrypts plaintext with the active key.
+//
+// additionalData is authenticated but not encrypted. It binds the sealed secret to its context, e.g. the ID of
+// its owner, so a secret copied to another row cannot be opened.
+func (b *Box) Seal(plaintext, additionalData []byte) ([]byte, error) {
+	k := b.ring.Active()
+	if len(k.ID) > 255 {
+		return nil, fmt.Errorf("secretbox: key ID %q is too long", k.ID)
+	}
+	aead, err := b.aead(k)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("secretbox: cannot generate nonce: %w", err)
+	}
+	out := make([]byte, 0, 2+len(k.ID)+len(nonce)+len(plaintext)+aead.Overhead())
+	out = append(out, version, byte(len(k.ID)))
+	out = append(out, k.ID...)
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, plaintext, additionalData), nil
+}
+
+// Open decrypts a secret sealed by a key of the keyring with the same additionalData.
+func (b *Box) Open(sealed, additionalData []byte) ([]byte, error) {
+	if len(sealed) < 2 || sealed[0] != version || len(sealed) < 2+int(sealed[1]) {
+		return nil, ErrOpen
+	}
+	id, rest := string(sealed[2:2+int(sealed[1])]), sealed[2+int(sealed[1]):]
+	k, ok := b.ring.Lookup(id)
+	if !ok {
+		return nil, ErrOpen
+	}
+	aead, err := b.aead(k)
+	if err != nil {
+		return nil, err
+	}
+	n := aead.NonceSize()
+	if len(rest) < n+aead.Overhead() {
+		return nil, ErrOpen
+	}
+	plaintext, err := aead.Open(nil, rest[:n], rest[n:], additionalData)
+	if err != nil {
+		return nil, ErrOpen
+	}
+	return plaintext, nil
+}
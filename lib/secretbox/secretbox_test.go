@@ -0,0 +1,77 @@
+package secretbox
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/AltSoyuz/adequate/lib/keys"
+)
+
+func testRing(t *testing.T, active keys.Key, retired ...keys.Key) *keys.Ring {
+	t.Helper()
+	r, err := keys.NewRing(active, retired...)
+	if err != nil {
+		t.Fatalf("cannot create keyring: %v", err)
+	}
+	return r
+}
+
+func TestBox(t *testing.T) {
+	k1 := keys.Key{ID: "k1", Secret: bytes.Repeat([]byte{1}, keys.SecretSize), CreatedAt: time.Unix(1, 0)}
+	k2 := keys.Key{ID: "k2", Secret: bytes.Repeat([]byte{2}, keys.SecretSize), CreatedAt: time.Unix(2, 0)}
+	box := New(testRing(t, k1), "totp")
+	other := New(testRing(t, k2), "totp")
+	otherPurpose := New(testRing(t, k1), "other")
+	// The rotated box seals with k2 and still opens the secrets sealed with k1.
+	rotated := New(testRing(t, k2, k1), "totp")
+
+	sealed, err := box.Seal([]byte("secret"), []byte("user:1"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bytes.Contains(sealed, []byte("secret")) {
+		t.Fatalf("sealed secret %q contains the plaintext", sealed)
+	}
+	again, _ := box.Seal([]byte("secret"), []byte("user:1"))
+	if bytes.Equal(sealed, again) {
+		t.Fatalf("sealing twice must use different nonces")
+	}
+
+	g := func(b *Box, sealed []byte) {
+		t.Helper()
+		plaintext, err := b.Open(sealed, []byte("user:1"))
+		if err != nil || string(plaintext) != "secret" {
+			t.Fatalf("Open() = %q, %v; want %q", plaintext, err, "secret")
+		}
+	}
+	g(box, sealed)
+	g(rotated, sealed)
+	resealed, err := rotated.Seal([]byte("secret"), []byte("user:1"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	g(other, resealed)
+
+	f := func(b *Box, sealed []byte, ad string) {
+		t.Helper()
+		if _, err := b.Open(sealed, []byte(ad)); !errors.Is(err, ErrOpen) {
+			t.Fatalf("Open() error = %v; want ErrOpen", err)
+		}
+	}
+	f(box, sealed, "user:2")
+	f(other, sealed, "user:1")
+	f(otherPurpose, sealed, "user:1")
+	f(box, resealed, "user:1")
+	f(box, sealed[:10], "user:1")
+	f(box, sealed[:2], "user:1")
+	f(box, nil, "user:1")
+	tampered := bytes.Clone(sealed)
+	tampered[len(tampered)-1] ^= 1
+	f(box, tampered, "user:1")
+	// Secrets whose key ID was changed are opened with the wrong key.
+	swapped := bytes.Clone(sealed)
+	swapped[3] = '2'
+	f(rotated, swapped, "user:1")
+}
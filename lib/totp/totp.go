@@ -0,0 +1,98 @@
+// Package totp implements the time-based one-time passwords of RFC 6238, as used by authenticator apps.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The parameters supported by all authenticator apps: HMAC-SHA1, 6 digits and a 30s period.
+const (
+	Digits     = 6
+	Period     = 30 * time.Second
+	SecretSize = 20
+)
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a random secret of SecretSize bytes.
+func GenerateSecret() ([]byte, error) {
+	secret := make([]byte, SecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("cannot generate TOTP secret: %w", err)
+	}
+	return secret, nil
+}
+
+// EncodeSecret returns the base32 encoding of secret, which users type in their app if they cannot scan a QR code.
+func EncodeSecret(secret []byte) string {
+	return b32.EncodeToString(secret)
+}
+
+// URI returns the otpauth:// provisioning URI of secret, which is encoded in QR codes for authenticator apps.
+//
+// issuer names the app and account names the user in the authenticator app.
+func URI(issuer, account string, secret []byte) string {
+	q := url.Values{
+		"secret":    {EncodeSecret(secret)},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(Digits)},
+		"period":    {fmt.Sprint(int(Period.Seconds()))},
+	}
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// Step returns the time step of t.
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period.Seconds())
+}
+
+// Code returns the code of secret at t.
+func Code(secret []byte, t time.Time) string {
+	return code(secret, Step(t))
+}
+
+// code implements the HOTP algorithm of RFC 4226 for the counter c.
+func code(secret []byte, c int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(c))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	v := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for range Digits {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, v%mod)
+}
+
+// Validate returns the time step of code if it is the code of secret at t, or at most skew steps before or after it,
+// so codes are accepted despite clock drift and typing delays.
+//
+// Callers must reject steps which are not after the step of the last accepted code, so codes cannot be replayed.
+func Validate(secret []byte, c string, t time.Time, skew int) (int64, bool) {
+	c = strings.ReplaceAll(c, " ", "")
+	if len(c) != Digits {
+		return 0, false
+	}
+	now := Step(t)
+	for i := -int64(skew); i <= int64(skew); i++ {
+		if subtle.ConstantTimeCompare([]byte(code(secret, now+i)), []byte(c)) == 1 {
+			return now + i, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,57 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCode(t *testing.T) {
+	// The SHA1 test vectors of RFC 6238, appendix B, truncated to 6 digits.
+	secret := []byte("12345678901234567890")
+	f := func(unix int64, want string) {
+		t.Helper()
+		if got := Code(secret, time.Unix(unix, 0)); got != want {
+			t.Fatalf("Code(%d) = %q; want %q", unix, got, want)
+		}
+	}
+	f(59, "287082")
+	f(1111111109, "081804")
+	f(1111111111, "050471")
+	f(1234567890, "005924")
+	f(2000000000, "279037")
+	f(20000000000, "353130")
+}
+
+func TestValidate(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	now := time.Unix(1111111111, 0)
+
+	f := func(code string, at time.Time, wantOK bool) {
+		t.Helper()
+		step, ok := Validate(secret, code, at, 1)
+		if ok != wantOK {
+			t.Fatalf("Validate(%q) = %v; want %v", code, ok, wantOK)
+		}
+		if ok && Code(secret, time.Unix(step*30, 0)) != strings.ReplaceAll(code, " ", "") {
+			t.Fatalf("Validate(%q) returned step %d of another code", code, step)
+		}
+	}
+	f(Code(secret, now), now, true)
+	f("050 471", now, true)
+	f(Code(secret, now.Add(-Period)), now, true)
+	f(Code(secret, now.Add(Period)), now, true)
+	f(Code(secret, now.Add(-2*Period)), now, false)
+	f(Code(secret, now.Add(2*Period)), now, false)
+	f("", now, false)
+	f("12345", now, false)
+	f("abcdef", now, false)
+}
+
+func TestURI(t *testing.T) {
+	got := URI("Adequate", "alice@example.com", []byte("12345678901234567890"))
+	want := "otpauth://totp/Adequate:alice@example.com?algorithm=SHA1&digits=6&issuer=Adequate&period=30&secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	if got != want {
+		t.Fatalf("URI() = %q; want %q", got, want)
+	}
+}
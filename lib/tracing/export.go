@@ -0,0 +1,292 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/AltSoyuz/adequate/lib/logger"
+	"github.com/AltSoyuz/adequate/lib/metrics"
+)
+
+var (
+	otlpEndpoint = flag.String("tracing.otlpEndpoint", "", "OTLP/HTTP traces endpoint spans are exported to as JSON, e.g. http://localhost:4318/v1/traces. "+
+		"Spans are not exported if empty")
+	serviceName   = flag.String("tracing.serviceName", "adequate", "The service.name resource attribute of exported spans")
+	batchInterval = flag.Duration("tracing.batchInterval", 5*time.Second, "How often queued spans are exported")
+	maxBatchSize  = flag.Int("tracing.maxBatchSize", 512, "The maximum number of spans per export request")
+	maxQueueSize  = flag.Int("tracing.maxQueueSize", 2048, "The maximum number of spans waiting for export. Spans are dropped when the queue is full")
+	exportTimeout = flag.Duration("tracing.exportTimeout", 10*time.Second, "Timeout for export requests")
+)
+
+var (
+	exportedSpans = metrics.NewCounter("tracing_exported_spans_total")
+	droppedSpans  = metrics.NewCounter("tracing_dropped_spans_total")
+	exportErrors  = metrics.NewCounter("tracing_export_errors_total")
+)
+
+// Instrumentation scope of exported spans.
+const scopeName = "github.com/AltSoyuz/adequate/lib/tracing"
+
+type exporter struct {
+	endpoint string
+	client   *http.Client
+	queue    chan *Span
+	stopCh   chan struct{}
+	wg       sync.WaitGroup
+}
+
+var (
+	expMu sync.RWMutex
+	exp   *exporter
+)
+
+// Init starts exporting spans to -tracing.otlpEndpoint, if set.
+//
+// It must be called after flags are parsed.
+func Init() {
+	if *otlpEndpoint == "" {
+		return
+	}
+	e := &exporter{
+		endpoint: *otlpEndpoint,
+		client:   &http.Client{Timeout: *exportTimeout},
+		queue:    make(chan *Span, *maxQueueSize),
+		stopCh:   make(chan struct{}),
+	}
+	e.wg.Add(1)
+	go e.run()
+
+	expMu.Lock()
+	exp = e
+	expMu.Unlock()
+	logger.Info("tracing.export", "endpoint", *otlpEndpoint)
+}
+
+// Stop exports the queued spans and stops the exporter started by Init.
+func Stop() {
+	expMu.Lock()
+	e := exp
+	exp = nil
+	expMu.Unlock()
+	if e == nil {
+		return
+	}
+	close(e.stopCh)
+	e.wg.Wait()
+}
+
+func exporterEnabled() bool {
+	expMu.RLock()
+	defer expMu.RUnlock()
+	return exp != nil
+}
+
+func export(s *Span) {
+	expMu.RLock()
+	defer expMu.RUnlock()
+	if exp == nil {
+		return
+	}
+	select {
+	case exp.queue <- s:
+	default:
+		droppedSpans.Inc()
+	}
+}
+
+func (e *exporter) run() {
+	defer e.wg.Done()
+
+	t := time.NewTicker(*batchInterval)
+	defer t.Stop()
+
+	batch := make([]*Span, 0, *maxBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		e.send(batch)
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case s := <-e.queue:
+			batch = append(batch, s)
+			if len(batch) >= *maxBatchSize {
+				flush()
+			}
+		case <-t.C:
+			flush()
+		case <-e.stopCh:
+			for {
+				select {
+				case s := <-e.queue:
+					batch = append(batch, s)
+					if len(batch) >= *maxBatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (e *exporter) send(spans []*Span) {
+	b, err := json.Marshal(newExportRequest(spans))
+	if err != nil {
+		exportErrors.Inc()
+		logger.Error("tracing.export", "err", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *exportTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(b))
+	if err != nil {
+		exportErrors.Inc()
+		logger.Error("tracing.export", "err", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := e.client.Do(req)
+	if err != nil {
+		exportErrors.Inc()
+		logger.Error("tracing.export", "err", err)
+		return
+	}
+	defer func() { _ = res.Body.Close() }()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+	if res.StatusCode/100 != 2 {
+		exportErrors.Inc()
+		logger.Error("tracing.export", "err", fmt.Errorf("unexpected status code %d from %s", res.StatusCode, e.endpoint))
+		return
+	}
+	exportedSpans.Add(len(spans))
+}
+
+// The types below follow the JSON encoding of the OTLP ExportTraceServiceRequest message.
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	TraceState        string         `json:"traceState,omitempty"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Flags             uint32         `json:"flags"`
+	Name              string         `json:"name"`
+	Kind              Kind           `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Message string `json:"message,omitempty"`
+	// Code is 0 for unset and 2 for error.
+	Code int `json:"code,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+const otlpStatusError = 2
+
+func newExportRequest(spans []*Span) *otlpRequest {
+	ss := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		s.mu.Lock()
+		o := otlpSpan{
+			TraceID:           s.sc.TraceID.String(),
+			SpanID:            s.sc.SpanID.String(),
+			TraceState:        s.sc.TraceState,
+			Flags:             uint32(s.sc.Flags),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        otlpAttrs(s.attrs),
+		}
+		if s.parent.IsValid() {
+			o.ParentSpanID = s.parent.String()
+		}
+		if s.hasError {
+			o.Status = otlpStatus{Code: otlpStatusError, Message: s.errMsg}
+		}
+		s.mu.Unlock()
+		ss = append(ss, o)
+	}
+
+	return &otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: otlpAttrs([]Attr{String("service.name", *serviceName)})},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: scopeName}, Spans: ss}},
+	}}}
+}
+
+func otlpAttrs(attrs []Attr) []otlpKeyValue {
+	kvs := make([]otlpKeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		var v otlpAnyValue
+		switch x := a.Value.(type) {
+		case string:
+			v.StringValue = &x
+		case bool:
+			v.BoolValue = &x
+		case int:
+			s := strconv.Itoa(x)
+			v.IntValue = &s
+		case int64:
+			s := strconv.FormatInt(x, 10)
+			v.IntValue = &s
+		case float64:
+			v.DoubleValue = &x
+		default:
+			s := fmt.Sprint(x)
+			v.StringValue = &s
+		}
+		kvs = append(kvs, otlpKeyValue{Key: a.Key, Value: v})
+	}
+	return kvs
+}
@@ -0,0 +1,97 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestExport(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		reqs []otlpRequest
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("content-type = %q; want application/json", ct)
+		}
+		var req otlpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("cannot decode export request: %v", err)
+		}
+		mu.Lock()
+		reqs = append(reqs, req)
+		mu.Unlock()
+	}))
+	defer collector.Close()
+
+	defer func(endpoint string, interval time.Duration) {
+		*otlpEndpoint = endpoint
+		*batchInterval = interval
+	}(*otlpEndpoint, *batchInterval)
+	*otlpEndpoint = collector.URL
+	*batchInterval = time.Hour
+
+	Init()
+
+	ctx, server := Start(context.Background(), "GET /api/items", KindServer, String("url.path", "/api/items"))
+	_, query := Start(ctx, "ListItems", KindClient)
+	query.SetError(errors.New("database is locked"))
+	query.End()
+	server.SetAttributes(Int("http.response.status_code", 500), Bool("retry", false))
+	server.End()
+
+	// Stop must flush the queued spans.
+	Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reqs) != 1 {
+		t.Fatalf("got %d export requests; want 1", len(reqs))
+	}
+	rs := reqs[0].ResourceSpans[0]
+	if v := rs.Resource.Attributes[0]; v.Key != "service.name" || v.Value.StringValue == nil || *v.Value.StringValue != *serviceName {
+		t.Fatalf("unexpected resource attributes %+v", rs.Resource.Attributes)
+	}
+	spans := rs.ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans; want 2", len(spans))
+	}
+
+	q, s := spans[0], spans[1]
+	if q.Name != "ListItems" || q.Kind != KindClient || q.ParentSpanID != s.SpanID || q.TraceID != s.TraceID {
+		t.Fatalf("unexpected query span %+v", q)
+	}
+	if q.Status.Code != otlpStatusError || q.Status.Message != "database is locked" {
+		t.Fatalf("unexpected query span status %+v", q.Status)
+	}
+	if s.ParentSpanID != "" || s.Kind != KindServer || s.StartTimeUnixNano == "" || s.EndTimeUnixNano == "" {
+		t.Fatalf("unexpected server span %+v", s)
+	}
+	attrs := map[string]otlpAnyValue{}
+	for _, kv := range s.Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	if v := attrs["http.response.status_code"].IntValue; v == nil || *v != "500" {
+		t.Fatalf("unexpected status code attribute in %+v", s.Attributes)
+	}
+	if v := attrs["retry"].BoolValue; v == nil || *v {
+		t.Fatalf("unexpected bool attribute in %+v", s.Attributes)
+	}
+}
+
+func TestExportDisabled(t *testing.T) {
+	_, s := Start(context.Background(), "job", KindInternal)
+	if s.recording {
+		t.Fatalf("spans must not be recorded without an exporter")
+	}
+	if !s.SpanContext().IsValid() {
+		t.Fatalf("spans must still be propagated without an exporter")
+	}
+	s.End()
+}
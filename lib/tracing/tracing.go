@@ -0,0 +1,314 @@
+package tracing
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var sampleRatio = flag.Float64("tracing.sampleRatio", 1, "The ratio of traces started by this app which are sampled, between 0 and 1. "+
+	"Traces started upstream follow the sampling decision of their traceparent header")
+
+// TraceID identifies a trace.
+type TraceID [16]byte
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+
+// IsValid reports whether id is not all zeros.
+func (id TraceID) IsValid() bool { return id != TraceID{} }
+
+// IsValid reports whether id is not all zeros.
+func (id SpanID) IsValid() bool { return id != SpanID{} }
+
+const flagSampled = 0x01
+
+// SpanContext is the part of a span propagated across process boundaries.
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Flags      byte
+	TraceState string
+	// Remote is set for span contexts extracted from incoming requests.
+	Remote bool
+}
+
+// IsValid reports whether sc has valid trace and span IDs.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// IsSampled reports whether the trace is recorded and exported.
+func (sc SpanContext) IsSampled() bool {
+	return sc.Flags&flagSampled != 0
+}
+
+// Traceparent returns the W3C traceparent header value of sc.
+func (sc SpanContext) Traceparent() string {
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + hex.EncodeToString([]byte{sc.Flags})
+}
+
+// Header names of the W3C trace context.
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+)
+
+var errInvalidTraceparent = errors.New("invalid traceparent")
+
+// ParseTraceparent parses a W3C traceparent header value.
+//
+// Values of future versions are accepted as long as they start with the version 00 fields.
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	s = strings.TrimSpace(s)
+	if len(s) < 55 {
+		return sc, errInvalidTraceparent
+	}
+	version, err := decodeHex(s[0:2])
+	if err != nil || version[0] == 0xff {
+		return sc, errInvalidTraceparent
+	}
+	if version[0] == 0 && len(s) != 55 || len(s) > 55 && s[55] != '-' {
+		return sc, errInvalidTraceparent
+	}
+	if s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return sc, errInvalidTraceparent
+	}
+
+	traceID, err := decodeHex(s[3:35])
+	if err != nil {
+		return sc, errInvalidTraceparent
+	}
+	spanID, err := decodeHex(s[36:52])
+	if err != nil {
+		return sc, errInvalidTraceparent
+	}
+	flags, err := decodeHex(s[53:55])
+	if err != nil {
+		return sc, errInvalidTraceparent
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Flags = flags[0] & flagSampled
+	if !sc.IsValid() {
+		return SpanContext{}, errInvalidTraceparent
+	}
+	return sc, nil
+}
+
+// decodeHex decodes lowercase hex, as required by the trace context spec.
+func decodeHex(s string) ([]byte, error) {
+	if strings.ToLower(s) != s {
+		return nil, errInvalidTraceparent
+	}
+	return hex.DecodeString(s)
+}
+
+const maxTracestateLen = 512
+
+// Extract returns the remote span context carried by the traceparent and tracestate headers of h.
+func Extract(h http.Header) (SpanContext, bool) {
+	sc, err := ParseTraceparent(h.Get(TraceparentHeader))
+	if err != nil {
+		return SpanContext{}, false
+	}
+	// Multiple tracestate headers are combined as a single list.
+	if ts := strings.Join(h.Values(TracestateHeader), ","); len(ts) <= maxTracestateLen {
+		sc.TraceState = ts
+	}
+	sc.Remote = true
+	return sc, true
+}
+
+// Inject sets the traceparent and tracestate headers of h from the span in ctx, if any.
+func Inject(ctx context.Context, h http.Header) {
+	sc := SpanFromContext(ctx).SpanContext()
+	if !sc.IsValid() {
+		return
+	}
+	h.Set(TraceparentHeader, sc.Traceparent())
+	if sc.TraceState != "" {
+		h.Set(TracestateHeader, sc.TraceState)
+	} else {
+		h.Del(TracestateHeader)
+	}
+}
+
+// Kind is the OTLP span kind.
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+// Attr is a span attribute. Value must be a string, bool, int, int64 or float64.
+type Attr struct {
+	Key   string
+	Value any
+}
+
+// String returns a string attribute.
+func String(key, value string) Attr { return Attr{Key: key, Value: value} }
+
+// Int returns an integer attribute.
+func Int(key string, value int64) Attr { return Attr{Key: key, Value: value} }
+
+// Bool returns a boolean attribute.
+func Bool(key string, value bool) Attr { return Attr{Key: key, Value: value} }
+
+// Span is an operation of a trace.
+//
+// All methods are safe for concurrent use and do nothing on a nil Span.
+type Span struct {
+	sc     SpanContext
+	parent SpanID
+	name   string
+	kind   Kind
+	start  time.Time
+
+	mu        sync.Mutex
+	end       time.Time
+	attrs     []Attr
+	errMsg    string
+	hasError  bool
+	ended     bool
+	recording bool
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// ContextWithRemote returns a copy of ctx carrying sc as the parent of the next started span.
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// SpanFromContext returns the span carried by ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// Start starts a span as a child of the span in ctx, of the remote span context in ctx,
+// or as the root of a new trace.
+func Start(ctx context.Context, name string, kind Kind, attrs ...Attr) (context.Context, *Span) {
+	s := &Span{
+		name:  name,
+		kind:  kind,
+		start: time.Now(),
+		attrs: attrs,
+	}
+	if parent := SpanFromContext(ctx); parent != nil {
+		s.sc = parent.sc
+		s.sc.Remote = false
+		s.parent = parent.sc.SpanID
+	} else if remote, ok := ctx.Value(remoteKey{}).(SpanContext); ok && remote.IsValid() {
+		s.sc = remote
+		s.sc.Remote = false
+		s.parent = remote.SpanID
+	} else {
+		s.sc.TraceID = newTraceID()
+		if rand.Float64() < *sampleRatio {
+			s.sc.Flags = flagSampled
+		}
+	}
+	s.sc.SpanID = newSpanID()
+	s.recording = s.sc.IsSampled() && exporterEnabled()
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// ChildOf starts a span only if ctx already carries one, so background work does not start new traces.
+func ChildOf(ctx context.Context, name string, kind Kind, attrs ...Attr) (context.Context, *Span) {
+	if SpanFromContext(ctx) == nil {
+		return ctx, nil
+	}
+	return Start(ctx, name, kind, attrs...)
+}
+
+// SpanContext returns the span context of s.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetName renames s, e.g. once the route of a request is known.
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.name = name
+}
+
+// SetAttributes adds attributes to s.
+func (s *Span) SetAttributes(attrs ...Attr) {
+	if s == nil || !s.recording {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs = append(s.attrs, attrs...)
+}
+
+// SetError marks s as failed with err.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hasError = true
+	s.errMsg = err.Error()
+}
+
+// End ends s and queues it for export if it is sampled.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+
+	if s.recording {
+		export(s)
+	}
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		binary.BigEndian.PutUint64(id[:8], rand.Uint64())
+		binary.BigEndian.PutUint64(id[8:], rand.Uint64())
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		binary.BigEndian.PutUint64(id[:], rand.Uint64())
+	}
+	return id
+}
@@ -0,0 +1,117 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	f := func(s string, wantOK bool, want string) {
+		t.Helper()
+		sc, err := ParseTraceparent(s)
+		if (err == nil) != wantOK {
+			t.Fatalf("ParseTraceparent(%q) error = %v; want ok=%v", s, err, wantOK)
+		}
+		if wantOK && sc.Traceparent() != want {
+			t.Fatalf("ParseTraceparent(%q).Traceparent() = %q; want %q", s, sc.Traceparent(), want)
+		}
+	}
+
+	// valid
+	f("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	f("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	f(" 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01 ", true, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	// future versions may append fields
+	f("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	// invalid
+	f("", false, "")
+	f("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, "")
+	f("ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, "")
+	f("00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, "")
+	f("00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, "")
+	f("00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false, "")
+	f("00-4bf92f3577b34da6a3ce929d0e0e4736_00f067aa0ba902b7-01", false, "")
+	f("00-4bf92f3577b34da6a3ce929d0e0e47zz-00f067aa0ba902b7-01", false, "")
+}
+
+func TestStartPropagation(t *testing.T) {
+	h := http.Header{}
+	h.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	h.Add(TracestateHeader, "vendor1=a")
+	h.Add(TracestateHeader, "vendor2=b")
+
+	remote, ok := Extract(h)
+	if !ok || !remote.Remote {
+		t.Fatalf("cannot extract remote span context from %v", h)
+	}
+	if remote.TraceState != "vendor1=a,vendor2=b" {
+		t.Fatalf("tracestate = %q; want %q", remote.TraceState, "vendor1=a,vendor2=b")
+	}
+
+	ctx, server := Start(ContextWithRemote(context.Background(), remote), "GET", KindServer)
+	if server.sc.TraceID != remote.TraceID || server.parent != remote.SpanID {
+		t.Fatalf("server span must continue the remote trace: %+v", server.sc)
+	}
+	if server.sc.SpanID == remote.SpanID || !server.sc.IsSampled() {
+		t.Fatalf("unexpected server span context %+v", server.sc)
+	}
+
+	_, client := Start(ctx, "query", KindClient)
+	if client.sc.TraceID != remote.TraceID || client.parent != server.sc.SpanID {
+		t.Fatalf("client span must be a child of the server span")
+	}
+
+	out := http.Header{}
+	Inject(ctx, out)
+	if got, want := out.Get(TraceparentHeader), server.sc.Traceparent(); got != want {
+		t.Fatalf("injected traceparent = %q; want %q", got, want)
+	}
+	if got := out.Get(TracestateHeader); got != "vendor1=a,vendor2=b" {
+		t.Fatalf("injected tracestate = %q; want %q", got, "vendor1=a,vendor2=b")
+	}
+
+	t.Run("root spans start new traces", func(t *testing.T) {
+		_, root := Start(context.Background(), "job", KindInternal)
+		if !root.sc.IsValid() || root.parent.IsValid() {
+			t.Fatalf("unexpected root span context %+v, parent %s", root.sc, root.parent)
+		}
+		if _, s := ChildOf(context.Background(), "query", KindClient); s != nil {
+			t.Fatalf("ChildOf must not start a trace")
+		}
+	})
+}
+
+func TestTransport(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get(TraceparentHeader)
+	}))
+	defer srv.Close()
+
+	ctx, parent := Start(context.Background(), "job", KindInternal)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatalf("cannot create request: %v", err)
+	}
+	cli := &http.Client{Transport: &Transport{}}
+	res, err := cli.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	_ = res.Body.Close()
+
+	sc, err := ParseTraceparent(got)
+	if err != nil {
+		t.Fatalf("outbound request carries invalid traceparent %q", got)
+	}
+	if sc.TraceID != parent.sc.TraceID || sc.SpanID == parent.sc.SpanID {
+		t.Fatalf("outbound traceparent %q must carry a client span of trace %s", got, parent.sc.TraceID)
+	}
+	if req.Header.Get(TraceparentHeader) != "" {
+		t.Fatalf("the original request must not be modified")
+	}
+}
@@ -0,0 +1,49 @@
+package tracing
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// Transport is a http.RoundTripper starting a client span for each request
+// and propagating it with the traceparent and tracestate headers.
+type Transport struct {
+	// Base is the underlying transport. http.DefaultTransport is used if nil.
+	Base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	ctx, span := Start(req.Context(), req.Method, KindClient,
+		String("http.request.method", req.Method),
+		String("server.address", req.URL.Host),
+		String("url.full", req.URL.Redacted()),
+	)
+	defer span.End()
+
+	// RoundTrip must not modify the request, so the headers are set on a clone.
+	req = req.Clone(ctx)
+	Inject(ctx, req.Header)
+
+	res, err := base.RoundTrip(req)
+	if err != nil {
+		span.SetError(err)
+		return nil, err
+	}
+	span.SetAttributes(Int("http.response.status_code", int64(res.StatusCode)))
+	if res.StatusCode >= 400 {
+		span.SetError(statusError(res.StatusCode))
+	}
+	return res, nil
+}
+
+type statusError int
+
+func (e statusError) Error() string {
+	return "HTTP status " + strconv.Itoa(int(e))
+}
@@ -0,0 +1,249 @@
+package tsgen
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/AltSoyuz/adequate/lib/httpserver"
+	"github.com/AltSoyuz/adequate/lib/openapi"
+)
+
+// Header is the first line of generated files.
+const Header = "// Code generated by `app tsgen`. DO NOT EDIT."
+
+// Generate returns a TypeScript module with a typed fetch function per route
+// and an interface per named struct type used by the routes.
+//
+// clientModule is the import path of the hand-written module providing
+// the ApiResult type and the request and query helpers.
+func Generate(routes []httpserver.Route, clientModule string) ([]byte, error) {
+	g := &generator{
+		decls: map[string]string{},
+		names: map[reflect.Type]string{},
+	}
+
+	var (
+		funcs     []string
+		withQuery bool
+	)
+	seen := map[string]httpserver.Route{}
+	for _, rt := range routes {
+		if prev, ok := seen[rt.Name]; ok {
+			return nil, fmt.Errorf("routes %s %s and %s %s have the same name %q", prev.Method, prev.Path, rt.Method, rt.Path, rt.Name)
+		}
+		seen[rt.Name] = rt
+		withQuery = withQuery || len(rt.QueryParams) > 0
+		f, err := g.function(rt)
+		if err != nil {
+			return nil, err
+		}
+		funcs = append(funcs, f)
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s\n\n", Header)
+	imports := "request, type ApiResult"
+	if withQuery {
+		imports = "query, " + imports
+	}
+	fmt.Fprintf(&b, "import { %s } from '%s';\n\n", imports, clientModule)
+	fmt.Fprintf(&b, "export type { ApiResult } from '%s';\n", clientModule)
+
+	names := make([]string, 0, len(g.decls))
+	for name := range g.decls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b.WriteString("\n")
+		b.WriteString(g.decls[name])
+	}
+	for _, f := range funcs {
+		b.WriteString("\n")
+		b.WriteString(f)
+	}
+	return b.Bytes(), nil
+}
+
+type generator struct {
+	decls map[string]string
+	names map[reflect.Type]string
+}
+
+var identRe = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+func (g *generator) function(rt httpserver.Route) (string, error) {
+	if !identRe.MatchString(rt.Name) {
+		return "", fmt.Errorf("route %s %s: name %q is not a valid TypeScript identifier", rt.Method, rt.Path, rt.Name)
+	}
+
+	params := []string{"fetch: typeof window.fetch"}
+	if len(rt.PathParams) > 0 {
+		var fields []string
+		for _, p := range rt.PathParams {
+			fields = append(fields, propName(p)+": string")
+		}
+		params = append(params, "params: { "+strings.Join(fields, "; ")+" }")
+	}
+	if rt.Request != nil {
+		params = append(params, "body: "+g.tsType(rt.Request))
+	}
+	if len(rt.QueryParams) > 0 {
+		var fields []string
+		for _, p := range rt.QueryParams {
+			fields = append(fields, propName(p.Name)+"?: string")
+		}
+		params = append(params, "q: { "+strings.Join(fields, "; ")+" } = {}")
+	}
+
+	path := "'" + rt.Path + "'"
+	if len(rt.PathParams) > 0 {
+		path = "`" + pathParamRe.ReplaceAllStringFunc(rt.Path, func(s string) string {
+			name := pathParamRe.FindStringSubmatch(s)[1]
+			return "${encodeURIComponent(params." + name + ")}"
+		}) + "`"
+	}
+	if len(rt.QueryParams) > 0 {
+		path += " + query(q)"
+	}
+
+	result := "void"
+	if rt.Response != nil {
+		result = g.tsType(rt.Response)
+	}
+
+	args := []string{"fetch", "'" + rt.Method + "'", path}
+	if rt.Request != nil {
+		args = append(args, "body")
+	}
+
+	var b strings.Builder
+	switch {
+	case rt.Summary != "" && rt.Deprecated:
+		fmt.Fprintf(&b, "/**\n * %s\n * @deprecated\n */\n", rt.Summary)
+	case rt.Summary != "":
+		fmt.Fprintf(&b, "/** %s */\n", rt.Summary)
+	case rt.Deprecated:
+		b.WriteString("/** @deprecated */\n")
+	}
+	fmt.Fprintf(&b, "export async function %s(\n", rt.Name)
+	for i, p := range params {
+		sep := ","
+		if i == len(params)-1 {
+			sep = ""
+		}
+		fmt.Fprintf(&b, "\t%s%s\n", p, sep)
+	}
+	fmt.Fprintf(&b, "): Promise<ApiResult<%s>> {\n", result)
+	fmt.Fprintf(&b, "\treturn request(%s);\n", strings.Join(args, ", "))
+	b.WriteString("}\n")
+	return b.String(), nil
+}
+
+var pathParamRe = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)(?:\.\.\.)?\}`)
+
+var timeType = reflect.TypeFor[time.Time]()
+
+// tsType returns the TypeScript type matching the JSON encoding of t.
+func (g *generator) tsType(t reflect.Type) string {
+	if t == timeType {
+		return "string"
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.tsType(t.Elem()) + " | null"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// []byte is encoded as a base64 string.
+			return "string"
+		}
+		elem := g.tsType(t.Elem())
+		if strings.Contains(elem, " ") {
+			elem = "(" + elem + ")"
+		}
+		return elem + "[]"
+	case reflect.Map:
+		return "Record<string, " + g.tsType(t.Elem()) + ">"
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.objectType(t, "")
+		}
+		name, ok := g.names[t]
+		if !ok {
+			name = openapi.TypeName(t)
+			g.names[t] = name
+			// Register the name first, so recursive types terminate.
+			g.decls[name] = ""
+			g.decls[name] = "export interface " + name + " " + g.objectType(t, "") + "\n"
+		}
+		return name
+	default:
+		return "unknown"
+	}
+}
+
+// objectType returns the TypeScript object type of the struct type t, indented with indent.
+func (g *generator) objectType(t reflect.Type, indent string) string {
+	var b strings.Builder
+	b.WriteString("{\n")
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || f.Anonymous {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" && opts == "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		var typ string
+		switch {
+		case hasOption(opts, "string"):
+			typ = "string"
+		case f.Type.Kind() == reflect.Struct && f.Type.Name() == "" && f.Type != timeType:
+			typ = g.objectType(f.Type, indent+"\t")
+		default:
+			typ = g.tsType(f.Type)
+		}
+
+		optional := ""
+		if hasOption(opts, "omitempty") || hasOption(opts, "omitzero") {
+			optional = "?"
+		}
+		fmt.Fprintf(&b, "%s\t%s%s: %s;\n", indent, propName(name), optional, typ)
+	}
+	b.WriteString(indent + "}")
+	return b.String()
+}
+
+func propName(name string) string {
+	if identRe.MatchString(name) {
+		return name
+	}
+	return "'" + strings.ReplaceAll(name, "'", `\'`) + "'"
+}
+
+func hasOption(opts, name string) bool {
+	for _, o := range strings.Split(opts, ",") {
+		if o == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package tsgen
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AltSoyuz/adequate/lib/httpserver"
+)
+
+type testItem struct {
+	ID        int64          `json:"id"`
+	Name      string         `json:"name"`
+	Note      *string        `json:"note,omitempty"`
+	Tags      []string       `json:"tags"`
+	Attrs     map[string]int `json:"attrs"`
+	CreatedAt time.Time      `json:"created_at"`
+	Parent    *testItem      `json:"parent"`
+	Count     int64          `json:"count,string"`
+	Skipped   string         `json:"-"`
+	Owner     struct {
+		Login string `json:"login"`
+	} `json:"owner"`
+	Extra string `json:"x-extra"`
+}
+
+type testReq struct {
+	Name string `json:"name"`
+}
+
+func TestGenerate(t *testing.T) {
+	rt := httpserver.NewRouter()
+	rt.Handle(http.MethodGet, "/api/items/{id}", http.NotFoundHandler(),
+		httpserver.Name("getItem"),
+		httpserver.Summary("Returns an item"),
+		httpserver.Response[testItem](http.StatusOK),
+	)
+	rt.Handle(http.MethodPost, "/api/items", http.NotFoundHandler(),
+		httpserver.Name("createItem"),
+		httpserver.Request[testReq](),
+		httpserver.Response[httpserver.Page[testItem]](http.StatusCreated),
+		httpserver.Query("dry_run", "Validate only"),
+	)
+	rt.Handle(http.MethodDelete, "/api/items/{id}", http.NotFoundHandler(), httpserver.Name("deleteItem"))
+
+	b, err := Generate(rt.Routes(), "./api-client")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out := string(b)
+
+	f := func(want string) {
+		t.Helper()
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in output:\n%s", want, out)
+		}
+	}
+
+	f(Header + "\n")
+	f("import { query, request, type ApiResult } from './api-client';\n")
+	f(`export interface testItem {
+	id: number;
+	name: string;
+	note?: string | null;
+	tags: string[];
+	attrs: Record<string, number>;
+	created_at: string;
+	parent: testItem | null;
+	count: string;
+	owner: {
+		login: string;
+	};
+	'x-extra': string;
+}
+`)
+	f(`export interface PageTestItem {
+	items: testItem[];
+	next_cursor: string;
+}
+`)
+	f(`/** Returns an item */
+export async function getItem(
+	fetch: typeof window.fetch,
+	params: { id: string }
+): Promise<ApiResult<testItem>> {
+	return request(fetch, 'GET', ` + "`/api/items/${encodeURIComponent(params.id)}`" + `);
+}
+`)
+	f(`export async function createItem(
+	fetch: typeof window.fetch,
+	body: testReq,
+	q: { dry_run?: string } = {}
+): Promise<ApiResult<PageTestItem>> {
+	return request(fetch, 'POST', '/api/items' + query(q), body);
+}
+`)
+	f(`): Promise<ApiResult<void>> {`)
+	if strings.Contains(out, "Skipped") {
+		t.Fatalf("fields tagged with json:\"-\" must be skipped:\n%s", out)
+	}
+}
+
+func TestGenerateInvalidName(t *testing.T) {
+	rt := httpserver.NewRouter()
+	rt.Handle(http.MethodGet, "/api/items", http.NotFoundHandler(), httpserver.Name("list-items"))
+
+	if _, err := Generate(rt.Routes(), "./api-client"); err == nil {
+		t.Fatal("expected error for invalid route name")
+	}
+}
+
+func TestGenerateDuplicateName(t *testing.T) {
+	rt := httpserver.NewRouter()
+	rt.Handle(http.MethodGet, "/api/v1/items", http.NotFoundHandler(), httpserver.Name("listItems"))
+	rt.Handle(http.MethodGet, "/api/v2/items", http.NotFoundHandler(), httpserver.Name("listItems"))
+
+	if _, err := Generate(rt.Routes(), "./api-client"); err == nil {
+		t.Fatal("expected error for duplicate route names")
+	}
+}
+
+func TestGenerateDeprecated(t *testing.T) {
+	rt := httpserver.NewRouter()
+	v1 := rt.Version(httpserver.APIVersion{Name: "v1", Deprecated: time.Unix(1700000000, 0)})
+	v1.Handle(http.MethodGet, "/items", http.NotFoundHandler(), httpserver.Name("listItemsV1"), httpserver.Summary("Lists items"))
+
+	b, err := Generate(rt.Routes(), "./api-client")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "/**\n * Lists items\n * @deprecated\n */\nexport async function listItemsV1(\n"
+	if !strings.Contains(string(b), want) {
+		t.Fatalf("expected %q in output:\n%s", want, b)
+	}
+}
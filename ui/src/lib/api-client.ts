@@ -0,0 +1,63 @@
+// type is the problem type of application/problem+json errors, e.g. urn:adequate:problem:mfa-required.
+export type ApiResult<T> = { result: T } | { error: string; status: number; type?: string };
+
+async function readApiError(res: Response): Promise<{ error: string; status: number; type?: string }> {
+	let body: { error?: string; detail?: string; type?: string } = {};
+	try {
+		body = (await res.json()) as { error?: string; detail?: string; type?: string };
+	} catch {
+		// Not a JSON error response, e.g. from a proxy.
+	}
+	return {
+		status: res.status,
+		error: body.error ?? body.detail ?? res.statusText,
+		type: body.type
+	};
+}
+
+export function query(params: Record<string, string | undefined>): string {
+	const search = new URLSearchParams();
+	for (const [key, value] of Object.entries(params)) {
+		if (value !== undefined && value !== '') search.set(key, value);
+	}
+	const s = search.toString();
+	return s ? '?' + s : '';
+}
+
+const csrfCookie = 'adequate_csrf';
+const csrfHeader = 'X-CSRF-Token';
+
+// csrfToken returns the double-submit token the server sets in a cookie on safe requests.
+function csrfToken(): string | undefined {
+	if (typeof document === 'undefined') return undefined;
+	for (const part of document.cookie.split(';')) {
+		const [name, ...value] = part.trim().split('=');
+		if (name === csrfCookie) return decodeURIComponent(value.join('='));
+	}
+	return undefined;
+}
+
+export async function request<T>(
+	fetch: typeof window.fetch,
+	method: string,
+	path: string,
+	body?: unknown
+): Promise<ApiResult<T>> {
+	const headers: Record<string, string> = {};
+	const init: RequestInit = { method, headers };
+	if (body !== undefined) {
+		headers['Content-Type'] = 'application/json';
+		init.body = JSON.stringify(body);
+	}
+	if (method !== 'GET' && method !== 'HEAD') {
+		const token = csrfToken();
+		if (token) headers[csrfHeader] = token;
+	}
+	const res = await fetch(path, init);
+
+	if (!res.ok) return readApiError(res);
+
+	if (res.status === 204) return { result: undefined as T };
+	const result = (await res.json()) as T;
+	return { result };
+}
@@ -1,22 +1,341 @@
-const serverURL = '/api';
+// Code generated by `app tsgen`. DO NOT EDIT.
 
-export type ApiResult<T> = { result: T } | { error: string; status: number };
+import { query, request, type ApiResult } from './api-client';
 
-async function readApiError(res: Response): Promise<{ error: string; status: number }> {
-	const body = (await res.json()) as { error?: string };
-	return {
-		status: res.status,
-		error: body.error ?? res.statusText
-	};
+export type { ApiResult } from './api-client';
+
+export interface APIToken {
+	id: number;
+	username: string;
+	name: string;
+	prefix: string;
+	scopes: string[];
+	created_at: string;
+	expires_at?: string;
+	last_used_at?: string;
+	revoked_at?: string;
+}
+
+export interface AuditChange {
+	from: unknown;
+	to: unknown;
+}
+
+export interface AuditEntry {
+	id: number;
+	time: string;
+	actor?: string;
+	actor_user_id?: number;
+	actor_token_id?: number;
+	action: string;
+	target: string;
+	diff?: Record<string, AuditChange>;
+	request_id?: string;
+	ip?: string;
+	user_agent?: string;
+}
+
+export interface AuthConfig {
+	oidc: boolean;
+	oidc_login_url?: string;
+}
+
+export interface CreateAPITokenRequest {
+	name: string;
+	scopes: string[];
+	expires_in_days: number;
+}
+
+export interface CreatedAPIToken {
+	id: number;
+	username: string;
+	name: string;
+	prefix: string;
+	scopes: string[];
+	created_at: string;
+	expires_at?: string;
+	last_used_at?: string;
+	revoked_at?: string;
+	token: string;
+}
+
+export interface Lockout {
+	kind: string;
+	name: string;
+	failures: number;
+	last_failure_at: string;
+	blocked_until: string;
+	locked: boolean;
 }
 
+export interface LoginRequest {
+	username: string;
+	password: string;
+	code?: string;
+}
+
+export interface MaintenanceState {
+	mode: string;
+	reason: string;
+	updated_at: string;
+}
+
+export interface MigrationHandlerResp {
+	version: number;
+}
+
+export interface MigrationListItem {
+	version: number;
+}
+
+export interface MyPermissions {
+	roles: string[];
+	permissions: string[];
+}
+
+export interface PageAuditEntry {
+	items: AuditEntry[];
+	next_cursor: string;
+}
+
+export interface PageMigrationListItem {
+	items: MigrationListItem[];
+	next_cursor: string;
+}
+
+export interface RecoveryCodes {
+	codes: string[];
+}
+
+export interface SetMaintenanceRequest {
+	mode: string;
+	reason: string;
+}
+
+export interface SlowRequest {
+	method: string;
+	path: string;
+	status: number;
+	request_id?: string;
+	start: string;
+	duration_ms: number;
+	spans: Span[];
+	dropped_spans?: number;
+}
+
+export interface Span {
+	phase: string;
+	detail?: string;
+	offset_ms: number;
+	duration_ms: number;
+}
+
+export interface TOTPCodeRequest {
+	code: string;
+}
+
+export interface TOTPEnableRequest {
+	password: string;
+	code: string;
+}
+
+export interface TOTPEnrollRequest {
+	password: string;
+}
+
+export interface TOTPEnrollment {
+	secret: string;
+	uri: string;
+}
+
+export interface TOTPStatus {
+	enabled: boolean;
+	recovery_codes_left: number;
+	verified_at?: string;
+}
+
+export interface User {
+	id: number;
+	username: string;
+}
+
+/** Returns the version of the last applied migration */
 export async function getMigrationVersion(
 	fetch: typeof window.fetch
-): Promise<ApiResult<{ version: string }>> {
-	const res = await fetch(serverURL + '/migrations/version');
+): Promise<ApiResult<MigrationHandlerResp>> {
+	return request(fetch, 'GET', '/api/v1/migrations/version');
+}
+
+/** Lists the applied migrations */
+export async function listMigrations(
+	fetch: typeof window.fetch,
+	q: { limit?: string; cursor?: string } = {}
+): Promise<ApiResult<PageMigrationListItem>> {
+	return request(fetch, 'GET', '/api/v1/migrations' + query(q));
+}
+
+/** Checks the credentials and starts a session in an HttpOnly cookie */
+export async function login(
+	fetch: typeof window.fetch,
+	body: LoginRequest
+): Promise<ApiResult<User>> {
+	return request(fetch, 'POST', '/api/v1/auth/login', body);
+}
+
+/** Ends the current session */
+export async function logout(
+	fetch: typeof window.fetch
+): Promise<ApiResult<void>> {
+	return request(fetch, 'POST', '/api/v1/auth/logout');
+}
+
+/** Returns the login methods of the app */
+export async function getAuthConfig(
+	fetch: typeof window.fetch
+): Promise<ApiResult<AuthConfig>> {
+	return request(fetch, 'GET', '/api/v1/auth/config');
+}
+
+/** Completes a login waiting for a two-factor code, such as a single sign-on, and starts a session */
+export async function completeMFALogin(
+	fetch: typeof window.fetch,
+	body: TOTPCodeRequest
+): Promise<ApiResult<User>> {
+	return request(fetch, 'POST', '/api/v1/auth/mfa', body);
+}
+
+/** Returns the two-factor authentication status of the current user */
+export async function getTOTPStatus(
+	fetch: typeof window.fetch
+): Promise<ApiResult<TOTPStatus>> {
+	return request(fetch, 'GET', '/api/v1/auth/totp');
+}
+
+/** Generates a TOTP secret to add to an authenticator app, replacing any unconfirmed one. Needs the current password */
+export async function enrollTOTP(
+	fetch: typeof window.fetch,
+	body: TOTPEnrollRequest
+): Promise<ApiResult<TOTPEnrollment>> {
+	return request(fetch, 'POST', '/api/v1/auth/totp/enroll', body);
+}
+
+/** Enables two-factor authentication with a first code of the enrolled secret and the current password, and returns recovery codes */
+export async function enableTOTP(
+	fetch: typeof window.fetch,
+	body: TOTPEnableRequest
+): Promise<ApiResult<RecoveryCodes>> {
+	return request(fetch, 'POST', '/api/v1/auth/totp/enable', body);
+}
+
+/** Checks a TOTP or recovery code, so the session can call sensitive endpoints. Failed codes are throttled */
+export async function verifyTOTP(
+	fetch: typeof window.fetch,
+	body: TOTPCodeRequest
+): Promise<ApiResult<void>> {
+	return request(fetch, 'POST', '/api/v1/auth/totp/verify', body);
+}
+
+/** Replaces the recovery codes. Requires a recent second factor verification */
+export async function regenerateRecoveryCodes(
+	fetch: typeof window.fetch
+): Promise<ApiResult<RecoveryCodes>> {
+	return request(fetch, 'POST', '/api/v1/auth/totp/recovery-codes');
+}
+
+/** Disables two-factor authentication. Requires a recent second factor verification */
+export async function disableTOTP(
+	fetch: typeof window.fetch
+): Promise<ApiResult<void>> {
+	return request(fetch, 'DELETE', '/api/v1/auth/totp');
+}
 
-	if (!res.ok) return readApiError(res);
+/** Returns the user of the current session */
+export async function getMe(
+	fetch: typeof window.fetch
+): Promise<ApiResult<User>> {
+	return request(fetch, 'GET', '/api/v1/me');
+}
 
-	const result = await res.json();
-	return { result };
+/** Returns the roles of the current user and the permissions they grant */
+export async function getMyPermissions(
+	fetch: typeof window.fetch
+): Promise<ApiResult<MyPermissions>> {
+	return request(fetch, 'GET', '/api/v1/me/permissions');
+}
+
+/** Returns the maintenance state */
+export async function getMaintenance(
+	fetch: typeof window.fetch
+): Promise<ApiResult<MaintenanceState>> {
+	return request(fetch, 'GET', '/api/admin/maintenance');
+}
+
+/** Switches the maintenance mode */
+export async function setMaintenance(
+	fetch: typeof window.fetch,
+	body: SetMaintenanceRequest
+): Promise<ApiResult<MaintenanceState>> {
+	return request(fetch, 'PUT', '/api/admin/maintenance', body);
+}
+
+/** Lists the API tokens, including expired and revoked ones */
+export async function listAPITokens(
+	fetch: typeof window.fetch
+): Promise<ApiResult<APIToken[]>> {
+	return request(fetch, 'GET', '/api/admin/tokens');
+}
+
+/** Creates an API token of the current user and returns its secret, which cannot be retrieved later. Sessions require a recent second factor verification */
+export async function createAPIToken(
+	fetch: typeof window.fetch,
+	body: CreateAPITokenRequest
+): Promise<ApiResult<CreatedAPIToken>> {
+	return request(fetch, 'POST', '/api/admin/tokens', body);
+}
+
+/** Creates an API token of another user and returns its secret, which cannot be retrieved later. Only sessions with a recent second factor verification issue tokens, and the admin scope needs a user with permissions */
+export async function issueAPIToken(
+	fetch: typeof window.fetch,
+	params: { username: string },
+	body: CreateAPITokenRequest
+): Promise<ApiResult<CreatedAPIToken>> {
+	return request(fetch, 'POST', `/api/admin/users/${encodeURIComponent(params.username)}/tokens`, body);
+}
+
+/** Revokes an API token. Sessions require a recent second factor verification */
+export async function revokeAPIToken(
+	fetch: typeof window.fetch,
+	params: { id: string }
+): Promise<ApiResult<void>> {
+	return request(fetch, 'DELETE', `/api/admin/tokens/${encodeURIComponent(params.id)}`);
+}
+
+/** Lists the accounts, client IPs and users whose logins or codes are rejected after failures */
+export async function listLockouts(
+	fetch: typeof window.fetch
+): Promise<ApiResult<Lockout[]>> {
+	return request(fetch, 'GET', '/api/admin/lockouts');
+}
+
+/** Forgets the failed logins of an account (kind user), a client IP (kind ip) or the failed codes of a user (kind mfa), so it can log in again. Requires a recent second factor verification */
+export async function unlock(
+	fetch: typeof window.fetch,
+	params: { kind: string; name: string }
+): Promise<ApiResult<void>> {
+	return request(fetch, 'DELETE', `/api/admin/lockouts/${encodeURIComponent(params.kind)}/${encodeURIComponent(params.name)}`);
+}
+
+/** Lists the audit log of changes, newest first */
+export async function listAuditLog(
+	fetch: typeof window.fetch,
+	q: { action?: string; actor?: string; target?: string; since?: string; until?: string; limit?: string; cursor?: string } = {}
+): Promise<ApiResult<PageAuditEntry>> {
+	return request(fetch, 'GET', '/api/admin/audit-log' + query(q));
+}
+
+/** Lists the slowest requests since the start, with their per-phase breakdown */
+export async function listSlowRequests(
+	fetch: typeof window.fetch
+): Promise<ApiResult<SlowRequest[]>> {
+	return request(fetch, 'GET', '/api/admin/slow-requests');
 }
@@ -1 +1,21 @@
+import { redirect } from '@sveltejs/kit';
+import { getMe, getMyPermissions, type User } from '$lib/api';
+import type { LayoutLoad } from './$types';
+
 export const ssr = false;
+
+export const load: LayoutLoad = async ({ fetch, url }) => {
+	if (url.pathname === '/app/login') {
+		return { user: null as User | null, permissions: [] as string[] };
+	}
+	const [me, perms] = await Promise.all([getMe(fetch), getMyPermissions(fetch)]);
+	if ('error' in me) {
+		if (me.status === 401) {
+			redirect(307, '/app/login?next=' + encodeURIComponent(url.pathname + url.search));
+		}
+		return { user: null as User | null, permissions: [] as string[] };
+	}
+	// Permissions only hide what the user cannot do; the API enforces them anyway.
+	const permissions = 'error' in perms ? [] : perms.result.permissions;
+	return { user: me.result as User | null, permissions };
+};
@@ -1,6 +1,10 @@
 import { getMigrationVersion } from '$lib/api';
 import type { PageLoad } from './$types';
 
-export const load: PageLoad = async ({ fetch }) => {
+export const load: PageLoad = async ({ fetch, parent }) => {
+	const { permissions } = await parent();
+	if (!permissions.includes('migrations:read')) {
+		return { forbidden: true };
+	}
 	return getMigrationVersion(fetch);
 };